@@ -0,0 +1,74 @@
+package email
+
+import (
+	"iter"
+	"runtime"
+
+	"github.com/Station-Manager/adif"
+	"github.com/Station-Manager/types"
+)
+
+// adifChunk is how many QSOs are converted to ADIF records as one unit of work.
+const adifChunk = 256
+
+// adifHeader returns the header section of an ADIF export, created at the time of the Clock.
+func (s *Service) adifHeader() *adif.HeaderSection {
+	return &adif.HeaderSection{CreatedTimestamp: s.now().UTC().Format("20060102150405")}
+}
+
+type adifRecord struct {
+	qso  types.Qso
+	text string
+	err  error
+}
+
+// composeADIF converts qsos to ADIF records on up to GOMAXPROCS goroutines while the caller builds the message
+// and encodes the records already converted. Chunks are received in the order of qsos; a chunk ends early at a
+// record that failed to convert. Only a few chunks are converted ahead of the caller, so the memory used does not
+// grow with the export. stop must be called once the caller is done, whether or not it read every chunk.
+func composeADIF(qsos iter.Seq[types.Qso]) (chunks <-chan chan []adifRecord, stop func()) {
+	workers := runtime.GOMAXPROCS(0)
+	pending := make(chan chan []adifRecord, workers)
+	done := make(chan struct{})
+	go func() {
+		defer close(pending)
+		batch := make([]types.Qso, 0, adifChunk)
+		dispatch := func() bool {
+			// Buffered, so a worker finishing after the caller stopped does not block
+			res := make(chan []adifRecord, 1)
+			select {
+			case pending <- res:
+			case <-done:
+				return false
+			}
+			go func(qs []types.Qso) { res <- convertADIFChunk(qs) }(batch)
+			batch = make([]types.Qso, 0, adifChunk)
+			return true
+		}
+		for q := range qsos {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if batch = append(batch, q); len(batch) == adifChunk && !dispatch() {
+				return
+			}
+		}
+		if len(batch) > 0 {
+			dispatch()
+		}
+	}()
+	return pending, func() { close(done) }
+}
+
+func convertADIFChunk(qs []types.Qso) []adifRecord {
+	out := make([]adifRecord, len(qs))
+	for i, q := range qs {
+		out[i].qso = q
+		if out[i].text, out[i].err = adif.ConvertQsoToAdifNoHeader(q); out[i].err != nil {
+			return out[:i+1]
+		}
+	}
+	return out
+}
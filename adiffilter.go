@@ -0,0 +1,124 @@
+package email
+
+import (
+	stderr "errors"
+	"fmt"
+	"iter"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+// ADIFOptions selects the QSOs included in an ADIF attachment. The zero value includes every QSO.
+type ADIFOptions struct {
+	// Since and Until bound the QSO start time (QSO_DATE/TIME_ON, UTC); Since is inclusive, Until exclusive. A zero
+	// bound is open.
+	Since time.Time
+	Until time.Time
+	// Bands and Modes restrict the export to the listed values, compared case-insensitively; empty means all.
+	Bands []string
+	Modes []string
+	// OnlyNotEmailed skips QSOs already marked as forwarded by email.
+	OnlyNotEmailed bool
+	// WhenEmpty decides what an export that selects no QSOs produces.
+	WhenEmpty EmptyExportPolicy
+}
+
+// EmptyExportPolicy is what to send when an export window holds no QSOs; the robots collecting club logs differ in
+// what they expect.
+type EmptyExportPolicy string
+
+const (
+	// EmptyExportSkip fails the build with ErrNoQSOs, so that nothing is sent.
+	EmptyExportSkip EmptyExportPolicy = ""
+	// EmptyExportNote builds a short text message saying there are no new QSOs, without an attachment.
+	EmptyExportNote EmptyExportPolicy = "note"
+	// EmptyExportADIF attaches an ADIF file with a header and no records.
+	EmptyExportADIF EmptyExportPolicy = "adif"
+)
+
+// ErrNoQSOs is wrapped by the error of an ADIF build that selected no QSOs under EmptyExportSkip, so that a
+// scheduler can tell an empty window from a failure.
+var ErrNoQSOs = stderr.New("no QSOs to export")
+
+// emptyExportNote is the body of the message sent under EmptyExportNote.
+func emptyExportNote(o ADIFOptions) string {
+	const layout = "2006-01-02 15:04"
+	switch {
+	case !o.Since.IsZero() && !o.Until.IsZero():
+		return fmt.Sprintf("No new QSOs between %s and %s UTC.", o.Since.UTC().Format(layout), o.Until.UTC().Format(layout))
+	case !o.Since.IsZero():
+		return fmt.Sprintf("No new QSOs since %s UTC.", o.Since.UTC().Format(layout))
+	case !o.Until.IsZero():
+		return fmt.Sprintf("No new QSOs before %s UTC.", o.Until.UTC().Format(layout))
+	}
+	return "No new QSOs."
+}
+
+func (o ADIFOptions) active() bool {
+	return !o.Since.IsZero() || !o.Until.IsZero() || len(o.Bands) > 0 || len(o.Modes) > 0 || o.OnlyNotEmailed
+}
+
+// Filter returns the QSOs of qsos that the options select, in order.
+func (o ADIFOptions) Filter(qsos iter.Seq[types.Qso]) iter.Seq[types.Qso] {
+	if !o.active() {
+		return qsos
+	}
+	return func(yield func(types.Qso) bool) {
+		for q := range qsos {
+			if o.match(q) && !yield(q) {
+				return
+			}
+		}
+	}
+}
+
+func (o ADIFOptions) match(q types.Qso) bool {
+	if o.OnlyNotEmailed && strings.EqualFold(strings.TrimSpace(q.SmFwrdByEmailStatus), "Y") {
+		return false
+	}
+	if len(o.Bands) > 0 && !containsFold(o.Bands, q.Band) {
+		return false
+	}
+	if len(o.Modes) > 0 && !containsFold(o.Modes, q.Mode) {
+		return false
+	}
+	if !o.Since.IsZero() || !o.Until.IsZero() {
+		at, ok := qsoStart(q)
+		if !ok {
+			return false
+		}
+		if !o.Since.IsZero() && at.Before(o.Since) {
+			return false
+		}
+		if !o.Until.IsZero() && !at.Before(o.Until) {
+			return false
+		}
+	}
+	return true
+}
+
+// qsoStart parses QSO_DATE (YYYYMMDD) and TIME_ON (HHMM or HHMMSS) as UTC.
+func qsoStart(q types.Qso) (time.Time, bool) {
+	date := strings.TrimSpace(q.QsoDate)
+	tm := strings.TrimSpace(q.TimeOn)
+	switch len(tm) {
+	case 0:
+		tm = "000000"
+	case 4:
+		tm += "00"
+	}
+	t, err := time.Parse("20060102150405", date+tm)
+	return t, err == nil
+}
+
+func containsFold(list []string, v string) bool {
+	v = strings.TrimSpace(v)
+	for _, s := range list {
+		if strings.EqualFold(strings.TrimSpace(s), v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package email
+
+import (
+	stderr "errors"
+	"iter"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/adif"
+	"github.com/Station-Manager/types"
+)
+
+func qsoStream(n int) iter.Seq[types.Qso] {
+	return func(yield func(types.Qso) bool) {
+		for i := 0; i < n; i++ {
+			if !yield(types.Qso{LogbookID: 1, SessionID: int64(i)}) {
+				return
+			}
+		}
+	}
+}
+
+func TestBuildEmailWithADIFStream(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{From: "from@example.com", To: "alice@example.com", Subject: "Subject", Body: "Body"}}
+
+	def, err := s.BuildEmailWithADIFStream("", "", "", nil, qsoStream(500), ADIFOptions{})
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	in, err := ParseInbound(strings.NewReader(def.Msg))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	atts, err := in.Attachments()
+	if err != nil || len(atts) != 1 {
+		t.Fatalf("expected one attachment, got %d, %v", len(atts), err)
+	}
+	if cte := def.Structure.Parts[1].Header.Get("Content-Transfer-Encoding"); cte != "quoted-printable" {
+		t.Fatalf("ADIF attachment sent as %s, want quoted-printable", cte)
+	}
+
+	qs := make([]types.Qso, 500)
+	for i := range qs {
+		qs[i] = types.Qso{LogbookID: 1, SessionID: int64(i)}
+	}
+	want, err := adif.ComposeToAdifString(qs)
+	if err != nil {
+		t.Fatalf("compose failed: %v", err)
+	}
+	// The header carries a creation timestamp; compare the records
+	_, wantRecs, _ := strings.Cut(want, "<EOH>")
+	_, gotRecs, _ := strings.Cut(string(atts[0].Data), "<EOH>")
+	if gotRecs != wantRecs {
+		t.Fatalf("streamed ADIF differs from composed ADIF")
+	}
+	for _, line := range strings.Split(def.Msg, "\r\n") {
+		if len(line) > 76 && !strings.Contains(line, ":") {
+			t.Fatalf("attachment line exceeds 76 characters: %d", len(line))
+		}
+	}
+
+	if _, err = s.BuildEmailWithADIFStream("", "", "", nil, qsoStream(0), ADIFOptions{}); err == nil {
+		t.Fatalf("expected an empty stream to be rejected")
+	}
+}
+
+func TestADIFOptionsFilter(t *testing.T) {
+	mk := func(date, timeOn, band, mode, emailed string) types.Qso {
+		q := types.Qso{SmFwrdByEmailStatus: emailed}
+		q.QsoDate, q.TimeOn, q.Band, q.Mode = date, timeOn, band, mode
+		return q
+	}
+	qs := []types.Qso{
+		mk("20240101", "2359", "20m", "SSB", ""),
+		mk("20240102", "0000", "20m", "CW", "Y"),
+		mk("20240102", "120000", "40M", "ft8", "N"),
+		mk("20240103", "0000", "20m", "CW", ""),
+		mk("", "", "20m", "CW", ""),
+	}
+	opts := ADIFOptions{
+		Since:          time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		Until:          time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC),
+		Bands:          []string{"20m", "40m"},
+		Modes:          []string{"CW", "FT8"},
+		OnlyNotEmailed: true,
+	}
+	var got []string
+	for q := range opts.Filter(slices.Values(qs)) {
+		got = append(got, q.QsoDate+"/"+q.Mode)
+	}
+	if strings.Join(got, ",") != "20240102/ft8" {
+		t.Fatalf("unexpected filter result %v", got)
+	}
+
+	s := &Service{Config: &types.EmailConfig{From: "from@example.com", To: "alice@example.com"}}
+	_, err := s.BuildEmailWithADIFStream("", "", "", nil, slices.Values(qs), ADIFOptions{Modes: []string{"RTTY"}})
+	if err == nil || !strings.Contains(err.Error(), "no QSOs match") {
+		t.Fatalf("expected no-match error, got %v", err)
+	}
+}
+
+func TestEmptyExportPolicy(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{From: "from@example.com", To: "robot@club.example", Subject: "Log {{.DateRange}}", Body: "Log attached"}}
+	opts := ADIFOptions{Since: time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC), Until: time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)}
+
+	if _, err := s.BuildEmailWithADIFStream("", "", "", nil, qsoStream(0), opts); !stderr.Is(err, ErrNoQSOs) {
+		t.Fatalf("skip: err = %v, want ErrNoQSOs", err)
+	}
+
+	opts.WhenEmpty = EmptyExportNote
+	def, err := s.BuildEmailWithADIFStream("", "", "", nil, qsoStream(0), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(def.Structure.Parts) != 1 || def.Structure.Parts[0].Body != "No new QSOs between 2026-10-14 00:00 and 2026-10-15 00:00 UTC." {
+		t.Fatalf("note = %+v", def.Structure.Parts)
+	}
+
+	opts.WhenEmpty = EmptyExportADIF
+	if def, err = s.BuildEmailWithADIFStream("", "", "", nil, qsoStream(0), opts); err != nil {
+		t.Fatal(err)
+	}
+	in, err := ParseInbound(strings.NewReader(def.Msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	atts, err := in.Attachments()
+	if err != nil || len(atts) != 1 || !strings.Contains(string(atts[0].Data), "<EOH>") || strings.Contains(string(atts[0].Data), "<EOR>") {
+		t.Fatalf("attachments = %+v, %v", atts, err)
+	}
+}
+
+func TestComposeADIFKeepsOrderAndStops(t *testing.T) {
+	n := 10*adifChunk + 7
+	chunks, stop := composeADIF(qsoStream(n))
+	next := int64(0)
+	for res := range chunks {
+		for _, rec := range <-res {
+			if rec.err != nil || rec.qso.SessionID != next || rec.text == "" {
+				t.Fatalf("record %d: session %d, %v", next, rec.qso.SessionID, rec.err)
+			}
+			next++
+		}
+	}
+	stop()
+	if next != int64(n) {
+		t.Fatalf("got %d records, want %d", next, n)
+	}
+
+	// A caller that stops early stops the QSOs being read; no more than a chunk per worker is read ahead
+	n = (runtime.GOMAXPROCS(0) + 4) * adifChunk
+	pulled := 0
+	counted := func(yield func(types.Qso) bool) {
+		for q := range qsoStream(n) {
+			pulled++
+			if !yield(q) {
+				return
+			}
+		}
+	}
+	chunks, stop = composeADIF(counted)
+	<-<-chunks
+	stop()
+	for range chunks {
+	}
+	if pulled == n {
+		t.Fatalf("all %d QSOs read after stop", n)
+	}
+}
@@ -0,0 +1,43 @@
+package email
+
+import (
+	"bufio"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+// checkAlignment compares the envelope sender's domain with the From header's. DMARC rejects a message whose SPF
+// domain (the Return-Path) does not align with From unless it carries an aligned DKIM signature, so a message
+// signed for the From domain passes. envelope is the sender before any SRS rewriting, which misaligns forwarded
+// mail by design.
+func (s *Service) checkAlignment(d *delivery, envelope string) error {
+	const op errors.Op = "email.Service.checkAlignment"
+	if s.Alignment == ComplianceOff || envelope == "" {
+		return nil
+	}
+	hdr, err := textproto.NewReader(bufio.NewReader(strings.NewReader(d.msg.Msg))).ReadMIMEHeader()
+	if err != nil && len(hdr) == 0 {
+		return nil // unparseable messages are the compliance check's concern
+	}
+	from, err := mail.ParseAddress(hdr.Get("From"))
+	if err != nil {
+		return nil
+	}
+	_, headerDomain, _ := strings.Cut(from.Address, "@")
+	_, envelopeDomain, _ := strings.Cut(envelope, "@")
+	if domainsAligned(headerDomain, envelopeDomain) {
+		return nil
+	}
+	if sig := hdr.Get("Dkim-Signature"); sig != "" && domainsAligned(headerDomain, dkimTag(sig, "d")) {
+		return nil
+	}
+	d.log.WarnWith().Str("message_id", d.rec.MessageID).Str("envelope_domain", envelopeDomain).Str("from_domain", headerDomain).
+		Msg("envelope sender does not align with the From header; DMARC may reject the message")
+	if s.Alignment == ComplianceStrict {
+		return errors.New(op).Msgf("envelope sender domain %s does not align with From header domain %s", envelopeDomain, headerDomain)
+	}
+	return nil
+}
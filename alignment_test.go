@@ -0,0 +1,55 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+func TestAlignmentCheck(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "k1abc@club.example"}}
+	s.isInitialized.Store(true)
+
+	calls := 0
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		calls++
+		return deliveryInfo{}, nil
+	})
+
+	aligned := MsgDef{To: []string{"to@example.com"}, Msg: "From: K1ABC <k1abc@mail.club.example>\r\nSubject: hi\r\n\r\nbody"}
+	misaligned := MsgDef{To: []string{"to@example.com"}, Msg: "From: K1ABC <k1abc@other.example>\r\nSubject: hi\r\n\r\nbody"}
+
+	s.Alignment = ComplianceStrict
+	if err := s.Send(aligned); err != nil || calls != 1 {
+		t.Fatalf("subdomain From should align: %v, %d calls", err, calls)
+	}
+	if err := s.Send(misaligned); err == nil || !strings.Contains(errors.Root(err).Error(), "does not align") || calls != 1 {
+		t.Fatalf("strict mode should refuse a misaligned message: %v, %d calls", err, calls)
+	}
+	s.Alignment = ComplianceReport
+	if err := s.Send(misaligned); err != nil || calls != 2 {
+		t.Fatalf("report mode should send anyway: %v, %d calls", err, calls)
+	}
+}
+
+func TestPreflightFlagsLoginReturnPath(t *testing.T) {
+	r := fakeResolver{txt: map[string][]string{
+		"club.example":        {"v=spf1 a:smtp.gmail.com -all"},
+		"_dmarc.club.example": {"v=DMARC1; p=reject"},
+	}, ips: map[string][]string{"smtp.gmail.com": {"192.0.2.10"}}}
+	s := &Service{Config: &types.EmailConfig{From: "k1abc@club.example", Host: "smtp.gmail.com", Username: "k1abc@gmail.com"}, resolver: r}
+
+	var found bool
+	for _, a := range s.PreflightSenderDomain(t.Context()) {
+		if strings.Contains(a.Message, "Return-Path") {
+			found = a.Severity == AdvisoryWarning
+		}
+	}
+	if !found {
+		t.Fatal("expected a Return-Path alignment warning")
+	}
+}
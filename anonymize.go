@@ -0,0 +1,89 @@
+package email
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// anonymizedPrefix marks a hashed address, so that it is not mistaken for one that can be mailed.
+const anonymizedPrefix = "anon-"
+
+// redactedSubject replaces subjects in anonymized records.
+const redactedSubject = "[redacted]"
+
+// AnonymizeConfig keeps addresses and subjects out of what the service records for statistics: the send history
+// and the delivery events published to EventSinks. Recipients are replaced by keyed hashes, so that the messages
+// to one address can still be counted together, and subjects are redacted.
+type AnonymizeConfig struct {
+	// Key keys the recipient hashes; the same address hashes alike only under the same key. Empty uses a random
+	// key for the life of the Service.
+	Key []byte
+
+	once sync.Once
+	key  []byte
+}
+
+func (a *AnonymizeConfig) address(addr string) string {
+	a.once.Do(func() {
+		a.key = a.Key
+		if len(a.key) == 0 {
+			a.key = make([]byte, 32)
+			_, _ = rand.Read(a.key)
+		}
+	})
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(suppressionKey(addr)))
+	return anonymizedPrefix + hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+func (a *AnonymizeConfig) addresses(list []string) []string {
+	out := make([]string, len(list))
+	for i, addr := range list {
+		out[i] = a.address(addr)
+	}
+	return out
+}
+
+// recordedAddress returns addr as the history records it.
+func (s *Service) recordedAddress(addr string) string {
+	if s.Anonymize == nil || strings.HasPrefix(addr, anonymizedPrefix) {
+		return addr
+	}
+	return s.Anonymize.address(addr)
+}
+
+// anonymizeRecord returns rec as the history keeps it.
+func (s *Service) anonymizeRecord(rec HistoryRecord) HistoryRecord {
+	if s.Anonymize == nil {
+		return rec
+	}
+	rec.To = s.Anonymize.addresses(rec.To)
+	if rec.Subject != "" {
+		rec.Subject = redactedSubject
+	}
+	return rec
+}
+
+// anonymizeStatus returns ds as the history keeps it.
+func (s *Service) anonymizeStatus(ds DeliveryStatus) DeliveryStatus {
+	if s.Anonymize != nil && ds.Recipient != "" {
+		ds.Recipient = s.Anonymize.address(ds.Recipient)
+	}
+	return ds
+}
+
+// anonymizeEvent returns ev as it is published.
+func (s *Service) anonymizeEvent(ev DeliveryEvent) DeliveryEvent {
+	if s.Anonymize == nil {
+		return ev
+	}
+	ev.Recipients = s.Anonymize.addresses(ev.Recipients)
+	if ev.Subject != "" {
+		ev.Subject = redactedSubject
+	}
+	return ev
+}
@@ -0,0 +1,60 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+type recordingSink struct {
+	mu     sync.Mutex
+	events []DeliveryEvent
+}
+
+func (r *recordingSink) Publish(ev DeliveryEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func TestAnonymizedHistoryAndEvents(t *testing.T) {
+	sink := &recordingSink{}
+	s := &Service{
+		Config:     &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "op@example.org"},
+		EventSinks: []EventSink{sink},
+		Anonymize:  &AnonymizeConfig{Key: []byte("club-stats")},
+	}
+	s.isInitialized.Store(true)
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		return deliveryInfo{}, nil
+	})
+
+	for range 2 {
+		if err := s.Send(MsgDef{To: []string{"DX@Example.com"}, Msg: "Subject: QSL for G4ABC\r\n\r\n73\r\n"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := s.History()
+	if len(h) != 2 || h[0].Subject != redactedSubject || len(h[0].To) != 1 {
+		t.Fatalf("history = %+v", h)
+	}
+	if to := h[0].To[0]; !strings.HasPrefix(to, anonymizedPrefix) || strings.Contains(to, "example") || to != h[1].To[0] {
+		t.Fatalf("recipients should hash alike and hide the address: %q, %q", to, h[1].To[0])
+	}
+	if len(sink.events) != 2 || sink.events[0].Recipients[0] != h[0].To[0] || sink.events[0].Subject != redactedSubject {
+		t.Fatalf("events = %+v", sink.events)
+	}
+	if data := s.ExportData("dx@example.com"); len(data.History) != 2 {
+		t.Fatalf("an access request should still find the anonymized records: %+v", data)
+	}
+
+	other := &Service{Anonymize: &AnonymizeConfig{Key: []byte("other")}}
+	if other.recordedAddress("dx@example.com") == h[0].To[0] {
+		t.Fatal("hashes under different keys must differ")
+	}
+}
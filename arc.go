@@ -0,0 +1,194 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// arcMaxInstances is the highest ARC instance RFC 8617 allows; longer chains are not sealed again.
+const arcMaxInstances = 50
+
+const defaultMaxForwardHops = 5
+
+// ARCConfig seals forwarded mail (RFC 8617) so that receivers can rely on the authentication results seen when the
+// message arrived here, after forwarding has broken its SPF and possibly its DKIM signatures.
+type ARCConfig struct {
+	Domain   string
+	Selector string
+	// Key is an *rsa.PrivateKey or ed25519.PrivateKey, as for DKIMConfig.
+	Key crypto.Signer
+	// AuthServID is the authserv-id of the receiving MTA whose Authentication-Results are preserved; results
+	// stamped under any other id are ignored since the sender could have forged them.
+	AuthServID string
+}
+
+// arcSeal carries what Forward learned from the received message to the sealing step of the send.
+type arcSeal struct {
+	// results is the payload of ARC-Authentication-Results, starting with the authserv-id
+	results string
+	// cv is the receiving MTA's verdict on the ARC chain the message arrived with
+	cv string
+}
+
+// Forward re-sends a received message to to, e.g. club mail to its officers, adding Resent-* fields and keeping the
+// original headers that Service.ForwardHeaders allows. The envelope sender stays the original author, so configure
+// SRS to keep SPF passing. With Service.ARC set the message is ARC sealed with the authentication results it arrived
+// with.
+func (s *Service) Forward(ctx context.Context, msg *InboundMessage, to []string) (SendResult, error) {
+	const op errors.Op = "email.Service.Forward"
+	if len(to) == 0 {
+		return SendResult{}, errors.New(op).Msg("forward recipients cannot be empty")
+	}
+	if hops := len(msg.Header["Resent-Message-Id"]); hops >= s.maxForwardHops() {
+		s.LoggerService.WarnWith().Str("message_id", msg.ID).Int("hops", hops).Msg("refusing to forward a looping message")
+		return SendResult{}, errors.New(op).Msg(errMsgTooManyHops)
+	}
+	cfg := s.config()
+	envelope := msg.Sender()
+	if envelope == "" {
+		envelope = strings.TrimSpace(cfg.From)
+	}
+
+	resentID := generateMessageID(s.random(), s.now())
+	var buf bytes.Buffer
+	for _, f := range [][2]string{
+		{"Resent-From", strings.TrimSpace(cfg.From)},
+		{"Resent-To", strings.Join(to, ", ")},
+		{"Resent-Date", s.now().UTC().Format(time.RFC1123Z)},
+		{"Resent-Message-ID", resentID},
+	} {
+		buf.WriteString(f[0] + ": " + headerBreaks.Replace(f[1]) + "\r\n")
+	}
+	policy := s.ForwardHeaders
+	if policy == nil {
+		policy = &defaultHeaderPolicy
+	}
+	hdr := policy.apply(msg.Header, s.random(), s.now(), s.LoggerService)
+	keys := make([]string, 0, len(hdr))
+	for k := range hdr {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range hdr[k] {
+			buf.WriteString(k + ": " + headerBreaks.Replace(v) + "\r\n")
+		}
+	}
+	buf.WriteString("\r\n")
+	buf.Write(msg.Body)
+
+	def := MsgDef{From: envelope, To: to, Msg: buf.String(), received: stampFor(msg, resentID, s.now)}
+	if s.ARC != nil {
+		def.arc = s.ARC.sealFor(msg)
+	}
+	res, err := s.SendWithResult(ctx, def)
+	if err != nil {
+		return res, errors.New(op).Err(err).Msg(err.Error())
+	}
+	return res, nil
+}
+
+func (s *Service) maxForwardHops() int {
+	if s.MaxForwardHops > 0 {
+		return s.MaxForwardHops
+	}
+	return defaultMaxForwardHops
+}
+
+// sealFor collects the trusted Authentication-Results of msg and the receiving MTA's verdict on its ARC chain.
+func (c *ARCConfig) sealFor(msg *InboundMessage) *arcSeal {
+	id := c.AuthServID
+	if id == "" {
+		id = c.Domain
+	}
+	seal := &arcSeal{results: id + "; none", cv: "fail"}
+	for _, raw := range msg.Header["Authentication-Results"] {
+		if fields := strings.Fields(raw); len(fields) > 0 && strings.EqualFold(strings.TrimSuffix(fields[0], ";"), id) {
+			seal.results = strings.TrimSpace(raw)
+			break
+		}
+	}
+	for _, r := range (&SenderAuthPolicy{AuthServID: id}).authResults(msg) {
+		if r.method == "arc" && r.result == "pass" {
+			seal.cv = "pass"
+		}
+	}
+	return seal
+}
+
+// sealARC prepends the next ARC set to msg. Like signDKIM it must run after every other change to the message.
+func sealARC(msg string, cfg *ARCConfig, seal arcSeal, now time.Time) (string, error) {
+	const op errors.Op = "email.sealARC"
+	if cfg.Domain == "" || cfg.Selector == "" || cfg.Key == nil {
+		return "", errors.New(op).Msg("arc domain, selector and key are required")
+	}
+	algo, err := dkimAlgorithm(cfg.Key)
+	if err != nil {
+		return "", errors.New(op).Err(err).Msg(err.Error())
+	}
+	head, _, _ := strings.Cut(msg, "\r\n\r\n")
+	fields := headerFields(head)
+	n := 1
+	for _, f := range fields {
+		if k, v, ok := strings.Cut(f, ":"); ok && strings.EqualFold(strings.TrimSpace(k), "ARC-Seal") {
+			if i, _ := strconv.Atoi(dkimTag(v, "i")); i >= n {
+				n = i + 1
+			}
+		}
+	}
+	if n > arcMaxInstances {
+		return "", errors.New(op).Msgf("arc chain already has %d instances", n-1)
+	}
+	cv := "none"
+	if n > 1 {
+		cv = seal.cv
+	}
+	i := "i=" + strconv.Itoa(n) + "; "
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	aar := "ARC-Authentication-Results: " + i + seal.results
+	signed, canon, bh := canonicalMessage(msg, nil)
+	ams := "ARC-Message-Signature: " + i + "a=" + algo + "; c=relaxed/relaxed; d=" + cfg.Domain + "; s=" + cfg.Selector +
+		"; t=" + ts + "; h=" + strings.Join(signed, ":") + ";\r\n\tbh=" + bh + "; b="
+	canon.WriteString(relaxedHeader(ams))
+	sig, err := dkimSign(cfg.Key, canon.String())
+	if err != nil {
+		return "", errors.New(op).Err(err).Msg("failed to sign arc message signature")
+	}
+	ams += sig
+
+	// The seal covers every earlier set, oldest first, then this one
+	var sealed strings.Builder
+	for j := 1; j < n; j++ {
+		for _, name := range []string{"ARC-Authentication-Results", "ARC-Message-Signature", "ARC-Seal"} {
+			f, ok := arcField(fields, name, j)
+			if !ok {
+				return "", errors.New(op).Msgf("arc set %d is incomplete", j)
+			}
+			sealed.WriteString(relaxedHeader(f) + "\r\n")
+		}
+	}
+	as := "ARC-Seal: " + i + "a=" + algo + "; t=" + ts + "; cv=" + cv + "; d=" + cfg.Domain + "; s=" + cfg.Selector + "; b="
+	sealed.WriteString(relaxedHeader(aar) + "\r\n" + relaxedHeader(ams) + "\r\n" + relaxedHeader(as))
+	if sig, err = dkimSign(cfg.Key, sealed.String()); err != nil {
+		return "", errors.New(op).Err(err).Msg("failed to sign arc seal")
+	}
+	return as + sig + "\r\n" + ams + "\r\n" + aar + "\r\n" + msg, nil
+}
+
+// arcField returns the field called name belonging to ARC instance i.
+func arcField(fields []string, name string, i int) (string, bool) {
+	for _, f := range fields {
+		if k, v, ok := strings.Cut(f, ":"); ok && strings.EqualFold(strings.TrimSpace(k), name) && dkimTag(v, "i") == strconv.Itoa(i) {
+			return f, true
+		}
+	}
+	return "", false
+}
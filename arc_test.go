@@ -0,0 +1,129 @@
+package email
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/smtp"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+var arcSigValue = regexp.MustCompile(`b=[A-Za-z0-9+/=\s]+$`)
+
+// verifyARC checks the message signature and seal of ARC instance n.
+func verifyARC(t *testing.T, msg string, n int, pub *rsa.PublicKey) {
+	t.Helper()
+	head, body, _ := strings.Cut(msg, "\r\n\r\n")
+	fields := headerFields(head)
+	check := func(what, data, b string) {
+		digest := sha256.Sum256([]byte(data))
+		sig, _ := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(b), ""))
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			t.Fatalf("instance %d %s invalid: %v", n, what, err)
+		}
+	}
+
+	ams, ok := arcField(fields, "ARC-Message-Signature", n)
+	if !ok {
+		t.Fatalf("no ARC-Message-Signature for instance %d", n)
+	}
+	_, amsValue, _ := strings.Cut(ams, ":")
+	bh := sha256.Sum256([]byte(relaxedBody(body)))
+	if dkimTag(strings.ReplaceAll(amsValue, "\r\n", ""), "bh") != strings.ToLower(base64.StdEncoding.EncodeToString(bh[:])) {
+		t.Fatalf("instance %d body hash mismatch", n)
+	}
+	var canon strings.Builder
+	for _, name := range strings.Split(dkimTag(amsValue, "h"), ":") {
+		f, _ := lastField(fields, name)
+		canon.WriteString(relaxedHeader(f) + "\r\n")
+	}
+	canon.WriteString(relaxedHeader(arcSigValue.ReplaceAllString(ams, "b=")))
+	check("message signature", canon.String(), arcSigValue.FindString(ams)[2:])
+
+	var sealed strings.Builder
+	for j := 1; j <= n; j++ {
+		for _, name := range []string{"ARC-Authentication-Results", "ARC-Message-Signature", "ARC-Seal"} {
+			f, ok := arcField(fields, name, j)
+			if !ok {
+				t.Fatalf("instance %d lacks %s", j, name)
+			}
+			if j == n && name == "ARC-Seal" {
+				sealed.WriteString(relaxedHeader(arcSigValue.ReplaceAllString(f, "b=")))
+				check("seal", sealed.String(), arcSigValue.FindString(f)[2:])
+				continue
+			}
+			sealed.WriteString(relaxedHeader(f) + "\r\n")
+		}
+	}
+}
+
+func TestForwardSealsWithARC(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{
+		Config: &types.EmailConfig{Enabled: true, Host: "smtp.club.example", Port: 587, From: "relay@club.example"},
+		ARC:    &ARCConfig{Domain: "club.example", Selector: "arc", Key: key, AuthServID: "mx.club.example"},
+	}
+	s.isInitialized.Store(true)
+
+	var sent []string
+	var envelope string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent, envelope = append(sent, string(msg)), from
+		return deliveryInfo{}, nil
+	})
+
+	raw := "Return-Path: <w1aw@example.org>\r\n" +
+		"Authentication-Results: evil.example; spf=pass smtp.mailfrom=example.org\r\n" +
+		"Authentication-Results: mx.club.example; spf=pass smtp.mailfrom=example.org; dkim=pass header.d=example.org\r\n" +
+		"From: W1AW <w1aw@example.org>\r\n" +
+		"To: info@club.example\r\n" +
+		"Subject: Field Day  plans\r\n" +
+		"Message-ID: <fd-1@example.org>\r\n" +
+		"\r\n" +
+		"See you on the hill.  \r\n"
+	in, err := ParseInbound(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Forward(t.Context(), in, []string{"president@club.example"}); err != nil {
+		t.Fatal(err)
+	}
+	if envelope != "w1aw@example.org" {
+		t.Errorf("envelope sender = %q, want the original author", envelope)
+	}
+	first := sent[0]
+	if !strings.HasPrefix(first, "ARC-Seal: i=1; a=rsa-sha256; ") || !strings.Contains(first, "; cv=none; d=club.example; s=arc;") {
+		t.Fatalf("first ARC set missing or wrong:\n%s", first)
+	}
+	if !strings.Contains(first, "ARC-Authentication-Results: i=1; mx.club.example; spf=pass") || strings.Contains(first, "Return-Path:") {
+		t.Fatalf("unexpected forwarded headers:\n%s", first)
+	}
+	if !strings.Contains(first, "Resent-To: president@club.example") {
+		t.Error("missing Resent-To")
+	}
+	verifyARC(t, first, 1, &key.PublicKey)
+
+	// The president's MTA verified the chain and forwards it on
+	hop, err := ParseInbound(strings.NewReader("Authentication-Results: mx.club.example; arc=pass; spf=fail\r\n" + first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Forward(t.Context(), hop, []string{"secretary@club.example"}); err != nil {
+		t.Fatal(err)
+	}
+	second := sent[1]
+	if !strings.HasPrefix(second, "ARC-Seal: i=2; ") || !strings.Contains(second, "cv=pass") {
+		t.Fatalf("second ARC set missing or wrong:\n%s", second)
+	}
+	verifyARC(t, second, 2, &key.PublicKey)
+}
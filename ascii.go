@@ -0,0 +1,93 @@
+package email
+
+import (
+	"context"
+	"mime"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+// latin1ASCII and latinExtAASCII transliterate U+00C0-U+00FF and U+0100-U+017F, one entry per code point.
+var (
+	latin1ASCII = strings.Fields(`A A A A Ae A AE C E E E E I I I I D N O O O O Oe x O U U U Ue Y Th ss
+		a a a a ae a ae c e e e e i i i i d n o o o o oe / o u u u ue y th y`)
+	latinExtAASCII = strings.Fields(`A a A a A a C c C c C c C c D d D d E e E e E e E e E e G g G g
+		G g G g H h H h I i I i I i I i I i IJ ij J j K k k L l L l L l L
+		l L l N n N n N n n N n O o O o O o OE oe R r R r R r S s S s S s
+		S s T t T t T t U u U u U u U u U u U u W w Y y Y Z z Z z Z z s`)
+)
+
+// asciiPunct transliterates the typographic characters word processors substitute for ASCII ones.
+var asciiPunct = map[rune]string{
+	'\u00a0': " ", '‘': "'", '’': "'", '‚': "'", '“': `"`, '”': `"`, '„': `"`,
+	'«': `"`, '»': `"`, '–': "-", '—': "-", '…': "...", '•': "*", '·': ".",
+	'€': "EUR", '£': "GBP", '©': "(c)", '®': "(R)", '°': "deg", '±': "+/-",
+	'½': "1/2", '¼': "1/4", '¾': "3/4",
+}
+
+// transliterate replaces the non-ASCII characters of s with ASCII approximations, ü becoming ue and é e, and
+// anything without one with '?'.
+func transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			b.WriteRune(r)
+		case asciiPunct[r] != "":
+			b.WriteString(asciiPunct[r])
+		case r >= 0xc0 && r <= 0xff:
+			b.WriteString(latin1ASCII[r-0xc0])
+		case r >= 0x100 && r <= 0x17f:
+			b.WriteString(latinExtAASCII[r-0x100])
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// asciiOnly reports whether any of to is on a domain listed in ASCIIOnlyDomains.
+func (s *Service) asciiOnly(to []string) bool {
+	for _, addr := range to {
+		_, domain, ok := strings.Cut(suppressionKey(addr), "@")
+		if !ok {
+			continue
+		}
+		for _, d := range s.ASCIIOnlyDomains {
+			if strings.EqualFold(strings.TrimSpace(d), domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyASCII transliterates the subject and text parts of email when a recipient cannot handle anything but
+// ASCII, instead of sending encoded words it cannot parse. Like middleware it runs once per send, and Structure is
+// not kept in sync.
+func (s *Service) applyASCII(ctx context.Context, email MsgDef) (MsgDef, error) {
+	const op errors.Op = "email.Service.applyASCII"
+	if len(s.ASCIIOnlyDomains) == 0 {
+		return email, nil
+	}
+	// Unresolvable recipients are reported by prepareDelivery
+	to, err := s.resolveRecipients(ctx, email.To)
+	if err != nil || !s.asciiOnly(to) {
+		return email, nil
+	}
+	head, _, _ := strings.Cut(email.Msg, "\r\n\r\n")
+	if f, ok := lastField(headerFields(head), "Subject"); ok {
+		_, raw, _ := strings.Cut(f, ":")
+		raw = strings.TrimSpace(strings.ReplaceAll(raw, "\r\n", ""))
+		if subject, derr := new(mime.WordDecoder).DecodeHeader(raw); derr == nil {
+			raw = subject
+		}
+		email.Msg = setHeader(email.Msg, "Subject", transliterate(raw))
+	}
+	email.Msg, err = rewriteText(email.Msg, func(_, text string) string { return transliterate(text) })
+	if err != nil {
+		return email, errors.New(op).Err(err).Msg("failed to transliterate message")
+	}
+	return email, nil
+}
@@ -0,0 +1,75 @@
+package email
+
+import (
+	"context"
+	"mime"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestTransliterate(t *testing.T) {
+	if len(latin1ASCII) != 0x40 || len(latinExtAASCII) != 0x80 {
+		t.Fatalf("table sizes %d and %d", len(latin1ASCII), len(latinExtAASCII))
+	}
+	cases := map[string]string{
+		"Grüße aus München": "Gruesse aus Muenchen",
+		"Café Ørsted":       "Cafe Orsted",
+		"Łódź – “73”":       `Lodz - "73"`,
+		"Šťastný Œuvre":     "Stastny OEuvre",
+		"20 °C … 5 €":       "20 degC ... 5 EUR",
+		"日本":                "??",
+		"plain ascii":       "plain ascii",
+	}
+	for in, want := range cases {
+		if got := transliterate(in); got != want {
+			t.Errorf("transliterate(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSendTransliteratesForASCIIOnlyDomains(t *testing.T) {
+	s := &Service{
+		Config:           &types.EmailConfig{Enabled: true, Host: "smtp.example.org", Port: 587, From: "dl1abc@example.org"},
+		ASCIIOnlyDomains: []string{"sms.example"},
+	}
+	s.isInitialized.Store(true)
+
+	var sent string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent = string(msg)
+		return deliveryInfo{}, nil
+	})
+
+	build := func(to string) MsgDef {
+		hdr := make(textproto.MIMEHeader)
+		hdr.Set("From", "dl1abc@example.org")
+		hdr.Set("To", to)
+		hdr.Set("Subject", mime.QEncoding.Encode("utf-8", "Grüße vom Fieldday"))
+		msg, structure, err := composeTextMessage(hdr, "Schöne Grüße vom Fieldday-Zelt, 73 de DL1ABC")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return MsgDef{From: "dl1abc@example.org", To: []string{to}, Msg: msg, Structure: structure}
+	}
+
+	if _, err := s.SendWithResult(t.Context(), build("+4917012345@SMS.example")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sent, "Subject: Gruesse vom Fieldday\r\n") || strings.Contains(sent, "=?utf-8?") {
+		t.Fatalf("subject not transliterated:\n%s", sent)
+	}
+	if !strings.Contains(sent, "\r\n\r\nSchoene Gruesse vom Fieldday-Zelt, 73 de DL1ABC") {
+		t.Fatalf("body not transliterated:\n%s", sent)
+	}
+
+	if _, err := s.SendWithResult(t.Context(), build("op@example.net")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sent, "=?utf-8?") {
+		t.Fatalf("mail to other domains must be left alone:\n%s", sent)
+	}
+}
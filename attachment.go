@@ -0,0 +1,155 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+// maxPartDepth guards against pathological MIME nesting.
+const maxPartDepth = 8
+
+// Attachment is a decoded file carried by a message.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+	// Content, when set, is read for the attachment instead of Data when building a message, e.g. to attach an open
+	// file.
+	Content io.Reader
+}
+
+// Reader returns a reader over the attachment content.
+func (a Attachment) Reader() io.Reader {
+	return bytes.NewReader(a.Data)
+}
+
+// Attachments returns the decoded attachments of the message in the order they appear.
+func (m *InboundMessage) Attachments() ([]Attachment, error) {
+	const op errors.Op = "email.InboundMessage.Attachments"
+	var (
+		out     []Attachment
+		partErr error
+	)
+	err := walkParts(m.Header.Get("Content-Type"), textproto.MIMEHeader(m.Header), m.Body, 0, func(hdr textproto.MIMEHeader, data []byte) bool {
+		if !isAttachmentPart(hdr) {
+			return true
+		}
+		decoded, derr := decodeTransferEncoding(hdr.Get("Content-Transfer-Encoding"), data)
+		if derr != nil {
+			partErr = derr
+			return false
+		}
+		mediaType, _, _ := mime.ParseMediaType(hdr.Get("Content-Type"))
+		if mediaType == "" {
+			mediaType = "application/octet-stream"
+		}
+		out = append(out, Attachment{Filename: attachmentFilename(hdr), ContentType: mediaType, Data: decoded})
+		return true
+	})
+	if err == nil {
+		err = partErr
+	}
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to decode attachments")
+	}
+	return out, nil
+}
+
+// walkParts calls fn for every leaf MIME part until fn returns false. Quoted-printable parts inside a multipart
+// body arrive already decoded (mime/multipart does this); other transfer encodings are left to the caller.
+func walkParts(contentType string, hdr textproto.MIMEHeader, body []byte, depth int, fn func(textproto.MIMEHeader, []byte) bool) error {
+	if depth > maxPartDepth {
+		return nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		fn(hdr, body)
+		return nil
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		p, perr := mr.NextPart()
+		if perr == io.EOF {
+			return nil
+		}
+		if perr != nil {
+			return perr
+		}
+		data, rerr := io.ReadAll(p)
+		if rerr != nil {
+			return rerr
+		}
+		stop := false
+		err = walkParts(p.Header.Get("Content-Type"), p.Header, data, depth+1, func(h textproto.MIMEHeader, d []byte) bool {
+			if !fn(h, d) {
+				stop = true
+				return false
+			}
+			return true
+		})
+		if err != nil || stop {
+			return err
+		}
+	}
+}
+
+func isAttachmentPart(hdr textproto.MIMEHeader) bool {
+	if d, params, err := mime.ParseMediaType(hdr.Get("Content-Disposition")); err == nil {
+		if d == "attachment" || params["filename"] != "" {
+			return true
+		}
+	}
+	if _, params, err := mime.ParseMediaType(hdr.Get("Content-Type")); err == nil && params["name"] != "" {
+		return true
+	}
+	return false
+}
+
+func attachmentFilename(hdr textproto.MIMEHeader) string {
+	name := ""
+	if _, params, err := mime.ParseMediaType(hdr.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		if _, params, err := mime.ParseMediaType(hdr.Get("Content-Type")); err == nil {
+			name = params["name"]
+		}
+	}
+	// Many clients still use RFC 2047 encoded words instead of RFC 2231 parameters
+	dec := new(mime.WordDecoder)
+	if decoded, err := dec.DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	return name
+}
+
+func decodeTransferEncoding(cte string, data []byte) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(cte)) {
+	case "base64":
+		// Line breaks are not part of the alphabet; strip them before decoding
+		clean := bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+				return -1
+			}
+			return r
+		}, data)
+		out := make([]byte, base64.StdEncoding.DecodedLen(len(clean)))
+		n, err := base64.StdEncoding.Decode(out, clean)
+		if err != nil {
+			return nil, err
+		}
+		return out[:n], nil
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(bytes.NewReader(data)))
+	default:
+		return data, nil
+	}
+}
@@ -0,0 +1,91 @@
+package email
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// FilenamePolicy constrains the attachment filenames sent to a recipient domain, for log robots that reject
+// otherwise valid submissions because of the filename alone.
+type FilenamePolicy struct {
+	// Extension replaces the filename extension, e.g. ".txt"; empty keeps it.
+	Extension string
+	// ASCIIOnly replaces everything but ASCII letters, digits, '.', '-' and '_' with '_'.
+	ASCIIOnly bool
+	// MaxLength caps the filename length in bytes, extension included, by shortening the name before it; zero
+	// leaves it unlimited.
+	MaxLength int
+}
+
+// filenamePolicy combines the policies of the recipients' domains: the strictest limits apply, and the extension
+// is that of the first recipient whose policy sets one.
+func (s *Service) filenamePolicy(to []string) (FilenamePolicy, bool) {
+	var (
+		out   FilenamePolicy
+		found bool
+	)
+	for _, addr := range to {
+		_, domain, ok := strings.Cut(suppressionKey(addr), "@")
+		if !ok {
+			continue
+		}
+		p, ok := s.FilenamePolicies[domain]
+		if !ok {
+			continue
+		}
+		found = true
+		if out.Extension == "" {
+			out.Extension = p.Extension
+		}
+		out.ASCIIOnly = out.ASCIIOnly || p.ASCIIOnly
+		if p.MaxLength > 0 && (out.MaxLength == 0 || p.MaxLength < out.MaxLength) {
+			out.MaxLength = p.MaxLength
+		}
+	}
+	return out, found
+}
+
+// attachmentName applies the filename policy of to, if any, to name.
+func (s *Service) attachmentName(to []string, name string) string {
+	p, ok := s.filenamePolicy(to)
+	if !ok {
+		return name
+	}
+	return p.apply(name)
+}
+
+func (p FilenamePolicy) apply(name string) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if p.Extension != "" {
+		ext = "." + strings.TrimPrefix(p.Extension, ".")
+	}
+	if p.ASCIIOnly {
+		stem, ext = asciiFilename(stem), asciiFilename(ext)
+	}
+	if p.MaxLength > 0 && len(stem)+len(ext) > p.MaxLength {
+		keep := max(p.MaxLength-len(ext), 1)
+		// Cut on a rune boundary so the name stays valid UTF-8
+		for keep > 0 && keep < len(stem) && !utf8.RuneStart(stem[keep]) {
+			keep--
+		}
+		stem = stem[:min(keep, len(stem))]
+	}
+	if stem == "" {
+		stem = "attachment"
+	}
+	return stem + ext
+}
+
+func asciiFilename(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '.' || r == '-' || r == '_' {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteByte('_')
+	}
+	return b.String()
+}
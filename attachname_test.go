@@ -0,0 +1,58 @@
+package email
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestFilenamePolicyApply(t *testing.T) {
+	cases := []struct {
+		policy FilenamePolicy
+		in     string
+		want   string
+	}{
+		{FilenamePolicy{}, "K1ABC log.adi", "K1ABC log.adi"},
+		{FilenamePolicy{Extension: "txt"}, "k1abc.adi", "k1abc.txt"},
+		{FilenamePolicy{Extension: ".txt"}, "k1abc", "k1abc.txt"},
+		{FilenamePolicy{ASCIIOnly: true}, "Müller Field Day.adi", "M_ller_Field_Day.adi"},
+		{FilenamePolicy{MaxLength: 12}, "20261015120000-export.adi", "20261015.adi"},
+		{FilenamePolicy{MaxLength: 8}, "ÜÜÜÜ.adi", "ÜÜ.adi"},
+		{FilenamePolicy{MaxLength: 7}, "ÜÜÜÜ.adi", "Ü.adi"},
+		{FilenamePolicy{ASCIIOnly: true, Extension: ".txt", MaxLength: 8}, "日本.adi", "__.txt"},
+	}
+	for _, c := range cases {
+		if got := c.policy.apply(c.in); got != c.want {
+			t.Errorf("%+v.apply(%q) = %q, want %q", c.policy, c.in, got, c.want)
+		}
+	}
+}
+
+func TestFilenamePolicyByRecipientDomain(t *testing.T) {
+	s := &Service{
+		Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.org", Port: 587, From: "k1abc@example.org"},
+		FilenamePolicies: map[string]FilenamePolicy{
+			"robot.example":     {Extension: ".txt", MaxLength: 20},
+			"strict.example":    {ASCIIOnly: true, MaxLength: 10},
+			"unrelated.example": {Extension: ".log"},
+		},
+	}
+	s.isInitialized.Store(true)
+
+	def, err := s.BuildEmailWithFile("", "log", "attached", []string{"Contest Robot <logs@Robot.example>"}, "Müller.adi", strings.NewReader("<EOH>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.Structure.Parts[1].Filename != "Müller.txt" {
+		t.Errorf("filename = %q, want Müller.txt", def.Structure.Parts[1].Filename)
+	}
+
+	p, _ := s.filenamePolicy([]string{"a@elsewhere.example", "logs@robot.example", "x@strict.example"})
+	if p != (FilenamePolicy{Extension: ".txt", ASCIIOnly: true, MaxLength: 10}) {
+		t.Errorf("combined policy = %+v", p)
+	}
+	if _, ok := s.filenamePolicy([]string{"a@elsewhere.example"}); ok {
+		t.Error("policy applied to an unlisted domain")
+	}
+}
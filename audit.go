@@ -0,0 +1,184 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+// AuditKind is what an audited change was made to.
+type AuditKind string
+
+const (
+	AuditConfig      AuditKind = "config"
+	AuditTemplate    AuditKind = "template"
+	AuditSuppression AuditKind = "suppression"
+	// AuditRecipients is for changes to recipient lists kept by the host, e.g. behind a RecipientResolver, which
+	// it records with RecordAudit.
+	AuditRecipients AuditKind = "recipients"
+)
+
+// AuditEntry records one change: who made it, when, and what changed.
+type AuditEntry struct {
+	At time.Time
+	// Actor is the ID of the Caller in the context of the change; empty when none was given, e.g. for a change
+	// made by the service itself.
+	Actor   string
+	Kind    AuditKind
+	Action  string
+	Subject string
+	Detail  string
+}
+
+// AuditStore persists the audit log. It only ever appends.
+type AuditStore interface {
+	Append(e AuditEntry) error
+	Load() ([]AuditEntry, error)
+}
+
+type auditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+}
+
+func (l *auditLog) append(e AuditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, e)
+}
+
+func (l *auditLog) snapshot() []AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]AuditEntry(nil), l.entries...)
+}
+
+// RecordAudit appends e to the audit log, stamping its time and taking its actor from the Caller in ctx when not
+// set. Changes made through the Service are recorded already; this is for those the host makes itself.
+func (s *Service) RecordAudit(ctx context.Context, e AuditEntry) error {
+	const op errors.Op = "email.Service.RecordAudit"
+	if e.At.IsZero() {
+		e.At = s.now().UTC()
+	}
+	if c, ok := CallerFromContext(ctx); ok && e.Actor == "" {
+		e.Actor = c.ID
+	}
+	if s.AuditStore != nil {
+		if err := s.AuditStore.Append(e); err != nil {
+			return errors.New(op).Err(err).Msg("failed to persist audit entry")
+		}
+	}
+	s.audit.append(e)
+	return nil
+}
+
+// AuditLog returns the audit log, oldest first: from the AuditStore if one is set, which holds the entries of
+// earlier runs too.
+func (s *Service) AuditLog() ([]AuditEntry, error) {
+	const op errors.Op = "email.Service.AuditLog"
+	if s.AuditStore == nil {
+		return s.audit.snapshot(), nil
+	}
+	list, err := s.AuditStore.Load()
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to load audit log")
+	}
+	return list, nil
+}
+
+// audited records a change the Service made. A change already made is not undone because its entry could not be
+// persisted; the failure is logged instead.
+func (s *Service) audited(ctx context.Context, e AuditEntry) {
+	if err := s.RecordAudit(ctx, e); err != nil {
+		s.LoggerService.ErrorWith().Err(err).Str("kind", string(e.Kind)).Str("subject", e.Subject).
+			Msg("failed to record audit entry")
+	}
+}
+
+// configChanges lists the names of the settings that differ between before and after. Values are left out, so
+// that the log never holds a password.
+func configChanges(before, after types.EmailConfig) string {
+	o, n := reflect.ValueOf(before), reflect.ValueOf(after)
+	var changed []string
+	for i := range o.NumField() {
+		if o.Type().Field(i).IsExported() && !reflect.DeepEqual(o.Field(i).Interface(), n.Field(i).Interface()) {
+			changed = append(changed, o.Type().Field(i).Name)
+		}
+	}
+	if len(changed) == 0 {
+		return "no changes"
+	}
+	return "changed " + strings.Join(changed, ", ")
+}
+
+// FileAuditStore keeps the audit log as a file of JSON lines, opened for appending only.
+type FileAuditStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (f *FileAuditStore) Append(e AuditEntry) error {
+	const op errors.Op = "email.FileAuditStore.Append"
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to encode audit entry")
+	}
+	if err = os.MkdirAll(filepath.Dir(f.Path), 0o700); err != nil {
+		return errors.New(op).Err(err).Msg("failed to create audit directory")
+	}
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to open audit log")
+	}
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		_ = file.Close()
+		return errors.New(op).Err(err).Msg("failed to write audit entry")
+	}
+	if err = file.Close(); err != nil {
+		return errors.New(op).Err(err).Msg("failed to write audit entry")
+	}
+	return nil
+}
+
+func (f *FileAuditStore) Load() ([]AuditEntry, error) {
+	const op errors.Op = "email.FileAuditStore.Load"
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.Open(f.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to read audit log")
+	}
+	defer func() { _ = file.Close() }()
+	var out []AuditEntry
+	sc := bufio.NewScanner(file)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e AuditEntry
+		if err = json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, errors.New(op).Err(err).Msg("failed to decode audit log")
+		}
+		out = append(out, e)
+	}
+	if err = sc.Err(); err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to read audit log")
+	}
+	return out, nil
+}
@@ -0,0 +1,75 @@
+package email
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestAuditLogRecordsChanges(t *testing.T) {
+	dir := t.TempDir()
+	store := &FileAuditStore{Path: filepath.Join(dir, "audit.jsonl")}
+	s := &Service{
+		Config:     &types.EmailConfig{Enabled: true, Host: "smtp.example.org", Port: 587, From: "op@example.org", Username: "op", Password: "old-secret"},
+		Templates:  &FileTemplateStore{Dir: dir},
+		AuditStore: store,
+	}
+	s.isInitialized.Store(true)
+	ctx := WithCaller(context.Background(), Caller{ID: "g4abc", Role: "admin"})
+
+	cfg := s.CurrentConfig()
+	cfg.Host, cfg.Password = "mail.example.org", "new-secret"
+	if err := s.SetConfigContext(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	tpl, _ := s.Template(TemplateADIFExport)
+	tpl.Subject = "{{.Callsign}} log"
+	if _, err := s.SaveTemplateContext(ctx, tpl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RollbackTemplate(TemplateADIFExport, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SuppressContext(ctx, Suppression{Address: "Spam@Example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordAudit(ctx, AuditEntry{Kind: AuditRecipients, Action: "add", Subject: "club-net", Detail: "m0xyz@example.org"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh store on the same file sees every entry, as after a restart
+	s = &Service{AuditStore: &FileAuditStore{Path: store.Path}}
+	log, err := s.AuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 5 {
+		t.Fatalf("expected 5 entries, got %+v", log)
+	}
+	want := []struct {
+		kind          AuditKind
+		action, actor string
+	}{
+		{AuditConfig, "set", "g4abc"},
+		{AuditTemplate, "save", "g4abc"},
+		{AuditTemplate, "rollback to version 1", ""},
+		{AuditSuppression, "suppress", "g4abc"},
+		{AuditRecipients, "add", "g4abc"},
+	}
+	for i, w := range want {
+		if e := log[i]; e.Kind != w.kind || e.Action != w.action || e.Actor != w.actor || e.At.IsZero() {
+			t.Errorf("entry %d = %+v, want %+v", i, e, w)
+		}
+	}
+	if d := log[0].Detail; d != "changed Password, Host" {
+		t.Errorf("config detail = %q", d)
+	}
+	for _, e := range log {
+		if strings.Contains(e.Detail, "secret") {
+			t.Errorf("audit entry leaks a password: %+v", e)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package email
+
+import (
+	"context"
+	stderr "errors"
+	"net/smtp"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+// Built-in SMTP authentication mechanisms selectable with Service.AuthMechanism.
+const (
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
+)
+
+// AuthFactory builds the smtp.Auth used for a send from the configured credentials and the server host name.
+type AuthFactory func(username, password, host string) (smtp.Auth, error)
+
+var builtinAuth = map[string]AuthFactory{
+	AuthPlain: func(username, password, host string) (smtp.Auth, error) {
+		return smtp.PlainAuth("", username, password, host), nil
+	},
+	AuthLogin: func(username, password, _ string) (smtp.Auth, error) {
+		return &loginAuth{username: username, password: password}, nil
+	},
+	AuthCRAMMD5: func(username, password, _ string) (smtp.Auth, error) {
+		return smtp.CRAMMD5Auth(username, password), nil
+	},
+	AuthXOAUTH2: func(username, token, _ string) (smtp.Auth, error) {
+		return &xoauth2Auth{username: username, token: token}, nil
+	},
+}
+
+// authFactory returns the factory for the configured mechanism; caller-registered mechanisms take precedence
+// over the built-in ones.
+func (s *Service) authFactory() (AuthFactory, error) {
+	const op errors.Op = "email.Service.authFactory"
+	name := strings.ToLower(strings.TrimSpace(s.AuthMechanism))
+	if name == "" {
+		name = AuthPlain
+	}
+	if s.StrictCrypto && isWeakAuthMechanism(name) {
+		return nil, errors.New(op).Msgf("SMTP auth mechanism %q is not allowed in strict crypto mode", s.AuthMechanism)
+	}
+	for k, f := range s.AuthMechanisms {
+		if strings.EqualFold(k, name) && f != nil {
+			return f, nil
+		}
+	}
+	if f, ok := builtinAuth[name]; ok {
+		return f, nil
+	}
+	return nil, errors.New(op).Msgf("unknown SMTP auth mechanism %q", s.AuthMechanism)
+}
+
+// smtpAuth returns the auth for a send, or nil when no username is configured. With AuthXOAUTH2 and an
+// OAuth2Config, the password is replaced by a current access token.
+func (s *Service) smtpAuth(ctx context.Context, username, password, host string) (smtp.Auth, error) {
+	const op errors.Op = "email.Service.smtpAuth"
+	if username == "" {
+		return nil, nil
+	}
+	factory, err := s.authFactory()
+	if err != nil {
+		return nil, err
+	}
+	if s.usesOAuth2() {
+		if password, err = s.OAuth2.accessToken(ctx); err != nil {
+			return nil, errors.New(op).Err(err).Msg(err.Error())
+		}
+	}
+	auth, err := factory(username, password, host)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to create SMTP auth")
+	}
+	if s.StrictCrypto {
+		auth = strictAuth{auth}
+	}
+	return auth, nil
+}
+
+// loginAuth implements the non-standard but widespread AUTH LOGIN mechanism (Exchange, Office 365).
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		return "", nil, stderr.New("unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, stderr.New("unexpected server challenge " + string(fromServer))
+}
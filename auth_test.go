@@ -0,0 +1,62 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+type xoauthStub struct{ user, token string }
+
+func (a *xoauthStub) Start(*smtp.ServerInfo) (string, []byte, error) {
+	return "XOAUTH2", []byte("user=" + a.user + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a *xoauthStub) Next([]byte, bool) ([]byte, error) { return nil, nil }
+
+func TestCustomAuthMechanism(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com", Username: "op", Password: "token"}}
+	s.AuthMechanism = "XOAUTH2"
+	s.AuthMechanisms = map[string]AuthFactory{
+		"xoauth2": func(username, password, host string) (smtp.Auth, error) {
+			return &xoauthStub{user: username, token: password}, nil
+		},
+	}
+	s.isInitialized.Store(true)
+
+	var got smtp.Auth
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		got = auth
+		return deliveryInfo{}, nil
+	})
+
+	if err := s.Send(MsgDef{To: []string{"to@example.com"}, Msg: "Subject: hi\r\n\r\nbody"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if a, ok := got.(*xoauthStub); !ok || a.user != "op" || a.token != "token" {
+		t.Fatalf("expected the registered auth to be used, got %#v", got)
+	}
+
+	s.AuthMechanism = "gssapi"
+	if err := s.Send(MsgDef{To: []string{"to@example.com"}, Msg: "Subject: hi\r\n\r\nbody"}); err == nil {
+		t.Fatalf("expected an unknown mechanism to fail")
+	}
+}
+
+func TestLoginAuth(t *testing.T) {
+	f, _ := (&Service{AuthMechanism: AuthLogin}).authFactory()
+	a, _ := f("op", "secret", "smtp.example.com")
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "smtp.example.com"}); err == nil {
+		t.Fatalf("LOGIN must refuse an unencrypted connection")
+	}
+	if mech, _, err := a.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: true}); err != nil || mech != "LOGIN" {
+		t.Fatalf("unexpected start: %q, %v", mech, err)
+	}
+	for challenge, want := range map[string]string{"Username:": "op", "Password:": "secret"} {
+		if resp, err := a.Next([]byte(challenge), true); err != nil || string(resp) != want {
+			t.Fatalf("challenge %q: got %q, %v", challenge, resp, err)
+		}
+	}
+}
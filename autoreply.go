@@ -0,0 +1,153 @@
+package email
+
+import (
+	"io"
+	"mime"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// AutoReplyRule describes a single automatic reply. Rules are evaluated in order and the first match wins.
+type AutoReplyRule struct {
+	Name string
+	// SubjectContains restricts the rule to subjects containing this text (case-insensitive).
+	SubjectContains string
+	// RequireAttachment restricts the rule to messages carrying an attachment, e.g. log submissions.
+	RequireAttachment bool
+	// UnknownSenderOnly restricts the rule to senders not listed in AutoReplyConfig.KnownSenders.
+	UnknownSenderOnly bool
+	// Subject of the reply; defaults to "Re: <original subject>".
+	Subject string
+	Body    string
+}
+
+// AutoReplyConfig holds the auto-reply rules for the inbound mailbox.
+type AutoReplyConfig struct {
+	Enabled bool
+	// From is the reply sender; defaults to the configured email from address.
+	From         string
+	KnownSenders []string
+	Rules        []AutoReplyRule
+}
+
+// AutoReply evaluates the configured auto-reply rules against an inbound message and sends the reply of the
+// first matching rule. Messages that are themselves automated are never answered.
+func (s *Service) AutoReply(msg *InboundMessage) error {
+	const op errors.Op = "email.Service.AutoReply"
+	if !s.isInitialized.Load() {
+		return errors.New(op).Msg(errMsgNotInitialized)
+	}
+	if s.AutoReplyConfig == nil || !s.AutoReplyConfig.Enabled || msg == nil {
+		return nil
+	}
+
+	reply, ok, err := s.AutoReplyConfig.buildReply(msg, s.config().From, s.random(), s.now())
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to build auto-reply")
+	}
+	if !ok {
+		return nil
+	}
+	return s.Send(reply)
+}
+
+// match returns the first rule applicable to msg, or nil when none applies.
+func (c *AutoReplyConfig) match(msg *InboundMessage) *AutoReplyRule {
+	sender := msg.Sender()
+	if sender == "" || isAutomatedMessage(msg) {
+		return nil
+	}
+	known := false
+	for _, k := range c.KnownSenders {
+		if strings.EqualFold(strings.TrimSpace(k), sender) {
+			known = true
+			break
+		}
+	}
+	subject := strings.ToLower(msg.Subject())
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if r.UnknownSenderOnly && known {
+			continue
+		}
+		if r.SubjectContains != "" && !strings.Contains(subject, strings.ToLower(r.SubjectContains)) {
+			continue
+		}
+		if r.RequireAttachment && !msg.HasAttachment() {
+			continue
+		}
+		return r
+	}
+	return nil
+}
+
+func (c *AutoReplyConfig) buildReply(msg *InboundMessage, defaultFrom string, r io.Reader, now time.Time) (MsgDef, bool, error) {
+	rule := c.match(msg)
+	if rule == nil {
+		return MsgDef{}, false, nil
+	}
+	from := strings.TrimSpace(c.From)
+	if from == "" {
+		from = strings.TrimSpace(defaultFrom)
+	}
+	// Never answer ourselves
+	if strings.EqualFold(from, msg.Sender()) {
+		return MsgDef{}, false, nil
+	}
+
+	subject := rule.Subject
+	if subject == "" {
+		subject = msg.Subject()
+		if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+			subject = "Re: " + subject
+		}
+	}
+
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("From", from)
+	hdr.Set("To", msg.Sender())
+	hdr.Set("Subject", mime.QEncoding.Encode("utf-8", subject))
+	hdr.Set("Date", now.UTC().Format(time.RFC1123Z))
+	hdr.Set("Message-ID", generateMessageID(r, now))
+	hdr.Set("Auto-Submitted", "auto-replied")
+	if mid := strings.TrimSpace(msg.Header.Get("Message-Id")); mid != "" {
+		hdr.Set("In-Reply-To", mid)
+		refs := strings.TrimSpace(msg.Header.Get("References"))
+		hdr.Set("References", strings.TrimSpace(refs+" "+mid))
+	}
+
+	body, structure, err := composeTextMessage(hdr, rule.Body)
+	if err != nil {
+		return MsgDef{}, false, err
+	}
+	return MsgDef{From: from, To: []string{msg.Sender()}, Msg: body, Structure: structure}, true, nil
+}
+
+// isAutomatedMessage implements the RFC 3834 loop-prevention checks for automatic responders.
+func isAutomatedMessage(msg *InboundMessage) bool {
+	if v := strings.ToLower(strings.TrimSpace(msg.Header.Get("Auto-Submitted"))); v != "" && v != "no" {
+		return true
+	}
+	switch strings.ToLower(strings.TrimSpace(msg.Header.Get("Precedence"))) {
+	case "bulk", "list", "junk", "auto_reply":
+		return true
+	}
+	if msg.Header.Get("List-Id") != "" || msg.Header.Get("List-Unsubscribe") != "" {
+		return true
+	}
+	if suppress := strings.ToLower(msg.Header.Get("X-Auto-Response-Suppress")); strings.Contains(suppress, "all") || strings.Contains(suppress, "autoreply") {
+		return true
+	}
+	if strings.TrimSpace(msg.Header.Get("Return-Path")) == "<>" {
+		return true
+	}
+	local, _, _ := strings.Cut(msg.Sender(), "@")
+	switch local {
+	case "mailer-daemon", "postmaster", "noreply", "no-reply", "donotreply", "do-not-reply":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+const testLogSubmission = "From: K1ABC <k1abc@example.com>\r\n" +
+	"To: logs@club.example.org\r\n" +
+	"Subject: Contest log\r\n" +
+	"Message-ID: <orig-1@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"my log\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/octet-stream; name=\"k1abc.adi\"\r\n" +
+	"Content-Disposition: attachment; filename=\"k1abc.adi\"\r\n" +
+	"\r\n" +
+	"<EOH>\r\n" +
+	"--b1--\r\n"
+
+func testAutoReplyConfig() *AutoReplyConfig {
+	return &AutoReplyConfig{
+		Enabled:      true,
+		KnownSenders: []string{"member@club.example.org"},
+		Rules: []AutoReplyRule{
+			{Name: "ack", SubjectContains: "log", RequireAttachment: true, Body: "Log received, thanks."},
+			{Name: "unknown", UnknownSenderOnly: true, Subject: "Instructions", Body: "Attach your ADIF log."},
+		},
+	}
+}
+
+func TestAutoReplyAcknowledgesLogSubmission(t *testing.T) {
+	msg, err := ParseInbound(strings.NewReader(testLogSubmission))
+	if err != nil {
+		t.Fatalf("ParseInbound failed: %v", err)
+	}
+	if !msg.HasAttachment() {
+		t.Fatalf("expected attachment to be detected")
+	}
+	reply, ok, err := testAutoReplyConfig().buildReply(msg, "logs@club.example.org", nil, time.Now())
+	if err != nil || !ok {
+		t.Fatalf("expected reply, ok=%v err=%v", ok, err)
+	}
+	if len(reply.To) != 1 || reply.To[0] != "k1abc@example.com" {
+		t.Fatalf("unexpected reply recipients: %v", reply.To)
+	}
+	for _, want := range []string{"Auto-Submitted: auto-replied", "In-Reply-To: <orig-1@example.com>", "Subject: Re: Contest log", "Log received, thanks."} {
+		if !strings.Contains(reply.Msg, want) {
+			t.Errorf("reply missing %q", want)
+		}
+	}
+}
+
+func TestAutoReplyUnknownSenderAndLoopPrevention(t *testing.T) {
+	cfg := testAutoReplyConfig()
+
+	unknown, _ := ParseInbound(strings.NewReader("From: stranger@example.net\r\nSubject: hello\r\n\r\nhi\r\n"))
+	reply, ok, err := cfg.buildReply(unknown, "logs@club.example.org", nil, time.Now())
+	if err != nil || !ok || !strings.Contains(reply.Msg, "Subject: Instructions") {
+		t.Fatalf("expected instructions reply, ok=%v err=%v", ok, err)
+	}
+
+	known, _ := ParseInbound(strings.NewReader("From: member@club.example.org\r\nSubject: hello\r\n\r\nhi\r\n"))
+	if _, ok, _ = cfg.buildReply(known, "logs@club.example.org", nil, time.Now()); ok {
+		t.Errorf("known sender without log should not get a reply")
+	}
+
+	for _, hdr := range []string{"Auto-Submitted: auto-replied", "Precedence: bulk", "List-Id: <club.example.org>"} {
+		automated, _ := ParseInbound(strings.NewReader("From: stranger@example.net\r\n" + hdr + "\r\nSubject: hello\r\n\r\nhi\r\n"))
+		if _, ok, _ = cfg.buildReply(automated, "logs@club.example.org", nil, time.Now()); ok {
+			t.Errorf("expected no reply to message with %q", hdr)
+		}
+	}
+}
+
+func TestServiceAutoReplySends(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "logs@club.example.org"}}
+	s.AutoReplyConfig = testAutoReplyConfig()
+	s.isInitialized.Store(true)
+
+	var sent []string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent = append(sent, to...)
+		return deliveryInfo{}, nil
+	})
+
+	msg, _ := ParseInbound(strings.NewReader(testLogSubmission))
+	if err := s.AutoReply(msg); err != nil {
+		t.Fatalf("AutoReply failed: %v", err)
+	}
+	if len(sent) != 1 || sent[0] != "k1abc@example.com" {
+		t.Fatalf("unexpected recipients: %v", sent)
+	}
+}
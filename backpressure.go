@@ -0,0 +1,60 @@
+package email
+
+import (
+	"context"
+	stderr "errors"
+	"fmt"
+)
+
+// BackpressureError is returned by the Send methods when the outbound queue has reached QueueConfig.MaxDepth.
+// Callers should slow down and try again once QueueDepth drops.
+type BackpressureError struct {
+	Depth    int
+	MaxDepth int
+}
+
+func (e *BackpressureError) Error() string {
+	return fmt.Sprintf("outbound queue is full (%d of %d messages)", e.Depth, e.MaxDepth)
+}
+
+// admit lets a new message through while the queue is below QueueConfig.MaxDepth. A full queue fails fast with a
+// BackpressureError or, with QueueConfig.BlockWhenFull, waits for room until ctx is done.
+func (s *Service) admit(ctx context.Context) error {
+	max := s.QueueConfig.MaxDepth
+	if max <= 0 {
+		return nil
+	}
+	for {
+		depth, shrunk := s.queue.depthAndShrunk()
+		if depth < max {
+			return nil
+		}
+		bp := &BackpressureError{Depth: depth, MaxDepth: max}
+		if !s.QueueConfig.BlockWhenFull {
+			return bp
+		}
+		select {
+		case <-shrunk:
+		case <-ctx.Done():
+			return stderr.Join(bp, ctx.Err())
+		}
+	}
+}
+
+// depthAndShrunk returns the queue depth and a channel closed the next time a message leaves the queue.
+func (q *outboundQueue) depthAndShrunk() (int, <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.shrunk == nil {
+		q.shrunk = make(chan struct{})
+	}
+	return len(q.items), q.shrunk
+}
+
+// signalShrunk wakes callers blocked on a full queue; q.mu must be held.
+func (q *outboundQueue) signalShrunk() {
+	if q.shrunk != nil {
+		close(q.shrunk)
+		q.shrunk = nil
+	}
+}
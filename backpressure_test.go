@@ -0,0 +1,52 @@
+package email
+
+import (
+	"context"
+	stderr "errors"
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestSendSignalsBackpressure(t *testing.T) {
+	s := &Service{
+		Config:      &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"},
+		QueueConfig: QueueConfig{MaxDepth: 1},
+	}
+	s.isInitialized.Store(true)
+
+	sent := 0
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent++
+		return deliveryInfo{}, nil
+	})
+
+	msg := MsgDef{To: []string{"to@example.com"}, Msg: "Subject: hi\r\n\r\nbody"}
+	s.queue.push(&QueuedMessage{ID: "q1", NextAttempt: time.Now().Add(time.Hour)})
+
+	err := s.Send(msg)
+	var bp *BackpressureError
+	if !stderr.As(err, &bp) || bp.Depth != 1 || bp.MaxDepth != 1 {
+		t.Fatalf("expected a BackpressureError, got %v", err)
+	}
+	if sent != 0 {
+		t.Fatal("a refused message must not be attempted")
+	}
+
+	s.QueueConfig.BlockWhenFull = true
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	if err = s.SendContext(ctx, msg); !stderr.As(err, &bp) || !stderr.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the blocked send to give up with the context, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.queue.remove("q1")
+	}()
+	if err = s.SendContext(t.Context(), msg); err != nil || sent != 1 {
+		t.Fatalf("expected the blocked send to go out once the queue drained, err=%v sent=%d", err, sent)
+	}
+}
@@ -0,0 +1,121 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/adif"
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+// RecipientData is one message of a BuildBatch mail merge.
+type RecipientData struct {
+	// To are the message's recipients; empty uses the configured recipients.
+	To []string
+	// Data is what the template is rendered with; nil renders it with the ExportMeta of QSOs.
+	Data any
+	// QSOs are attached as ADIF, when any are given. Messages given the same slice share one composition of it.
+	QSOs []types.Qso
+}
+
+// batchADIF is an ADIF export shared by the messages of a batch.
+type batchADIF struct {
+	data []byte
+	meta ExportMeta
+	hash string
+}
+
+// BuildBatch builds a message from tmpl for each entry of recipients, e.g. to send every contest participant
+// their QSOs. The template is parsed once for the batch, so the cost of many small messages is dominated by
+// their composition. It fails on the first message that cannot be built.
+func (s *Service) BuildBatch(tmpl Template, recipients []RecipientData) ([]MsgDef, error) {
+	const op errors.Op = "email.Service.BuildBatch"
+	ct, err := tmpl.compile()
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg(err.Error())
+	}
+	from := strings.TrimSpace(s.config().From)
+	now := s.now()
+	type sliceKey struct {
+		first *types.Qso
+		n     int
+	}
+	exports := map[sliceKey]*batchADIF{}
+	defs := make([]MsgDef, 0, len(recipients))
+	for i, r := range recipients {
+		to := r.To
+		if len(to) == 0 {
+			to = s.defaultTo(false)
+		}
+		if to, err = s.resolveRecipients(context.Background(), to); err != nil {
+			return nil, errors.New(op).Err(err).Msgf("message %d: failed to resolve recipients", i)
+		}
+		if len(to) == 0 {
+			return nil, errors.New(op).Msgf("message %d: email TO address cannot be empty", i)
+		}
+
+		data := r.Data
+		var atts []Attachment
+		var export *batchADIF
+		if len(r.QSOs) > 0 {
+			key := sliceKey{&r.QSOs[0], len(r.QSOs)}
+			if export = exports[key]; export == nil {
+				if export, err = composeBatchADIF(s.adifHeader(), r.QSOs); err != nil {
+					return nil, errors.New(op).Err(err).Msgf("message %d: failed to compose ADIF record", i)
+				}
+				exports[key] = export
+			}
+			meta := export.meta
+			meta.Filename = s.attachmentName(to, fmt.Sprintf("%s-export.adi", now.Format("20060102150405")))
+			if data == nil {
+				data = meta
+			}
+			atts = []Attachment{{Filename: meta.Filename, ContentType: "application/octet-stream", Data: export.data}}
+		}
+		out, err := ct.render(data)
+		if err != nil {
+			return nil, errors.New(op).Err(err).Msgf("message %d: %v", i, err)
+		}
+
+		hdr := make(textproto.MIMEHeader)
+		hdr.Set("From", from)
+		hdr.Set("To", s.headerTo(to))
+		hdr.Set("Subject", mime.QEncoding.Encode("utf-8", strings.TrimSpace(out.Subject)))
+		hdr.Set("Date", now.UTC().Format(time.RFC1123Z))
+		hdr.Set("Message-ID", generateMessageID(s.random(), now))
+		raw, structure, err := composeMessage(s.random(), hdr, out.Text, out.HTML, atts)
+		if err != nil {
+			return nil, errors.New(op).Err(err).Msgf("message %d: failed to compose message", i)
+		}
+		def := MsgDef{From: from, To: to, Msg: raw, Structure: structure}
+		if export != nil {
+			def.Category, def.ExportHash = CategoryExport, export.hash
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+func composeBatchADIF(header *adif.HeaderSection, qsos []types.Qso) (*batchADIF, error) {
+	var b strings.Builder
+	b.WriteString(header.String())
+	var set qsoSetHash
+	export := &batchADIF{}
+	for _, q := range qsos {
+		rec, err := adif.ConvertQsoToAdifNoHeader(q)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(rec)
+		export.meta.observe(q)
+		set.add(rec)
+	}
+	export.meta.finish()
+	export.data, export.hash = []byte(b.String()), set.String()
+	return export, nil
+}
@@ -0,0 +1,58 @@
+package email
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestBuildBatch(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{From: "contest@club.example.org", To: "log@club.example.org"}}
+	shared := batchQSOs(3)
+	tmpl := Template{Name: "participant", Subject: "{{.QSOCount}} QSOs", Body: "Attached: {{.Filename}}"}
+	defs, err := s.BuildBatch(tmpl, []RecipientData{
+		{To: []string{"k1abc@example.com"}, QSOs: shared},
+		{To: []string{"w2xyz@example.com"}, QSOs: shared},
+		{To: []string{"n3def@example.com"}, QSOs: batchQSOs(1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 3 {
+		t.Fatalf("got %d messages", len(defs))
+	}
+	for i, want := range []string{"3 QSOs", "3 QSOs", "1 QSOs"} {
+		in, err := ParseInbound(strings.NewReader(defs[i].Msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		atts, err := in.Attachments()
+		if in.Subject() != want || err != nil || len(atts) != 1 || !strings.Contains(defs[i].Msg, "Attached: "+atts[0].Filename) {
+			t.Errorf("message %d: subject %q, attachments %v, %v", i, in.Subject(), len(atts), err)
+		}
+		if defs[i].Category != CategoryExport || defs[i].ExportHash == "" {
+			t.Errorf("message %d: category %q, hash %q", i, defs[i].Category, defs[i].ExportHash)
+		}
+	}
+	if defs[0].ExportHash != defs[1].ExportHash || defs[0].ExportHash == defs[2].ExportHash {
+		t.Errorf("export hashes %q, %q, %q", defs[0].ExportHash, defs[1].ExportHash, defs[2].ExportHash)
+	}
+
+	// Data replaces the ExportMeta, and a message without QSOs goes to the configured recipients
+	defs, err = s.BuildBatch(Template{Name: "note", Subject: "73 {{.}}", Body: "Thanks"}, []RecipientData{{Data: "K1ABC"}})
+	if err != nil || len(defs) != 1 || defs[0].To[0] != "log@club.example.org" || !strings.Contains(defs[0].Msg, "Subject: 73 K1ABC") {
+		t.Fatalf("err=%v defs=%+v", err, defs)
+	}
+	if _, err = s.BuildBatch(Template{Name: "bad", Body: "{{.Missing}}"}, []RecipientData{{Data: map[string]string{}}}); err == nil {
+		t.Fatalf("expected a rendering error")
+	}
+}
+
+func batchQSOs(n int) []types.Qso {
+	qs := make([]types.Qso, n)
+	for i := range qs {
+		qs[i] = types.Qso{LogbookID: 1, SessionID: int64(i)}
+	}
+	return qs
+}
@@ -0,0 +1,213 @@
+package email
+
+import (
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// MessageBuilder composes a message from its parts without a Service, for callers that need more than the ADIF
+// builders offer:
+//
+//	def, err := email.NewMessage().
+//		From("Station <op@example.org>").
+//		To("qsl@club.example").
+//		Subject("Rig alert").
+//		TextBody("SWR high on 20m").
+//		Attach("swr.csv", "text/csv", data).
+//		Build()
+//
+// Errors, such as an unparsable address, are reported by Build.
+type MessageBuilder struct {
+	from     string
+	to       []string
+	cc       []string
+	bcc      []string
+	replyTo  string
+	subject  string
+	body     string
+	html     string
+	atts     []Attachment
+	extra    textproto.MIMEHeader
+	priority Priority
+	ttl      time.Duration
+	random   io.Reader
+	clock    Clock
+}
+
+// NewMessage starts an empty message.
+func NewMessage() *MessageBuilder {
+	return &MessageBuilder{extra: make(textproto.MIMEHeader)}
+}
+
+// From sets the sender, a bare address or one with a display name.
+func (b *MessageBuilder) From(addr string) *MessageBuilder {
+	b.from = addr
+	return b
+}
+
+// To adds recipients listed in the To header.
+func (b *MessageBuilder) To(addrs ...string) *MessageBuilder {
+	b.to = append(b.to, addrs...)
+	return b
+}
+
+// Cc adds recipients listed in the Cc header.
+func (b *MessageBuilder) Cc(addrs ...string) *MessageBuilder {
+	b.cc = append(b.cc, addrs...)
+	return b
+}
+
+// Bcc adds recipients that receive the message without being listed in it.
+func (b *MessageBuilder) Bcc(addrs ...string) *MessageBuilder {
+	b.bcc = append(b.bcc, addrs...)
+	return b
+}
+
+// ReplyTo sets where replies should go instead of the sender.
+func (b *MessageBuilder) ReplyTo(addr string) *MessageBuilder {
+	b.replyTo = addr
+	return b
+}
+
+// Subject sets the subject; non-ASCII text is encoded as needed.
+func (b *MessageBuilder) Subject(subject string) *MessageBuilder {
+	b.subject = subject
+	return b
+}
+
+// TextBody sets the text/plain body.
+func (b *MessageBuilder) TextBody(body string) *MessageBuilder {
+	b.body = body
+	return b
+}
+
+// HTMLBody sets an HTML version of the body, sent as a multipart/alternative with the text body, which mail
+// clients that do not show HTML fall back to.
+func (b *MessageBuilder) HTMLBody(html string) *MessageBuilder {
+	b.html = html
+	return b
+}
+
+// Attach adds a file; an empty contentType is sent as application/octet-stream.
+func (b *MessageBuilder) Attach(filename, contentType string, data []byte) *MessageBuilder {
+	b.atts = append(b.atts, Attachment{Filename: filename, ContentType: contentType, Data: data})
+	return b
+}
+
+// Header sets an additional header field. The fields describing the MIME structure are set by Build and cannot be
+// overridden.
+func (b *MessageBuilder) Header(name, value string) *MessageBuilder {
+	b.extra.Set(name, value)
+	return b
+}
+
+// Priority sets MsgDef.Priority.
+func (b *MessageBuilder) Priority(p Priority) *MessageBuilder {
+	b.priority = p
+	return b
+}
+
+// TTL sets MsgDef.TTL.
+func (b *MessageBuilder) TTL(ttl time.Duration) *MessageBuilder {
+	b.ttl = ttl
+	return b
+}
+
+// Random sets the entropy source of the Message-ID and multipart boundaries, e.g. a fixed one for reproducible
+// output in tests; by default crypto/rand.
+func (b *MessageBuilder) Random(r io.Reader) *MessageBuilder {
+	b.random = r
+	return b
+}
+
+// Clock sets the source of the time in the Date and Message-ID, e.g. Service.Clock; by default the system clock.
+func (b *MessageBuilder) Clock(c Clock) *MessageBuilder {
+	b.clock = c
+	return b
+}
+
+// Build renders the message. It needs a sender and at least one recipient.
+func (b *MessageBuilder) Build() (MsgDef, error) {
+	const op errors.Op = "email.MessageBuilder.Build"
+	from, err := mail.ParseAddress(strings.TrimSpace(b.from))
+	if err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msgf("invalid sender %q", b.from)
+	}
+	var envelope []string
+	list := func(addrs []string) (string, error) {
+		out := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			a, perr := mail.ParseAddress(strings.TrimSpace(addr))
+			if perr != nil {
+				return "", errors.New(op).Err(perr).Msgf("invalid recipient %q", addr)
+			}
+			envelope = append(envelope, a.Address)
+			out = append(out, headerAddress(a))
+		}
+		return strings.Join(out, ", "), nil
+	}
+	to, err := list(b.to)
+	if err != nil {
+		return MsgDef{}, err
+	}
+	cc, err := list(b.cc)
+	if err != nil {
+		return MsgDef{}, err
+	}
+	if _, err = list(b.bcc); err != nil {
+		return MsgDef{}, err
+	}
+	if len(envelope) == 0 {
+		return MsgDef{}, errors.New(op).Msg("email TO address cannot be empty")
+	}
+
+	hdr := cloneHeader(b.extra)
+	for _, k := range []string{"Mime-Version", "Content-Type", "Content-Transfer-Encoding", "Bcc"} {
+		hdr.Del(k)
+	}
+	hdr.Set("From", headerAddress(from))
+	if to != "" {
+		hdr.Set("To", to)
+	}
+	if cc != "" {
+		hdr.Set("Cc", cc)
+	}
+	if b.replyTo != "" {
+		rt, perr := mail.ParseAddress(strings.TrimSpace(b.replyTo))
+		if perr != nil {
+			return MsgDef{}, errors.New(op).Err(perr).Msgf("invalid reply-to address %q", b.replyTo)
+		}
+		hdr.Set("Reply-To", headerAddress(rt))
+	}
+	hdr.Set("Subject", mime.QEncoding.Encode("utf-8", b.subject))
+	now := time.Now()
+	if b.clock != nil {
+		now = b.clock.Now()
+	}
+	if hdr.Get("Date") == "" {
+		hdr.Set("Date", now.UTC().Format(time.RFC1123Z))
+	}
+	if hdr.Get("Message-ID") == "" {
+		hdr.Set("Message-ID", generateMessageID(b.random, now))
+	}
+
+	msg, structure, err := composeMessage(b.random, hdr, b.body, b.html, b.atts)
+	if err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msg("failed to compose message")
+	}
+	return MsgDef{From: from.Address, To: envelope, Msg: msg, TTL: b.ttl, Priority: b.priority, Structure: structure}, nil
+}
+
+// headerAddress formats a for a header, leaving a bare address without angle brackets.
+func headerAddress(a *mail.Address) string {
+	if a.Name == "" {
+		return a.Address
+	}
+	return a.String()
+}
@@ -0,0 +1,100 @@
+package email
+
+import (
+	"mime"
+	"net/textproto"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMessageBuilder(t *testing.T) {
+	def, err := NewMessage().
+		From("Field Day Station <op@example.org>").
+		To("qsl@club.example").
+		Cc("Jörg <dl1abc@example.de>").
+		Bcc("archive@example.org").
+		ReplyTo("ops@example.org").
+		Subject("Grüße vom Fieldday").
+		TextBody("Log attached, 73").
+		Attach("log.csv", "text/csv", []byte("call,band\r\nDL1XYZ,20m\r\n")).
+		Header("Content-Type", "text/html").
+		Header("X-Station", "DL0FD").
+		Priority(PriorityUrgent).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.From != "op@example.org" || !slices.Equal(def.To, []string{"qsl@club.example", "dl1abc@example.de", "archive@example.org"}) || def.Priority != PriorityUrgent {
+		t.Fatalf("envelope = %s %v priority %d", def.From, def.To, def.Priority)
+	}
+
+	in, err := ParseInbound(strings.NewReader(def.Msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Subject() != "Grüße vom Fieldday" || in.Header.Get("Reply-To") != "ops@example.org" || in.Header.Get("X-Station") != "DL0FD" {
+		t.Errorf("headers = %v", in.Header)
+	}
+	if in.Header.Get("Bcc") != "" || strings.Contains(def.Msg, "archive@") {
+		t.Error("Bcc recipient is listed in the message")
+	}
+	if !strings.HasPrefix(in.Header.Get("Content-Type"), "multipart/mixed") {
+		t.Errorf("Content-Type %q was overridden", in.Header.Get("Content-Type"))
+	}
+	atts, err := in.Attachments()
+	if err != nil || len(atts) != 1 || atts[0].Filename != "log.csv" || string(atts[0].Data) != "call,band\r\nDL1XYZ,20m\r\n" {
+		t.Fatalf("attachments = %+v, %v", atts, err)
+	}
+
+	for name, b := range map[string]*MessageBuilder{
+		"no sender":     NewMessage().To("qsl@club.example"),
+		"no recipients": NewMessage().From("op@example.org"),
+		"bad recipient": NewMessage().From("op@example.org").To("not an address"),
+	} {
+		if _, err = b.Build(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMessageBuilderHTMLBody(t *testing.T) {
+	contentTypes := func(msg string) []string {
+		t.Helper()
+		in, err := ParseInbound(strings.NewReader(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		err = walkParts(in.Header.Get("Content-Type"), textproto.MIMEHeader(in.Header), in.Body, 0, func(hdr textproto.MIMEHeader, _ []byte) bool {
+			mediaType, _, _ := mime.ParseMediaType(hdr.Get("Content-Type"))
+			out = append(out, mediaType)
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	b := NewMessage().From("op@example.org").To("club@example.org").Subject("Station status").
+		TextBody("All systems nominal").HTMLBody("<p>All systems <b>nominal</b></p>")
+	def, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := contentTypes(def.Msg); !slices.Equal(got, []string{"text/plain", "text/html"}) || !strings.Contains(def.Msg, "multipart/alternative") {
+		t.Fatalf("parts = %v", got)
+	}
+
+	def, err = b.Attach("status.csv", "text/csv", []byte("swr,1.2\r\n")).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := contentTypes(def.Msg); !slices.Equal(got, []string{"text/plain", "text/html", "text/csv"}) {
+		t.Fatalf("parts = %v", got)
+	}
+	if !strings.HasPrefix(def.Structure.Header.Get("Content-Type"), "multipart/mixed") || len(def.Structure.Parts) != 3 || def.Structure.Parts[1].Body != "<p>All systems <b>nominal</b></p>" {
+		t.Fatalf("structure = %+v", def.Structure)
+	}
+}
@@ -0,0 +1,86 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestCancelQueuedDelivery(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}}
+	s.isInitialized.Store(true)
+
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		return deliveryInfo{}, &textproto.Error{Code: 451, Msg: "4.7.1 Greylisted"}
+	})
+
+	res, err := s.SendWithResult(t.Context(), MsgDef{To: []string{"wrong-list@example.com"}, Msg: "Message-Id: <a@example.com>\r\nSubject: export\r\n\r\nbody"})
+	if err != nil || res.Status != SendStatusQueued {
+		t.Fatalf("expected queued result, got %+v, %v", res, err)
+	}
+	if err = s.Cancel(res.QueueID); err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	if s.QueueDepth() != 0 {
+		t.Fatalf("expected queue to be empty after cancel")
+	}
+	if err = s.Cancel(res.QueueID); err == nil {
+		t.Fatalf("expected error cancelling an unknown delivery")
+	}
+}
+
+func TestCancelInFlightDelivery(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com", SmtpRetryCount: 3}}
+	s.isInitialized.Store(true)
+
+	started := make(chan struct{})
+	s.Transport = smtpFunc(func(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		close(started)
+		<-ctx.Done()
+		return deliveryInfo{}, ctx.Err()
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Send(MsgDef{To: []string{"wrong-list@example.com"}, Msg: "Message-Id: <b@example.com>\r\nSubject: export\r\n\r\nbody"})
+	}()
+	<-started
+	if err := s.Cancel("<b@example.com>"); err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), errMsgDeliveryCancelled) {
+			t.Fatalf("expected cancelled error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("send did not return after cancel")
+	}
+}
+
+func TestSendContextAbortsRetrySleep(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com", SmtpRetryCount: 3, SmtpRetryDelaySec: 60}}
+	s.isInitialized.Store(true)
+
+	calls := 0
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		calls++
+		return deliveryInfo{}, &textproto.Error{Code: 421, Msg: "4.3.2 Service not available"}
+	})
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := s.SendContext(ctx, MsgDef{To: []string{"to@example.com"}, Msg: "Subject: hi\r\n\r\nbody"})
+	if err == nil || !strings.Contains(err.Error(), errMsgDeliveryCancelled) {
+		t.Fatalf("expected cancelled error, got %v", err)
+	}
+	if calls != 1 || time.Since(start) > 5*time.Second {
+		t.Fatalf("%d attempts in %v; the deadline should have cut the retry sleep short", calls, time.Since(start))
+	}
+}
@@ -0,0 +1,64 @@
+package email
+
+import (
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// defaultMaxClockSkew is how far ahead of the Clock a message's Date may be before it is stamped afresh.
+const defaultMaxClockSkew = 2 * time.Minute
+
+// Clock tells the time. A portable station whose real-time clock drifts can take it from a better source, such as
+// GPS or NTP, by setting Service.Clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts a function to a Clock.
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// now returns the time from Service.Clock, or the system clock when none is set.
+func (s *Service) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock.Now()
+	}
+	return time.Now()
+}
+
+func (s *Service) maxClockSkew() time.Duration {
+	if s.MaxClockSkew > 0 {
+		return s.MaxClockSkew
+	}
+	return defaultMaxClockSkew
+}
+
+// fixDate stamps msg with the current time when its Date is ahead of the Clock by more than MaxClockSkew, as after
+// it was built on a machine whose clock runs fast: spam filters distrust mail from the future.
+func (s *Service) fixDate(msg string) string {
+	head, _, ok := strings.Cut(msg, "\r\n\r\n")
+	if !ok {
+		return msg
+	}
+	m, err := mail.ReadMessage(strings.NewReader(head + "\r\n\r\n"))
+	if err != nil {
+		return msg
+	}
+	date, err := m.Header.Date()
+	now := s.now()
+	if err != nil || date.Sub(now) <= s.maxClockSkew() {
+		return msg
+	}
+	s.LoggerService.WarnWith().Time("date", date).Time("now", now).Msg("message is dated in the future; stamping it afresh")
+	return setHeader(msg, "Date", now.UTC().Format(time.RFC1123Z))
+}
+
+// clockJump returns how far the Clock has moved beyond the elapsed time measured by the monotonic clock since the
+// times sinceWall and sinceMono were taken together: the size of a correction or jump of the Clock.
+func (s *Service) clockJump(sinceWall, sinceMono time.Time) time.Duration {
+	return s.now().Round(0).Sub(sinceWall.Round(0)) - time.Since(sinceMono)
+}
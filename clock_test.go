@@ -0,0 +1,155 @@
+package email
+
+import (
+	"context"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestFutureDateIsStampedFromClock(t *testing.T) {
+	gps := time.Date(2024, 6, 22, 18, 0, 0, 0, time.UTC)
+	s := &Service{
+		Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "op@example.org"},
+		Clock:  ClockFunc(func() time.Time { return gps }),
+	}
+	s.isInitialized.Store(true)
+
+	var sent string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent = string(msg)
+		return deliveryInfo{}, nil
+	})
+
+	dateOf := func(raw string) time.Time {
+		m, err := mail.ReadMessage(strings.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := m.Header.Date()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	// Built by the service, the message is dated by the Clock
+	def, err := s.BuildEmailWithAttachments("op@example.org", "Field Day", "73", []string{"dx@example.com"},
+		Attachment{Filename: "log.adi", Data: []byte("<EOR>")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := dateOf(def.Msg); !d.Equal(gps) {
+		t.Fatalf("built message dated %v, want %v", d, gps)
+	}
+
+	// Built elsewhere by a fast clock, it is stamped afresh; within the tolerance it is left alone
+	for _, tc := range []struct {
+		date time.Time
+		want time.Time
+	}{
+		{gps.Add(3 * time.Hour), gps},
+		{gps.Add(time.Minute), gps.Add(time.Minute)},
+		{gps.Add(-time.Hour), gps.Add(-time.Hour)},
+	} {
+		msg := "Date: " + tc.date.Format(time.RFC1123Z) + "\r\nSubject: qsl\r\n\r\n73\r\n"
+		if err = s.Send(MsgDef{To: []string{"dx@example.com"}, Msg: msg}); err != nil {
+			t.Fatal(err)
+		}
+		if d := dateOf(sent); !d.Equal(tc.want) {
+			t.Errorf("date %v sent as %v, want %v", tc.date, d, tc.want)
+		}
+	}
+}
+
+func TestQueueScheduleFollowsClockJump(t *testing.T) {
+	var offset atomic.Int64
+	s := &Service{Clock: ClockFunc(func() time.Time { return time.Now().Add(time.Duration(offset.Load())) })}
+	wall, mono := s.now(), time.Now()
+	due := wall.Add(10 * time.Minute)
+	s.queue.push(&QueuedMessage{ID: "q1", NextAttempt: due})
+
+	// A GPS fix corrects the clock by an hour
+	offset.Store(int64(time.Hour))
+	jump := s.clockJump(wall, mono)
+	if jump < 59*time.Minute || jump > 61*time.Minute {
+		t.Fatalf("jump = %v, want about an hour", jump)
+	}
+	s.queue.shift(jump)
+	if got := s.queue.snapshot()[0].NextAttempt; got.Sub(due) != jump {
+		t.Fatalf("next attempt moved by %v, want %v", got.Sub(due), jump)
+	}
+	if len(s.queue.popDue(s.now())) != 0 {
+		t.Fatal("the retry must still be ten minutes away, not due at once")
+	}
+}
+
+func TestRetryScheduleRunsOnMonotonicTime(t *testing.T) {
+	var offset atomic.Int64
+	s := &Service{Clock: ClockFunc(func() time.Time { return time.Now().Round(0).Add(time.Duration(offset.Load())) })}
+	s.enqueue(&QueuedMessage{ID: "q1", NextAttempt: s.now().Add(time.Minute), ExpiresAt: s.now().Add(time.Hour)})
+
+	// The clock springs forward an hour: the retry is not due, nor has the message expired
+	offset.Store(int64(time.Hour))
+	if due := s.queue.popDue(time.Now()); len(due) != 0 {
+		t.Fatalf("clock change fired the retry early: %+v", due)
+	}
+
+	// It falls back two hours: the retry is still due a minute after it was scheduled
+	offset.Store(int64(-time.Hour))
+	due := s.queue.popDue(time.Now().Add(2 * time.Minute))
+	if len(due) != 1 || due[0].expired(time.Now().Add(2*time.Minute)) {
+		t.Fatalf("clock change held the retry back: %+v", due)
+	}
+}
+
+func TestRecordsAndBuildsAreTimedByClock(t *testing.T) {
+	gps := time.Date(2024, 6, 22, 18, 0, 0, 0, time.UTC)
+	clock := ClockFunc(func() time.Time { return gps })
+	sink := &recordingSink{}
+	s := &Service{
+		Config:     &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "op@example.org"},
+		Clock:      clock,
+		EventSinks: []EventSink{sink},
+	}
+	s.isInitialized.Store(true)
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		return deliveryInfo{}, nil
+	})
+
+	def, err := NewMessage().From("op@example.org").To("dx@example.com").Subject("qsl").Clock(clock).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(def.Msg, "\r\nDate: "+gps.Format(time.RFC1123Z)+"\r\n") {
+		t.Fatalf("builder did not date the message by the clock:\n%s", def.Msg)
+	}
+	if err = s.Send(def); err != nil {
+		t.Fatal(err)
+	}
+	if h := s.History(); len(h) != 1 || !h[0].SentAt.Equal(gps) {
+		t.Fatalf("history = %+v", h)
+	}
+	if len(sink.events) != 1 || !sink.events[0].Time.Equal(gps) {
+		t.Fatalf("events = %+v", sink.events)
+	}
+
+	q := types.Qso{}
+	q.Call, q.Band, q.Mode, q.QsoDate, q.TimeOn = "DL1XYZ", "20m", "CW", "20240622", "1755"
+	if def, err = s.BuildEmailWithADIFAttachment("", "log", "73", []string{"dx@example.com"}, []types.Qso{q}); err != nil {
+		t.Fatal(err)
+	}
+	in, err := ParseInbound(strings.NewReader(def.Msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	atts, err := in.Attachments()
+	if err != nil || len(atts) != 1 || !strings.Contains(string(atts[0].Data), "<CREATED_TIMESTAMP:14>20240622180000") {
+		t.Fatalf("ADIF header not stamped by the clock: %v %+v", err, atts)
+	}
+}
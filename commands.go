@@ -0,0 +1,123 @@
+package email
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// Command is an email subcommand for the Station-Manager CLI, so headless operators can manage email over SSH.
+type Command struct {
+	Name  string
+	Usage string
+	Run   func(ctx context.Context, args []string, out io.Writer) error
+}
+
+// Commands returns the email subcommands bound to s.
+func (s *Service) Commands() []Command {
+	return []Command{
+		{Name: "test-connection", Usage: "test-connection", Run: s.cmdTestConnection},
+		{Name: "send-file", Usage: "send-file [-to addr,...] [-subject text] [-body text] FILE", Run: s.cmdSendFile},
+		{Name: "flush-queue", Usage: "flush-queue", Run: s.cmdFlushQueue},
+		{Name: "show-history", Usage: "show-history [-n count]", Run: s.cmdShowHistory},
+	}
+}
+
+func (s *Service) cmdTestConnection(ctx context.Context, args []string, out io.Writer) error {
+	const op errors.Op = "email.Service.cmdTestConnection"
+	if len(args) != 0 {
+		return errors.New(op).Msg("usage: test-connection")
+	}
+	report, err := s.TestConnection(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "transport\t%s\n", report.Transport)
+	_, _ = fmt.Fprintf(tw, "tls\t%s\n", report.TLSVersion)
+	_, _ = fmt.Fprintf(tw, "authenticated\t%t\n", report.Authenticated)
+	_, _ = fmt.Fprintf(tw, "auth mechanisms\t%s\n", strings.Join(report.AuthMechanisms, " "))
+	if report.MaxSize > 0 {
+		_, _ = fmt.Fprintf(tw, "max size\t%d\n", report.MaxSize)
+	}
+	if report.Legacy {
+		_, _ = fmt.Fprintf(tw, "greeting\tHELO (server rejected EHLO)\n")
+	}
+	for _, a := range report.Advisories {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\n", a.Severity, a.Message)
+	}
+	return tw.Flush()
+}
+
+func (s *Service) cmdSendFile(ctx context.Context, args []string, out io.Writer) error {
+	const op errors.Op = "email.Service.cmdSendFile"
+	fs := flag.NewFlagSet("send-file", flag.ContinueOnError)
+	fs.SetOutput(out)
+	to := fs.String("to", "", "comma-separated recipients (default: configured To)")
+	subject := fs.String("subject", "", "subject (default: configured Subject)")
+	body := fs.String("body", "", "message text (default: configured Body)")
+	if err := fs.Parse(args); err != nil {
+		return errors.New(op).Err(err).Msg("invalid arguments")
+	}
+	if fs.NArg() != 1 {
+		return errors.New(op).Msg("usage: send-file [-to addr,...] [-subject text] [-body text] FILE")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return errors.New(op).Err(err).Msg("opening file")
+	}
+	defer func() { _ = f.Close() }()
+
+	def, err := s.BuildEmailWithFile("", *subject, *body, splitAndTrim(*to), fs.Arg(0), f)
+	if err != nil {
+		return err
+	}
+	res, err := s.SendWithResult(ctx, def)
+	if err != nil {
+		return err
+	}
+	if res.Status == SendStatusQueued {
+		_, err = fmt.Fprintf(out, "queued %s as %s\n", res.MessageID, res.QueueID)
+		return err
+	}
+	_, err = fmt.Fprintf(out, "sent %s\n", res.MessageID)
+	return err
+}
+
+func (s *Service) cmdFlushQueue(ctx context.Context, args []string, out io.Writer) error {
+	const op errors.Op = "email.Service.cmdFlushQueue"
+	if len(args) != 0 {
+		return errors.New(op).Msg("usage: flush-queue")
+	}
+	before := s.QueueDepth()
+	s.FlushQueue(ctx)
+	_, err := fmt.Fprintf(out, "attempted %d queued message(s); %d remain queued\n", before, s.QueueDepth())
+	return err
+}
+
+func (s *Service) cmdShowHistory(_ context.Context, args []string, out io.Writer) error {
+	const op errors.Op = "email.Service.cmdShowHistory"
+	fs := flag.NewFlagSet("show-history", flag.ContinueOnError)
+	fs.SetOutput(out)
+	n := fs.Int("n", 20, "number of most recent messages to show")
+	if err := fs.Parse(args); err != nil {
+		return errors.New(op).Err(err).Msg("invalid arguments")
+	}
+	history := s.History()
+	if *n > 0 && len(history) > *n {
+		history = history[len(history)-*n:]
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "SENT\tSTATE\tTO\tSUBJECT\tMESSAGE-ID")
+	for _, rec := range history {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", rec.SentAt.Local().Format(time.DateTime), rec.State, strings.Join(rec.To, ","), rec.Subject, rec.MessageID)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,69 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func runCommand(t *testing.T, s *Service, name string, args ...string) (string, error) {
+	t.Helper()
+	for _, c := range s.Commands() {
+		if c.Name == name {
+			var out bytes.Buffer
+			err := c.Run(t.Context(), args, &out)
+			return out.String(), err
+		}
+	}
+	t.Fatalf("no command %q", name)
+	return "", nil
+}
+
+func TestCommands(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "k1abc@example.com", To: "log@example.org"}}
+	s.isInitialized.Store(true)
+
+	var sent []string
+	greylist := true
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		if greylist {
+			return deliveryInfo{}, &textproto.Error{Code: 451, Msg: "4.7.1 Greylisted"}
+		}
+		sent = append(sent, string(msg))
+		return deliveryInfo{}, nil
+	})
+
+	path := filepath.Join(t.TempDir(), "field-day.adi")
+	if err := os.WriteFile(path, []byte("<EOH>\n<CALL:4>W1AW<EOR>\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := runCommand(t, s, "send-file", "-subject", "Field Day log", path)
+	if err != nil || !strings.HasPrefix(out, "queued ") {
+		t.Fatalf("send-file = %q, %v", out, err)
+	}
+
+	greylist = false
+	out, err = runCommand(t, s, "flush-queue")
+	if err != nil || out != "attempted 1 queued message(s); 0 remain queued\n" {
+		t.Fatalf("flush-queue = %q, %v", out, err)
+	}
+	if len(sent) != 1 || !strings.Contains(sent[0], "filename=field-day.adi") || !strings.Contains(sent[0], "Subject: Field Day log") {
+		t.Fatalf("unexpected message sent: %q", sent)
+	}
+
+	out, err = runCommand(t, s, "show-history", "-n", "5")
+	if err != nil || !strings.Contains(out, "Field Day log") || !strings.Contains(out, "log@example.org") {
+		t.Fatalf("show-history = %q, %v", out, err)
+	}
+
+	if _, err = runCommand(t, s, "send-file"); err == nil {
+		t.Fatal("send-file without a file should fail")
+	}
+}
@@ -0,0 +1,74 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"sync"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestConcurrentSendReloadShutdown(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Name: "a", Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}}
+	s.startQueue()
+	s.isInitialized.Store(true)
+
+	var mu sync.Mutex
+	seenFrom := map[string]bool{}
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		mu.Lock()
+		seenFrom[from] = true
+		mu.Unlock()
+		return deliveryInfo{}, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := s.Send(MsgDef{To: []string{"to@example.com"}, Msg: "Subject: hi\r\n\r\nbody"}); err != nil {
+					t.Errorf("send failed: %v", err)
+				}
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				cfg := types.EmailConfig{Name: "b", Enabled: true, Host: "smtp.example.net", Port: 465, From: fmt.Sprintf("from%d@example.net", i)}
+				if err := s.SetConfig(cfg); err != nil {
+					t.Errorf("reload failed: %v", err)
+				}
+				_ = s.CurrentConfig()
+				_ = s.Stats()
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.Shutdown()
+	}()
+	wg.Wait()
+	s.Shutdown()
+
+	if s.CurrentConfig().Name != "b" {
+		t.Fatalf("expected reloaded config to be in effect")
+	}
+	if s.Config.Name != "a" {
+		t.Fatalf("reload must not modify the initial Config")
+	}
+}
+
+func TestSetConfigRejectsInvalidConfig(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}}
+	if err := s.SetConfig(types.EmailConfig{Host: "smtp.example.com", Port: 0, From: "from@example.com"}); err == nil {
+		t.Fatalf("expected invalid config to be rejected")
+	}
+	if s.CurrentConfig().Port != 587 {
+		t.Fatalf("rejected config should not be applied")
+	}
+}
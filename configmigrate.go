@@ -0,0 +1,136 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+// ConfigVersion is the layout of the email config that EncodeEmailConfig writes. Version 0 is any unversioned
+// config, which may use the field names of older releases.
+const ConfigVersion = 1
+
+// configMigrations upgrade a decoded config from the version of their index to the next.
+var configMigrations = []func(map[string]json.RawMessage) error{
+	migrateConfigV0,
+}
+
+// legacyConfigNames maps the field names of older releases to those of types.EmailConfig, earlier ones taking
+// precedence.
+var legacyConfigNames = [][2]string{
+	{"smtp_host", "host"},
+	{"server", "host"},
+	{"smtp_port", "port"},
+	{"user", "username"},
+	{"login", "username"},
+	{"pass", "password"},
+	{"sender", "from"},
+	{"recipients", "to"},
+	{"recipient", "to"},
+	{"dial_timeout", "smtp_dial_timeout_sec"},
+	{"timeout", "smtp_dial_timeout_sec"},
+	{"retry_count", "smtp_retry_count"},
+	{"retries", "smtp_retry_count"},
+	{"retry_delay", "smtp_retry_delay_sec"},
+}
+
+// migrateConfigV0 renames legacy fields, keeping the current name when both are set, and joins a list of
+// recipients into the comma-separated To.
+func migrateConfigV0(m map[string]json.RawMessage) error {
+	for _, rename := range legacyConfigNames {
+		old, name := rename[0], rename[1]
+		v, ok := m[old]
+		if !ok {
+			continue
+		}
+		if _, set := m[name]; !set {
+			m[name] = v
+		}
+		delete(m, old)
+	}
+	to, ok := m["to"]
+	if !ok || !bytes.HasPrefix(bytes.TrimSpace(to), []byte("[")) {
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(to, &list); err != nil {
+		return err
+	}
+	joined, err := json.Marshal(strings.Join(list, ", "))
+	if err != nil {
+		return err
+	}
+	m["to"] = joined
+	return nil
+}
+
+// DecodeEmailConfig decodes an email config of any version up to ConfigVersion, upgrading an older layout.
+func DecodeEmailConfig(data []byte) (types.EmailConfig, error) {
+	cfg, _, err := decodeEmailConfig(data)
+	return cfg, err
+}
+
+// decodeEmailConfig also returns the version data was written in.
+func decodeEmailConfig(data []byte) (types.EmailConfig, int, error) {
+	const op errors.Op = "email.DecodeEmailConfig"
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return types.EmailConfig{}, 0, errors.New(op).Err(err).Msg("failed to decode email config")
+	}
+	var version int
+	if v, ok := m["version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return types.EmailConfig{}, 0, errors.New(op).Err(err).Msg("invalid email config version")
+		}
+		delete(m, "version")
+	}
+	if version < 0 || version > ConfigVersion {
+		return types.EmailConfig{}, version, errors.New(op).Msgf("email config version %d is newer than this release supports (%d)",
+			version, ConfigVersion)
+	}
+	for v := version; v < ConfigVersion; v++ {
+		if err := configMigrations[v](m); err != nil {
+			return types.EmailConfig{}, version, errors.New(op).Err(err).Msgf("failed to upgrade email config from version %d", v)
+		}
+	}
+	upgraded, err := json.Marshal(m)
+	if err != nil {
+		return types.EmailConfig{}, version, errors.New(op).Err(err).Msg("failed to encode upgraded email config")
+	}
+	var cfg types.EmailConfig
+	if err = json.Unmarshal(upgraded, &cfg); err != nil {
+		return types.EmailConfig{}, version, errors.New(op).Err(err).Msg("failed to decode email config")
+	}
+	return cfg, version, nil
+}
+
+// EncodeEmailConfig encodes cfg in the current layout, marked with ConfigVersion.
+func EncodeEmailConfig(cfg types.EmailConfig) ([]byte, error) {
+	const op errors.Op = "email.EncodeEmailConfig"
+	data, err := json.Marshal(struct {
+		Version int `json:"version"`
+		types.EmailConfig
+	}{ConfigVersion, cfg})
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to encode email config")
+	}
+	return data, nil
+}
+
+// SetConfigJSON decodes an email config of any version, as DecodeEmailConfig does, and swaps it in as SetConfig
+// does.
+func (s *Service) SetConfigJSON(data []byte) error {
+	const op errors.Op = "email.Service.SetConfigJSON"
+	cfg, version, err := decodeEmailConfig(data)
+	if err != nil {
+		return errors.New(op).Err(err).Msg(err.Error())
+	}
+	if version < ConfigVersion {
+		s.LoggerService.InfoWith().Int("from", version).Int("to", ConfigVersion).Msg("email config upgraded")
+	}
+	return s.setConfig(context.Background(), op, cfg, "set")
+}
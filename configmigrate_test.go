@@ -0,0 +1,54 @@
+package email
+
+import (
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestDecodeLegacyEmailConfig(t *testing.T) {
+	legacy := `{"name":"club","enabled":true,"server":"smtp.example.com","smtp_port":587,"user":"op","pass":"secret",
+		"sender":"op@example.org","recipients":["qsl@example.org","log@example.org"],"timeout":10,"retries":2}`
+	cfg, err := DecodeEmailConfig([]byte(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := types.EmailConfig{Name: "club", Enabled: true, Host: "smtp.example.com", Port: 587, Username: "op", Password: "secret",
+		From: "op@example.org", To: "qsl@example.org, log@example.org", SmtpDialTimeoutSec: 10, SmtpRetryCount: 2}
+	if cfg != want {
+		t.Fatalf("decoded %+v", cfg)
+	}
+
+	// The current name wins over a legacy one left beside it
+	cfg, err = DecodeEmailConfig([]byte(`{"host":"smtp.new.example","server":"smtp.old.example","to":"qsl@example.org"}`))
+	if err != nil || cfg.Host != "smtp.new.example" || cfg.To != "qsl@example.org" {
+		t.Fatalf("cfg=%+v err=%v", cfg, err)
+	}
+}
+
+func TestEncodeEmailConfigRoundTrip(t *testing.T) {
+	cfg := types.EmailConfig{Name: "club", Enabled: true, Host: "smtp.example.com", Port: 465, From: "op@example.org", To: "qsl@example.org"}
+	data, err := EncodeEmailConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeEmailConfig(data)
+	if err != nil || got != cfg {
+		t.Fatalf("got %+v err=%v from %s", got, err, data)
+	}
+
+	if _, err = DecodeEmailConfig([]byte(`{"version":99,"host":"smtp.example.com"}`)); err == nil {
+		t.Fatal("expected a config from a newer release to be refused")
+	}
+}
+
+func TestSetConfigJSON(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "op@example.org"}}
+	s.isInitialized.Store(true)
+	if err := s.SetConfigJSON([]byte(`{"enabled":true,"smtp_host":"smtp.club.example","smtp_port":465,"sender":"club@example.org"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg := s.CurrentConfig(); cfg.Host != "smtp.club.example" || cfg.Port != 465 || cfg.From != "club@example.org" {
+		t.Fatalf("config %+v", cfg)
+	}
+}
@@ -0,0 +1,158 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	stderr "errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+// reportedExtensions are the EHLO keywords TestConnection looks for.
+var reportedExtensions = []string{"SIZE", "PIPELINING", "STARTTLS", "8BITMIME", "SMTPUTF8", "AUTH", "DSN", "CHUNKING", "ENHANCEDSTATUSCODES"}
+
+// ConnectionReport describes what the configured server supports, for display in the settings UI.
+type ConnectionReport struct {
+	Transport  string
+	TLSVersion string
+	// Extensions maps each advertised EHLO keyword to its parameters. Keywords are those seen after TLS was
+	// established, except STARTTLS, which is only advertised before.
+	Extensions     map[string]string
+	MaxSize        int64
+	Pipelining     bool
+	StartTLS       bool
+	EightBitMIME   bool
+	SMTPUTF8       bool
+	DSN            bool
+	AuthMechanisms []string
+	// Authenticated is true when the configured credentials were accepted.
+	Authenticated bool
+	// Legacy is true when the server rejected EHLO and was greeted with HELO; such a session has no extensions.
+	Legacy bool
+	// Advisories are the sender-domain preflight findings.
+	Advisories []Advisory
+}
+
+// TestConnection connects to the configured server, authenticates if credentials are set, and reports the
+// server's capabilities without sending a message.
+func (s *Service) TestConnection(ctx context.Context) (ConnectionReport, error) {
+	const op errors.Op = "email.Service.TestConnection"
+	cfg := s.config()
+	if err := validateEmailConfig(op, cfg); err != nil {
+		return ConnectionReport{}, err
+	}
+	report, err := s.probe(ctx, cfg, true)
+	if err != nil {
+		return report, errors.New(op).Err(err).Msg("connection test failed")
+	}
+	report.Advisories = s.PreflightSenderDomain(ctx)
+	return report, nil
+}
+
+// probe connects to the server in cfg and reports its capabilities, authenticating when withAuth is set and
+// credentials are configured.
+func (s *Service) probe(ctx context.Context, cfg *types.EmailConfig, withAuth bool) (ConnectionReport, error) {
+	host := strings.TrimSpace(cfg.Host)
+	addr := net.JoinHostPort(host, strconv.Itoa(cfg.Port))
+	var auth smtp.Auth
+	if withAuth {
+		var err error
+		if auth, err = s.smtpAuth(ctx, strings.TrimSpace(cfg.Username), strings.TrimSpace(cfg.Password), host); err != nil {
+			return ConnectionReport{}, err
+		}
+	}
+
+	if err := s.conns.acquire(ctx, s.MaxConnections); err != nil {
+		return ConnectionReport{}, err
+	}
+	defer s.conns.release()
+	return probeSMTP(s.sessionContext(ctx, cfg), host, addr, auth)
+}
+
+// probeSMTP tries implicit TLS, then STARTTLS, as sendMailWithTLS does.
+func probeSMTP(ctx context.Context, host, addr string, auth smtp.Auth) (ConnectionReport, error) {
+	d := &tls.Dialer{NetDialer: newDialer(dialTimeoutFromContext(ctx)), Config: tlsConfig(ctx, host)}
+	if conn, err := d.DialContext(ctx, "tcp", addr); err == nil {
+		report, perr := inspectServer(ctx, conn, host, auth, true)
+		if stderr.Is(perr, errHelloFailed) && ctx.Err() == nil {
+			if conn, err = d.DialContext(ctx, "tcp", addr); err == nil {
+				report, perr = inspectServer(ctx, &heloOnlyConn{Conn: conn}, host, auth, true)
+			}
+		}
+		// The server speaks implicit TLS, so a later failure (such as rejected credentials) is the real answer
+		return report, perr
+	}
+	conn, err := newDialer(dialTimeoutFromContext(ctx)).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return ConnectionReport{}, err
+	}
+	return inspectServer(ctx, conn, host, auth, false)
+}
+
+func inspectServer(ctx context.Context, conn net.Conn, host string, auth smtp.Auth, alreadyTLS bool) (ConnectionReport, error) {
+	const op errors.Op = "email.inspectServer"
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return ConnectionReport{}, err
+	}
+	defer func() { _ = client.Close() }()
+	if err = client.Hello(resolveHostname()); err != nil {
+		return ConnectionReport{}, fmt.Errorf("%w: %w", errHelloFailed, err)
+	}
+	_, legacy := conn.(*heloOnlyConn)
+
+	report := ConnectionReport{Transport: transportImplicitTLS, Extensions: map[string]string{}, Legacy: legacy}
+	if !alreadyTLS {
+		report.Transport = transportStartTLS
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return report, errors.New(op).Msg("smtp server does not support STARTTLS; TLS required")
+		}
+		report.StartTLS = true
+		report.Extensions["STARTTLS"] = ""
+		if err = client.StartTLS(tlsConfig(ctx, host)); err != nil {
+			return report, err
+		}
+	}
+	if state, ok := client.TLSConnectionState(); ok {
+		report.TLSVersion = tls.VersionName(state.Version)
+	}
+
+	for _, ext := range reportedExtensions {
+		if ok, param := client.Extension(ext); ok {
+			report.Extensions[ext] = param
+		}
+	}
+	report.Pipelining = hasKey(report.Extensions, "PIPELINING")
+	report.EightBitMIME = hasKey(report.Extensions, "8BITMIME")
+	report.SMTPUTF8 = hasKey(report.Extensions, "SMTPUTF8")
+	report.DSN = hasKey(report.Extensions, "DSN")
+	if size, ok := report.Extensions["SIZE"]; ok {
+		report.MaxSize, _ = strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+	}
+	if mechs, ok := report.Extensions["AUTH"]; ok {
+		report.AuthMechanisms = strings.Fields(mechs)
+	}
+
+	if auth != nil && !legacy {
+		if err = client.Auth(auth); err != nil {
+			return report, err
+		}
+		report.Authenticated = true
+	}
+	_ = client.Quit()
+	return report, nil
+}
+
+func hasKey(m map[string]string, k string) bool {
+	_, ok := m[k]
+	return ok
+}
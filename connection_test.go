@@ -0,0 +1,107 @@
+package email
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+// fakeCert is the throwaway certificate of fakeTLSListener and a pool trusting it.
+var fakeCert = sync.OnceValues(func() (tls.Certificate, *x509.CertPool) {
+	hs := httptest.NewUnstartedServer(nil)
+	hs.StartTLS()
+	defer hs.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(hs.Certificate())
+	return hs.TLS.Certificates[0], pool
+})
+
+// fakeRoots trusts the certificate of fakeTLSListener, e.g. as Service.RootCAs.
+func fakeRoots() *x509.CertPool {
+	_, pool := fakeCert()
+	return pool
+}
+
+// fakeTLSListener listens on loopback with a certificate that fakeRoots trusts.
+func fakeTLSListener(t *testing.T) net.Listener {
+	t.Helper()
+	cert, _ := fakeCert()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	return ln
+}
+
+// startFakeSMTPS serves a scripted implicit-TLS SMTP session and returns its address.
+func startFakeSMTPS(t *testing.T, ehlo []string) string {
+	t.Helper()
+	ln := fakeTLSListener(t)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		r := bufio.NewReader(conn)
+		_, _ = conn.Write([]byte("220 fake ESMTP\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO":
+				for i, ext := range ehlo {
+					sep := "-"
+					if i == len(ehlo)-1 {
+						sep = " "
+					}
+					_, _ = conn.Write([]byte("250" + sep + ext + "\r\n"))
+				}
+			case "AUTH":
+				_, _ = conn.Write([]byte("235 2.7.0 Authentication successful\r\n"))
+			case "QUIT":
+				_, _ = conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				_, _ = conn.Write([]byte("502 unsupported\r\n"))
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestConnectionReportsCapabilities(t *testing.T) {
+	addr := startFakeSMTPS(t, []string{"fake.example.com", "SIZE 35882577", "PIPELINING", "8BITMIME", "AUTH PLAIN LOGIN XOAUTH2", "SMTPUTF8"})
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	s := &Service{
+		Config:  &types.EmailConfig{Host: host, Port: p, From: "op@example.com", Username: "op", Password: "secret"},
+		RootCAs: fakeRoots(),
+	}
+	s.resolver = fakeResolver{}
+
+	report, err := s.TestConnection(t.Context())
+	if err != nil {
+		t.Fatalf("TestConnection failed: %v", err)
+	}
+	if report.Transport != transportImplicitTLS || report.TLSVersion == "" || !report.Authenticated {
+		t.Fatalf("unexpected connection details %+v", report)
+	}
+	if report.MaxSize != 35882577 || !report.Pipelining || !report.EightBitMIME || !report.SMTPUTF8 || report.DSN || report.StartTLS {
+		t.Fatalf("unexpected capabilities %+v", report)
+	}
+	if strings.Join(report.AuthMechanisms, ",") != "PLAIN,LOGIN,XOAUTH2" {
+		t.Fatalf("unexpected auth mechanisms %v", report.AuthMechanisms)
+	}
+}
@@ -0,0 +1,42 @@
+package email
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Station-Manager/errors"
+)
+
+// defaultMaxConnections keeps the service well under the concurrent-session limits providers enforce.
+const defaultMaxConnections = 3
+
+// connLimiter caps simultaneous SMTP connections across all profiles of a Service.
+type connLimiter struct {
+	once  sync.Once
+	slots chan struct{}
+}
+
+// acquire waits for a free slot; max is only read on first use.
+func (l *connLimiter) acquire(ctx context.Context, max int) error {
+	const op errors.Op = "email.connLimiter.acquire"
+	l.once.Do(func() {
+		if max <= 0 {
+			max = defaultMaxConnections
+		}
+		l.slots = make(chan struct{}, max)
+	})
+	// select picks at random among ready cases, so a cancelled send could otherwise still take a free slot
+	if err := ctx.Err(); err != nil {
+		return errors.New(op).Err(err).Msg("waiting for a free smtp connection slot")
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errors.New(op).Err(ctx.Err()).Msg("waiting for a free smtp connection slot")
+	}
+}
+
+func (l *connLimiter) release() {
+	<-l.slots
+}
@@ -0,0 +1,55 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestSendRespectsConnectionLimit(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}, MaxConnections: 2}
+	s.isInitialized.Store(true)
+
+	var open, peak atomic.Int32
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		n := open.Add(1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(10 * time.Millisecond)
+		open.Add(-1)
+		return deliveryInfo{}, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Send(MsgDef{To: []string{"to@example.com"}, Msg: "Subject: hi\r\n\r\nbody"}); err != nil {
+				t.Errorf("send failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := peak.Load(); got > 2 {
+		t.Fatalf("expected at most 2 concurrent connections, peak was %d", got)
+	}
+}
+
+func TestConnectionSlotWaitHonoursContext(t *testing.T) {
+	var l connLimiter
+	if err := l.acquire(t.Context(), 1); err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx, 1); err == nil {
+		t.Fatal("expected acquire to give up when the context ends")
+	}
+	l.release()
+}
@@ -0,0 +1,63 @@
+package email
+
+import (
+	"sync"
+	"time"
+)
+
+// deadLetterCapacity bounds the dead-letter queue; the oldest entries are dropped first.
+const deadLetterCapacity = 500
+
+// DeadReason records why a message was moved to the dead-letter queue.
+type DeadReason string
+
+const (
+	DeadReasonExpired DeadReason = "expired"
+	DeadReasonFailed  DeadReason = "failed"
+)
+
+// DeadLetter is a queued message that will not be attempted again.
+type DeadLetter struct {
+	QueuedMessage
+	Reason DeadReason
+	DiedAt time.Time
+}
+
+type deadLetterQueue struct {
+	mu    sync.Mutex
+	items []DeadLetter
+}
+
+func (q *deadLetterQueue) add(m *QueuedMessage, reason DeadReason, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items = append(q.items, DeadLetter{QueuedMessage: *m, Reason: reason, DiedAt: now.UTC()})
+	if n := len(q.items) - deadLetterCapacity; n > 0 {
+		q.items = append(q.items[:0], q.items[n:]...)
+	}
+}
+
+func (q *deadLetterQueue) snapshot() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]DeadLetter, len(q.items))
+	copy(out, q.items)
+	return out
+}
+
+// DeadLetters returns the messages that expired or exhausted their attempts, oldest first.
+func (s *Service) DeadLetters() []DeadLetter {
+	s.applyRetention()
+	return s.deadLetters.snapshot()
+}
+
+// expire moves an undelivered message past its TTL to the dead-letter queue.
+func (s *Service) expire(m *QueuedMessage) {
+	s.LoggerService.WarnWith().Str("queue_id", m.ID).Str("message_id", m.MessageID).Int("attempts", m.Attempts).
+		Str("last_error", m.LastError).Msg("queued message expired undelivered")
+	s.stats.recordFailed()
+	s.deadLetters.add(m, DeadReasonExpired, s.now())
+	s.applyRetention()
+	s.emit(DeliveryEvent{Type: EventFailed, MessageID: m.MessageID, Recipients: headerRecipients(m.Msg.To, m.Msg.Bcc),
+		Error: "expired undelivered", TraceID: m.TraceID})
+}
@@ -0,0 +1,42 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestQueuedMessageExpiresToDeadLetters(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}}
+	s.QueueConfig.DefaultTTL = 24 * time.Hour
+	s.isInitialized.Store(true)
+
+	calls := 0
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		calls++
+		return deliveryInfo{}, &textproto.Error{Code: 451, Msg: "4.7.1 Greylisted"}
+	})
+
+	if _, err := s.SendWithResult(t.Context(), MsgDef{To: []string{"op@example.com"}, Msg: "Subject: rig disconnected\r\n\r\nbody", TTL: 10 * time.Minute}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q := s.Queued(); len(q) != 1 || q[0].ExpiresAt.IsZero() {
+		t.Fatalf("expected queued message with expiry, got %+v", q)
+	}
+
+	s.flushQueue(t.Context(), time.Now().Add(time.Hour))
+	if calls != 1 {
+		t.Fatalf("expired message should not be attempted, got %d attempts", calls)
+	}
+	if s.QueueDepth() != 0 {
+		t.Fatalf("expected expired message to leave the queue")
+	}
+	dl := s.DeadLetters()
+	if len(dl) != 1 || dl[0].Reason != DeadReasonExpired {
+		t.Fatalf("expected one expired dead letter, got %+v", dl)
+	}
+}
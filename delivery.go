@@ -0,0 +1,225 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/logging"
+	"github.com/Station-Manager/types"
+)
+
+// delivery carries the resolved state of one outbound message across its attempts.
+type delivery struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	queueID   string
+	cfg       *types.EmailConfig
+	msg       MsgDef
+	traceID   string
+	log       logging.Logger
+	rec       HistoryRecord
+	quotaCost int
+	// delivered lists recipients already sent the message by an attempt that failed for the others
+	delivered []string
+	// unconfirmed is set once an attempt failed in a way that leaves open whether the server accepted the message
+	unconfirmed bool
+}
+
+// prepareDelivery resolves the envelope sender, stamps the trace header and checks the quota. The caller must call
+// d.cancel once the delivery is finished with.
+func (s *Service) prepareDelivery(ctx context.Context, email MsgDef) (*delivery, error) {
+	const op errors.Op = "email.Service.prepareDelivery"
+	cfg, err := s.transportFor(email)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to select the transport")
+	}
+	d := &delivery{traceID: TraceIDFromContext(ctx), cfg: cfg, log: s.LoggerService}
+	d.ctx, d.cancel = context.WithCancel(ctx)
+	if d.traceID != "" {
+		d.log = s.LoggerService.With().Str("trace_id", d.traceID).Logger()
+	}
+
+	email.From = strings.TrimSpace(email.From)
+	if email.From == "" {
+		email.From = strings.TrimSpace(d.cfg.From)
+	}
+	if email.From == "" {
+		d.cancel()
+		return nil, errors.New(op).Msg("email from address cannot be empty")
+	}
+	envelope := email.From
+	email.From = s.srsRewrite(email.From, d.cfg.From, s.now())
+	to, err := s.resolveRecipients(ctx, email.To)
+	if err != nil {
+		d.cancel()
+		return nil, errors.New(op).Err(err).Msg("failed to resolve recipients")
+	}
+	if len(to) == 0 {
+		d.cancel()
+		return nil, errors.New(op).Msg("email TO address cannot be empty")
+	}
+	if to = s.dropSuppressed(d, to); len(to) == 0 {
+		d.cancel()
+		return nil, errors.New(op).Msg(errMsgAllSuppressed)
+	}
+	if err = email.Options.validate(op); err != nil {
+		d.cancel()
+		return nil, err
+	}
+	email.To = to
+	email.Msg = withTraceHeader(email.Msg, d.traceID)
+	d.msg = email
+
+	if s.Quota != nil && s.Quota.DailyLimit > 0 {
+		d.quotaCost = s.Quota.cost(len(email.To))
+		if used := s.quota.used(d.cfg.Name, time.Now()); used+d.quotaCost > s.Quota.DailyLimit {
+			d.log.ErrorWith().Str("profile", d.cfg.Name).Int("used", used).Int("limit", s.Quota.DailyLimit).Msg(errMsgQuotaExceeded)
+			d.cancel()
+			return nil, errors.New(op).Err(errQuotaExceeded).Msg(errMsgQuotaExceeded)
+		}
+	}
+	d.rec = newHistoryRecord(email.From, headerRecipients(email.To, email.Bcc), []byte(email.Msg), s.now())
+	d.rec.ExportHash = email.ExportHash
+	if err = s.checkCompliance(d); err != nil {
+		d.cancel()
+		return nil, err
+	}
+	if err = s.checkAlignment(d, envelope); err != nil {
+		d.cancel()
+		return nil, err
+	}
+	return d, nil
+}
+
+// attempt makes a single delivery attempt and records the outcome of a successful one.
+func (s *Service) attempt(d *delivery, attempt int) error {
+	const op errors.Op = "email.Service.attempt"
+	host := strings.TrimSpace(d.cfg.Host)
+	username := strings.TrimSpace(d.cfg.Username)
+	password := strings.TrimSpace(d.cfg.Password)
+
+	var auth smtp.Auth
+	if s.speaksSMTP() {
+		var err error
+		if auth, err = s.smtpAuth(d.ctx, username, password, host); err != nil {
+			return err
+		}
+	}
+
+	s.inflight.add(d)
+	defer s.inflight.remove(d)
+
+	expvarMetrics.Add(metricAttempts, 1)
+	started := time.Now()
+	ctx := withRcptLimit(withReceivedStamp(withRecipientDSN(s.sessionContext(d.ctx, d.cfg), d.msg.Options.DSN), d.msg.received),
+		s.MaxRecipientsPerTransaction)
+	info, err := s.deliver(ctx, d.cfg, auth, d.msg.From, d.msg.To, []byte(d.msg.Msg))
+	if isAuthFailure(err) && auth != nil && s.usesOAuth2() {
+		// The cached access token may have been revoked before it expired; try once more with a fresh one
+		s.OAuth2.invalidate()
+		if auth, err = s.smtpAuth(d.ctx, username, password, host); err != nil {
+			return err
+		}
+		info, err = s.deliver(ctx, d.cfg, auth, d.msg.From, d.msg.To, []byte(d.msg.Msg))
+	}
+	if !d.cancelled() {
+		s.learnOutcome(host, time.Since(started), err)
+		s.trackReachability(host, err)
+	}
+	if err != nil {
+		d.acceptRecipients(info.Accepted)
+		d.unconfirmed = d.unconfirmed || isAcceptanceUnknown(err)
+		if d.cancelled() {
+			err = errors.New(op).Err(fmt.Errorf("%w: %w", errDeliveryCancelled, err)).Msg(errMsgDeliveryCancelled)
+		}
+		sendLogFields(d.log.ErrorWith().Err(err), host, d.rec, len(d.msg.Msg), attempt, info).Msg("email send failed")
+		return err
+	}
+	// A send cancelled after the server accepted the message is delivered regardless, and recorded as sent
+	sendLogFields(d.log.InfoWith(), host, d.rec, len(d.msg.Msg), attempt, info).Msg("email sent")
+	expvarMetrics.Add(metricSent, 1)
+	s.stats.recordSent(time.Since(started))
+	if d.quotaCost > 0 {
+		s.recordQuota(d.log, d.quotaCost)
+	}
+	d.rec.SentAt = s.now().UTC()
+	s.history.add(s.anonymizeRecord(d.rec))
+	s.applyRetention()
+	s.emit(DeliveryEvent{Type: EventSent, MessageID: d.rec.MessageID, Recipients: d.rec.To, Subject: d.rec.Subject, TraceID: d.traceID})
+	return nil
+}
+
+// deliveryFailed records a message that will not be attempted again.
+func (s *Service) deliveryFailed(d *delivery, err error) {
+	recordExpvarError(err)
+	s.stats.recordFailed()
+	s.emit(DeliveryEvent{Type: EventFailed, MessageID: d.rec.MessageID, Recipients: headerRecipients(d.msg.To, d.msg.Bcc), Error: err.Error(),
+		TraceID: d.traceID})
+}
+
+// cancelled reports whether the delivery was aborted by Cancel or its caller's context.
+func (d *delivery) cancelled() bool {
+	return d.ctx.Err() != nil
+}
+
+// inflightSet tracks deliveries currently talking to the server so they can be aborted.
+type inflightSet struct {
+	mu    sync.Mutex
+	items map[*delivery]struct{}
+}
+
+func (f *inflightSet) add(d *delivery) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.items == nil {
+		f.items = make(map[*delivery]struct{})
+	}
+	f.items[d] = struct{}{}
+}
+
+func (f *inflightSet) remove(d *delivery) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.items, d)
+}
+
+// cancel aborts every in-flight delivery whose Message-ID or queue ID equals id.
+func (f *inflightSet) cancel(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	found := false
+	for d := range f.items {
+		if d.rec.MessageID == id || (d.queueID != "" && d.queueID == id) {
+			d.cancel()
+			found = true
+		}
+	}
+	return found
+}
+
+// Cancel aborts the delivery identified by deliveryID, which is either a queue ID or the message's Message-ID.
+// Queued messages are removed from the queue; in-flight ones have their connection closed, which is best effort:
+// a message the server has already accepted cannot be recalled.
+func (s *Service) Cancel(deliveryID string) error {
+	const op errors.Op = "email.Service.Cancel"
+	deliveryID = strings.TrimSpace(deliveryID)
+	if deliveryID == "" {
+		return errors.New(op).Msg("delivery id cannot be empty")
+	}
+	queued := s.queue.remove(deliveryID)
+	if queued != nil {
+		s.unstore(queued.ID)
+	}
+	removed := queued != nil
+	aborted := s.inflight.cancel(deliveryID)
+	if !removed && !aborted {
+		return errors.New(op).Msg(errMsgUnknownDelivery)
+	}
+	s.LoggerService.InfoWith().Str("delivery_id", deliveryID).Bool("queued", removed).Bool("in_flight", aborted).Msg("delivery cancelled")
+	return nil
+}
@@ -0,0 +1,177 @@
+package email
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// defaultDKIMHeaders are signed when present, in this order, unless DKIMConfig.Headers overrides them.
+var defaultDKIMHeaders = []string{"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID", "In-Reply-To",
+	"References", "MIME-Version", "Content-Type", "Content-Transfer-Encoding"}
+
+// DKIMConfig signs outgoing messages for one domain (RFC 6376), using relaxed/relaxed canonicalization.
+type DKIMConfig struct {
+	Domain   string
+	Selector string
+	// Key is an *rsa.PrivateKey (rsa-sha256) or ed25519.PrivateKey (ed25519-sha256, RFC 8463).
+	Key crypto.Signer
+	// Headers lists the header fields to sign; defaults to defaultDKIMHeaders.
+	Headers []string
+}
+
+// dkimFor returns the signing configuration of a message: its identity's, falling back to Service.DKIM.
+func (s *Service) dkimFor(email MsgDef) *DKIMConfig {
+	if id, ok, _ := s.identity(email.Identity); ok && id.DKIM != nil {
+		return id.DKIM
+	}
+	return s.DKIM
+}
+
+// signDKIM prepends a DKIM-Signature to msg. It must run after every other change to the signed headers and body.
+func signDKIM(msg string, cfg *DKIMConfig, now time.Time) (string, error) {
+	const op errors.Op = "email.signDKIM"
+	if cfg.Domain == "" || cfg.Selector == "" || cfg.Key == nil {
+		return "", errors.New(op).Msg("dkim domain, selector and key are required")
+	}
+	algo, err := dkimAlgorithm(cfg.Key)
+	if err != nil {
+		return "", errors.New(op).Err(err).Msg(err.Error())
+	}
+
+	signed, canon, bh := canonicalMessage(msg, cfg.Headers)
+	sigHeader := "DKIM-Signature: v=1; a=" + algo + "; c=relaxed/relaxed; d=" + cfg.Domain + "; s=" + cfg.Selector +
+		"; t=" + strconv.FormatInt(now.Unix(), 10) + "; h=" + strings.Join(signed, ":") + ";\r\n\tbh=" + bh + "; b="
+	canon.WriteString(relaxedHeader(sigHeader))
+	sig, err := dkimSign(cfg.Key, canon.String())
+	if err != nil {
+		return "", errors.New(op).Err(err).Msg("failed to sign message")
+	}
+	return sigHeader + sig + "\r\n" + msg, nil
+}
+
+// dkimAlgorithm returns the a= tag for key.
+func dkimAlgorithm(key crypto.Signer) (string, error) {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return "rsa-sha256", nil
+	case ed25519.PrivateKey:
+		return "ed25519-sha256", nil
+	}
+	return "", errors.New("email.dkimAlgorithm").Msgf("unsupported dkim key type %T", key)
+}
+
+// dkimSign signs the SHA-256 of the canonicalized data and returns the folded base64 b= value.
+func dkimSign(key crypto.Signer, data string) (string, error) {
+	digest := sha256.Sum256([]byte(data))
+	hash := crypto.SHA256
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		hash = crypto.Hash(0) // RFC 8463 signs the digest with PureEdDSA
+	}
+	sig, err := key.Sign(rand.Reader, digest[:], hash)
+	if err != nil {
+		return "", err
+	}
+	return foldBase64(base64.StdEncoding.EncodeToString(sig)), nil
+}
+
+// canonicalMessage returns the names of the headers present to sign, their relaxed canonical form and the body
+// hash, as shared by DKIM-Signature and ARC-Message-Signature.
+func canonicalMessage(msg string, names []string) ([]string, *strings.Builder, string) {
+	head, body, _ := strings.Cut(msg, "\r\n\r\n")
+	fields := headerFields(head)
+	if len(names) == 0 {
+		names = defaultDKIMHeaders
+	}
+	var (
+		signed []string
+		canon  strings.Builder
+	)
+	for _, name := range names {
+		if f, ok := lastField(fields, name); ok {
+			signed = append(signed, strings.ToLower(name))
+			canon.WriteString(relaxedHeader(f))
+			canon.WriteString("\r\n")
+		}
+	}
+	bh := sha256.Sum256([]byte(relaxedBody(body)))
+	return signed, &canon, base64.StdEncoding.EncodeToString(bh[:])
+}
+
+// headerFields splits a header block into fields, each with its continuation lines.
+func headerFields(head string) []string {
+	var fields []string
+	for _, line := range strings.Split(head, "\r\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(fields) > 0 {
+			fields[len(fields)-1] += "\r\n" + line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+// lastField returns the bottom-most field called name, the instance a verifier matches first.
+func lastField(fields []string, name string) (string, bool) {
+	for i := len(fields) - 1; i >= 0; i-- {
+		if k, _, ok := strings.Cut(fields[i], ":"); ok && strings.EqualFold(strings.TrimSpace(k), name) {
+			return fields[i], true
+		}
+	}
+	return "", false
+}
+
+// relaxedHeader applies the relaxed header canonicalization of RFC 6376 section 3.4.2.
+func relaxedHeader(field string) string {
+	k, v, _ := strings.Cut(field, ":")
+	v = strings.ReplaceAll(v, "\r\n", "")
+	return strings.ToLower(strings.TrimSpace(k)) + ":" + strings.Join(strings.Fields(v), " ")
+}
+
+// relaxedBody applies the relaxed body canonicalization of RFC 6376 section 3.4.4.
+func relaxedBody(body string) string {
+	lines := strings.Split(body, "\r\n")
+	for i, line := range lines {
+		collapsed := strings.Join(strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == '\t' }), " ")
+		if line != "" && (line[0] == ' ' || line[0] == '\t') && collapsed != "" {
+			collapsed = " " + collapsed
+		}
+		lines[i] = collapsed
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+func foldBase64(s string) string {
+	var b strings.Builder
+	for len(s) > 72 {
+		b.WriteString(s[:72])
+		b.WriteString("\r\n\t")
+		s = s[72:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// dkimTag returns the value of tag name in a DKIM-Signature or ARC field value, lower-cased.
+func dkimTag(value, name string) string {
+	for _, tag := range strings.Split(value, ";") {
+		if k, v, ok := strings.Cut(tag, "="); ok && strings.TrimSpace(k) == name {
+			return strings.ToLower(strings.TrimSpace(v))
+		}
+	}
+	return ""
+}
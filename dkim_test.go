@@ -0,0 +1,116 @@
+package email
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/smtp"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestDKIMRelaxedCanonicalization(t *testing.T) {
+	// RFC 6376 section 3.4.5
+	if got := relaxedHeader("A: X") + "\r\n" + relaxedHeader("B : Y\t\r\n\tZ  "); got != "a:X\r\nb:Y Z" {
+		t.Errorf("relaxed headers = %q", got)
+	}
+	if got := relaxedBody(" C \r\nD \t E\r\n\r\n\r\n"); got != " C\r\nD E\r\n" {
+		t.Errorf("relaxed body = %q", got)
+	}
+}
+
+// verifyDKIM checks the first DKIM-Signature of msg against pub, recomputing the relaxed/relaxed hashes.
+func verifyDKIM(t *testing.T, msg string, pub crypto.PublicKey) map[string]string {
+	t.Helper()
+	head, body, _ := strings.Cut(msg, "\r\n\r\n")
+	fields := headerFields(head)
+	sigField, ok := lastField(fields, "DKIM-Signature")
+	if !ok {
+		t.Fatal("message is not signed")
+	}
+	tags := map[string]string{}
+	for _, tag := range strings.Split(strings.ReplaceAll(relaxedHeader(sigField)[len("dkim-signature:"):], " ", ""), ";") {
+		if k, v, ok := strings.Cut(tag, "="); ok {
+			tags[k] = v
+		}
+	}
+	bh := sha256.Sum256([]byte(relaxedBody(body)))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(bh[:]) {
+		t.Fatal("body hash mismatch")
+	}
+	var canon strings.Builder
+	for _, name := range strings.Split(tags["h"], ":") {
+		f, _ := lastField(fields, name)
+		canon.WriteString(relaxedHeader(f) + "\r\n")
+	}
+	canon.WriteString(relaxedHeader(regexp.MustCompile(`b=[A-Za-z0-9+/=\s]+$`).ReplaceAllString(sigField, "b=")))
+	digest := sha256.Sum256([]byte(canon.String()))
+	sig, _ := base64.StdEncoding.DecodeString(tags["b"])
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+			t.Fatalf("rsa signature invalid: %v", err)
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, digest[:], sig) {
+			t.Fatal("ed25519 signature invalid")
+		}
+	}
+	return tags
+}
+
+func TestSendSignsWithIdentityDKIM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	s := &Service{
+		Config:    &types.EmailConfig{Enabled: true, Host: "relay.example.net", Port: 587, From: "k1abc@example.com"},
+		DKIM:      &DKIMConfig{Domain: "example.com", Selector: "sm", Key: rsaKey},
+		Alignment: ComplianceStrict,
+		Identities: map[string]SenderIdentity{
+			"club": {From: "info@club.example.org", DisplayName: "Example ARC", DKIM: &DKIMConfig{Domain: "club.example.org", Selector: "arc", Key: edKey}},
+		},
+	}
+	s.isInitialized.Store(true)
+
+	var sent []string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent = append(sent, string(msg))
+		return deliveryInfo{}, nil
+	})
+
+	raw := "From: k1abc@example.com\r\nTo: w1aw@example.org\r\nSubject: Log  export\r\nDate: " + time.Now().Format(time.RFC1123Z) +
+		"\r\nMessage-ID: <dkim-1@example.com>\r\n\r\nLog attached.   \r\n\r\n"
+	if err = s.Send(MsgDef{To: []string{"w1aw@example.org"}, Msg: raw}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Send(MsgDef{To: []string{"w1aw@example.org"}, Msg: raw, Identity: "club"}); err != nil {
+		t.Fatal(err)
+	}
+	if tags := verifyDKIM(t, sent[0], &rsaKey.PublicKey); tags["d"] != "example.com" || tags["a"] != "rsa-sha256" || tags["h"] != "from:subject:date:to:message-id" {
+		t.Errorf("unexpected service signature tags %v", tags)
+	}
+	if tags := verifyDKIM(t, sent[1], edPub); tags["d"] != "club.example.org" || tags["s"] != "arc" || tags["a"] != "ed25519-sha256" {
+		t.Errorf("unexpected identity signature tags %v", tags)
+	}
+
+	// An envelope on the relay's domain is acceptable to strict alignment when the message is signed for From
+	if err = s.Send(MsgDef{From: "bounces@relay.example.net", To: []string{"w1aw@example.org"}, Msg: raw}); err != nil {
+		t.Fatalf("aligned DKIM signature should satisfy alignment: %v", err)
+	}
+	s.DKIM = nil
+	if err = s.Send(MsgDef{From: "bounces@relay.example.net", To: []string{"w1aw@example.org"}, Msg: raw}); err == nil {
+		t.Fatal("expected unsigned misaligned message to be refused")
+	}
+}
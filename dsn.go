@@ -0,0 +1,262 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// DeliveryState is the lifecycle state of a sent message as reported by DSNs (RFC 3464) and MDNs (RFC 8098).
+type DeliveryState string
+
+const (
+	DeliveryStateSent      DeliveryState = "sent"
+	DeliveryStateDelayed   DeliveryState = "delayed"
+	DeliveryStateDelivered DeliveryState = "delivered"
+	DeliveryStateRead      DeliveryState = "read"
+	DeliveryStateDeleted   DeliveryState = "deleted"
+	DeliveryStateBounced   DeliveryState = "bounced"
+	// DeliveryStateComplained is reported by provider feedback loops when a recipient marks the message as spam.
+	DeliveryStateComplained DeliveryState = "complained"
+)
+
+// rank orders states so that a late "delayed" report never hides an earlier bounce or read receipt.
+func (d DeliveryState) rank() int {
+	switch d {
+	case DeliveryStateDelayed:
+		return 1
+	case DeliveryStateDelivered:
+		return 2
+	case DeliveryStateRead, DeliveryStateDeleted, DeliveryStateComplained:
+		return 3
+	case DeliveryStateBounced:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// DeliveryStatus is a single per-recipient report extracted from a DSN or MDN.
+type DeliveryStatus struct {
+	// MessageID is the Message-ID of the original outbound message.
+	MessageID string
+	Recipient string
+	State     DeliveryState
+	// Status is the enhanced status code (e.g. "5.1.1") for DSNs, or the disposition for MDNs.
+	Status     string
+	Diagnostic string
+	ReportedAt time.Time
+}
+
+// ParseDeliveryStatus extracts delivery status records from a multipart/report message: DSNs, MDNs and ARF
+// (RFC 5965) spam complaints. Messages that are not such reports yield no records and no error.
+func ParseDeliveryStatus(msg *InboundMessage) ([]DeliveryStatus, error) {
+	return parseDeliveryStatus(msg, time.Now())
+}
+
+// parseDeliveryStatus is ParseDeliveryStatus, dating reports without a Date field at now.
+func parseDeliveryStatus(msg *InboundMessage, now time.Time) ([]DeliveryStatus, error) {
+	const op errors.Op = "email.ParseDeliveryStatus"
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" {
+		return nil, nil
+	}
+
+	reportedAt := now.UTC()
+	if d, derr := msg.Header.Date(); derr == nil {
+		reportedAt = d.UTC()
+	}
+
+	var (
+		statuses  []DeliveryStatus
+		messageID string
+		// originalTo is the recipient of the reported message, for complaints that redact Original-Rcpt-To
+		originalTo string
+	)
+	mr := multipart.NewReader(bytes.NewReader(msg.Body), params["boundary"])
+	for {
+		p, perr := mr.NextPart()
+		if perr == io.EOF {
+			break
+		}
+		if perr != nil {
+			return nil, errors.New(op).Err(perr).Msg("failed to read report part")
+		}
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		data, rerr := io.ReadAll(p)
+		if rerr != nil {
+			return nil, errors.New(op).Err(rerr).Msg("failed to read report part")
+		}
+		switch partType {
+		case "message/delivery-status", "message/global-delivery-status":
+			recs, derr := parseDSNFields(data)
+			if derr != nil {
+				return nil, errors.New(op).Err(derr).Msg("malformed delivery-status part")
+			}
+			statuses = append(statuses, recs...)
+		case "message/disposition-notification", "message/global-disposition-notification":
+			rec, derr := parseMDNFields(data)
+			if derr != nil {
+				return nil, errors.New(op).Err(derr).Msg("malformed disposition-notification part")
+			}
+			statuses = append(statuses, rec)
+		case "message/feedback-report":
+			rec, derr := parseARFFields(data)
+			if derr != nil {
+				return nil, errors.New(op).Err(derr).Msg("malformed feedback-report part")
+			}
+			statuses = append(statuses, rec)
+		case "text/rfc822-headers", "message/rfc822", "message/global-headers", "message/global":
+			if m, merr := mail.ReadMessage(bytes.NewReader(data)); merr == nil {
+				messageID = strings.TrimSpace(m.Header.Get("Message-Id"))
+				originalTo = strings.TrimSpace(m.Header.Get("To"))
+			}
+		}
+	}
+
+	// Fall back to threading headers when the original headers were not returned
+	if messageID == "" {
+		messageID = strings.TrimSpace(msg.Header.Get("In-Reply-To"))
+	}
+	for i := range statuses {
+		if statuses[i].MessageID == "" {
+			statuses[i].MessageID = messageID
+		}
+		if statuses[i].Recipient == "" && statuses[i].State == DeliveryStateComplained {
+			statuses[i].Recipient = originalTo
+		}
+		statuses[i].ReportedAt = reportedAt
+	}
+	return statuses, nil
+}
+
+// parseDSNFields parses the per-message block followed by one block per recipient.
+func parseDSNFields(data []byte) ([]DeliveryStatus, error) {
+	tr := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	// Per-message fields carry nothing we surface
+	if _, err := tr.ReadMIMEHeader(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	var out []DeliveryStatus
+	for {
+		h, err := tr.ReadMIMEHeader()
+		if len(h) > 0 {
+			ds := DeliveryStatus{
+				Recipient:  addressField(h.Get("Final-Recipient")),
+				Status:     strings.TrimSpace(h.Get("Status")),
+				Diagnostic: addressField(h.Get("Diagnostic-Code")),
+			}
+			if ds.Recipient == "" {
+				ds.Recipient = addressField(h.Get("Original-Recipient"))
+			}
+			switch strings.ToLower(strings.TrimSpace(h.Get("Action"))) {
+			case "failed":
+				ds.State = DeliveryStateBounced
+			case "delayed":
+				ds.State = DeliveryStateDelayed
+			case "delivered", "relayed", "expanded":
+				ds.State = DeliveryStateDelivered
+			}
+			if ds.State != "" {
+				out = append(out, ds)
+			}
+		}
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func parseMDNFields(data []byte) (DeliveryStatus, error) {
+	tr := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	h, err := tr.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return DeliveryStatus{}, err
+	}
+	disposition := strings.ToLower(strings.TrimSpace(h.Get("Disposition")))
+	// Disposition: action-mode/sending-mode; type[/modifier]
+	_, dtype, _ := strings.Cut(disposition, ";")
+	dtype, _, _ = strings.Cut(strings.TrimSpace(dtype), "/")
+
+	ds := DeliveryStatus{
+		MessageID: strings.TrimSpace(h.Get("Original-Message-Id")),
+		Recipient: addressField(h.Get("Final-Recipient")),
+		Status:    dtype,
+		State:     DeliveryStateRead,
+	}
+	if dtype == "deleted" {
+		ds.State = DeliveryStateDeleted
+	}
+	return ds, nil
+}
+
+// parseARFFields reads an RFC 5965 feedback report. Every feedback type is treated as a complaint.
+func parseARFFields(data []byte) (DeliveryStatus, error) {
+	tr := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	hdr, err := tr.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return DeliveryStatus{}, err
+	}
+	return DeliveryStatus{
+		Recipient:  addressField(hdr.Get("Original-Rcpt-To")),
+		State:      DeliveryStateComplained,
+		Status:     strings.ToLower(strings.TrimSpace(hdr.Get("Feedback-Type"))),
+		Diagnostic: strings.TrimSpace(hdr.Get("User-Agent")),
+	}, nil
+}
+
+// addressField strips the type prefix from typed DSN fields such as "rfc822; user@example.com".
+func addressField(v string) string {
+	v = strings.TrimSpace(v)
+	if _, rest, ok := strings.Cut(v, ";"); ok {
+		return strings.TrimSpace(rest)
+	}
+	return v
+}
+
+// ApplyDeliveryStatus parses a DSN or MDN and records its statuses against the matching send history entries.
+func (s *Service) ApplyDeliveryStatus(msg *InboundMessage) ([]DeliveryStatus, error) {
+	const op errors.Op = "email.Service.ApplyDeliveryStatus"
+	statuses, err := parseDeliveryStatus(msg, s.now())
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to parse delivery status")
+	}
+	s.recordDeliveryStatus(statuses)
+	return statuses, nil
+}
+
+// wasRecipient reports whether the send history shows the message ds reports on as sent to ds.Recipient.
+func (s *Service) wasRecipient(ds DeliveryStatus) bool {
+	return s.history.sentTo(ds.MessageID, s.recordedAddress(ds.Recipient))
+}
+
+// recordDeliveryStatus attaches statuses to the send history and publishes their events.
+func (s *Service) recordDeliveryStatus(statuses []DeliveryStatus) {
+	for _, ds := range statuses {
+		s.unconfirmed.confirm(ds.MessageID, ds.Recipient)
+		if !s.history.applyStatus(s.anonymizeStatus(ds)) {
+			s.LoggerService.DebugWith().Str("message_id", ds.MessageID).Str("state", string(ds.State)).Msg("delivery status for unknown message")
+		}
+		if ds.State == DeliveryStateBounced {
+			expvarMetrics.Add(metricBounced, 1)
+			s.stats.recordBounced()
+		}
+		if ds.State == DeliveryStateComplained {
+			s.suppressComplainant(ds)
+		}
+		if ev, ok := deliveryEventForStatus(ds); ok {
+			s.emit(ev)
+		}
+	}
+}
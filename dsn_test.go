@@ -0,0 +1,92 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+const testBounce = "From: MAILER-DAEMON@mx.example.net\r\n" +
+	"To: from@example.com\r\n" +
+	"Subject: Undelivered Mail Returned to Sender\r\n" +
+	"Date: Mon, 02 Jun 2025 10:00:00 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"r1\"\r\n" +
+	"\r\n" +
+	"--r1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Delivery failed.\r\n" +
+	"--r1\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example.net\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; nobody@example.net\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 User unknown\r\n" +
+	"\r\n" +
+	"--r1\r\n" +
+	"Content-Type: text/rfc822-headers\r\n" +
+	"\r\n" +
+	"Message-ID: <sent-1@example.com>\r\n" +
+	"Subject: Log export\r\n" +
+	"\r\n" +
+	"--r1--\r\n"
+
+const testReadReceipt = "From: k1abc@example.net\r\n" +
+	"Subject: Read: Log export\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=disposition-notification; boundary=\"r2\"\r\n" +
+	"\r\n" +
+	"--r2\r\n" +
+	"Content-Type: message/disposition-notification\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; k1abc@example.net\r\n" +
+	"Original-Message-ID: <sent-1@example.com>\r\n" +
+	"Disposition: manual-action/MDN-sent-manually; displayed\r\n" +
+	"\r\n" +
+	"--r2--\r\n"
+
+func TestParseDeliveryStatusBounce(t *testing.T) {
+	msg, err := ParseInbound(strings.NewReader(testBounce))
+	if err != nil {
+		t.Fatalf("ParseInbound failed: %v", err)
+	}
+	statuses, err := ParseDeliveryStatus(msg)
+	if err != nil {
+		t.Fatalf("ParseDeliveryStatus failed: %v", err)
+	}
+	if len(statuses) != 1 {
+		t.Fatalf("expected 1 status, got %d", len(statuses))
+	}
+	ds := statuses[0]
+	if ds.State != DeliveryStateBounced || ds.Status != "5.1.1" || ds.Recipient != "nobody@example.net" {
+		t.Errorf("unexpected status: %+v", ds)
+	}
+	if ds.MessageID != "<sent-1@example.com>" {
+		t.Errorf("expected original message id, got %q", ds.MessageID)
+	}
+}
+
+func TestApplyDeliveryStatusUpdatesHistory(t *testing.T) {
+	s := &Service{}
+	s.history.add(HistoryRecord{MessageID: "<sent-1@example.com>", State: DeliveryStateSent})
+
+	mdn, _ := ParseInbound(strings.NewReader(testReadReceipt))
+	if _, err := s.ApplyDeliveryStatus(mdn); err != nil {
+		t.Fatalf("ApplyDeliveryStatus failed: %v", err)
+	}
+	if got := s.History()[0].State; got != DeliveryStateRead {
+		t.Fatalf("expected read state, got %q", got)
+	}
+
+	bounce, _ := ParseInbound(strings.NewReader(testBounce))
+	if _, err := s.ApplyDeliveryStatus(bounce); err != nil {
+		t.Fatalf("ApplyDeliveryStatus failed: %v", err)
+	}
+	rec := s.History()[0]
+	if rec.State != DeliveryStateBounced || len(rec.Deliveries) != 2 {
+		t.Fatalf("expected bounced state with 2 reports, got %q/%d", rec.State, len(rec.Deliveries))
+	}
+}
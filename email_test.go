@@ -1,6 +1,7 @@
 package email
 
 import (
+	"context"
 	"regexp"
 	"strings"
 	"sync/atomic"
@@ -41,10 +42,8 @@ func TestSend_AddrJoinHostPortAndRetry(t *testing.T) {
 	s.isInitialized.Store(true)
 
 	var calls int32
-	old := sendMailFn
-	t.Cleanup(func() { sendMailFn = old })
 
-	sendMailFn = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
 		// signature adapt using type assertion for smtp.Auth is not possible in test, use interface{}/panic if mismatch
 		atomic.AddInt32(&calls, 1)
 		// ensure address uses JoinHostPort canonical form (host:port)
@@ -52,10 +51,10 @@ func TestSend_AddrJoinHostPortAndRetry(t *testing.T) {
 			t.Errorf("addr not built with JoinHostPort, got %q", addr)
 		}
 		if atomic.LoadInt32(&calls) < 3 {
-			return assertError("temporary")
+			return deliveryInfo{}, assertError("temporary")
 		}
-		return nil
-	}
+		return deliveryInfo{}, nil
+	})
 
 	email := MsgDef{From: "from@example.com", To: []string{"to@example.com"}, Msg: "hi"}
 	if err := s.Send(email); err != nil {
@@ -141,12 +140,10 @@ func TestSendDefaultsEnvelopeFrom(t *testing.T) {
 	s.isInitialized.Store(true)
 
 	var capturedFrom string
-	old := sendMailFn
-	sendMailFn = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
 		capturedFrom = from
-		return nil
-	}
-	t.Cleanup(func() { sendMailFn = old })
+		return deliveryInfo{}, nil
+	})
 
 	if err := s.Send(MsgDef{To: []string{"to@example.com"}, Msg: "body"}); err != nil {
 		t.Fatalf("Send failed: %v", err)
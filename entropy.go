@@ -0,0 +1,44 @@
+package email
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+)
+
+// boundaryBytes is the entropy of a multipart boundary: 192 bits, as the likelihood of a boundary occurring in the
+// content of a part must be negligible.
+const boundaryBytes = 24
+
+// random returns Service.Random, or nil for crypto/rand.
+func (s *Service) random() io.Reader {
+	return s.Random
+}
+
+// randomBytes reads n bytes from r, falling back to crypto/rand when r is nil or cannot supply them, so an
+// exhausted test source never yields a repeated or empty value.
+func randomBytes(r io.Reader, n int) []byte {
+	b := make([]byte, n)
+	if r != nil {
+		if _, err := io.ReadFull(r, b); err == nil {
+			return b
+		}
+	}
+	_, _ = rand.Read(b)
+	return b
+}
+
+// newBoundary returns a multipart boundary. Its "=_" prefix cannot occur in quoted-printable or base64 content,
+// leaving only the unencoded text parts to the randomness.
+func newBoundary(r io.Reader) string {
+	return "=_" + hex.EncodeToString(randomBytes(r, boundaryBytes))
+}
+
+// newMultipartWriter returns a multipart.Writer to w whose boundary is drawn from r.
+func newMultipartWriter(w io.Writer, r io.Reader) *multipart.Writer {
+	mw := multipart.NewWriter(w)
+	// A boundary of valid characters and length cannot be refused
+	_ = mw.SetBoundary(newBoundary(r))
+	return mw
+}
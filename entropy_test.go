@@ -0,0 +1,41 @@
+package email
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestRandomSourceMakesMessagesReproducible(t *testing.T) {
+	now := time.Date(2024, 6, 22, 18, 0, 0, 0, time.UTC)
+	s := &Service{
+		Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "op@example.org"},
+		Clock:  ClockFunc(func() time.Time { return now }),
+	}
+	build := func() string {
+		s.Random = bytes.NewReader(bytes.Repeat([]byte{0xab}, 256))
+		def, err := s.BuildEmailWithAttachments("op@example.org", "Log", "73", []string{"qsl@example.com"},
+			Attachment{Filename: "log.adi", ContentType: "text/plain", Data: []byte("<EOH>")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return def.Msg
+	}
+	first := build()
+	if second := build(); first != second {
+		t.Fatalf("messages differ:\n%s\n%s", first, second)
+	}
+	if !strings.Contains(first, `boundary="=_`+strings.Repeat("ab", boundaryBytes)+`"`) ||
+		!strings.Contains(first, "."+strings.Repeat("ab", 12)+"@") {
+		t.Errorf("boundary and Message-ID not drawn from the source:\n%s", first)
+	}
+
+	// An exhausted source falls back to crypto/rand rather than repeating itself
+	s.Random = bytes.NewReader(nil)
+	if a, b := newBoundary(s.random()), newBoundary(s.random()); a == b || len(a) != 2+2*boundaryBytes {
+		t.Errorf("boundaries %q, %q", a, b)
+	}
+}
@@ -1,5 +1,11 @@
 package email
 
 var (
-	errMsgNotInitialized = "email service not initialized"
+	errMsgNotInitialized    = "email service not initialized"
+	errMsgQuotaExceeded     = "sending would exceed the provider's daily quota"
+	errMsgDeliveryCancelled = "delivery cancelled"
+	errMsgUnknownDelivery   = "no queued or in-flight delivery with that id"
+	errMsgAllSuppressed     = "every recipient is on the suppression list"
+	errMsgTooManyHops       = "message has been re-sent too many times; refusing to forward it again"
+	errMsgNoCaller          = "the send policy requires an identified caller"
 )
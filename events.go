@@ -0,0 +1,124 @@
+package email
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// eventBacklog bounds the events buffered by an asynchronous sink.
+const eventBacklog = 100
+
+// DeliveryEventType identifies a point in a message's delivery lifecycle.
+type DeliveryEventType string
+
+const (
+	EventSent       DeliveryEventType = "sent"
+	EventFailed     DeliveryEventType = "failed"
+	EventDelivered  DeliveryEventType = "delivered"
+	EventRead       DeliveryEventType = "read"
+	EventBounced    DeliveryEventType = "bounced"
+	EventComplained DeliveryEventType = "complained"
+)
+
+// DeliveryEvent is published to every configured EventSink.
+type DeliveryEvent struct {
+	Type       DeliveryEventType `json:"type"`
+	MessageID  string            `json:"message_id,omitempty"`
+	Recipients []string          `json:"recipients,omitempty"`
+	Subject    string            `json:"subject,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	TraceID    string            `json:"trace_id,omitempty"`
+	Time       time.Time         `json:"time"`
+}
+
+// EventSink receives delivery events. Publish is called on the send path and must not block.
+type EventSink interface {
+	Publish(ev DeliveryEvent)
+}
+
+// Stopper is implemented by sinks that deliver on a background worker. Shutdown calls Stop, which must cancel any
+// delivery in flight and return once the worker has exited.
+type Stopper interface {
+	Stop()
+}
+
+func (s *Service) emit(ev DeliveryEvent) {
+	ev = s.anonymizeEvent(ev)
+	if ev.Time.IsZero() {
+		ev.Time = s.now().UTC()
+	}
+	for _, sink := range s.EventSinks {
+		sink.Publish(ev)
+	}
+}
+
+// deliveryEventForStatus maps a DSN/MDN state to the event published for it; ok is false for states not surfaced.
+func deliveryEventForStatus(ds DeliveryStatus) (DeliveryEvent, bool) {
+	ev := DeliveryEvent{MessageID: ds.MessageID, Recipients: []string{ds.Recipient}, Time: ds.ReportedAt}
+	switch ds.State {
+	case DeliveryStateBounced:
+		ev.Type = EventBounced
+		ev.Error = ds.Diagnostic
+	case DeliveryStateDelivered:
+		ev.Type = EventDelivered
+	case DeliveryStateRead:
+		ev.Type = EventRead
+	case DeliveryStateComplained:
+		ev.Type = EventComplained
+	default:
+		return DeliveryEvent{}, false
+	}
+	return ev, true
+}
+
+// eventWorker delivers events in order on a background goroutine so that sinks never delay the send path.
+type eventWorker struct {
+	mu     sync.Mutex
+	events chan DeliveryEvent
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// enqueue hands ev to deliver, starting the worker if it is not running. It reports false when the backlog is full
+// and the event was dropped.
+func (w *eventWorker) enqueue(ev DeliveryEvent, deliver func(context.Context, DeliveryEvent)) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.events == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		events, done := make(chan DeliveryEvent, eventBacklog), make(chan struct{})
+		w.events, w.cancel, w.done = events, cancel, done
+		go func() {
+			defer close(done)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case e := <-events:
+					deliver(ctx, e)
+				}
+			}
+		}()
+	}
+	select {
+	case w.events <- ev:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop cancels the delivery in flight, discards the backlog and waits for the worker to exit. A later enqueue
+// starts it afresh, as a Tenants service restarted after Shutdown shares its sinks.
+func (w *eventWorker) stop() {
+	w.mu.Lock()
+	cancel, done := w.cancel, w.done
+	w.events, w.cancel, w.done = nil, nil, nil
+	w.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
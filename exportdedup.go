@@ -0,0 +1,60 @@
+package email
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// qsoSetHash is an order-independent hash of ADIF records: the sum, modulo 2^256, of their SHA-256 digests, so that
+// the same QSOs streamed in a different order hash the same.
+type qsoSetHash [sha256.Size]byte
+
+func (h *qsoSetHash) add(record string) {
+	sum := sha256.Sum256([]byte(record))
+	carry := 0
+	for i := len(h) - 1; i >= 0; i-- {
+		v := int(h[i]) + int(sum[i]) + carry
+		h[i], carry = byte(v), v>>8
+	}
+}
+
+func (h *qsoSetHash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// ExportUnchanged reports whether def, built by BuildEmailWithADIFStream, carries the same QSOs as the last export
+// successfully sent to each of its recipients. A scheduled export can then be skipped, or replaced by a short
+// "no new QSOs" note, rather than mailing the same log again. Exports that bounced do not count.
+func (s *Service) ExportUnchanged(def MsgDef) bool {
+	if def.ExportHash == "" || len(def.To) == 0 {
+		return false
+	}
+	last := make(map[string]string)
+	for _, rec := range s.history.snapshot() {
+		if rec.ExportHash == "" {
+			continue
+		}
+		for _, to := range rec.To {
+			if !rec.bounced(to) {
+				last[strings.ToLower(to)] = rec.ExportHash
+			}
+		}
+	}
+	for _, to := range def.To {
+		if last[strings.ToLower(s.recordedAddress(to))] != def.ExportHash {
+			return false
+		}
+	}
+	return true
+}
+
+// bounced reports whether a delivery report showed that rec did not reach recipient.
+func (rec HistoryRecord) bounced(recipient string) bool {
+	for _, ds := range rec.Deliveries {
+		if ds.State == DeliveryStateBounced && strings.EqualFold(ds.Recipient, recipient) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"slices"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestExportUnchangedAgainstHistory(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.org", Port: 587, From: "op@example.org", Subject: "Log", Body: "Log attached"}}
+	s.isInitialized.Store(true)
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		return deliveryInfo{Accepted: to}, nil
+	})
+
+	qso := func(call string) types.Qso {
+		var q types.Qso
+		q.Call, q.Band, q.Mode, q.QsoDate, q.TimeOn = call, "20m", "CW", "20261015", "1200"
+		return q
+	}
+	log := []types.Qso{qso("DL1XYZ"), qso("G4ABC"), qso("JA1AA")}
+	build := func(to string, qs []types.Qso) MsgDef {
+		def, err := s.BuildEmailWithADIFStream("", "", "", []string{to}, slices.Values(qs), ADIFOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return def
+	}
+
+	first := build("qsl@club.example", log)
+	if s.ExportUnchanged(first) {
+		t.Fatal("first export reported unchanged")
+	}
+	if _, err := s.SendWithResult(t.Context(), first); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same QSOs in another order are the same export
+	if !s.ExportUnchanged(build("QSL@club.example", []types.Qso{log[2], log[0], log[1]})) {
+		t.Error("reordered export reported changed")
+	}
+	if s.ExportUnchanged(build("qsl@club.example", append(slices.Clone(log), qso("VK2XX")))) {
+		t.Error("export with a new QSO reported unchanged")
+	}
+	if s.ExportUnchanged(build("awards@club.example", log)) {
+		t.Error("export to a new recipient reported unchanged")
+	}
+
+	// A bounced export was never received
+	s.recordDeliveryStatus([]DeliveryStatus{{MessageID: s.History()[0].MessageID, Recipient: "qsl@club.example", State: DeliveryStateBounced}})
+	if s.ExportUnchanged(build("qsl@club.example", log)) {
+		t.Error("export that bounced last time reported unchanged")
+	}
+}
@@ -0,0 +1,35 @@
+package email
+
+import (
+	"expvar"
+	"time"
+)
+
+// Counter names published under the station_manager_email expvar map.
+const (
+	metricAttempts = "attempts"
+	metricSent     = "sent"
+	metricFailed   = "failed"
+	metricBounced  = "bounced"
+)
+
+// expvarMetrics exposes basic counters and the last error through expvar (served on /debug/vars when the host
+// application mounts expvar's handler), so minimal deployments get observability without a metrics stack.
+var (
+	expvarLastError     = new(expvar.String)
+	expvarLastErrorTime = new(expvar.String)
+	expvarMetrics       = newExpvarMetrics()
+)
+
+func newExpvarMetrics() *expvar.Map {
+	m := expvar.NewMap("station_manager_email")
+	m.Set("last_error", expvarLastError)
+	m.Set("last_error_time", expvarLastErrorTime)
+	return m
+}
+
+func recordExpvarError(err error) {
+	expvarMetrics.Add(metricFailed, 1)
+	expvarLastError.Set(err.Error())
+	expvarLastErrorTime.Set(time.Now().UTC().Format(time.RFC3339))
+}
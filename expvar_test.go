@@ -0,0 +1,50 @@
+package email
+
+import (
+	"context"
+	"expvar"
+	"net/smtp"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func expvarInt(name string) int64 {
+	if v, ok := expvarMetrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestExpvarCountersTrackSends(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}}
+	s.isInitialized.Store(true)
+
+	sentBefore, failedBefore, attemptsBefore := expvarInt(metricSent), expvarInt(metricFailed), expvarInt(metricAttempts)
+
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		return deliveryInfo{}, nil
+	})
+	if err := s.Send(MsgDef{To: []string{"to@example.com"}, Msg: "hi"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		return deliveryInfo{}, assertError("550 relay denied")
+	})
+	if err := s.Send(MsgDef{To: []string{"to@example.com"}, Msg: "hi"}); err == nil {
+		t.Fatalf("expected Send to fail")
+	}
+
+	if got := expvarInt(metricSent) - sentBefore; got != 1 {
+		t.Errorf("expected 1 sent, got %d", got)
+	}
+	if got := expvarInt(metricFailed) - failedBefore; got != 1 {
+		t.Errorf("expected 1 failed, got %d", got)
+	}
+	if got := expvarInt(metricAttempts) - attemptsBefore; got != 2 {
+		t.Errorf("expected 2 attempts, got %d", got)
+	}
+	if expvarLastError.Value() != "550 relay denied" {
+		t.Errorf("unexpected last error %q", expvarLastError.Value())
+	}
+}
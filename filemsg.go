@@ -0,0 +1,79 @@
+package email
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// BuildEmailWithFile builds a message carrying r as an attachment named filename. Empty from, to, subject and msg
+// fall back to the config, as for BuildEmailWithADIFAttachment.
+func (s *Service) BuildEmailWithFile(from, subject, msg string, to []string, filename string, r io.Reader) (MsgDef, error) {
+	return s.BuildEmailWithAttachments(from, subject, msg, to, Attachment{Filename: filename, Content: r})
+}
+
+// BuildEmailWithAttachments builds a multipart/mixed message carrying atts after the text body, in order. An
+// attachment without a ContentType gets one from its filename's extension. Empty from, to, subject and msg fall
+// back to the config, as for BuildEmailWithADIFAttachment.
+func (s *Service) BuildEmailWithAttachments(from, subject, msg string, to []string, atts ...Attachment) (MsgDef, error) {
+	const op errors.Op = "email.Service.BuildEmailWithAttachments"
+	cfg := s.config()
+
+	from = strings.TrimSpace(from)
+	if from == "" {
+		from = cfg.From
+	}
+	tos := to
+	if len(tos) == 0 {
+		tos = s.defaultTo(false)
+	}
+	tos, err := s.resolveRecipients(context.Background(), tos)
+	if err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msg("failed to resolve recipients")
+	}
+	if len(tos) == 0 {
+		return MsgDef{}, errors.New(op).Msg("email TO address cannot be empty")
+	}
+	subject = strings.TrimSpace(subject)
+	if subject == "" {
+		subject = cfg.Subject
+	}
+	msg = strings.TrimSpace(msg)
+	if msg == "" {
+		msg = cfg.Body
+	}
+	if len(atts) == 0 {
+		return MsgDef{}, errors.New(op).Msg("at least one attachment is required")
+	}
+	atts = append([]Attachment(nil), atts...)
+	for i := range atts {
+		a := &atts[i]
+		a.Filename = filepath.Base(strings.TrimSpace(a.Filename))
+		if a.Filename == "" || a.Filename == "." || a.Filename == string(filepath.Separator) {
+			return MsgDef{}, errors.New(op).Msg("attachment filename cannot be empty")
+		}
+		if a.ContentType == "" {
+			a.ContentType = mime.TypeByExtension(filepath.Ext(a.Filename))
+		}
+		a.Filename = s.attachmentName(tos, a.Filename)
+	}
+
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("From", from)
+	hdr.Set("To", s.headerTo(tos))
+	hdr.Set("Subject", subject)
+	hdr.Set("Date", s.now().UTC().Format(time.RFC1123Z))
+	hdr.Set("Message-ID", generateMessageID(s.random(), s.now()))
+
+	raw, structure, err := composeMixedMessage(s.random(), hdr, msg, "", atts)
+	if err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msg("failed to compose message")
+	}
+	return MsgDef{From: from, To: tos, Msg: raw, Structure: structure}, nil
+}
@@ -0,0 +1,43 @@
+package email
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestBuildEmailWithAttachments(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{From: "op@example.org", To: "qsl@club.example", Subject: "Field Day", Body: "Files attached"}}
+	photo := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	def, err := s.BuildEmailWithAttachments("", "", "", nil,
+		Attachment{Filename: "logs/fd-2026.adi", Data: []byte("<EOH>\n<CALL:5>DL1AB\n<EOR>\n")},
+		Attachment{Filename: "antenna.png", Content: bytes.NewReader(photo)},
+		Attachment{Filename: "notes", ContentType: "text/plain", Data: []byte("73")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := ParseInbound(strings.NewReader(def.Msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	atts, err := in.Attachments()
+	if err != nil || len(atts) != 3 {
+		t.Fatalf("attachments = %+v, %v", atts, err)
+	}
+	if atts[0].Filename != "fd-2026.adi" || atts[1].ContentType != "image/png" || !bytes.Equal(atts[1].Data, photo) || atts[2].ContentType != "text/plain" {
+		t.Errorf("attachments = %+v", atts)
+	}
+	if parts := def.Structure.Attachments(); len(parts) != 3 || parts[1].Size != len(photo) {
+		t.Errorf("structure = %+v", parts)
+	}
+
+	if _, err = s.BuildEmailWithAttachments("", "", "", nil); err == nil {
+		t.Error("expected a message without attachments to be rejected")
+	}
+	if _, err = s.BuildEmailWithAttachments("", "", "", nil, Attachment{Filename: " ", Data: photo}); err == nil {
+		t.Error("expected an attachment without a filename to be rejected")
+	}
+}
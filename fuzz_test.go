@@ -0,0 +1,111 @@
+package email
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+// addEMLSeeds seeds f with the real-world messages in testdata/eml.
+func addEMLSeeds(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("testdata", "eml", "*.eml"))
+	if err != nil || len(files) == 0 {
+		f.Fatalf("no seed corpus found: %v", err)
+	}
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			f.Fatalf("read %s: %v", name, err)
+		}
+		f.Add(data)
+	}
+}
+
+func FuzzParseInbound(f *testing.F) {
+	addEMLSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := ParseInbound(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		_ = msg.Subject()
+		_ = msg.Sender()
+		_ = msg.HasAttachment()
+		_, _ = msg.Attachments()
+		_, _ = ParseDeliveryStatus(msg)
+		_ = isAutomatedMessage(msg)
+	})
+}
+
+func TestParseInboundSeedCorpus(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join("testdata", "eml", "*.eml"))
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if _, err = ParseInbound(bytes.NewReader(data)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// headerCount counts the header fields named name in a built message.
+func headerCount(t *testing.T, msg, name string) int {
+	in, err := ParseInbound(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("built message does not parse: %v\n%q", err, msg)
+	}
+	return len(in.Header[name])
+}
+
+func FuzzBuildEmailWithADIFAttachment(f *testing.F) {
+	f.Add("from@example.com", "Log export", "hello world")
+	f.Add("", "Subject\r\nBcc: victim@example.net", "body\r\n.\r\nMAIL FROM:<x>")
+	f.Add("a@example.com\r\nX-Injected: 1", "{{.QSOCount}} QSOs", "Süd 73")
+	s := &Service{Config: &types.EmailConfig{From: "from@example.com", To: "alice@example.com"}}
+	qs := []types.Qso{{LogbookID: 1, SessionID: 1}}
+	f.Fuzz(func(t *testing.T, from, subject, body string) {
+		def, err := s.BuildEmailWithADIFAttachment(from, subject, body, nil, qs)
+		if err != nil {
+			return
+		}
+		for _, h := range []string{"From", "To", "Subject", "Bcc", "X-Injected"} {
+			want := 1
+			if h == "Bcc" || h == "X-Injected" {
+				want = 0
+			}
+			if n := headerCount(t, def.Msg, h); n != want {
+				t.Fatalf("header %s appears %d times; input leaked into the header block", h, n)
+			}
+		}
+		if atts := def.Structure.Attachments(); len(atts) != 1 {
+			t.Fatalf("expected one attachment, got %d", len(atts))
+		}
+	})
+}
+
+func FuzzComposeNotification(f *testing.F) {
+	f.Add("Rig alert", "SWR high")
+	f.Add("Réseau\r\nBcc: victim@example.net", "=?utf-8?q?x?=")
+	f.Add(strings.Repeat("long subject ", 40), "")
+	s := &Service{Config: &types.EmailConfig{From: "from@example.com", To: "alice@example.com"}}
+	f.Fuzz(func(t *testing.T, subject, body string) {
+		def, err := s.composeNotification(t.Context(), []string{"op@example.com"}, subject, body, nil, nil)
+		if err != nil {
+			return
+		}
+		if n := headerCount(t, def.Msg, "Bcc"); n != 0 {
+			t.Fatalf("subject leaked into the header block")
+		}
+		for _, v := range def.Validate() {
+			if v.Rule == "header-syntax" || v.Rule == "header-count" || v.Rule == "structure" {
+				t.Fatalf("header encoder produced %v for subject %q", v, subject)
+			}
+		}
+	})
+}
@@ -8,6 +8,7 @@ require (
 	github.com/Station-Manager/errors v0.0.11
 	github.com/Station-Manager/logging v0.0.12
 	github.com/Station-Manager/types v0.0.71
+	golang.org/x/crypto v0.46.0
 )
 
 require (
@@ -25,7 +26,6 @@ require (
 	github.com/rs/zerolog v1.34.0 // indirect
 	go.bug.st/serial v1.6.4 // indirect
 	go.uber.org/atomic v1.11.0 // indirect
-	golang.org/x/crypto v0.46.0 // indirect
 	golang.org/x/net v0.48.0 // indirect
 	golang.org/x/sys v0.39.0 // indirect
 	golang.org/x/text v0.32.0 // indirect
@@ -0,0 +1,53 @@
+package email
+
+import (
+	stderr "errors"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+)
+
+// greylistPatterns are reply fragments used by common greylisting implementations (postgrey, Exim, Microsoft,
+// Yahoo) on their 450/451 responses.
+var greylistPatterns = []string{
+	"greylist",
+	"graylist",
+	"try again later",
+	"please retry",
+	"temporarily deferred",
+	"temporarily rejected",
+	"4.7.1",
+}
+
+// isGreylisted reports whether err is a transient SMTP reply that looks like greylisting rather than a real fault.
+func isGreylisted(err error) bool {
+	var tpErr *textproto.Error
+	if !stderr.As(err, &tpErr) {
+		return false
+	}
+	if tpErr.Code != 450 && tpErr.Code != 451 {
+		return false
+	}
+	msg := strings.ToLower(tpErr.Msg)
+	for _, p := range greylistPatterns {
+		if strings.Contains(msg, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransient reports whether err is worth retrying later: a 4xx SMTP reply or a network-level failure. 5xx replies
+// and local faults (bad config, TLS verification) are permanent.
+func isTransient(err error) bool {
+	var tpErr *textproto.Error
+	if stderr.As(err, &tpErr) {
+		return tpErr.Code >= 400 && tpErr.Code < 500
+	}
+	var netErr net.Error
+	if stderr.As(err, &netErr) {
+		return true
+	}
+	return stderr.Is(err, io.EOF) || stderr.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,158 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestIsGreylisted(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&textproto.Error{Code: 451, Msg: "4.7.1 Greylisted, please try again later"}, true},
+		{&textproto.Error{Code: 450, Msg: "4.2.0 Recipient address rejected: Greylisted"}, true},
+		{&textproto.Error{Code: 421, Msg: "4.7.0 Try again later, closing connection"}, false},
+		{&textproto.Error{Code: 451, Msg: "4.3.0 Local error in processing"}, false},
+		{&textproto.Error{Code: 550, Msg: "5.7.1 greylist policy violation"}, false},
+		{errors.New("dial tcp: connection refused"), false},
+	}
+	for _, c := range cases {
+		if got := isGreylisted(c.err); got != c.want {
+			t.Errorf("isGreylisted(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestSendQueuesGreylistedMessage(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com", SmtpRetryCount: 3}}
+	s.QueueConfig.GreylistDelay = time.Minute
+	s.isInitialized.Store(true)
+
+	calls := 0
+	greylisted := true
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		calls++
+		if greylisted {
+			return deliveryInfo{}, &textproto.Error{Code: 451, Msg: "4.7.1 Greylisted, try again later"}
+		}
+		return deliveryInfo{}, nil
+	})
+
+	if err := s.Send(MsgDef{To: []string{"to@example.com"}, Msg: "Subject: hi\r\n\r\nbody"}); err != nil {
+		t.Fatalf("expected greylisted send to be queued, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected no immediate retries after greylisting, got %d attempts", calls)
+	}
+	if s.QueueDepth() != 1 {
+		t.Fatalf("expected one queued message, got %d", s.QueueDepth())
+	}
+
+	// Not due yet
+	s.flushQueue(t.Context(), time.Now())
+	if calls != 1 {
+		t.Fatalf("queued message retried before the greylist delay elapsed")
+	}
+
+	greylisted = false
+	s.flushQueue(t.Context(), time.Now().Add(2*time.Minute))
+	if calls != 2 || s.QueueDepth() != 0 {
+		t.Fatalf("expected queued message to be delivered, calls=%d depth=%d", calls, s.QueueDepth())
+	}
+	if h := s.History(); len(h) != 1 {
+		t.Fatalf("expected one history record after delivery, got %d", len(h))
+	}
+}
+
+func TestQueueGivesUpAfterMaxAttempts(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}}
+	s.QueueConfig.MaxAttempts = 2
+	s.isInitialized.Store(true)
+
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		return deliveryInfo{}, &textproto.Error{Code: 451, Msg: "greylisted"}
+	})
+
+	if err := s.Send(MsgDef{To: []string{"to@example.com"}, Msg: "Subject: hi\r\n\r\nbody"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.flushQueue(t.Context(), time.Now().Add(time.Hour))
+	if s.QueueDepth() != 0 {
+		t.Fatalf("expected message to be dropped after max attempts, depth=%d", s.QueueDepth())
+	}
+}
+
+func TestSendHandsOffTransientFailureToQueue(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com", SmtpRetryCount: 5, SmtpRetryDelaySec: 30}}
+	s.QueueConfig.HandOffTransient = true
+	s.isInitialized.Store(true)
+
+	calls := 0
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		calls++
+		return deliveryInfo{}, &textproto.Error{Code: 421, Msg: "4.3.2 Service not available"}
+	})
+
+	res, err := s.SendWithResult(t.Context(), MsgDef{To: []string{"to@example.com"}, Msg: "Subject: hi\r\n\r\nbody"})
+	if err != nil {
+		t.Fatalf("expected transient failure to be queued, got %v", err)
+	}
+	if res.Status != SendStatusQueued || res.QueueID == "" || calls != 1 {
+		t.Fatalf("unexpected result %+v after %d attempts", res, calls)
+	}
+	if q := s.Queued(); len(q) != 1 || q[0].Attempts != 1 {
+		t.Fatalf("expected foreground attempt to count against the queue budget, got %+v", q)
+	}
+
+	// Permanent failures are not queued
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		return deliveryInfo{}, &textproto.Error{Code: 550, Msg: "5.1.1 No such user"}
+	})
+	if _, err = s.SendWithResult(t.Context(), MsgDef{To: []string{"nobody@example.com"}, Msg: "Subject: hi\r\n\r\nbody"}); err == nil {
+		t.Fatalf("expected permanent failure to be returned")
+	}
+	if s.QueueDepth() != 1 {
+		t.Fatalf("permanent failure should not be queued, depth=%d", s.QueueDepth())
+	}
+}
+
+func TestQueueDrainsByPriority(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}}
+	s.isInitialized.Store(true)
+
+	var order []string
+	failing := true
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		if failing {
+			return deliveryInfo{}, &textproto.Error{Code: 451, Msg: "4.7.1 Greylisted"}
+		}
+		order = append(order, to[0])
+		return deliveryInfo{}, nil
+	})
+
+	for _, m := range []MsgDef{
+		{To: []string{"bulk@example.com"}, Priority: PriorityBulk},
+		{To: []string{"normal@example.com"}},
+		{To: []string{"urgent@example.com"}, Priority: PriorityUrgent},
+	} {
+		m.Msg = "Subject: hi\r\n\r\nbody"
+		if err := s.Send(m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	failing = false
+	s.flushQueue(t.Context(), time.Now().Add(time.Hour))
+	want := []string{"urgent@example.com", "normal@example.com", "bulk@example.com"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected drain order %v", order)
+	}
+}
@@ -0,0 +1,90 @@
+package email
+
+import (
+	"io"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/logging"
+)
+
+// HeaderAction decides what happens to an original header when Forward re-sends a message.
+type HeaderAction string
+
+const (
+	HeaderKeep  HeaderAction = "keep"
+	HeaderStrip HeaderAction = "strip"
+	// HeaderRewrite replaces the values with those returned by HeaderPolicy.Rewrite.
+	HeaderRewrite HeaderAction = "rewrite"
+)
+
+// HeaderPolicy controls which original headers Forward preserves, strips or rewrites, e.g. stripping Received to
+// avoid leaking internal hosts or giving the copy a new Message-ID.
+type HeaderPolicy struct {
+	// Actions maps header names, matched case-insensitively, to their action.
+	Actions map[string]HeaderAction
+	// Default applies to headers without an entry in Actions; empty keeps them.
+	Default HeaderAction
+	// Rewrite returns the new values of a header under HeaderRewrite; none strips it. nil gives Message-ID a fresh
+	// ID and strips any other rewritten header.
+	Rewrite func(name string, values []string) []string
+}
+
+// defaultHeaderPolicy drops the headers that belong to the final delivery or must never travel further.
+var defaultHeaderPolicy = HeaderPolicy{Actions: map[string]HeaderAction{
+	"Return-Path": HeaderStrip,
+	"Bcc":         HeaderStrip,
+}}
+
+func (p *HeaderPolicy) action(name string) HeaderAction {
+	for k, a := range p.Actions {
+		if strings.EqualFold(k, name) {
+			return a
+		}
+	}
+	if p.Default != "" {
+		return p.Default
+	}
+	return HeaderKeep
+}
+
+func (p *HeaderPolicy) rewrite(name string, values []string, r io.Reader, now time.Time) []string {
+	if p.Rewrite != nil {
+		return p.Rewrite(name, values)
+	}
+	if strings.EqualFold(name, "Message-Id") {
+		return []string{generateMessageID(r, now)}
+	}
+	return nil
+}
+
+// apply returns the headers to re-send. A kept DKIM-Signature covering a header the policy changed will no longer
+// verify, which is logged since the policy may not have intended it.
+func (p *HeaderPolicy) apply(hdr map[string][]string, r io.Reader, now time.Time, log *logging.Service) map[string][]string {
+	out := make(map[string][]string, len(hdr))
+	changed := map[string]bool{}
+	for k, values := range hdr {
+		switch p.action(k) {
+		case HeaderStrip:
+			changed[strings.ToLower(k)] = true
+		case HeaderRewrite:
+			changed[strings.ToLower(k)] = true
+			if nv := p.rewrite(k, values, r, now); len(nv) > 0 {
+				out[k] = nv
+			}
+		default:
+			out[k] = values
+		}
+	}
+	for _, sig := range out[textproto.CanonicalMIMEHeaderKey("DKIM-Signature")] {
+		for _, name := range strings.Split(dkimTag(sig, "h"), ":") {
+			if name = strings.TrimSpace(name); changed[name] {
+				log.WarnWith().Str("domain", dkimTag(sig, "d")).Str("header", name).
+					Msg("forward header policy changes a DKIM-signed header; the original signature will fail")
+				break
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,92 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestForwardHeaderPolicy(t *testing.T) {
+	var sent string
+	deliver := smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent = string(msg)
+		return deliveryInfo{}, nil
+	})
+
+	raw := "Return-Path: <w1aw@example.org>\r\n" +
+		"Received: from laptop.internal.example.org (10.0.0.7) by mx.example.org\r\n" +
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.org; s=sel; h=from:subject:message-id; bh=x; b=y\r\n" +
+		"From: W1AW <w1aw@example.org>\r\n" +
+		"Bcc: secret@example.org\r\n" +
+		"Subject: Net tonight\r\n" +
+		"Message-ID: <net-1@example.org>\r\n" +
+		"\r\n" +
+		"73\r\n"
+	in, err := ParseInbound(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		policy  *HeaderPolicy
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "default",
+			want:    []string{"Received: from laptop", "Dkim-Signature: v=1", "Message-Id: <net-1@example.org>"},
+			notWant: []string{"Return-Path:", "Bcc:"},
+		},
+		{
+			name: "strip and rewrite",
+			policy: &HeaderPolicy{Actions: map[string]HeaderAction{
+				"received":       HeaderStrip,
+				"DKIM-Signature": HeaderStrip,
+				"Message-ID":     HeaderRewrite,
+			}},
+			want:    []string{"Message-Id: <", "Bcc: secret@example.org"},
+			notWant: []string{"Received:", "Dkim-Signature:", "net-1@example.org"},
+		},
+		{
+			name: "allow list with custom rewrite",
+			policy: &HeaderPolicy{
+				Default: HeaderStrip,
+				Actions: map[string]HeaderAction{"From": HeaderKeep, "Subject": HeaderRewrite},
+				Rewrite: func(name string, values []string) []string { return []string{"[club] " + values[0]} },
+			},
+			want:    []string{"From: W1AW <w1aw@example.org>", "Subject: [club] Net tonight"},
+			notWant: []string{"Received:", "Message-Id:", "Dkim-Signature:"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &Service{
+				Config:         &types.EmailConfig{Enabled: true, Host: "smtp.club.example", Port: 587, From: "relay@club.example"},
+				ForwardHeaders: c.policy,
+				Transport:      deliver,
+			}
+			s.isInitialized.Store(true)
+			if _, err := s.Forward(t.Context(), in, []string{"president@club.example"}); err != nil {
+				t.Fatal(err)
+			}
+			head, _, _ := strings.Cut(sent, "\r\n\r\n")
+			for _, w := range c.want {
+				if !strings.Contains(head, w) {
+					t.Errorf("missing %q in:\n%s", w, head)
+				}
+			}
+			for _, w := range c.notWant {
+				if strings.Contains(head, w) {
+					t.Errorf("unexpected %q in:\n%s", w, head)
+				}
+			}
+			if !strings.Contains(head, "Resent-Message-ID: <") {
+				t.Error("Resent-* fields must not be subject to the policy")
+			}
+		})
+	}
+}
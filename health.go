@@ -0,0 +1,20 @@
+package email
+
+import "time"
+
+// HealthCheck is the latest outcome of a background check that does not fail Initialize.
+type HealthCheck struct {
+	Name      string
+	OK        bool
+	Error     string
+	CheckedAt time.Time
+}
+
+// Health reports the background checks that have run so far.
+func (s *Service) Health() []HealthCheck {
+	var checks []HealthCheck
+	if c, ok := s.warm.health(); ok {
+		checks = append(checks, c)
+	}
+	return checks
+}
@@ -0,0 +1,38 @@
+package email
+
+import (
+	stderr "errors"
+	"net"
+	"strings"
+)
+
+// errHelloFailed marks a greeting that failed even after net/smtp's own HELO fallback, which usually means a
+// pre-ESMTP server hung up on the unknown EHLO verb.
+var errHelloFailed = stderr.New("smtp greeting failed")
+
+// heloReject is the reply heloOnlyConn fabricates for EHLO.
+const heloReject = "502 5.5.1 EHLO not used with this server\r\n"
+
+// heloOnlyConn keeps EHLO from ever reaching the server: it answers the command locally with a 502, so net/smtp falls
+// straight back to HELO. A session greeted this way has no extensions, so no AUTH, SIZE, 8BITMIME or SMTPUTF8.
+type heloOnlyConn struct {
+	net.Conn
+	pending []byte
+}
+
+func (c *heloOnlyConn) Write(p []byte) (int, error) {
+	if len(p) >= 5 && strings.EqualFold(string(p[:5]), "EHLO ") {
+		c.pending = append(c.pending, heloReject...)
+		return len(p), nil
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *heloOnlyConn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
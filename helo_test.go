@@ -0,0 +1,111 @@
+package email
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// startPreESMTPServer emulates a relay that hangs up on EHLO and otherwise speaks RFC 821. It records the
+// verbs it was sent.
+func startPreESMTPServer(t *testing.T) (string, func() []string) {
+	t.Helper()
+	addr, lines := startScriptedSMTPServer(t, nil)
+	return addr, func() []string {
+		var verbs []string
+		for _, l := range lines() {
+			verbs = append(verbs, strings.ToUpper(strings.Fields(l)[0]))
+		}
+		return verbs
+	}
+}
+
+// startScriptedSMTPServer accepts implicit-TLS sessions that advertise ehlo, or hang up on EHLO when ehlo is nil,
+// and accept every message. It records the command lines it was sent.
+func startScriptedSMTPServer(t *testing.T, ehlo []string) (string, func() []string) {
+	t.Helper()
+	ln := fakeTLSListener(t)
+	var mu sync.Mutex
+	var seen []string
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer func() { _ = conn.Close() }()
+				r := bufio.NewReader(conn)
+				_, _ = conn.Write([]byte("220 gateway SMTP ready\r\n"))
+				inData := false
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if inData {
+						if line == ".\r\n" {
+							inData = false
+							_, _ = conn.Write([]byte("250 OK\r\n"))
+						}
+						continue
+					}
+					verb := strings.ToUpper(strings.Fields(line)[0])
+					mu.Lock()
+					seen = append(seen, strings.TrimSpace(line))
+					mu.Unlock()
+					switch verb {
+					case "EHLO":
+						if ehlo == nil {
+							return
+						}
+						for i, ext := range ehlo {
+							sep := "-"
+							if i == len(ehlo)-1 {
+								sep = " "
+							}
+							_, _ = conn.Write([]byte("250" + sep + ext + "\r\n"))
+						}
+					case "HELO", "NOOP", "MAIL", "RCPT":
+						_, _ = conn.Write([]byte("250 OK\r\n"))
+					case "DATA":
+						inData = true
+						_, _ = conn.Write([]byte("354 go ahead\r\n"))
+					case "QUIT":
+						_, _ = conn.Write([]byte("221 bye\r\n"))
+						return
+					default:
+						_, _ = conn.Write([]byte("500 unrecognised command\r\n"))
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), seen...)
+	}
+}
+
+func TestSendFallsBackToHELO(t *testing.T) {
+	addr, seen := startPreESMTPServer(t)
+	auth, err := (&Service{}).smtpAuth(t.Context(), "op", "secret", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("smtpAuth: %v", err)
+	}
+
+	info, err := sendMailWithTLS(withRootCAs(t.Context(), fakeRoots()), addr, auth, "op@example.com", []string{"dx@example.org"}, []byte("Subject: hi\r\n\r\n73\r\n"))
+	if err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if !info.Legacy {
+		t.Fatalf("expected a legacy HELO session, got %+v", info)
+	}
+	got := strings.Join(seen(), " ")
+	if got != "EHLO HELO MAIL RCPT DATA QUIT" {
+		t.Fatalf("unexpected command sequence %q", got)
+	}
+}
@@ -0,0 +1,137 @@
+package email
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/logging"
+)
+
+// historyCapacity bounds the in-memory send history; the oldest records are evicted first.
+const historyCapacity = 1000
+
+// HistoryRecord describes one message accepted by the mail server and what is known about its delivery since.
+type HistoryRecord struct {
+	MessageID  string
+	From       string
+	To         []string
+	Subject    string
+	SentAt     time.Time
+	State      DeliveryState
+	Deliveries []DeliveryStatus
+	// ExportHash is the MsgDef.ExportHash of an ADIF export.
+	ExportHash string
+}
+
+type sendHistory struct {
+	mu      sync.Mutex
+	records []HistoryRecord
+}
+
+func (h *sendHistory) add(rec HistoryRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.records) >= historyCapacity {
+		h.records = append(h.records[:0], h.records[1:]...)
+	}
+	h.records = append(h.records, rec)
+}
+
+// applyStatus attaches ds to the record sent with the same Message-ID and reports whether one was found.
+func (h *sendHistory) applyStatus(ds DeliveryStatus) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.records) - 1; i >= 0; i-- {
+		rec := &h.records[i]
+		if rec.MessageID == "" || rec.MessageID != ds.MessageID {
+			continue
+		}
+		rec.Deliveries = append(rec.Deliveries, ds)
+		if ds.State.rank() > rec.State.rank() {
+			rec.State = ds.State
+		}
+		return true
+	}
+	return false
+}
+
+// sentTo reports whether the record sent with msgID lists addr, as recordedAddress gives it, among its recipients.
+func (h *sendHistory) sentTo(msgID, addr string) bool {
+	if msgID == "" {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.records) - 1; i >= 0; i-- {
+		if h.records[i].MessageID == msgID {
+			return listsAddress(h.records[i].To, addr)
+		}
+	}
+	return false
+}
+
+// listsAddress reports whether list holds addr, compared as suppressionKey normalises them.
+func listsAddress(list []string, addr string) bool {
+	key := suppressionKey(addr)
+	if key == "" {
+		return false
+	}
+	for _, a := range list {
+		if suppressionKey(a) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *sendHistory) snapshot() []HistoryRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]HistoryRecord, len(h.records))
+	for i, rec := range h.records {
+		rec.To = append([]string(nil), rec.To...)
+		rec.Deliveries = append([]DeliveryStatus(nil), rec.Deliveries...)
+		out[i] = rec
+	}
+	return out
+}
+
+// History returns a copy of the send history, oldest first.
+func (s *Service) History() []HistoryRecord {
+	s.applyRetention()
+	return s.history.snapshot()
+}
+
+// newHistoryRecord extracts the identifying headers from a rendered message sent at now.
+func newHistoryRecord(from string, to []string, msg []byte, now time.Time) HistoryRecord {
+	rec := HistoryRecord{
+		From:   from,
+		To:     append([]string(nil), to...),
+		SentAt: now.UTC(),
+		State:  DeliveryStateSent,
+	}
+	if m, err := mail.ReadMessage(strings.NewReader(string(msg))); err == nil {
+		rec.MessageID = strings.TrimSpace(m.Header.Get("Message-Id"))
+		rec.Subject = m.Header.Get("Subject")
+	}
+	return rec
+}
+
+// sendLogFields adds the per-send fields used by log-based dashboards. The subject is hashed so that logs can
+// group related sends without recording message content.
+func sendLogFields(ev logging.LogEvent, host string, rec HistoryRecord, size, attempt int, info deliveryInfo) logging.LogEvent {
+	sum := sha256.Sum256([]byte(rec.Subject))
+	return ev.Str("host", host).
+		Str("message_id", rec.MessageID).
+		Str("subject_hash", hex.EncodeToString(sum[:8])).
+		Int("recipients", len(rec.To)).
+		Int("size", size).
+		Int("attempt", attempt).
+		Str("transport", info.Transport).
+		Str("tls_version", info.TLSVersion).
+		Bool("legacy_helo", info.Legacy)
+}
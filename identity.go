@@ -0,0 +1,142 @@
+package email
+
+import (
+	"html"
+	"net/mail"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+// SenderIdentity is a named way of presenting outgoing mail, e.g. "K1ABC Contest" and "K1ABC Personal" sharing
+// one SMTP account. Empty fields leave the message and the configuration as they are.
+type SenderIdentity struct {
+	From        string
+	DisplayName string
+	ReplyTo     string
+	// Signature is appended, after a "-- " delimiter, to the first plain text and first HTML part.
+	Signature string
+	// Transport sends the identity's mail through another SMTP profile instead of the active configuration.
+	Transport *types.EmailConfig
+	// DKIM signs the identity's mail for its own domain instead of with Service.DKIM.
+	DKIM *DKIMConfig
+}
+
+// identity returns the identity a message selected; ok is false when it selected none.
+func (s *Service) identity(name string) (SenderIdentity, bool, error) {
+	const op errors.Op = "email.Service.identity"
+	if name == "" {
+		return SenderIdentity{}, false, nil
+	}
+	id, ok := s.Identities[name]
+	if !ok {
+		return SenderIdentity{}, false, errors.New(op).Msgf("unknown sender identity %q", name)
+	}
+	return id, true, nil
+}
+
+// transportFor returns the configuration a message is sent with: its identity's transport, if any, or the live
+// configuration.
+func (s *Service) transportFor(email MsgDef) (*types.EmailConfig, error) {
+	const op errors.Op = "email.Service.transportFor"
+	id, ok, err := s.identity(email.Identity)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg(err.Error())
+	}
+	if !ok || id.Transport == nil {
+		return s.config(), nil
+	}
+	if err = validateEmailConfig(op, id.Transport); err != nil {
+		return nil, err
+	}
+	return id.Transport, nil
+}
+
+// applyIdentity rewrites the From and Reply-To headers, envelope sender and signature of email for the identity
+// it selected. Like middleware it runs once per send, and Structure is not kept in sync.
+func (s *Service) applyIdentity(email MsgDef) (MsgDef, error) {
+	const op errors.Op = "email.Service.applyIdentity"
+	id, ok, err := s.identity(email.Identity)
+	if err != nil || !ok {
+		return email, err
+	}
+	if from := strings.TrimSpace(id.From); from != "" {
+		email.From = from
+		email.Msg = setHeader(email.Msg, "From", (&mail.Address{Name: id.DisplayName, Address: from}).String())
+	}
+	if replyTo := strings.TrimSpace(id.ReplyTo); replyTo != "" {
+		email.Msg = setHeader(email.Msg, "Reply-To", replyTo)
+	}
+	if id.Signature != "" {
+		if email.Msg, err = rewriteText(email.Msg, signWith(id.Signature)); err != nil {
+			return email, errors.New(op).Err(err).Msg("failed to add signature")
+		}
+	}
+	return email, nil
+}
+
+// signWith appends signature to the first text/plain and the first text/html part it sees.
+func signWith(signature string) textRewrite {
+	sig := strings.ReplaceAll(strings.ReplaceAll(strings.TrimRight(signature, "\r\n"), "\r\n", "\n"), "\n", "\r\n")
+	var plainDone, htmlDone bool
+	return func(mediaType, text string) string {
+		switch {
+		case mediaType == "text/plain" && !plainDone:
+			plainDone = true
+			return strings.TrimRight(text, "\r\n") + "\r\n\r\n-- \r\n" + sig + "\r\n"
+		case mediaType == "text/html" && !htmlDone:
+			htmlDone = true
+			block := `<div class="signature">-- <br>` + strings.ReplaceAll(html.EscapeString(sig), "\r\n", "<br>") + `</div>`
+			if i := strings.LastIndex(strings.ToLower(text), "</body>"); i >= 0 {
+				return text[:i] + block + text[i:]
+			}
+			return text + block
+		}
+		return text
+	}
+}
+
+// setHeader replaces every occurrence of the header field name, continuation lines included, with a single field
+// in the place of the first, or appends it to the header block when absent.
+func setHeader(msg, name, value string) string {
+	return replaceHeader(msg, name, name+": "+headerBreaks.Replace(value))
+}
+
+// removeHeader removes every occurrence of the header field name, continuation lines included.
+func removeHeader(msg, name string) string {
+	return replaceHeader(msg, name, "")
+}
+
+// replaceHeader replaces the fields name with field, or removes them when field is empty.
+func replaceHeader(msg, name, field string) string {
+	head, body, hasBody := strings.Cut(msg, "\r\n\r\n")
+	var (
+		lines    []string
+		placed   bool
+		skipping bool
+	)
+	for _, line := range strings.Split(head, "\r\n") {
+		if skipping && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			continue
+		}
+		skipping = false
+		if k, _, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(k), name) {
+			skipping = true
+			if !placed && field != "" {
+				lines = append(lines, field)
+				placed = true
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if !placed && field != "" {
+		lines = append(lines, field)
+	}
+	out := strings.Join(lines, "\r\n")
+	if hasBody {
+		out += "\r\n\r\n" + body
+	}
+	return out
+}
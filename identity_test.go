@@ -0,0 +1,68 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+func TestSendAsIdentity(t *testing.T) {
+	s := &Service{
+		Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "k1abc@example.com", To: "club@example.org"},
+		Identities: map[string]SenderIdentity{
+			"contest": {
+				From:        "contest@k1abc.example",
+				DisplayName: "K1ABC Contest",
+				ReplyTo:     "logs@k1abc.example",
+				Signature:   "73 de K1ABC\nFN31",
+				Transport:   &types.EmailConfig{Enabled: true, Host: "mail.k1abc.example", Port: 587, From: "contest@k1abc.example"},
+			},
+		},
+	}
+	s.isInitialized.Store(true)
+
+	var gotAddr, gotFrom, gotMsg string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		gotAddr, gotFrom, gotMsg = addr, from, string(msg)
+		return deliveryInfo{}, nil
+	})
+
+	def, err := s.BuildEmailWithFile("", "Contest log", "Log attached.", nil, "cq-ww.adi", strings.NewReader("<CALL:5>W1AW <EOR>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	def.Identity = "contest"
+	if err = s.Send(def); err != nil {
+		t.Fatal(err)
+	}
+	if gotAddr != "mail.k1abc.example:587" || gotFrom != "contest@k1abc.example" {
+		t.Fatalf("sent via %s as %s, want the identity's transport and address", gotAddr, gotFrom)
+	}
+	in, err := ParseInbound(strings.NewReader(gotMsg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Header.Get("From") != `"K1ABC Contest" <contest@k1abc.example>` || in.Header.Get("Reply-To") != "logs@k1abc.example" {
+		t.Fatalf("headers not rewritten: From=%q Reply-To=%q", in.Header.Get("From"), in.Header.Get("Reply-To"))
+	}
+	if strings.Count(gotMsg, "\r\nFrom:") != 1 {
+		t.Fatal("expected exactly one From header")
+	}
+	bodies := partBodies(t, gotMsg)
+	if !strings.HasSuffix(bodies[0], "Log attached.\r\n\r\n-- \r\n73 de K1ABC\r\nFN31\r\n") {
+		t.Fatalf("signature not appended to the text part: %q", bodies[0])
+	}
+	atts, _ := in.Attachments()
+	if len(atts) != 1 || string(atts[0].Data) != "<CALL:5>W1AW <EOR>" {
+		t.Fatalf("attachment altered: %+v", atts)
+	}
+
+	def.Identity = "personal"
+	if err = s.Send(def); err == nil || !strings.Contains(errors.Root(err).Error(), `unknown sender identity "personal"`) {
+		t.Fatalf("expected unknown identity error, got %v", err)
+	}
+}
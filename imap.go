@@ -0,0 +1,299 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	stderr "errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/logging"
+)
+
+// imapCommandTimeout bounds each IMAP command, so a connection the server has silently dropped is noticed.
+const imapCommandTimeout = 2 * time.Minute
+
+// imapConn is a minimal IMAP4rev1 client covering what the inbound subsystem needs: LOGIN, SELECT,
+// UID SEARCH/FETCH/STORE and IDLE (RFC 2177).
+type imapConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	tag    int
+	caps   map[string]bool
+	logger *logging.Service
+}
+
+type imapResponse struct {
+	line     string
+	literals [][]byte
+}
+
+func dialIMAP(ctx context.Context, cfg InboundConfig, logger *logging.Service) (Mailbox, error) {
+	const op errors.Op = "email.dialIMAP"
+	host := strings.TrimSpace(cfg.Host)
+	addr := net.JoinHostPort(host, strconv.Itoa(cfg.Port))
+	d := tls.Dialer{NetDialer: newDialer(defaultDialTimeout), Config: &tls.Config{ServerName: host}}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to connect to imap server")
+	}
+	_ = conn.SetDeadline(time.Now().Add(imapCommandTimeout))
+	c, err := newIMAPConn(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.New(op).Err(err).Msg("imap handshake failed")
+	}
+	c.logger = logger
+	if err = c.login(ctx, cfg.Username, cfg.Password); err != nil {
+		_ = c.Close()
+		return nil, errors.New(op).Err(err).Msg("imap login failed")
+	}
+	if err = c.selectMailbox(ctx, cfg.mailboxName()); err != nil {
+		_ = c.Close()
+		return nil, errors.New(op).Err(err).Msg("imap select failed")
+	}
+	return c, nil
+}
+
+// newIMAPConn reads the server greeting from an established connection.
+func newIMAPConn(conn net.Conn) (*imapConn, error) {
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn), caps: map[string]bool{}}
+	greeting, err := c.readResponse()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting.line, "* OK") && !strings.HasPrefix(greeting.line, "* PREAUTH") {
+		return nil, fmt.Errorf("unexpected imap greeting: %q", greeting.line)
+	}
+	return c, nil
+}
+
+func (c *imapConn) login(ctx context.Context, username, password string) error {
+	if _, err := c.command(ctx, "LOGIN "+imapQuote(username)+" "+imapQuote(password)); err != nil {
+		return err
+	}
+	resps, err := c.command(ctx, "CAPABILITY")
+	if err != nil {
+		return err
+	}
+	for _, r := range resps {
+		if rest, ok := strings.CutPrefix(r.line, "* CAPABILITY "); ok {
+			for _, capName := range strings.Fields(rest) {
+				c.caps[strings.ToUpper(capName)] = true
+			}
+		}
+	}
+	return nil
+}
+
+func (c *imapConn) selectMailbox(ctx context.Context, name string) error {
+	_, err := c.command(ctx, "SELECT "+imapQuote(name))
+	return err
+}
+
+// Fetch returns unseen messages, leaving them unseen until they are acknowledged. A message that cannot be parsed
+// is logged and flagged as seen and \Flagged, so it stays in the mailbox for inspection without being fetched
+// again.
+func (c *imapConn) Fetch(ctx context.Context) ([]*InboundMessage, error) {
+	resps, err := c.command(ctx, "UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+	var uids []string
+	for _, r := range resps {
+		if rest, ok := strings.CutPrefix(r.line, "* SEARCH"); ok {
+			uids = append(uids, strings.Fields(rest)...)
+		}
+	}
+
+	out := make([]*InboundMessage, 0, len(uids))
+	for _, uid := range uids {
+		if err = ctx.Err(); err != nil {
+			return out, err
+		}
+		resps, err = c.command(ctx, "UID FETCH "+uid+" (BODY.PEEK[])")
+		if err != nil {
+			return out, err
+		}
+		for _, r := range resps {
+			if !strings.Contains(r.line, " FETCH ") || len(r.literals) == 0 {
+				continue
+			}
+			msg, perr := ParseInbound(strings.NewReader(string(r.literals[0])))
+			if perr != nil {
+				c.logger.WarnWith().Err(perr).Str("uid", uid).Msg("skipping unparseable inbound message")
+				if _, err = c.command(ctx, "UID STORE "+uid+` +FLAGS.SILENT (\Seen \Flagged)`); err != nil {
+					return out, err
+				}
+				continue
+			}
+			msg.ID = uid
+			out = append(out, msg)
+		}
+	}
+	return out, nil
+}
+
+// Ack marks the message with UID id as seen.
+func (c *imapConn) Ack(id string) error {
+	_, err := c.command(context.Background(), "UID STORE "+id+` +FLAGS.SILENT (\Seen)`)
+	return err
+}
+
+func (c *imapConn) CanIdle() bool {
+	return c.caps["IDLE"]
+}
+
+// Idle waits until the server announces new mail, the timeout elapses or ctx is cancelled.
+func (c *imapConn) Idle(ctx context.Context, timeout time.Duration) error {
+	_ = c.conn.SetDeadline(time.Now().Add(imapCommandTimeout))
+	tag := c.nextTag()
+	if _, err := fmt.Fprintf(c.conn, "%s IDLE\r\n", tag); err != nil {
+		return err
+	}
+	cont, err := c.readResponse()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(cont.line, "+") {
+		return fmt.Errorf("imap server refused IDLE: %q", cont.line)
+	}
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	stop := context.AfterFunc(ctx, func() { _ = c.conn.SetReadDeadline(time.Now()) })
+	for {
+		r, rerr := c.readResponse()
+		if rerr != nil {
+			var ne net.Error
+			if !stderr.As(rerr, &ne) || !ne.Timeout() {
+				stop()
+				return rerr
+			}
+			break
+		}
+		if strings.HasPrefix(r.line, "* BYE") {
+			stop()
+			return fmt.Errorf("imap server closed connection: %q", r.line)
+		}
+		if strings.HasSuffix(r.line, " EXISTS") || strings.HasSuffix(r.line, " RECENT") {
+			break
+		}
+	}
+	stop()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	_ = c.conn.SetDeadline(time.Now().Add(imapCommandTimeout))
+
+	if _, err = fmt.Fprint(c.conn, "DONE\r\n"); err != nil {
+		return err
+	}
+	_, err = c.awaitTagged(tag)
+	return err
+}
+
+func (c *imapConn) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = c.command(ctx, "LOGOUT")
+	return c.conn.Close()
+}
+
+func (c *imapConn) nextTag() string {
+	c.tag++
+	return fmt.Sprintf("A%04d", c.tag)
+}
+
+// command sends a tagged command and returns the untagged responses once it completes with OK. It gives up after
+// imapCommandTimeout, or as soon as ctx is cancelled.
+func (c *imapConn) command(ctx context.Context, cmd string) ([]imapResponse, error) {
+	_ = c.conn.SetDeadline(time.Now().Add(imapCommandTimeout))
+	defer context.AfterFunc(ctx, func() { _ = c.conn.SetDeadline(time.Now()) })()
+	tag := c.nextTag()
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, cmd); err != nil {
+		return nil, cancelledOr(ctx, err)
+	}
+	resps, err := c.awaitTagged(tag)
+	if err != nil {
+		return nil, cancelledOr(ctx, err)
+	}
+	return resps, nil
+}
+
+// cancelledOr returns ctx's error in place of err when a cancelled ctx is what cut the command short.
+func cancelledOr(ctx context.Context, err error) error {
+	if cerr := ctx.Err(); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+func (c *imapConn) awaitTagged(tag string) ([]imapResponse, error) {
+	var untagged []imapResponse
+	for {
+		r, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		rest, ok := strings.CutPrefix(r.line, tag+" ")
+		if !ok {
+			untagged = append(untagged, r)
+			continue
+		}
+		if !strings.HasPrefix(strings.ToUpper(rest), "OK") {
+			return nil, fmt.Errorf("imap command failed: %s", rest)
+		}
+		return untagged, nil
+	}
+}
+
+// readResponse reads one response line, collecting any literals ({n}) it announces.
+func (c *imapConn) readResponse() (imapResponse, error) {
+	var resp imapResponse
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return resp, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		resp.line += line
+
+		n, ok := literalSize(line)
+		if !ok {
+			return resp, nil
+		}
+		lit := make([]byte, n)
+		if _, err = io.ReadFull(c.r, lit); err != nil {
+			return resp, err
+		}
+		resp.literals = append(resp.literals, lit)
+	}
+}
+
+// literalSize parses a trailing IMAP literal marker such as "{123}".
+func literalSize(line string) (int, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	open := strings.LastIndexByte(line, '{')
+	if open < 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(line[open+1:len(line)-1], "+"))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func imapQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,306 @@
+package email
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/logging"
+)
+
+// InboundMessage is a received message held in memory so that several handlers can inspect it.
+type InboundMessage struct {
+	// ID is the mailbox-specific identifier, falling back to the Message-ID header.
+	ID     string
+	Header mail.Header
+	Body   []byte
+	// Received is when Inbound fetched the message.
+	Received time.Time
+}
+
+// ParseInbound reads a raw RFC 5322 message into an InboundMessage.
+func ParseInbound(r io.Reader) (*InboundMessage, error) {
+	const op errors.Op = "email.ParseInbound"
+	m, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to parse inbound message")
+	}
+	body, err := io.ReadAll(m.Body)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to read inbound message body")
+	}
+	return &InboundMessage{ID: m.Header.Get("Message-Id"), Header: m.Header, Body: body}, nil
+}
+
+// Subject returns the decoded Subject header.
+func (m *InboundMessage) Subject() string {
+	raw := m.Header.Get("Subject")
+	dec := new(mime.WordDecoder)
+	if s, err := dec.DecodeHeader(raw); err == nil {
+		return s
+	}
+	return raw
+}
+
+// Sender returns the lower-cased address of the From header, or an empty string when it cannot be parsed.
+func (m *InboundMessage) Sender() string {
+	addr, err := mail.ParseAddress(m.Header.Get("From"))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(addr.Address)
+}
+
+// HasAttachment reports whether any MIME part is marked as an attachment or carries a filename.
+func (m *InboundMessage) HasAttachment() bool {
+	found := false
+	_ = walkParts(m.Header.Get("Content-Type"), textproto.MIMEHeader(m.Header), m.Body, 0, func(hdr textproto.MIMEHeader, _ []byte) bool {
+		found = isAttachmentPart(hdr)
+		return !found
+	})
+	return found
+}
+
+const (
+	defaultInboundPollInterval = 5 * time.Minute
+	// RFC 2177 asks clients to re-issue IDLE at least every 29 minutes
+	defaultInboundIdleTimeout = 25 * time.Minute
+	inboundMinBackoff         = 5 * time.Second
+	inboundMaxBackoff         = 5 * time.Minute
+	// inboundStoreCapacity bounds how many recent messages are kept for later lookups by ID
+	inboundStoreCapacity = 200
+)
+
+// Inbound mailbox protocols.
+const (
+	InboundProtocolIMAP = "imap"
+	InboundProtocolPOP3 = "pop3"
+)
+
+// InboundConfig describes the mailbox watched for bounces, receipts and command emails.
+type InboundConfig struct {
+	// Protocol is InboundProtocolIMAP (default) or InboundProtocolPOP3.
+	Protocol string
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Mailbox defaults to INBOX; IMAP only.
+	Mailbox string
+	// DeleteAfterFetch removes messages from the server once they have been handled; POP3 only.
+	DeleteAfterFetch bool
+	// PollInterval is used for POP3, and for IMAP when the server lacks IDLE or DisableIdle is set.
+	PollInterval time.Duration
+	// IdleTimeout bounds a single IDLE command before it is re-issued.
+	IdleTimeout time.Duration
+	DisableIdle bool
+	// ReconnectBackoff is the initial delay after a failed session; it doubles up to five minutes.
+	ReconnectBackoff time.Duration
+}
+
+func (c InboundConfig) mailboxName() string {
+	if strings.TrimSpace(c.Mailbox) == "" {
+		return "INBOX"
+	}
+	return c.Mailbox
+}
+
+// Mailbox is a connected inbound message source.
+type Mailbox interface {
+	// Fetch returns messages not yet processed. Unless the mailbox is an Acker, it marks them as processed.
+	Fetch(ctx context.Context) ([]*InboundMessage, error)
+	Close() error
+}
+
+// Acker is implemented by mailboxes that mark a message as processed only once Inbound has handled it, so a
+// message is fetched again after a failure rather than lost.
+type Acker interface {
+	// Ack marks the message with the ID returned by Fetch as processed.
+	Ack(id string) error
+}
+
+// Idler is implemented by mailboxes able to push new-mail notifications, such as IMAP IDLE.
+type Idler interface {
+	CanIdle() bool
+	// Idle blocks until new mail may be available, the timeout elapses or ctx is cancelled.
+	Idle(ctx context.Context, timeout time.Duration) error
+}
+
+// Inbound watches a mailbox and hands every received message to Handler. It uses IDLE when available, falls back
+// to polling otherwise and reconnects with exponential backoff after failures.
+type Inbound struct {
+	Config  InboundConfig
+	Handler func(*InboundMessage) error
+	Logger  *logging.Service
+	// Retention limits how long received messages are kept; see also Purge and PurgeAddress.
+	Retention Retention
+	// SeenStore persists which POP3 messages have been processed when they are left on the server; nil keeps
+	// them in memory, so a restart processes them again.
+	SeenStore SeenStore
+
+	// Dial opens the mailbox; defaults to the configured protocol over implicit TLS.
+	Dial func(ctx context.Context, cfg InboundConfig) (Mailbox, error)
+
+	mu     sync.Mutex
+	recent []*InboundMessage
+}
+
+// Attachments returns the decoded attachments of a recently received message so other services can consume
+// received files without handling MIME themselves.
+func (in *Inbound) Attachments(msgID string) ([]Attachment, error) {
+	const op errors.Op = "email.Inbound.Attachments"
+	msg := in.lookup(msgID)
+	if msg == nil {
+		return nil, errors.New(op).Err(errors.ErrNotFound).Msgf("inbound message %q not found", msgID)
+	}
+	return msg.Attachments()
+}
+
+func (in *Inbound) remember(msg *InboundMessage) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if msg.Received.IsZero() {
+		msg.Received = time.Now().UTC()
+	}
+	cutoff := in.Retention.cutoff(msg.Received)
+	in.recent = append(in.recent, msg)
+	in.recent, _ = retain(in.recent, in.Retention.limit(inboundStoreCapacity), func(m *InboundMessage) bool {
+		return !m.Received.Before(cutoff)
+	})
+}
+
+func (in *Inbound) lookup(msgID string) *InboundMessage {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for i := len(in.recent) - 1; i >= 0; i-- {
+		if in.recent[i].ID == msgID {
+			return in.recent[i]
+		}
+	}
+	return nil
+}
+
+// Run processes inbound mail until ctx is cancelled.
+func (in *Inbound) Run(ctx context.Context) error {
+	const op errors.Op = "email.Inbound.Run"
+	if in.Handler == nil {
+		return errors.New(op).Msg("inbound handler has not been set")
+	}
+	dial := in.Dial
+	if dial == nil {
+		switch strings.ToLower(strings.TrimSpace(in.Config.Protocol)) {
+		case "", InboundProtocolIMAP:
+			dial = func(ctx context.Context, cfg InboundConfig) (Mailbox, error) { return dialIMAP(ctx, cfg, in.Logger) }
+		case InboundProtocolPOP3:
+			// The POP3 mailbox reconnects per fetch, so one instance keeps its seen-set across sessions
+			mb := newPOP3Mailbox(in.Config, in.SeenStore, in.Logger)
+			dial = func(context.Context, InboundConfig) (Mailbox, error) { return mb, nil }
+		default:
+			return errors.New(op).Msgf("unsupported inbound protocol %q", in.Config.Protocol)
+		}
+	}
+
+	minBackoff := in.Config.ReconnectBackoff
+	if minBackoff <= 0 {
+		minBackoff = inboundMinBackoff
+	}
+	backoff := minBackoff
+	for {
+		mb, err := dial(ctx, in.Config)
+		if err == nil {
+			err = in.session(ctx, mb, func() { backoff = minBackoff })
+			_ = mb.Close()
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		in.Logger.WarnWith().Err(err).Dur("backoff", backoff).Msg("inbound mailbox session failed; reconnecting")
+		if !sleepContext(ctx, backoff) {
+			return nil
+		}
+		backoff = min(backoff*2, inboundMaxBackoff)
+	}
+}
+
+// session fetches and dispatches messages until an error occurs, calling healthy after each successful fetch.
+func (in *Inbound) session(ctx context.Context, mb Mailbox, healthy func()) error {
+	poll := in.Config.PollInterval
+	if poll <= 0 {
+		poll = defaultInboundPollInterval
+	}
+	idleTimeout := in.Config.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultInboundIdleTimeout
+	}
+	idler, canIdle := mb.(Idler)
+	canIdle = canIdle && !in.Config.DisableIdle && idler.CanIdle()
+	acker, _ := mb.(Acker)
+
+	for {
+		msgs, err := mb.Fetch(ctx)
+		if err != nil {
+			return err
+		}
+		healthy()
+		for _, msg := range msgs {
+			in.remember(msg)
+			if herr := in.Handler(msg); herr != nil {
+				in.Logger.ErrorWith().Err(herr).Str("id", msg.ID).Msg("inbound message handler failed")
+			}
+			// A failed handler has been logged; fetching the message again would only fail again
+			if acker != nil {
+				if err = acker.Ack(msg.ID); err != nil {
+					return err
+				}
+			}
+		}
+
+		if canIdle {
+			if err = idler.Idle(ctx, idleTimeout); err != nil {
+				return err
+			}
+			continue
+		}
+		if !sleepContext(ctx, poll) {
+			return ctx.Err()
+		}
+	}
+}
+
+// sleepContext waits for d and reports false if ctx was cancelled first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// HandleInbound applies delivery reports to the send history and log sync messages to the log, and runs
+// auto-replies for everything else.
+func (s *Service) HandleInbound(msg *InboundMessage) error {
+	const op errors.Op = "email.Service.HandleInbound"
+	statuses, err := s.ApplyDeliveryStatus(msg)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to apply delivery status")
+	}
+	if len(statuses) > 0 {
+		return nil
+	}
+	if ok, serr := s.ApplySync(msg); ok {
+		if serr != nil {
+			return errors.New(op).Err(serr).Msg("failed to apply log sync")
+		}
+		return nil
+	}
+	return s.AutoReply(msg)
+}
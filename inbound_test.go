@@ -0,0 +1,338 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	stderr "errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIMAPServer scripts the server side of an IMAP session over an in-memory pipe, serving messages under UIDs
+// 42, 43 and so on. It records the UID STORE commands it is sent in stores.
+func fakeIMAPServer(t *testing.T, conn net.Conn, stores *[]string, messages ...string) {
+	t.Helper()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, cmd, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch {
+		case strings.HasPrefix(cmd, "CAPABILITY"):
+			fmt.Fprint(conn, "* CAPABILITY IMAP4rev1 IDLE\r\n")
+		case strings.HasPrefix(cmd, "UID SEARCH"):
+			fmt.Fprint(conn, "* SEARCH")
+			for i := range messages {
+				fmt.Fprintf(conn, " %d", 42+i)
+			}
+			fmt.Fprint(conn, "\r\n")
+		case strings.HasPrefix(cmd, "UID FETCH"):
+			uid, _ := strconv.Atoi(strings.Fields(cmd)[2])
+			message := messages[uid-42]
+			fmt.Fprintf(conn, "* %d FETCH (UID %d BODY[] {%d}\r\n%s)\r\n", uid-41, uid, len(message), message)
+		case strings.HasPrefix(cmd, "UID STORE"):
+			*stores = append(*stores, cmd)
+		case cmd == "IDLE":
+			fmt.Fprint(conn, "+ idling\r\n")
+			fmt.Fprint(conn, "* 2 EXISTS\r\n")
+			if done, _ := r.ReadString('\n'); strings.TrimSpace(done) != "DONE" {
+				t.Errorf("expected DONE, got %q", done)
+			}
+		case cmd == "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK\r\n", tag)
+			return
+		}
+		fmt.Fprintf(conn, "%s OK done\r\n", tag)
+	}
+}
+
+func TestIMAPFetchAndIdle(t *testing.T) {
+	client, server := net.Pipe()
+	msg := "From: k1abc@example.com\r\nSubject: hi\r\n\r\nbody\r\n"
+	var stores []string
+	go fakeIMAPServer(t, server, &stores, msg, "not a header\r\n")
+
+	c, err := newIMAPConn(client)
+	if err != nil {
+		t.Fatalf("newIMAPConn failed: %v", err)
+	}
+	if err = c.login(t.Context(), "user", "pa\"ss"); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if !c.CanIdle() {
+		t.Fatalf("expected IDLE capability")
+	}
+	if err = c.selectMailbox(t.Context(), "INBOX"); err != nil {
+		t.Fatalf("select failed: %v", err)
+	}
+	msgs, err := c.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].ID != "42" || msgs[0].Subject() != "hi" {
+		t.Fatalf("unexpected messages: %+v", msgs)
+	}
+	// The unparseable message is set aside; the good one stays unseen until it has been handled
+	if len(stores) != 1 || stores[0] != `UID STORE 43 +FLAGS.SILENT (\Seen \Flagged)` {
+		t.Fatalf("unexpected stores after fetch: %q", stores)
+	}
+	if err = c.Ack("42"); err != nil || len(stores) != 2 || stores[1] != `UID STORE 42 +FLAGS.SILENT (\Seen)` {
+		t.Fatalf("Ack: %v, stores %q", err, stores)
+	}
+	if err = c.Idle(context.Background(), time.Second); err != nil {
+		t.Fatalf("Idle failed: %v", err)
+	}
+	if err = c.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestIMAPFetchStopsWhenCancelled(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		// Greet, then read commands without ever answering, like a half-open connection
+		fmt.Fprint(server, "* OK ready\r\n")
+		_, _ = io.Copy(io.Discard, server)
+	}()
+	c, err := newIMAPConn(client)
+	if err != nil {
+		t.Fatalf("newIMAPConn failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, ferr := c.Fetch(ctx)
+		done <- ferr
+	}()
+	select {
+	case err = <-done:
+		if !stderr.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the context's error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Fetch did not return once its context was done")
+	}
+}
+
+type fakeMailbox struct {
+	mu      sync.Mutex
+	batches [][]*InboundMessage
+	failAt  int
+	fetches int
+	acked   []string
+}
+
+func (f *fakeMailbox) Fetch(ctx context.Context) ([]*InboundMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fetches++
+	if f.fetches == f.failAt {
+		return nil, fmt.Errorf("connection reset")
+	}
+	if len(f.batches) == 0 {
+		return nil, nil
+	}
+	b := f.batches[0]
+	f.batches = f.batches[1:]
+	return b, nil
+}
+
+func (f *fakeMailbox) Ack(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.acked = append(f.acked, id)
+	return nil
+}
+
+func (f *fakeMailbox) Close() error { return nil }
+
+func TestInboundRunPollsAndReconnects(t *testing.T) {
+	mb := &fakeMailbox{
+		batches: [][]*InboundMessage{{{ID: "1"}}, {{ID: "2"}}},
+		failAt:  2,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	var dials int
+	in := &Inbound{
+		Config: InboundConfig{PollInterval: time.Millisecond, ReconnectBackoff: time.Millisecond},
+		Handler: func(m *InboundMessage) error {
+			mu.Lock()
+			defer mu.Unlock()
+			mb.mu.Lock()
+			if len(mb.acked) != len(seen) {
+				t.Errorf("message %s acknowledged before it was handled: %v", m.ID, mb.acked)
+			}
+			mb.mu.Unlock()
+			seen = append(seen, m.ID)
+			if len(seen) == 2 {
+				cancel()
+			}
+			return nil
+		},
+		Dial: func(ctx context.Context, cfg InboundConfig) (Mailbox, error) {
+			dials++
+			return mb, nil
+		},
+	}
+	done := make(chan error, 1)
+	go func() { done <- in.Run(ctx) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatalf("Run did not stop")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(seen, ",") != "1,2" {
+		t.Fatalf("unexpected handled messages: %v", seen)
+	}
+	if dials != 2 {
+		t.Fatalf("expected a reconnect after the fetch failure, got %d dials", dials)
+	}
+	if strings.Join(mb.acked, ",") != "1,2" {
+		t.Fatalf("unexpected acknowledged messages: %v", mb.acked)
+	}
+}
+
+func TestPOP3FetchLeavesOrDeletes(t *testing.T) {
+	msg := "From: k1abc@example.com\r\nSubject: log\r\n\r\n.dot-stuffed\r\n"
+	var deletes int
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "+OK POP3 ready\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.TrimSpace(line); {
+			case cmd == "UIDL":
+				fmt.Fprint(conn, "+OK\r\n1 uid-1\r\n2 uid-2\r\n.\r\n")
+			case cmd == "RETR 1":
+				fmt.Fprintf(conn, "+OK\r\n%s.\r\n", strings.ReplaceAll(msg, "\r\n.", "\r\n.."))
+			case cmd == "RETR 2":
+				fmt.Fprint(conn, "+OK\r\nnot a header\r\n.\r\n")
+			case cmd == "DELE 1":
+				deletes++
+				fmt.Fprint(conn, "+OK\r\n")
+			case cmd == "QUIT":
+				fmt.Fprint(conn, "+OK bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "+OK\r\n")
+			}
+		}
+	}
+
+	dial := func(ctx context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serve(server)
+		return client, nil
+	}
+	for _, del := range []bool{false, true} {
+		deletes = 0
+		store := &FileSeenStore{Path: filepath.Join(t.TempDir(), "seen.json")}
+		cfg := InboundConfig{Host: "pop.example.com", Port: 995, DeleteAfterFetch: del}
+
+		// A mailbox that stops before acknowledging must neither delete the message nor mark it as seen
+		crashed := newPOP3Mailbox(cfg, store, nil)
+		crashed.dial = dial
+		if msgs, err := crashed.Fetch(context.Background()); err != nil || len(msgs) != 1 {
+			t.Fatalf("expected one message before the crash, got %d err=%v", len(msgs), err)
+		}
+		crashed.drop()
+		if deletes != 0 {
+			t.Fatalf("saw %d DELE commands before the message was acknowledged", deletes)
+		}
+
+		mb := newPOP3Mailbox(cfg, store, nil)
+		mb.dial = dial
+		msgs, err := mb.Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		if len(msgs) != 1 || msgs[0].ID != "uid-1" || !strings.Contains(string(msgs[0].Body), ".dot-stuffed") {
+			t.Fatalf("unexpected messages: %+v", msgs)
+		}
+		if err = mb.Ack(msgs[0].ID); err != nil {
+			t.Fatalf("Ack failed: %v", err)
+		}
+		// The unparseable message is skipped and left on the server
+		if del != (deletes == 1) {
+			t.Fatalf("delete-after-fetch=%v but saw %d DELE commands", del, deletes)
+		}
+		// A second fetch must not return the same message again, nor must a restarted mailbox
+		if msgs, err = mb.Fetch(context.Background()); err != nil || len(msgs) != 0 {
+			t.Fatalf("expected no repeat delivery, got %d messages err=%v", len(msgs), err)
+		}
+		restarted := newPOP3Mailbox(mb.cfg, store, nil)
+		restarted.dial = dial
+		if msgs, err = restarted.Fetch(context.Background()); err != nil || len(msgs) != 0 {
+			t.Fatalf("expected no repeat delivery after a restart, got %d messages err=%v", len(msgs), err)
+		}
+	}
+}
+
+func TestInboundAttachmentsByID(t *testing.T) {
+	raw := "From: k1abc@example.com\r\n" +
+		"Subject: log\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"b\"\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"see attached\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/octet-stream; name=\"=?utf-8?q?k1abc_log.adi?=\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Disposition: attachment\r\n" +
+		"\r\n" +
+		"PEVPSD4K\r\n" +
+		"--b--\r\n"
+	msg, err := ParseInbound(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ParseInbound failed: %v", err)
+	}
+	msg.ID = "7"
+
+	in := &Inbound{}
+	in.remember(msg)
+	atts, err := in.Attachments("7")
+	if err != nil {
+		t.Fatalf("Attachments failed: %v", err)
+	}
+	if len(atts) != 1 {
+		t.Fatalf("expected 1 attachment, got %d", len(atts))
+	}
+	a := atts[0]
+	if a.Filename != "k1abc log.adi" || a.ContentType != "application/octet-stream" || string(a.Data) != "<EOH>\n" {
+		t.Fatalf("unexpected attachment: %+v", a)
+	}
+	if _, err = in.Attachments("missing"); err == nil {
+		t.Fatalf("expected error for unknown message")
+	}
+}
@@ -0,0 +1,130 @@
+//go:build integration
+
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+// The integration suite sends through a local Mailpit (https://mailpit.axllent.org) and checks what it received.
+// Start one with STARTTLS and AUTH enabled, e.g. via `task integration`, then run
+//
+//	MAILPIT_SMTP=localhost:1025 MAILPIT_API=http://localhost:8025 MAILPIT_CA=/tmp/sm-mailpit/cert.pem \
+//	MAILPIT_USER=station MAILPIT_PASS=manager go test -tags integration -run Integration ./...
+func mailpitEnv(t *testing.T) (smtpAddr, api string, roots *x509.CertPool) {
+	t.Helper()
+	smtpAddr, api = os.Getenv("MAILPIT_SMTP"), os.Getenv("MAILPIT_API")
+	if smtpAddr == "" || api == "" {
+		t.Skip("MAILPIT_SMTP and MAILPIT_API are not set")
+	}
+	if ca := os.Getenv("MAILPIT_CA"); ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			t.Fatalf("read CA: %v", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			t.Fatalf("no certificates in %s", ca)
+		}
+	}
+	return smtpAddr, api, roots
+}
+
+type mailpitSummary struct {
+	ID        string `json:"ID"`
+	MessageID string `json:"MessageID"`
+}
+
+// mailpitRaw waits for the message with messageID to arrive and returns its raw source.
+func mailpitRaw(t *testing.T, api, messageID string) []byte {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(api + "/api/v1/search?query=" + url.QueryEscape("message-id:"+messageID))
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		var res struct {
+			Messages []mailpitSummary `json:"messages"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatalf("decode search: %v", err)
+		}
+		if len(res.Messages) > 0 {
+			raw, err := http.Get(api + "/api/v1/message/" + res.Messages[0].ID + "/raw")
+			if err != nil {
+				t.Fatalf("raw: %v", err)
+			}
+			defer func() { _ = raw.Body.Close() }()
+			data, err := io.ReadAll(raw.Body)
+			if err != nil {
+				t.Fatalf("read raw: %v", err)
+			}
+			return data
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	t.Fatalf("message %s did not arrive", messageID)
+	return nil
+}
+
+func TestIntegrationSendADIFThroughMailpit(t *testing.T) {
+	smtpAddr, api, roots := mailpitEnv(t)
+	host, portStr, err := net.SplitHostPort(smtpAddr)
+	if err != nil {
+		t.Fatalf("MAILPIT_SMTP: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	s := &Service{Config: &types.EmailConfig{
+		Enabled:  true,
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("MAILPIT_USER"),
+		Password: os.Getenv("MAILPIT_PASS"),
+		From:     "station@example.com",
+		To:       "log@example.com",
+		Subject:  "{{.QSOCount}} QSOs",
+	}, RootCAs: roots}
+	s.isInitialized.Store(true)
+
+	qs := make([]types.Qso, 50)
+	for i := range qs {
+		qs[i] = types.Qso{LogbookID: 1, SessionID: int64(i)}
+		qs[i].QsoDate, qs[i].TimeOn, qs[i].Call = "20240302", "1200", fmt.Sprintf("ZS%dABC", i%10)
+	}
+	def, err := s.BuildEmailWithADIFAttachment("", "", "Integration run\r\n.\r\nA line that needs dot-stuffing above", nil, qs)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	res, err := s.SendWithResult(context.Background(), def)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if res.Status != SendStatusSent {
+		t.Fatalf("expected a direct delivery, got %+v", res)
+	}
+
+	raw := mailpitRaw(t, api, strings.Trim(def.Structure.Header.Get("Message-Id"), "<>"))
+	// Mailpit may prepend trace headers; everything we sent must arrive unchanged
+	if !bytes.HasSuffix(raw, []byte(def.Msg)) {
+		t.Fatalf("received message differs from the one sent\nsent:\n%s\nreceived:\n%s", def.Msg, raw)
+	}
+}
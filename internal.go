@@ -1,43 +1,55 @@
 package email
 
 import (
-	"crypto/rand"
+	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	stderr "errors"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net"
 	"net/smtp"
 	"net/textproto"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
 )
 
 func (s *Service) validateConfig(op errors.Op) error {
+	return validateEmailConfig(op, s.config())
+}
+
+func validateEmailConfig(op errors.Op, cfg *types.EmailConfig) error {
 	// Quick sanity check to ensure TLS enforcement has needed inputs
-	host := strings.TrimSpace(s.Config.Host)
+	host := strings.TrimSpace(cfg.Host)
 	if host == "" {
 		return errors.New(op).Msg("email host cannot be empty")
 	}
 	if strings.Contains(host, " ") {
 		return errors.New(op).Msg("email host cannot contain spaces")
 	}
-	if s.Config.Port <= 0 || s.Config.Port > 65535 {
+	if cfg.Port <= 0 || cfg.Port > 65535 {
 		return errors.New(op).Msg("email port must be between 1 and 65535")
 	}
 	// Use JoinHostPort to be IPv6-safe during validation
-	addr := net.JoinHostPort(host, strconv.Itoa(s.Config.Port))
+	addr := net.JoinHostPort(host, strconv.Itoa(cfg.Port))
 	if _, _, err := net.SplitHostPort(addr); err != nil {
 		return errors.New(op).Err(err).Msg("invalid host or port for email config")
 	}
-	from := strings.TrimSpace(s.Config.From)
+	from := strings.TrimSpace(cfg.From)
 	if from == "" {
 		return errors.New(op).Msg("email from address cannot be empty")
 	}
-	username := strings.TrimSpace(s.Config.Username)
-	password := strings.TrimSpace(s.Config.Password)
+	username := strings.TrimSpace(cfg.Username)
+	password := strings.TrimSpace(cfg.Password)
 	if username == "" && password != "" {
 		return errors.New(op).Msg("email username must be set when password is provided")
 	}
@@ -47,115 +59,278 @@ func (s *Service) validateConfig(op errors.Op) error {
 	return nil
 }
 
-// dialerFactory allows tests to override dialer behavior
-var dialerFactory = func(timeout time.Duration) *net.Dialer {
+// newDialer returns the dialer of outbound connections, giving up on a connect after timeout.
+func newDialer(timeout time.Duration) *net.Dialer {
 	return &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
 }
 
-// smtpDialTimeout controls outbound SMTP dial deadlines; set by service Initialize
-var smtpDialTimeout = 10 * time.Second
+type rootCAsKey struct{}
+
+// withRootCAs carries Service.RootCAs to the TLS handshakes of the SMTP session.
+func withRootCAs(ctx context.Context, pool *x509.CertPool) context.Context {
+	if pool == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, rootCAsKey{}, pool)
+}
+
+func rootCAsFromContext(ctx context.Context) *x509.CertPool {
+	pool, _ := ctx.Value(rootCAsKey{}).(*x509.CertPool)
+	return pool
+}
+
+// defaultDialTimeout applies when the config does not set SmtpDialTimeoutSec
+const defaultDialTimeout = 10 * time.Second
+
+type dialTimeoutKey struct{}
+
+// withDialTimeout carries the per-send dial timeout to sendMailWithTLS.
+func withDialTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, dialTimeoutKey{}, d)
+}
+
+func dialTimeoutFromContext(ctx context.Context) time.Duration {
+	if d, ok := ctx.Value(dialTimeoutKey{}).(time.Duration); ok && d > 0 {
+		return d
+	}
+	return defaultDialTimeout
+}
+
+// dialTimeout returns the configured SMTP dial timeout, with sane bounds
+func dialTimeout(cfg *types.EmailConfig) time.Duration {
+	if cfg.SmtpDialTimeoutSec <= 0 {
+		return defaultDialTimeout
+	}
+	return min(max(time.Duration(cfg.SmtpDialTimeoutSec)*time.Second, time.Second), 60*time.Second)
+}
+
+// Transport names reported in deliveryInfo.
+const (
+	transportImplicitTLS = "smtps"
+	transportStartTLS    = "starttls"
+)
+
+// deliveryInfo describes how a message was handed to the server, for logging.
+type deliveryInfo struct {
+	Transport  string
+	TLSVersion string
+	TLSCipher  string
+	// Authenticated is true when the session logged in with AUTH.
+	Authenticated bool
+	// Legacy is true when the server was greeted with HELO because it rejected EHLO.
+	Legacy bool
+	// Accepted lists the recipients the server took the message for, in Transactions MAIL transactions. After a
+	// failure it holds those accepted before it, which must not be sent the message again.
+	Accepted     []string
+	Transactions int
+}
+
+// smtpSession is a connection that has been greeted, secured and authenticated, ready for MAIL FROM.
+type smtpSession struct {
+	conn   net.Conn
+	client *smtp.Client
+	info   deliveryInfo
+}
 
-func sendMailWithTLS(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
-	const op errors.Op = "email.sendMailWithTLS"
+func sendMailWithTLS(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+	sess, err := openSession(ctx, addr, auth)
+	if err != nil {
+		return deliveryInfo{}, err
+	}
+	return sess.send(ctx, from, to, msg)
+}
+
+// openSession connects over implicit TLS, falling back to STARTTLS, and authenticates.
+func openSession(ctx context.Context, addr string, auth smtp.Auth) (*smtpSession, error) {
+	const op errors.Op = "email.openSession"
 	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		return errors.New(op).Err(err).Msg("invalid smtp address")
+		return nil, errors.New(op).Err(err).Msg("invalid smtp address")
 	}
 
-	if err = tryImplicitTLS(host, addr, auth, from, to, msg); err == nil {
-		return nil
+	if sess, ierr := tryImplicitTLS(ctx, host, addr, auth); ierr == nil {
+		return sess, nil
 	}
 
-	return tryStartTLS(host, addr, auth, from, to, msg)
+	if ctx.Err() != nil {
+		return nil, errors.New(op).Err(ctx.Err()).Msg("delivery cancelled")
+	}
+	return tryStartTLS(ctx, host, addr, auth)
+}
+
+func tryImplicitTLS(ctx context.Context, host, addr string, auth smtp.Auth) (*smtpSession, error) {
+	sess, err := implicitTLSSession(ctx, host, addr, auth, false)
+	if stderr.Is(err, errHelloFailed) && ctx.Err() == nil {
+		// Pre-ESMTP relays may drop the connection on EHLO; reconnect and greet with HELO only
+		return implicitTLSSession(ctx, host, addr, auth, true)
+	}
+	return sess, err
 }
 
-func tryImplicitTLS(host, addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
-	const op errors.Op = "email.tryImplicitTLS"
+func implicitTLSSession(ctx context.Context, host, addr string, auth smtp.Auth, legacy bool) (*smtpSession, error) {
+	const op errors.Op = "email.implicitTLSSession"
 	// Use a dialer with timeout for robustness
-	conn, err := tls.DialWithDialer(dialerFactory(smtpDialTimeout), "tcp", addr, &tls.Config{ServerName: host})
+	d := &tls.Dialer{NetDialer: newDialer(dialTimeoutFromContext(ctx)), Config: tlsConfig(ctx, host)}
+	conn, err := d.DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return errors.New(op).Err(err)
+		return nil, errors.New(op).Err(err)
 	}
-	return sendWithClient(conn, host, auth, from, to, msg, true)
+	// Closing the connection is the only way to abort net/smtp mid-conversation
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	if legacy {
+		return startSession(ctx, &heloOnlyConn{Conn: conn}, host, auth, true)
+	}
+	return startSession(ctx, conn, host, auth, true)
 }
 
-func tryStartTLS(host, addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+func tryStartTLS(ctx context.Context, host, addr string, auth smtp.Auth) (*smtpSession, error) {
 	const op errors.Op = "email.tryStartTLS"
-	conn, err := dialerFactory(smtpDialTimeout).Dial("tcp", addr)
+	conn, err := newDialer(dialTimeoutFromContext(ctx)).DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return errors.New(op).Err(err)
+		return nil, errors.New(op).Err(err)
 	}
-	return sendWithClient(conn, host, auth, from, to, msg, false)
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	return startSession(ctx, conn, host, auth, false)
 }
 
-func sendWithClient(conn net.Conn, host string, auth smtp.Auth, from string, to []string, msg []byte, alreadyTLS bool) error {
-	const op errors.Op = "email.sendWithClient"
+// startSession greets the server, upgrades to TLS if needed, and authenticates. The connection is closed on error.
+func startSession(ctx context.Context, conn net.Conn, host string, auth smtp.Auth, alreadyTLS bool) (*smtpSession, error) {
+	const op errors.Op = "email.startSession"
 	client, err := smtp.NewClient(conn, host)
 	if err != nil {
 		cerr := conn.Close()
 		if cerr != nil {
-			return errors.New(op).Err(cerr)
+			return nil, errors.New(op).Err(cerr)
 		}
-		return errors.New(op).Err(err)
+		return nil, errors.New(op).Err(err)
+	}
+	sess := &smtpSession{conn: conn, client: client}
+	if err = sess.handshake(ctx, host, auth, alreadyTLS); err != nil {
+		sess.close()
+		return nil, err
 	}
-	defer func(client *smtp.Client) {
-		_ = client.Close()
-	}(client)
+	return sess, nil
+}
 
+func (sess *smtpSession) handshake(ctx context.Context, host string, auth smtp.Auth, alreadyTLS bool) error {
+	const op errors.Op = "email.smtpSession.handshake"
+	client := sess.client
 	hostname := resolveHostname()
 	// Issue EHLO/Hello to ensure extensions are populated prior to checking STARTTLS support
-	if err = client.Hello(hostname); err != nil {
-		return errors.New(op).Err(err)
+	if err := client.Hello(hostname); err != nil {
+		return errors.New(op).Err(fmt.Errorf("%w: %w", errHelloFailed, err))
 	}
+	_, legacy := sess.conn.(*heloOnlyConn)
 
 	if !alreadyTLS {
 		if ok, _ := client.Extension("STARTTLS"); !ok {
-			return errors.New(op).Msg("smtp server does not support STARTTLS; TLS required")
+			return errors.New(op).Err(errTLSFailed).Msg("smtp server does not support STARTTLS; TLS required")
 		}
-		tlsCfg := &tls.Config{ServerName: host}
+		tlsCfg := tlsConfig(ctx, host)
 		if cerr := client.StartTLS(tlsCfg); cerr != nil {
-			return errors.New(op).Err(cerr)
+			return errors.New(op).Err(fmt.Errorf("%w: %w", errTLSFailed, cerr))
 		}
 		// Note: net/smtp does not allow calling Hello twice in some states.
 		// Many servers accept AUTH immediately after STARTTLS without a second EHLO.
 		// Avoid re-issuing Hello here to prevent "smtp: Hello called after other methods" errors.
 	}
 
-	if auth != nil {
+	sess.info = deliveryInfo{Transport: transportImplicitTLS, Legacy: legacy}
+	if !alreadyTLS {
+		sess.info.Transport = transportStartTLS
+	}
+	if state, ok := client.TLSConnectionState(); ok {
+		sess.info.TLSVersion = tls.VersionName(state.Version)
+		sess.info.TLSCipher = tls.CipherSuiteName(state.CipherSuite)
+	}
+
+	// A HELO session has no AUTH; legacy relays authorise by source address instead
+	if auth != nil && !legacy {
 		if aerr := client.Auth(auth); aerr != nil {
 			return errors.New(op).Err(aerr)
 		}
+		sess.info.Authenticated = true
+	}
+	return nil
+}
+
+// send transmits one message and ends the session. Recipients beyond the transaction limit (see rcptLimit) are sent
+// the message again in further transactions; on failure the returned info lists those already accepted.
+func (sess *smtpSession) send(ctx context.Context, from string, to []string, msg []byte) (deliveryInfo, error) {
+	defer sess.close()
+	stop := context.AfterFunc(ctx, func() { _ = sess.conn.Close() })
+	defer stop()
+	client := sess.client
+
+	dsn := recipientDSNFromContext(ctx)
+	if ok, _ := client.Extension("DSN"); !ok {
+		dsn = nil
+	}
+	limit := rcptLimit(client, rcptLimitFromContext(ctx))
+	info := sess.info
+	for len(to) > 0 {
+		n, err := sess.transaction(ctx, from, to[:min(limit, len(to))], msg, dsn)
+		if err != nil {
+			return info, err
+		}
+		info.Accepted = append(info.Accepted, to[:n]...)
+		info.Transactions++
+		to = to[n:]
+	}
+
+	if qerr := client.Quit(); qerr != nil {
+		// message already accepted; treat QUIT failures as best-effort to avoid duplicate retries
+		return info, nil
 	}
+	return info, nil
+}
 
+// transaction sends msg to to in one MAIL transaction and returns how many of to it was sent to: all of them,
+// unless the server answered 452 (too many recipients) part way through, in which case the rest are left for the
+// next transaction as RFC 5321 section 4.5.3.1.10 advises.
+func (sess *smtpSession) transaction(ctx context.Context, from string, to []string, msg []byte, dsn map[string]RecipientDSN) (int, error) {
+	const op errors.Op = "email.smtpSession.transaction"
+	client := sess.client
 	if merr := client.Mail(from); merr != nil {
-		return merr
+		return 0, merr
 	}
-	for _, addr := range to {
-		if aerr := client.Rcpt(addr); aerr != nil {
-			return errors.New(op).Err(aerr)
+	for i, addr := range to {
+		if aerr := rcpt(client, addr, dsn[addr].params()); aerr != nil {
+			if i > 0 && isTooManyRecipients(aerr) {
+				to = to[:i]
+				break
+			}
+			return 0, errors.New(op).Err(aerr)
 		}
 	}
 
 	wc, err := client.Data()
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if st := receivedStampFromContext(ctx); st != nil {
+		msg = append([]byte(st.header(sess.info, to, st.at())), msg...)
 	}
 	if _, err = wc.Write(msg); err != nil {
 		cerr := wc.Close()
 		if cerr != nil {
-			return errors.New(op).Err(cerr)
+			return 0, errors.New(op).Err(cerr)
 		}
-		return errors.New(op).Err(err)
+		return 0, errors.New(op).Err(err)
 	}
 	if cerr := wc.Close(); cerr != nil {
-		return errors.New(op).Err(cerr)
+		if lostAwaitingReply(cerr) {
+			cerr = fmt.Errorf("%w: %w", errAcceptanceUnknown, cerr)
+		}
+		return 0, errors.New(op).Err(cerr)
 	}
+	return len(to), nil
+}
 
-	if qerr := client.Quit(); qerr != nil {
-		// message already accepted; treat QUIT failures as best-effort to avoid duplicate retries
-		return nil
-	}
-	return nil
+func (sess *smtpSession) close() {
+	_ = sess.client.Close()
 }
 
 func resolveHostname() string {
@@ -179,37 +354,185 @@ func resolveHostname() string {
 	return cleaned
 }
 
-func splitAndTrim(s string) []string {
-	if s == "" {
-		return nil
+// generateMessageID returns a Message-ID of the time, 96 bits drawn from r and the host name.
+func generateMessageID(r io.Reader, now time.Time) string {
+	b := randomBytes(r, 12)
+	host := "localhost"
+	if h, err := os.Hostname(); err == nil && h != "" {
+		host = h
 	}
-	// Split on comma, semicolon, or whitespace
-	repl := strings.NewReplacer(";", ",", " ", ",")
-	s = repl.Replace(s)
-	parts := strings.Split(s, ",")
-	out := make([]string, 0, len(parts))
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p != "" {
-			out = append(out, p)
+	return fmt.Sprintf("<%d.%x@%s>", now.UnixNano(), b, host)
+}
+
+// headerBreaks flattens line breaks in header values, which would otherwise let caller-supplied text (a subject, a
+// from address) inject header fields.
+var headerBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// writeHeaders writes hdr in a stable order followed by the blank line that ends the header block.
+func writeHeaders(buf *bytes.Buffer, hdr textproto.MIMEHeader) {
+	keys := make([]string, 0, len(hdr))
+	for k := range hdr {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := hdr[k]
+		if len(v) == 0 {
+			continue
 		}
+		buf.WriteString(k)
+		buf.WriteString(": ")
+		buf.WriteString(headerBreaks.Replace(strings.Join(v, ", ")))
+		buf.WriteString("\r\n")
 	}
-	return out
+	buf.WriteString("\r\n")
 }
 
-func generateMessageID() string {
-	// random 12 bytes hex + hostname
-	b := make([]byte, 12)
-	_, _ = rand.Read(b)
-	host := "localhost"
-	if h, err := osHostname(); err == nil && h != "" {
-		host = h
+// composeTextMessage renders a single-part text/plain message in the transfer encoding body calls for.
+func composeTextMessage(hdr textproto.MIMEHeader, body string) (string, *Message, error) {
+	hdr.Set("MIME-Version", "1.0")
+	hdr.Set("Content-Type", "text/plain; charset=utf-8")
+
+	var buf bytes.Buffer
+	pw := &partWriter{create: func(cte string) (io.Writer, error) {
+		hdr.Set("Content-Transfer-Encoding", cte)
+		writeHeaders(&buf, hdr)
+		return &buf, nil
+	}}
+	if _, err := io.WriteString(pw, body); err != nil {
+		return "", nil, err
+	}
+	if err := pw.Close(); err != nil {
+		return "", nil, err
+	}
+	partHdr := mapToMIMEHeader(map[string]string{
+		"Content-Type":              hdr.Get("Content-Type"),
+		"Content-Transfer-Encoding": hdr.Get("Content-Transfer-Encoding"),
+	})
+	structure := &Message{Header: cloneHeader(hdr), Parts: []MessagePart{{Header: partHdr, Body: body, Size: len(body)}}}
+	return buf.String(), structure, nil
+}
+
+// composeMessage renders a message of a text body, with htmlBody as its alternative when set, followed by atts: a
+// single text/plain part, a multipart/alternative, or a multipart/mixed with the body first.
+func composeMessage(r io.Reader, hdr textproto.MIMEHeader, body, htmlBody string, atts []Attachment) (string, *Message, error) {
+	switch {
+	case len(atts) > 0:
+		return composeMixedMessage(r, hdr, body, htmlBody, atts)
+	case htmlBody != "":
+		return composeAlternativeMessage(r, hdr, body, htmlBody)
+	}
+	return composeTextMessage(hdr, body)
+}
+
+// composeAlternativeMessage renders a multipart/alternative message of a text and an HTML version of the body.
+func composeAlternativeMessage(r io.Reader, hdr textproto.MIMEHeader, body, htmlBody string) (string, *Message, error) {
+	var parts bytes.Buffer
+	mw := newMultipartWriter(&parts, r)
+	hdr.Set("MIME-Version", "1.0")
+	hdr.Set("Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", mw.Boundary()))
+	bodies, err := writeAlternative(mw, body, htmlBody)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var buf bytes.Buffer
+	writeHeaders(&buf, hdr)
+	buf.Write(parts.Bytes())
+	return buf.String(), &Message{Header: cloneHeader(hdr), Boundary: mw.Boundary(), Parts: bodies}, nil
+}
+
+// writeAlternative writes body and htmlBody as the parts of the multipart/alternative mw and closes it. The plain
+// text comes first: RFC 2046 orders the versions from least to most preferred.
+func writeAlternative(mw *multipart.Writer, body, htmlBody string) ([]MessagePart, error) {
+	var out []MessagePart
+	for _, v := range [...]struct{ contentType, text string }{
+		{"text/plain; charset=utf-8", body},
+		{"text/html; charset=utf-8", htmlBody},
+	} {
+		partHdr := mapToMIMEHeader(map[string]string{"Content-Type": v.contentType})
+		wp := newPart(mw, partHdr, false)
+		if _, err := io.WriteString(wp, v.text); err != nil {
+			return nil, err
+		}
+		if err := wp.Close(); err != nil {
+			return nil, err
+		}
+		out = append(out, MessagePart{Header: partHdr, Body: v.text, Size: len(v.text)})
 	}
-	return fmt.Sprintf("<%d.%x@%s>", time.Now().UnixNano(), b, host)
+	return out, mw.Close()
 }
 
-// osHostname is split for testability
-var osHostname = os.Hostname
+// composeMixedMessage builds a multipart/mixed message of a text body, or a multipart/alternative of it and
+// htmlBody when set, followed by atts, each part in the transfer encoding its content calls for.
+func composeMixedMessage(r io.Reader, hdr textproto.MIMEHeader, body, htmlBody string, atts []Attachment) (string, *Message, error) {
+	var parts bytes.Buffer
+	mw := newMultipartWriter(&parts, r)
+	hdr.Set("MIME-Version", "1.0")
+	hdr.Set("Content-Type", fmt.Sprintf("multipart/mixed; boundary=%q", mw.Boundary()))
+	structure := &Message{Boundary: mw.Boundary()}
+
+	if htmlBody != "" {
+		// The nested boundary goes in the part's header, before the part's writer exists
+		boundary := newBoundary(r)
+		w, err := mw.CreatePart(mapToMIMEHeader(map[string]string{
+			"Content-Type": fmt.Sprintf("multipart/alternative; boundary=%q", boundary),
+		}))
+		if err != nil {
+			return "", nil, err
+		}
+		alt := multipart.NewWriter(w)
+		if err = alt.SetBoundary(boundary); err != nil {
+			return "", nil, err
+		}
+		if structure.Parts, err = writeAlternative(alt, body, htmlBody); err != nil {
+			return "", nil, err
+		}
+	} else {
+		bodyHdr := mapToMIMEHeader(map[string]string{"Content-Type": "text/plain; charset=utf-8"})
+		wp := newPart(mw, bodyHdr, false)
+		if _, err := io.WriteString(wp, body); err != nil {
+			return "", nil, err
+		}
+		if err := wp.Close(); err != nil {
+			return "", nil, err
+		}
+		structure.Parts = []MessagePart{{Header: bodyHdr, Body: body, Size: len(body)}}
+	}
+
+	for _, a := range atts {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		attHdr := mapToMIMEHeader(map[string]string{
+			"Content-Type":        mime.FormatMediaType(contentType, map[string]string{"name": a.Filename}),
+			"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}),
+		})
+		ap := newPart(mw, attHdr, true)
+		content := a.Content
+		if content == nil {
+			content = bytes.NewReader(a.Data)
+		}
+		var raw countingWriter
+		if _, err := io.Copy(io.MultiWriter(ap, &raw), content); err != nil {
+			return "", nil, err
+		}
+		if err := ap.Close(); err != nil {
+			return "", nil, err
+		}
+		structure.Parts = append(structure.Parts, MessagePart{Header: attHdr, Filename: a.Filename, Size: raw.n})
+	}
+	if err := mw.Close(); err != nil {
+		return "", nil, err
+	}
+
+	var buf bytes.Buffer
+	writeHeaders(&buf, hdr)
+	buf.Write(parts.Bytes())
+	structure.Header = cloneHeader(hdr)
+	return buf.String(), structure, nil
+}
 
 func mapToMIMEHeader(m map[string]string) textproto.MIMEHeader {
 	h := make(textproto.MIMEHeader)
@@ -218,3 +541,40 @@ func mapToMIMEHeader(m map[string]string) textproto.MIMEHeader {
 	}
 	return h
 }
+
+// lineWrapper breaks the stream written to it into CRLF-terminated lines of width bytes. Close terminates a final
+// partial line.
+type lineWrapper struct {
+	w     io.Writer
+	width int
+	col   int
+}
+
+func (l *lineWrapper) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := min(l.width-l.col, len(p))
+		if _, err := l.w.Write(p[:chunk]); err != nil {
+			return n, err
+		}
+		n += chunk
+		l.col += chunk
+		p = p[chunk:]
+		if l.col == l.width {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return n, err
+			}
+			l.col = 0
+		}
+	}
+	return n, nil
+}
+
+func (l *lineWrapper) Close() error {
+	if l.col == 0 {
+		return nil
+	}
+	l.col = 0
+	_, err := io.WriteString(l.w, "\r\n")
+	return err
+}
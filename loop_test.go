@@ -0,0 +1,55 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+// Two instances mailing each other: neither answers the other's notifications, and forwarding stops after the hop
+// limit.
+func TestLoopPrevention(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.club.example", Port: 587, From: "station@club.example"}}
+	s.isInitialized.Store(true)
+
+	note, err := s.composeNotification(t.Context(), []string{"logs@club.example.org"}, "Contest log", "my log", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := ParseInbound(strings.NewReader(note.Msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := in.Header.Get("Auto-Submitted"); got != "auto-generated" {
+		t.Fatalf("Auto-Submitted = %q, want auto-generated", got)
+	}
+	cfg := testAutoReplyConfig()
+	cfg.Rules = append(cfg.Rules, AutoReplyRule{Name: "any", Body: "Thanks."})
+	if _, ok, _ := cfg.buildReply(in, "logs@club.example.org", nil, time.Now()); ok {
+		t.Fatal("auto-replied to an auto-generated notification")
+	}
+
+	var sent []string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent = append(sent, string(msg))
+		return deliveryInfo{}, nil
+	})
+
+	s.MaxForwardHops = 3
+	for hop := 0; ; hop++ {
+		_, err = s.Forward(t.Context(), in, []string{"other@club.example"})
+		if err != nil {
+			if hop != 3 || !strings.Contains(err.Error(), errMsgTooManyHops) {
+				t.Fatalf("hop %d: %v", hop, err)
+			}
+			break
+		}
+		if in, err = ParseInbound(strings.NewReader(sent[len(sent)-1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
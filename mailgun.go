@@ -0,0 +1,100 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+// Mailgun API base URLs, for domains in the US and EU regions.
+const (
+	MailgunAPIBase   = "https://api.mailgun.net"
+	MailgunEUAPIBase = "https://api.eu.mailgun.net"
+)
+
+// MailgunTransport delivers through Mailgun's messages.mime API over HTTPS, for stations whose ISP blocks
+// outbound SMTP. The composed message is posted as is, so its headers, DKIM signature included, are kept.
+type MailgunTransport struct {
+	// Domain is the sending domain configured in Mailgun; empty uses the domain of the envelope sender.
+	Domain string
+	// APIKey authenticates to Mailgun; empty uses the EmailConfig password of the send.
+	APIKey string
+	// BaseURL overrides MailgunAPIBase, e.g. with MailgunEUAPIBase.
+	BaseURL string
+	Client  *http.Client
+}
+
+func (t *MailgunTransport) Deliver(ctx context.Context, sub Submission) error {
+	const op errors.Op = "email.MailgunTransport.Deliver"
+	from, to, msg := sub.From, sub.To, sub.Msg
+	key := t.APIKey
+	if key == "" {
+		key = strings.TrimSpace(sub.Config.Password)
+	}
+	if key == "" {
+		return errors.New(op).Msg("no Mailgun API key configured")
+	}
+	domain := t.Domain
+	if domain == "" {
+		if at := strings.LastIndexByte(from, '@'); at >= 0 {
+			domain = from[at+1:]
+		}
+	}
+	if domain == "" {
+		return errors.New(op).Msg("no Mailgun sending domain configured")
+	}
+	base := t.BaseURL
+	if base == "" {
+		base = MailgunAPIBase
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.WriteField("to", strings.Join(to, ",")); err != nil {
+		return errors.New(op).Err(err).Msg("failed to build Mailgun request")
+	}
+	part, err := mw.CreateFormFile("message", "message.eml")
+	if err == nil {
+		_, err = part.Write(msg)
+	}
+	if err == nil {
+		err = mw.Close()
+	}
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to build Mailgun request")
+	}
+	endpoint := strings.TrimSuffix(base, "/") + "/v3/" + url.PathEscape(domain) + "/messages.mime"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to build Mailgun request")
+	}
+	req.SetBasicAuth("api", key)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("Mailgun request failed")
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxAPIResponseBody))
+	var failure struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &failure) != nil {
+		failure.Message = strings.TrimSpace(string(data))
+	}
+	return errors.New(op).Err(apiReply(resp.StatusCode, failure.Message)).Msgf("Mailgun refused the message (HTTP %d)", resp.StatusCode)
+}
@@ -0,0 +1,65 @@
+package email
+
+import (
+	stderr "errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestMailgunTransport(t *testing.T) {
+	var path, user, key, to, message string
+	statuses := []int{http.StatusOK}
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		user, key, _ = r.BasicAuth()
+		to = r.FormValue("to")
+		if f, _, err := r.FormFile("message"); err == nil {
+			data, _ := io.ReadAll(f)
+			message = string(data)
+		}
+		status := statuses[min(calls, len(statuses)-1)]
+		calls++
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			_, _ = w.Write([]byte(`{"id":"<20261015.1@mg.example.org>","message":"Queued. Thank you."}`))
+		} else {
+			_, _ = w.Write([]byte(`{"message":"Internal error"}`))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	s := &Service{
+		Config:    &types.EmailConfig{Enabled: true, Host: "smtp.mailgun.org", Port: 587, From: "op@mg.example.org", SmtpRetryCount: 2},
+		Transport: &MailgunTransport{APIKey: "key-123", BaseURL: ts.URL, Client: ts.Client()},
+	}
+	s.isInitialized.Store(true)
+
+	def := MsgDef{To: []string{"qsl@example.com", "log@example.com"}, Msg: "Subject: qsl\r\nMessage-ID: <a1@mg.example.org>\r\n\r\n73\r\n"}
+	if err := s.Send(def); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/v3/mg.example.org/messages.mime" || user != "api" || key != "key-123" || to != "qsl@example.com,log@example.com" {
+		t.Errorf("path=%s user=%s key=%s to=%s", path, user, key, to)
+	}
+	if !strings.Contains(message, "Message-ID: <a1@mg.example.org>") || !strings.HasSuffix(message, "73\r\n") {
+		t.Errorf("message %q", message)
+	}
+
+	// A 5xx is retried under the configured policy
+	calls, statuses = 0, []int{http.StatusBadGateway, http.StatusOK}
+	if err := s.Send(def); err != nil || calls != 2 {
+		t.Fatalf("err=%v calls=%d", err, calls)
+	}
+	calls, statuses = 0, []int{http.StatusUnauthorized}
+	err := s.Send(def)
+	var se *SendError
+	if !stderr.As(err, &se) || se.Reason != ReasonAuthFailed || calls != 1 {
+		t.Fatalf("err=%v calls=%d", err, calls)
+	}
+}
@@ -0,0 +1,43 @@
+package email
+
+import (
+	"maps"
+	"net/textproto"
+)
+
+// Message is the structure of a built message, so callers and tests can inspect what was generated without
+// parsing the MIME text.
+type Message struct {
+	Header textproto.MIMEHeader
+	// Boundary is the multipart boundary; empty for single-part messages.
+	Boundary string
+	// Parts are the leaf parts in order: the text body, its HTML alternative if any, then the attachments.
+	Parts []MessagePart
+}
+
+// MessagePart is one MIME part. Attachment bodies are not retained; Size is their decoded length.
+type MessagePart struct {
+	Header   textproto.MIMEHeader
+	Filename string
+	Body     string
+	Size     int
+}
+
+// Attachments returns the parts carrying a file.
+func (m *Message) Attachments() []MessagePart {
+	var out []MessagePart
+	for _, p := range m.Parts {
+		if p.Filename != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func cloneHeader(h textproto.MIMEHeader) textproto.MIMEHeader {
+	out := maps.Clone(h)
+	for k, v := range out {
+		out[k] = append([]string(nil), v...)
+	}
+	return out
+}
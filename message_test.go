@@ -0,0 +1,53 @@
+package email
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestBuildersReturnStructure(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{From: "from@example.com", To: "alice@example.com, bob@example.org", Subject: "Subject"}}
+	qs := []types.Qso{{LogbookID: 1, SessionID: 1}, {LogbookID: 1, SessionID: 2}}
+
+	def, err := s.BuildEmailWithADIFAttachment("", "", "hello world", nil, qs)
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	m := def.Structure
+	if m == nil {
+		t.Fatalf("expected the builder to return the message structure")
+	}
+	if m.Header.Get("To") != "alice@example.com, bob@example.org" || m.Header.Get("Subject") != "Subject" {
+		t.Fatalf("unexpected headers %v", m.Header)
+	}
+	if m.Boundary == "" || !strings.Contains(def.Msg, "--"+m.Boundary+"--") {
+		t.Fatalf("boundary %q does not delimit the wire message", m.Boundary)
+	}
+	if len(m.Parts) != 2 || m.Parts[0].Body != "hello world" {
+		t.Fatalf("unexpected parts %+v", m.Parts)
+	}
+	atts := m.Attachments()
+	if len(atts) != 1 || !strings.HasSuffix(atts[0].Filename, "-export.adi") || atts[0].Size == 0 {
+		t.Fatalf("unexpected attachments %+v", atts)
+	}
+
+	// The reported size matches the decoded attachment on the wire
+	in, err := ParseInbound(strings.NewReader(def.Msg))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	wire, err := in.Attachments()
+	if err != nil || len(wire) != 1 || len(wire[0].Data) != atts[0].Size {
+		t.Fatalf("attachment size %d does not match wire data (%v)", atts[0].Size, err)
+	}
+
+	note, err := s.composeNotification(t.Context(), []string{"op@example.com"}, "Rig alert", "SWR high", nil, nil)
+	if err != nil {
+		t.Fatalf("compose failed: %v", err)
+	}
+	if note.Structure == nil || len(note.Structure.Parts) != 1 || note.Structure.Parts[0].Body != "SWR high" {
+		t.Fatalf("unexpected notification structure %+v", note.Structure)
+	}
+}
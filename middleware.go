@@ -0,0 +1,21 @@
+package email
+
+import "github.com/Station-Manager/errors"
+
+// MessageMiddleware transforms a message before it is handed to the transport, e.g. to sign it, add a footer,
+// archive a copy or redact content. It sees the wire text in Msg; Structure, if set, describes the message as
+// built and is not kept in sync. Returning an error aborts the send.
+type MessageMiddleware func(MsgDef) (MsgDef, error)
+
+// applyMiddleware runs s.Middleware in order. It runs once per send; queued retries reuse its output.
+func (s *Service) applyMiddleware(email MsgDef) (MsgDef, error) {
+	const op errors.Op = "email.Service.applyMiddleware"
+	for i, mw := range s.Middleware {
+		out, err := mw(email)
+		if err != nil {
+			return MsgDef{}, errors.New(op).Err(err).Msgf("message middleware %d: %v", i, err)
+		}
+		email = out
+	}
+	return email, nil
+}
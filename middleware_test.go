@@ -0,0 +1,64 @@
+package email
+
+import (
+	"context"
+	stderr "errors"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+func TestMiddlewareRunsInOrderBeforeTransport(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}}
+	s.isInitialized.Store(true)
+
+	var sent string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent = string(msg)
+		return deliveryInfo{}, nil
+	})
+
+	var archived []string
+	s.Middleware = []MessageMiddleware{
+		func(m MsgDef) (MsgDef, error) {
+			m.Msg = strings.ReplaceAll(m.Msg, "secret-grid", "[redacted]")
+			return m, nil
+		},
+		func(m MsgDef) (MsgDef, error) {
+			m.Msg += "\r\n-- \r\nSent by Station Manager\r\n"
+			return m, nil
+		},
+		func(m MsgDef) (MsgDef, error) {
+			archived = append(archived, m.Msg)
+			return m, nil
+		},
+	}
+
+	if err := s.Send(MsgDef{To: []string{"to@example.com"}, Msg: "Subject: hi\r\n\r\nmeet at secret-grid"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if !strings.Contains(sent, "meet at [redacted]\r\n-- \r\nSent by Station Manager") {
+		t.Fatalf("middleware output not sent: %q", sent)
+	}
+	if len(archived) != 1 || strings.Contains(archived[0], "secret-grid") {
+		t.Fatalf("archive saw %q", archived)
+	}
+}
+
+func TestMiddlewareErrorAbortsSend(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}}
+	s.isInitialized.Store(true)
+
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		t.Fatal("transport must not be reached")
+		return deliveryInfo{}, nil
+	})
+
+	s.Middleware = []MessageMiddleware{func(m MsgDef) (MsgDef, error) { return m, stderr.New("signing key unavailable") }}
+	if err := s.Send(MsgDef{To: []string{"to@example.com"}, Msg: "Subject: hi\r\n\r\nbody"}); err == nil || !strings.Contains(errors.Root(err).Error(), "signing key unavailable") {
+		t.Fatalf("expected the middleware error, got %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/logging"
+)
+
+const (
+	mqttTimeout = 10 * time.Second
+
+	mqttConnect    = 0x10
+	mqttConnAck    = 0x20
+	mqttPublish    = 0x30
+	mqttPubAck     = 0x40
+	mqttDisconnect = 0xe0
+)
+
+// MQTTSink publishes delivery events as JSON to an MQTT 3.1.1 broker, as already used in many shacks for rig and
+// rotator telemetry. The connection is opened lazily and re-established after failures. Stop, called by
+// Service.Shutdown, cancels the publish in flight, discards the backlog and disconnects.
+type MQTTSink struct {
+	// Broker is the host:port of the broker.
+	Broker   string
+	TLS      bool
+	ClientID string
+	Username string
+	Password string
+	Topic    string
+	// QoS is 0 (at most once) or 1 (at least once).
+	QoS    byte
+	Retain bool
+	Logger *logging.Service
+
+	worker   eventWorker
+	conn     net.Conn
+	r        *bufio.Reader
+	packetID uint16
+}
+
+// Publish queues ev for publication.
+func (m *MQTTSink) Publish(ev DeliveryEvent) {
+	if !m.worker.enqueue(ev, m.deliver) {
+		m.Logger.WarnWith().Str("broker", m.Broker).Str("event", string(ev.Type)).Msg("mqtt backlog full; dropping event")
+	}
+}
+
+// Stop stops the background worker, waits for it to exit and disconnects from the broker.
+func (m *MQTTSink) Stop() {
+	m.worker.stop()
+	m.close()
+}
+
+func (m *MQTTSink) deliver(ctx context.Context, ev DeliveryEvent) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		m.Logger.ErrorWith().Err(err).Msg("failed to encode mqtt event")
+		return
+	}
+	// A broker may have dropped an idle connection; retry once on a fresh one
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = m.publish(ctx, payload); err == nil {
+			return
+		}
+		m.close()
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	m.Logger.ErrorWith().Err(err).Str("broker", m.Broker).Str("event", string(ev.Type)).Msg("mqtt publish failed")
+}
+
+func (m *MQTTSink) publish(ctx context.Context, payload []byte) error {
+	const op errors.Op = "email.MQTTSink.publish"
+	if m.conn == nil {
+		if err := m.connect(ctx); err != nil {
+			return errors.New(op).Err(err).Msg("mqtt connect failed")
+		}
+	}
+	_ = m.conn.SetDeadline(time.Now().Add(mqttTimeout))
+	// Cancelling ctx expires the deadline, unblocking a write or a wait for PUBACK
+	conn := m.conn
+	defer context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })()
+
+	var vh []byte
+	vh = appendMQTTString(vh, m.Topic)
+	header := byte(mqttPublish)
+	if m.QoS > 0 {
+		m.packetID++
+		if m.packetID == 0 {
+			m.packetID = 1
+		}
+		header |= 1 << 1
+		vh = binary.BigEndian.AppendUint16(vh, m.packetID)
+	}
+	if m.Retain {
+		header |= 1
+	}
+	if _, err := m.conn.Write(mqttPacket(header, append(vh, payload...))); err != nil {
+		return errors.New(op).Err(err).Msg("mqtt write failed")
+	}
+	if m.QoS == 0 {
+		return nil
+	}
+
+	typ, body, err := readMQTTPacket(m.r)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("mqtt read failed")
+	}
+	if typ&0xf0 != mqttPubAck || len(body) < 2 || binary.BigEndian.Uint16(body) != m.packetID {
+		return errors.New(op).Msgf("unexpected mqtt packet 0x%02x while awaiting PUBACK", typ)
+	}
+	return nil
+}
+
+func (m *MQTTSink) connect(ctx context.Context) error {
+	d := newDialer(mqttTimeout)
+	var (
+		conn net.Conn
+		err  error
+	)
+	if m.TLS {
+		host, _, _ := net.SplitHostPort(m.Broker)
+		td := &tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: host}}
+		conn, err = td.DialContext(ctx, "tcp", m.Broker)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", m.Broker)
+	}
+	if err != nil {
+		return err
+	}
+	if err = m.handshake(conn); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	return nil
+}
+
+// handshake sends CONNECT on an established connection and waits for CONNACK.
+func (m *MQTTSink) handshake(conn net.Conn) error {
+	_ = conn.SetDeadline(time.Now().Add(mqttTimeout))
+	flags := byte(0x02) // clean session
+	var payload []byte
+	payload = appendMQTTString(payload, m.ClientID)
+	if m.Username != "" {
+		flags |= 0x80
+		payload = appendMQTTString(payload, m.Username)
+		if m.Password != "" {
+			flags |= 0x40
+			payload = appendMQTTString(payload, m.Password)
+		}
+	}
+	var vh []byte
+	vh = appendMQTTString(vh, "MQTT")
+	// Protocol level 4 (3.1.1); keep-alive disabled since publishing reconnects on demand
+	vh = append(vh, 4, flags, 0, 0)
+	if _, err := conn.Write(mqttPacket(mqttConnect, append(vh, payload...))); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	typ, body, err := readMQTTPacket(r)
+	if err != nil {
+		return err
+	}
+	if typ != mqttConnAck || len(body) != 2 {
+		return fmt.Errorf("unexpected mqtt packet 0x%02x while awaiting CONNACK", typ)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("mqtt broker refused connection: return code %d", body[1])
+	}
+	m.conn, m.r = conn, r
+	return nil
+}
+
+func (m *MQTTSink) close() {
+	if m.conn == nil {
+		return
+	}
+	_, _ = m.conn.Write([]byte{mqttDisconnect, 0})
+	_ = m.conn.Close()
+	m.conn, m.r = nil, nil
+}
+
+func mqttPacket(header byte, body []byte) []byte {
+	out := []byte{header}
+	// Remaining length is a base-128 varint
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
+
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, fmt.Errorf("malformed mqtt remaining length")
+		}
+		b, rerr := r.ReadByte()
+		if rerr != nil {
+			return 0, nil, rerr
+		}
+		n += int(b&0x7f) * mult
+		mult *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, n)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return typ, body, nil
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
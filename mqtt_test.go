@@ -0,0 +1,116 @@
+package email
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMQTTSinkPublishesWithQoS1(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	type publish struct {
+		topic   string
+		payload []byte
+	}
+	got := make(chan publish, 1)
+	go func() {
+		conn, aerr := ln.Accept()
+		if aerr != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		typ, body, rerr := readMQTTPacket(r)
+		if rerr != nil || typ != mqttConnect || string(body[2:6]) != "MQTT" {
+			t.Errorf("expected CONNECT, got 0x%02x err=%v", typ, rerr)
+			return
+		}
+		_, _ = conn.Write([]byte{mqttConnAck, 2, 0, 0})
+
+		typ, body, rerr = readMQTTPacket(r)
+		if rerr != nil || typ&0xf0 != mqttPublish || typ&0x06 != 0x02 {
+			t.Errorf("expected QoS1 PUBLISH, got 0x%02x err=%v", typ, rerr)
+			return
+		}
+		tl := int(binary.BigEndian.Uint16(body))
+		topic := string(body[2 : 2+tl])
+		id := body[2+tl : 4+tl]
+		_, _ = conn.Write(append([]byte{mqttPubAck, 2}, id...))
+		got <- publish{topic: topic, payload: body[4+tl:]}
+	}()
+
+	sink := &MQTTSink{Broker: ln.Addr().String(), ClientID: "sm-email", Topic: "shack/email", QoS: 1}
+	sink.Publish(DeliveryEvent{Type: EventBounced, MessageID: "<m1@example.com>"})
+
+	select {
+	case p := <-got:
+		if p.topic != "shack/email" {
+			t.Errorf("unexpected topic %q", p.topic)
+		}
+		var ev DeliveryEvent
+		if err = json.Unmarshal(p.payload, &ev); err != nil || ev.Type != EventBounced {
+			t.Errorf("unexpected payload %s (err=%v)", p.payload, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no publish received")
+	}
+}
+
+func TestMQTTSinkStopCancelsPublishAwaitingPubAck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	published := make(chan struct{}, 1)
+	go func() {
+		conn, aerr := ln.Accept()
+		if aerr != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		if _, _, rerr := readMQTTPacket(r); rerr != nil {
+			return
+		}
+		_, _ = conn.Write([]byte{mqttConnAck, 2, 0, 0})
+		if _, _, rerr := readMQTTPacket(r); rerr != nil {
+			return
+		}
+		// Never acknowledge the PUBLISH
+		published <- struct{}{}
+		_, _ = io.Copy(io.Discard, r)
+	}()
+
+	sink := &MQTTSink{Broker: ln.Addr().String(), ClientID: "sm-email", Topic: "shack/email", QoS: 1}
+	sink.Publish(DeliveryEvent{Type: EventSent, MessageID: "<m1@example.com>"})
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no publish received")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		sink.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(mqttTimeout / 2):
+		t.Fatalf("Stop did not cancel the publish awaiting PUBACK")
+	}
+	if sink.conn != nil {
+		t.Fatalf("expected Stop to disconnect from the broker")
+	}
+}
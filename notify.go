@@ -0,0 +1,347 @@
+package email
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// NotificationCategory groups notifications so users can tune verbosity per kind of mail.
+type NotificationCategory string
+
+const (
+	CategoryExport NotificationCategory = "export"
+	CategoryAlert  NotificationCategory = "alert"
+	CategoryDigest NotificationCategory = "digest"
+)
+
+// DigestSchedule controls whether a category is mailed immediately or batched into a periodic digest.
+type DigestSchedule string
+
+const (
+	ScheduleImmediate DigestSchedule = ""
+	ScheduleDaily     DigestSchedule = "daily"
+	ScheduleWeekly    DigestSchedule = "weekly"
+)
+
+func (d DigestSchedule) period() time.Duration {
+	switch d {
+	case ScheduleDaily:
+		return 24 * time.Hour
+	case ScheduleWeekly:
+		return 7 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// CategorySettings tunes one notification category.
+type CategorySettings struct {
+	Enabled bool
+	// To overrides the configured recipients for this category.
+	To       []string
+	Schedule DigestSchedule
+}
+
+// NotificationConfig holds the per-category settings. Categories without an entry are enabled and sent
+// immediately to the configured recipients.
+type NotificationConfig struct {
+	Categories map[NotificationCategory]CategorySettings
+}
+
+func (c *NotificationConfig) settings(cat NotificationCategory) CategorySettings {
+	if c != nil {
+		if cs, ok := c.Categories[cat]; ok {
+			return cs
+		}
+	}
+	return CategorySettings{Enabled: true}
+}
+
+// Notification is an event-driven message raised by other Station-Manager services.
+type Notification struct {
+	Category NotificationCategory
+	Subject  string
+	Body     string
+	// Attachments are sent with the notification. In a digest, identical content raised by several events is
+	// attached once.
+	Attachments []Attachment
+}
+
+type digestStore struct {
+	mu      sync.Mutex
+	pending map[NotificationCategory][]Notification
+	// windowStart is when the current digest period of a category began
+	windowStart map[NotificationCategory]time.Time
+	// threads holds the Message-IDs of the most recent digests of each category, oldest first
+	threads map[NotificationCategory][]string
+}
+
+// maxThreadRefs bounds the References chain of a digest thread; the stable root is always kept in front.
+const maxThreadRefs = 10
+
+// Notify delivers n according to its category settings: dropped when disabled, sent now, or held for the next
+// digest of its category.
+func (s *Service) Notify(ctx context.Context, n Notification) error {
+	const op errors.Op = "email.Service.Notify"
+	if !s.isInitialized.Load() {
+		return errors.New(op).Msg(errMsgNotInitialized)
+	}
+	cs := s.Notifications.settings(n.Category)
+	if !cs.Enabled {
+		s.LoggerService.DebugWith().Str("category", string(n.Category)).Msg("notification category disabled")
+		return nil
+	}
+	if cs.Schedule.period() > 0 {
+		s.digests.add(n, s.now())
+		return nil
+	}
+
+	subject, body := n.Subject, n.Body
+	if s.Templates != nil {
+		tmpl, terr := s.Template(TemplateNotification)
+		if terr == nil {
+			var r Rendered
+			if r, terr = tmpl.Render(n); terr == nil {
+				subject, body = r.Subject, r.Text
+			}
+		}
+		if terr != nil {
+			s.LoggerService.WarnWith().Err(terr).Msg("notification template failed; sending the notification as raised")
+		}
+	}
+	msg, err := s.composeNotification(ctx, s.categoryRecipients(cs, false), subject, body, n.Attachments, nil)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to compose notification")
+	}
+	msg.Priority = n.Category.priority()
+	msg.Category = n.Category
+	return s.SendContext(ctx, msg)
+}
+
+// FlushDigests sends the digest of every category whose schedule period has elapsed since its last digest.
+// It is intended to be called periodically by the host application's scheduler.
+func (s *Service) FlushDigests(ctx context.Context, now time.Time) error {
+	const op errors.Op = "email.Service.FlushDigests"
+	if !s.isInitialized.Load() {
+		return errors.New(op).Msg(errMsgNotInitialized)
+	}
+	for _, cat := range s.digests.categories() {
+		cs := s.Notifications.settings(cat)
+		items := s.digests.take(cat, now, cs.Schedule.period())
+		if len(items) == 0 {
+			continue
+		}
+		body, atts := digestBody(items)
+		thread := s.digests.threadHeader(cat, s.config().From)
+		msg, err := s.composeNotification(ctx, s.categoryRecipients(cs, true), digestSubject(cat, len(items)), body, atts, thread)
+		if err == nil {
+			msg.Priority = PriorityBulk
+			msg.Category = CategoryDigest
+			err = s.SendContext(ctx, msg)
+		}
+		if err != nil {
+			s.digests.restore(cat, items)
+			return errors.New(op).Err(err).Msgf("failed to send %s digest", cat)
+		}
+		s.digests.recordSent(cat, msg.Structure.Header.Get("Message-ID"))
+	}
+	return nil
+}
+
+// priority returns the queue priority for immediate notifications of the category.
+func (c NotificationCategory) priority() Priority {
+	switch c {
+	case CategoryAlert:
+		return PriorityUrgent
+	case CategoryDigest:
+		return PriorityBulk
+	}
+	return PriorityNormal
+}
+
+// categoryRecipients returns whom mail of a category is sent to; digest selects the recipients of its digests.
+func (s *Service) categoryRecipients(cs CategorySettings, digest bool) []string {
+	if len(cs.To) > 0 {
+		return cs.To
+	}
+	return s.defaultTo(digest)
+}
+
+// composeNotification builds a notification message. extra headers, such as threading, are added as given.
+func (s *Service) composeNotification(ctx context.Context, to []string, subject, body string, atts []Attachment, extra textproto.MIMEHeader) (MsgDef, error) {
+	const op errors.Op = "email.Service.composeNotification"
+	to, err := s.resolveRecipients(ctx, to)
+	if err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msg("failed to resolve recipients")
+	}
+	if len(to) == 0 {
+		return MsgDef{}, errors.New(op).Msg("email TO address cannot be empty")
+	}
+	from := strings.TrimSpace(s.config().From)
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("From", from)
+	hdr.Set("To", s.headerTo(to))
+	hdr.Set("Subject", mime.QEncoding.Encode("utf-8", subject))
+	hdr.Set("Date", s.now().UTC().Format(time.RFC1123Z))
+	hdr.Set("Message-ID", generateMessageID(s.random(), s.now()))
+	// RFC 3834: tells other Station-Manager instances and vacation responders not to answer
+	hdr.Set("Auto-Submitted", "auto-generated")
+	for k, v := range extra {
+		hdr[k] = v
+	}
+	msg, structure, err := composeMessage(s.random(), hdr, body, "", atts)
+	if err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msg("failed to compose message")
+	}
+	return MsgDef{From: from, To: to, Msg: msg, Structure: structure}, nil
+}
+
+func digestSubject(cat NotificationCategory, n int) string {
+	return fmt.Sprintf("Station-Manager %s digest (%d items)", cat, n)
+}
+
+// digestBody joins the items into sections and collects their attachments, keeping one copy of each distinct
+// content. Every section lists the attachments it refers to by the name they carry in the digest.
+func digestBody(items []Notification) (string, []Attachment) {
+	var (
+		b     strings.Builder
+		atts  []Attachment
+		seen  = map[[sha256.Size]byte]string{}
+		names = map[string]int{}
+	)
+	for i, n := range items {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString("== ")
+		b.WriteString(n.Subject)
+		b.WriteString(" ==\n")
+		b.WriteString(n.Body)
+		for _, a := range n.Attachments {
+			sum := sha256.Sum256(a.Data)
+			name, ok := seen[sum]
+			if !ok {
+				name = uniqueFilename(a.Filename, names)
+				seen[sum] = name
+				a.Filename = name
+				atts = append(atts, a)
+			}
+			b.WriteString("\n[attachment: ")
+			b.WriteString(name)
+			b.WriteString("]")
+		}
+	}
+	return b.String(), atts
+}
+
+// uniqueFilename keeps distinct contents that share a filename apart by numbering the later ones.
+func uniqueFilename(name string, used map[string]int) string {
+	if name == "" {
+		name = "attachment"
+	}
+	used[name]++
+	if used[name] == 1 {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), used[name], ext)
+}
+
+func (d *digestStore) add(n Notification, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending == nil {
+		d.pending = map[NotificationCategory][]Notification{}
+		d.windowStart = map[NotificationCategory]time.Time{}
+	}
+	if _, ok := d.windowStart[n.Category]; !ok {
+		d.windowStart[n.Category] = now
+	}
+	d.pending[n.Category] = append(d.pending[n.Category], n)
+}
+
+func (d *digestStore) categories() []NotificationCategory {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]NotificationCategory, 0, len(d.pending))
+	for cat := range d.pending {
+		out = append(out, cat)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// take removes and returns the pending items of cat once its digest period has elapsed.
+func (d *digestStore) take(cat NotificationCategory, now time.Time, period time.Duration) []Notification {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.windowStart[cat]) < period {
+		return nil
+	}
+	items := d.pending[cat]
+	delete(d.pending, cat)
+	d.windowStart[cat] = now
+	return items
+}
+
+// restore puts back items whose digest failed to send so they go out at the next flush.
+func (d *digestStore) restore(cat NotificationCategory, items []Notification) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending[cat] = append(items, d.pending[cat]...)
+	d.windowStart[cat] = time.Time{}
+}
+
+// threadHeader returns the In-Reply-To and References that place the next digest of cat in its conversation. The
+// chain starts at a root derived from the category and sender, so it stays stable across restarts.
+func (d *digestStore) threadHeader(cat NotificationCategory, from string) textproto.MIMEHeader {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	refs := append([]string{digestThreadRoot(cat, from)}, d.threads[cat]...)
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("In-Reply-To", refs[len(refs)-1])
+	hdr.Set("References", strings.Join(refs, " "))
+	return hdr
+}
+
+// recordSent appends the Message-ID of a delivered digest to the thread of cat.
+func (d *digestStore) recordSent(cat NotificationCategory, messageID string) {
+	if messageID == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.threads == nil {
+		d.threads = map[NotificationCategory][]string{}
+	}
+	ids := append(d.threads[cat], messageID)
+	if len(ids) > maxThreadRefs {
+		ids = ids[len(ids)-maxThreadRefs:]
+	}
+	d.threads[cat] = ids
+}
+
+func digestThreadRoot(cat NotificationCategory, from string) string {
+	addr := strings.TrimSpace(from)
+	if a, err := mail.ParseAddress(addr); err == nil {
+		addr = a.Address
+	}
+	addr = strings.ToLower(addr)
+	_, domain, _ := strings.Cut(addr, "@")
+	if domain == "" {
+		domain = "localhost"
+	}
+	sum := sha256.Sum256([]byte(addr))
+	return fmt.Sprintf("<digest.%s.%x@%s>", cat, sum[:6], domain)
+}
@@ -0,0 +1,153 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestNotifyHonoursCategorySettings(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com", To: "op@example.com"}}
+	s.Notifications = &NotificationConfig{Categories: map[NotificationCategory]CategorySettings{
+		CategoryAlert:  {Enabled: false},
+		CategoryExport: {Enabled: true, To: []string{"club@example.org"}},
+		CategoryDigest: {Enabled: true, Schedule: ScheduleWeekly},
+	}}
+	s.isInitialized.Store(true)
+
+	type sent struct {
+		to  []string
+		msg string
+	}
+	var got []sent
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		got = append(got, sent{to: to, msg: string(msg)})
+		return deliveryInfo{}, nil
+	})
+
+	ctx := context.Background()
+	for _, n := range []Notification{
+		{Category: CategoryAlert, Subject: "Rig disconnected"},
+		{Category: CategoryExport, Subject: "Export ready"},
+		{Category: CategoryDigest, Subject: "QSO summary", Body: "12 QSOs"},
+		{Category: CategoryDigest, Subject: "Upload summary", Body: "3 uploads"},
+	} {
+		if err := s.Notify(ctx, n); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+	}
+	if len(got) != 1 || got[0].to[0] != "club@example.org" {
+		t.Fatalf("expected only the export to be sent to the override recipient, got %+v", got)
+	}
+
+	// Not due yet
+	if err := s.FlushDigests(ctx, time.Now()); err != nil || len(got) != 1 {
+		t.Fatalf("digest sent early: %d messages, err=%v", len(got), err)
+	}
+	if err := s.FlushDigests(ctx, time.Now().Add(8*24*time.Hour)); err != nil {
+		t.Fatalf("FlushDigests failed: %v", err)
+	}
+	if len(got) != 2 || got[1].to[0] != "op@example.com" {
+		t.Fatalf("expected weekly digest to configured recipients, got %+v", got)
+	}
+	if !strings.Contains(got[1].msg, "digest (2 items)") || !strings.Contains(got[1].msg, "3 uploads") {
+		t.Errorf("digest content incomplete: %q", got[1].msg)
+	}
+}
+
+func TestDigestAttachesSharedContentOnce(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com", To: "op@example.com"}}
+	s.Notifications = &NotificationConfig{Categories: map[NotificationCategory]CategorySettings{
+		CategoryExport: {Enabled: true, Schedule: ScheduleDaily},
+	}}
+	s.isInitialized.Store(true)
+
+	var got string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		got = string(msg)
+		return deliveryInfo{}, nil
+	})
+
+	adif := Attachment{Filename: "field-day.adi", ContentType: "text/plain", Data: []byte("<CALL:5>K1ABC<EOR>")}
+	other := Attachment{Filename: "field-day.adi", ContentType: "text/plain", Data: []byte("<CALL:5>W1AW <EOR>")}
+	for _, n := range []Notification{
+		{Category: CategoryExport, Subject: "LoTW upload", Attachments: []Attachment{adif}},
+		{Category: CategoryExport, Subject: "Club upload", Attachments: []Attachment{adif}},
+		{Category: CategoryExport, Subject: "Late QSOs", Attachments: []Attachment{other}},
+	} {
+		if err := s.Notify(t.Context(), n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.FlushDigests(t.Context(), time.Now().Add(25*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := ParseInbound(strings.NewReader(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	atts, err := in.Attachments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atts) != 2 || atts[0].Filename != "field-day.adi" || atts[1].Filename != "field-day-2.adi" {
+		t.Fatalf("expected two distinct attachments, got %+v", atts)
+	}
+	if n := strings.Count(got, "[attachment: field-day.adi]"); n != 2 {
+		t.Errorf("expected both sections to reference the shared attachment, got %d references", n)
+	}
+	if !strings.Contains(got, "[attachment: field-day-2.adi]") {
+		t.Error("expected the differing content to be referenced under its own name")
+	}
+}
+
+func TestDigestsShareAThread(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "Station <from@example.com>", To: "op@example.com"}}
+	s.Notifications = &NotificationConfig{Categories: map[NotificationCategory]CategorySettings{
+		CategoryDigest: {Enabled: true, Schedule: ScheduleDaily},
+	}}
+	s.isInitialized.Store(true)
+
+	var got []*InboundMessage
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		in, err := ParseInbound(strings.NewReader(string(msg)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, in)
+		return deliveryInfo{}, nil
+	})
+
+	now := time.Now()
+	for day := 1; day <= 3; day++ {
+		if err := s.Notify(t.Context(), Notification{Category: CategoryDigest, Subject: "QSO summary"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.FlushDigests(t.Context(), now.Add(time.Duration(day)*25*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 digests, got %d", len(got))
+	}
+
+	root := digestThreadRoot(CategoryDigest, "from@example.com")
+	if !strings.HasSuffix(root, "@example.com>") {
+		t.Errorf("unexpected thread root %q", root)
+	}
+	if got[0].Header.Get("In-Reply-To") != root || got[0].Header.Get("References") != root {
+		t.Errorf("first digest should reply to the thread root, got %q / %q", got[0].Header.Get("In-Reply-To"), got[0].Header.Get("References"))
+	}
+	want := strings.Join([]string{root, got[0].ID, got[1].ID}, " ")
+	if refs := got[2].Header.Get("References"); refs != want {
+		t.Errorf("References = %q, want %q", refs, want)
+	}
+	if got[2].Header.Get("In-Reply-To") != got[1].ID {
+		t.Errorf("third digest should reply to the second, got %q", got[2].Header.Get("In-Reply-To"))
+	}
+}
@@ -0,0 +1,169 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	stderr "errors"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+const (
+	// AuthXOAUTH2 authenticates with an OAuth 2.0 access token, as Gmail and Microsoft 365 require.
+	AuthXOAUTH2 = "xoauth2"
+
+	// Token endpoints of the providers supporting XOAUTH2 for SMTP.
+	GoogleTokenURL    = "https://oauth2.googleapis.com/token"
+	MicrosoftTokenURL = "https://login.microsoftonline.com/common/oauth2/v2.0/token"
+
+	oauth2Timeout = 30 * time.Second
+	// oauth2ExpiryMargin renews an access token this long before it expires, so that it does not lapse mid-send
+	oauth2ExpiryMargin = time.Minute
+)
+
+// OAuth2Config obtains the access tokens used with AuthXOAUTH2 from the provider's token endpoint, using the refresh
+// token the operator granted when linking the account. EmailConfig.Username is the account's address and
+// EmailConfig.Password is not used. Without an OAuth2Config, the password is sent as the access token.
+type OAuth2Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+	// Scopes is sent with the refresh for providers that require it, e.g. "https://outlook.office.com/SMTP.Send"
+	// for Microsoft 365.
+	Scopes []string
+	// TokenSource, when set, is the token-refresh hook used instead of the token endpoint, e.g. for a token kept
+	// by another part of the application.
+	TokenSource func(ctx context.Context) (token string, expiry time.Time, err error)
+	// RefreshTokenRotated is called with the new refresh token when the provider issues one, so that it can be
+	// saved in place of the old, which Microsoft revokes.
+	RefreshTokenRotated func(refreshToken string)
+	Client              *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// accessToken returns a valid access token, refreshing it when it is missing or about to expire.
+func (c *OAuth2Config) accessToken(ctx context.Context) (string, error) {
+	const op errors.Op = "email.OAuth2Config.accessToken"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Until(c.expiry) > oauth2ExpiryMargin {
+		return c.token, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, oauth2Timeout)
+	defer cancel()
+	var (
+		token  string
+		expiry time.Time
+		err    error
+	)
+	if c.TokenSource != nil {
+		token, expiry, err = c.TokenSource(ctx)
+	} else {
+		token, expiry, err = c.refresh(ctx)
+	}
+	if err != nil {
+		return "", errors.New(op).Err(err).Msg("failed to obtain an OAuth2 access token")
+	}
+	if token == "" {
+		return "", errors.New(op).Msg("the OAuth2 token source returned no access token")
+	}
+	c.token, c.expiry = token, expiry
+	return token, nil
+}
+
+// invalidate drops the cached access token, e.g. after the server rejected it.
+func (c *OAuth2Config) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = ""
+}
+
+// refresh redeems the refresh token at the token endpoint (RFC 6749 section 6).
+func (c *OAuth2Config) refresh(ctx context.Context) (string, time.Time, error) {
+	const op errors.Op = "email.OAuth2Config.refresh"
+	if c.TokenURL == "" || c.ClientID == "" || c.RefreshToken == "" {
+		return "", time.Time{}, errors.New(op).Msg("OAuth2 token URL, client ID and refresh token must be set")
+	}
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {c.RefreshToken},
+		"client_id":     {c.ClientID},
+	}
+	if c.ClientSecret != "" {
+		form.Set("client_secret", c.ClientSecret)
+	}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, errors.New(op).Err(err).Msg("failed to build token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, errors.New(op).Err(err).Msg("token request failed")
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var body struct {
+		AccessToken  string `json:"access_token"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Error        string `json:"error"`
+		Description  string `json:"error_description"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", time.Time{}, errors.New(op).Err(err).Msgf("failed to decode token response (HTTP %d)", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", time.Time{}, errors.New(op).Msgf("token endpoint refused the refresh (HTTP %d): %s %s", resp.StatusCode,
+			body.Error, body.Description)
+	}
+	if body.RefreshToken != "" && body.RefreshToken != c.RefreshToken {
+		c.RefreshToken = body.RefreshToken
+		if c.RefreshTokenRotated != nil {
+			c.RefreshTokenRotated(body.RefreshToken)
+		}
+	}
+	return body.AccessToken, time.Now().Add(time.Duration(body.ExpiresIn) * time.Second), nil
+}
+
+// usesOAuth2 reports whether sends authenticate with access tokens from Service.OAuth2.
+func (s *Service) usesOAuth2() bool {
+	return s.OAuth2 != nil && strings.EqualFold(strings.TrimSpace(s.AuthMechanism), AuthXOAUTH2)
+}
+
+// xoauth2Auth implements the XOAUTH2 SASL mechanism of Google and Microsoft.
+type xoauth2Auth struct {
+	username, token string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		return "", nil, stderr.New("unencrypted connection")
+	}
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+// Next answers the JSON error challenge a rejected token draws with an empty response, after which the server
+// sends its failure reply.
+func (a *xoauth2Auth) Next(_ []byte, more bool) ([]byte, error) {
+	if more {
+		return []byte{}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,93 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/textproto"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestXOAUTH2RefreshesAndRotatesTokens(t *testing.T) {
+	var refreshes int
+	var gotRefresh string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("client_id") != "app" {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		refreshes++
+		gotRefresh = r.PostForm.Get("refresh_token")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"at-%d","expires_in":3600,"refresh_token":"rt-%d"}`, refreshes, refreshes)
+	}))
+	t.Cleanup(ts.Close)
+
+	var rotated string
+	s := &Service{
+		Config:        &types.EmailConfig{Enabled: true, Host: "smtp.gmail.com", Port: 587, From: "op@example.org", Username: "op@example.org"},
+		AuthMechanism: AuthXOAUTH2,
+		OAuth2: &OAuth2Config{TokenURL: ts.URL, ClientID: "app", RefreshToken: "rt-0",
+			RefreshTokenRotated: func(rt string) { rotated = rt }},
+	}
+	s.isInitialized.Store(true)
+
+	var responses []string
+	rejectFirst := true
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		mech, resp, err := auth.Start(&smtp.ServerInfo{Name: "smtp.gmail.com", TLS: true})
+		if err != nil || mech != "XOAUTH2" {
+			t.Fatalf("mech=%q err=%v", mech, err)
+		}
+		responses = append(responses, string(resp))
+		if rejectFirst {
+			rejectFirst = false
+			return deliveryInfo{}, &textproto.Error{Code: 535, Msg: "5.7.8 Username and Password not accepted"}
+		}
+		return deliveryInfo{}, nil
+	})
+
+	// A rejected token is dropped and the send tried again with a fresh one
+	if err := s.Send(MsgDef{To: []string{"dx@example.com"}, Msg: "Subject: qsl\r\n\r\n73\r\n"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"user=op@example.org\x01auth=Bearer at-1\x01\x01", "user=op@example.org\x01auth=Bearer at-2\x01\x01"}
+	if len(responses) != 2 || responses[0] != want[0] || responses[1] != want[1] {
+		t.Fatalf("responses %q", responses)
+	}
+	if gotRefresh != "rt-1" || rotated != "rt-2" || s.OAuth2.RefreshToken != "rt-2" {
+		t.Fatalf("refresh token sent %q, rotated %q, kept %q", gotRefresh, rotated, s.OAuth2.RefreshToken)
+	}
+
+	// A valid token is reused
+	if err := s.Send(MsgDef{To: []string{"dx@example.com"}, Msg: "Subject: qsl\r\n\r\n73\r\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if refreshes != 2 || responses[2] != want[1] {
+		t.Fatalf("refreshes=%d responses %q", refreshes, responses)
+	}
+}
+
+func TestXOAUTH2RequiresTLS(t *testing.T) {
+	a := &xoauth2Auth{username: "op@example.org", token: "at"}
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "smtp.gmail.com"}); err == nil {
+		t.Fatal("expected XOAUTH2 to refuse an unencrypted connection")
+	}
+}
+
+func TestOAuth2RefreshFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`))
+	}))
+	t.Cleanup(ts.Close)
+
+	s := &Service{AuthMechanism: AuthXOAUTH2, OAuth2: &OAuth2Config{TokenURL: ts.URL, ClientID: "app", RefreshToken: "rt"}}
+	if _, err := s.smtpAuth(t.Context(), "op@example.org", "", "smtp.gmail.com"); err == nil {
+		t.Fatal("expected the refused refresh to fail the auth")
+	}
+}
@@ -0,0 +1,318 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/logging"
+)
+
+// SeenStore persists the UIDL values of the POP3 messages already processed, so that mail left on the server is
+// not processed again after a restart.
+type SeenStore interface {
+	Save(uids []string) error
+	Load() ([]string, error)
+}
+
+// pop3AckTimeout bounds the commands Ack sends on the session held open since Fetch.
+const pop3AckTimeout = 2 * time.Minute
+
+// pop3Mailbox retrieves mail over POP3 (RFC 1939) with implicit TLS. POP3 sessions only ever see the maildrop as
+// it was at login, so every Fetch opens a fresh session. That session stays open until Inbound has acknowledged
+// every message it returned, because a DELE only takes effect in the session that issued it.
+type pop3Mailbox struct {
+	cfg InboundConfig
+	// seen holds the UIDL values already processed when messages are left on the server
+	seen   map[string]bool
+	store  SeenStore
+	logger *logging.Service
+	dial   func(ctx context.Context) (net.Conn, error)
+	// session is the session of the last Fetch while any of its messages await Ack
+	session *pop3Session
+}
+
+// pop3Session is an open POP3 session and the message numbers of the fetched messages not yet acknowledged,
+// keyed by UIDL value.
+type pop3Session struct {
+	conn    net.Conn
+	tp      *textproto.Conn
+	pending map[string]string
+}
+
+func newPOP3Mailbox(cfg InboundConfig, store SeenStore, logger *logging.Service) *pop3Mailbox {
+	host := strings.TrimSpace(cfg.Host)
+	addr := net.JoinHostPort(host, strconv.Itoa(cfg.Port))
+	p := &pop3Mailbox{
+		cfg:    cfg,
+		seen:   map[string]bool{},
+		store:  store,
+		logger: logger,
+		dial: func(ctx context.Context) (net.Conn, error) {
+			d := tls.Dialer{NetDialer: newDialer(defaultDialTimeout), Config: &tls.Config{ServerName: host}}
+			return d.DialContext(ctx, "tcp", addr)
+		},
+	}
+	if store != nil {
+		uids, err := store.Load()
+		if err != nil {
+			logger.ErrorWith().Err(err).Msg("failed to load seen pop3 messages")
+		}
+		for _, uid := range uids {
+			p.seen[uid] = true
+		}
+	}
+	return p
+}
+
+// Fetch returns messages not seen before. They are only marked as seen, or deleted from the server when
+// DeleteAfterFetch is set, once acknowledged. A message that cannot be parsed is logged and counted as seen, and left
+// on the server for inspection.
+func (p *pop3Mailbox) Fetch(ctx context.Context) ([]*InboundMessage, error) {
+	const op errors.Op = "email.pop3Mailbox.Fetch"
+	if err := p.quit(); err != nil {
+		p.logger.WarnWith().Err(err).Msg("failed to end previous pop3 session")
+	}
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to connect to pop3 server")
+	}
+	held := false
+	defer func() {
+		if !held {
+			_ = conn.Close()
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	tp := textproto.NewConn(conn)
+	if _, err = pop3Reply(tp); err != nil {
+		return nil, errors.New(op).Err(err).Msg("pop3 greeting failed")
+	}
+	if _, err = pop3Cmd(tp, "USER %s", p.cfg.Username); err != nil {
+		return nil, errors.New(op).Err(err).Msg("pop3 login failed")
+	}
+	if _, err = pop3Cmd(tp, "PASS %s", p.cfg.Password); err != nil {
+		return nil, errors.New(op).Err(err).Msg("pop3 login failed")
+	}
+
+	if _, err = pop3Cmd(tp, "UIDL"); err != nil {
+		return nil, errors.New(op).Err(err).Msg("pop3 UIDL failed")
+	}
+	lines, err := tp.ReadDotLines()
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("pop3 UIDL failed")
+	}
+
+	current := make(map[string]bool, len(lines))
+	pending := map[string]string{}
+	var (
+		out []*InboundMessage
+		bad []string
+	)
+	for _, line := range lines {
+		num, uid, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		current[uid] = true
+		if p.seen[uid] {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		if _, err = pop3Cmd(tp, "RETR %s", num); err != nil {
+			break
+		}
+		raw, rerr := tp.ReadDotBytes()
+		if rerr != nil {
+			err = rerr
+			break
+		}
+		msg, perr := ParseInbound(strings.NewReader(string(raw)))
+		if perr != nil {
+			p.logger.WarnWith().Err(perr).Str("uidl", uid).Msg("skipping unparseable inbound message")
+			bad = append(bad, uid)
+			continue
+		}
+		msg.ID = uid
+		pending[uid] = num
+		out = append(out, msg)
+	}
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("pop3 retrieval failed")
+	}
+
+	// Forget messages that have disappeared from the server
+	for uid := range p.seen {
+		if !current[uid] {
+			delete(p.seen, uid)
+		}
+	}
+	for _, uid := range bad {
+		p.seen[uid] = true
+	}
+	p.saveSeen()
+
+	if len(out) == 0 {
+		if _, err = pop3Cmd(tp, "QUIT"); err != nil {
+			return nil, errors.New(op).Err(err).Msg("pop3 QUIT failed")
+		}
+		return nil, nil
+	}
+	held = true
+	p.session = &pop3Session{conn: conn, tp: tp, pending: pending}
+	return out, nil
+}
+
+// Ack marks the message with the given UIDL value as seen, deleting it when DeleteAfterFetch is set. The session
+// is ended once the last message of the batch is acknowledged, which is when the server carries out the deletions.
+func (p *pop3Mailbox) Ack(id string) error {
+	const op errors.Op = "email.pop3Mailbox.Ack"
+	sess := p.session
+	if sess == nil {
+		return errors.New(op).Msgf("no pop3 session holds message %s", id)
+	}
+	num, ok := sess.pending[id]
+	if !ok {
+		return errors.New(op).Msgf("message %s was not fetched in the current pop3 session", id)
+	}
+	_ = sess.conn.SetDeadline(time.Now().Add(pop3AckTimeout))
+	if p.cfg.DeleteAfterFetch {
+		if _, err := pop3Cmd(sess.tp, "DELE %s", num); err != nil {
+			// Without QUIT the server rolls back the session's deletions, so the batch is fetched again
+			p.drop()
+			return errors.New(op).Err(err).Msg("pop3 DELE failed")
+		}
+	}
+	delete(sess.pending, id)
+	p.seen[id] = true
+	p.saveSeen()
+
+	if len(sess.pending) == 0 {
+		if err := p.quit(); err != nil {
+			return errors.New(op).Err(err).Msg("pop3 QUIT failed")
+		}
+	}
+	return nil
+}
+
+// Close ends a session left open by a partly acknowledged batch, so the deletions of the acknowledged messages
+// still take effect.
+func (p *pop3Mailbox) Close() error {
+	const op errors.Op = "email.pop3Mailbox.Close"
+	if err := p.quit(); err != nil {
+		return errors.New(op).Err(err).Msg("pop3 QUIT failed")
+	}
+	return nil
+}
+
+// quit ends the held session, if any, with QUIT.
+func (p *pop3Mailbox) quit() error {
+	sess := p.session
+	if sess == nil {
+		return nil
+	}
+	p.session = nil
+	defer func() { _ = sess.conn.Close() }()
+	_ = sess.conn.SetDeadline(time.Now().Add(pop3AckTimeout))
+	_, err := pop3Cmd(sess.tp, "QUIT")
+	return err
+}
+
+// drop closes the held session without QUIT.
+func (p *pop3Mailbox) drop() {
+	if p.session != nil {
+		_ = p.session.conn.Close()
+		p.session = nil
+	}
+}
+
+// saveSeen persists the seen set, logging a failure since the messages themselves have been handled.
+func (p *pop3Mailbox) saveSeen() {
+	if p.store == nil {
+		return
+	}
+	seen := make([]string, 0, len(p.seen))
+	for uid := range p.seen {
+		seen = append(seen, uid)
+	}
+	sort.Strings(seen)
+	if err := p.store.Save(seen); err != nil {
+		p.logger.ErrorWith().Err(err).Msg("failed to persist seen pop3 messages")
+	}
+}
+
+func pop3Cmd(tp *textproto.Conn, format string, args ...any) (string, error) {
+	if err := tp.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return pop3Reply(tp)
+}
+
+func pop3Reply(tp *textproto.Conn) (string, error) {
+	line, err := tp.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if rest, ok := strings.CutPrefix(line, "+OK"); ok {
+		return strings.TrimSpace(rest), nil
+	}
+	return "", fmt.Errorf("pop3 error: %s", line)
+}
+
+// FileSeenStore keeps the seen UIDL values in a single JSON file.
+type FileSeenStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (f *FileSeenStore) Save(uids []string) error {
+	const op errors.Op = "email.FileSeenStore.Save"
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, err := json.Marshal(uids)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to encode seen messages")
+	}
+	if err = os.MkdirAll(filepath.Dir(f.Path), 0o700); err != nil {
+		return errors.New(op).Err(err).Msg("failed to create seen message directory")
+	}
+	if err = writeFileAtomic(f.Path, data, 0o600); err != nil {
+		return errors.New(op).Err(err).Msg("failed to write seen messages")
+	}
+	return nil
+}
+
+func (f *FileSeenStore) Load() ([]string, error) {
+	const op errors.Op = "email.FileSeenStore.Load"
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, err := os.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to read seen messages")
+	}
+	var out []string
+	if err = json.Unmarshal(data, &out); err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to decode seen messages")
+	}
+	return out, nil
+}
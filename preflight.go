@@ -0,0 +1,178 @@
+package email
+
+import (
+	"context"
+	stderr "errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Advisory severities.
+const (
+	AdvisoryInfo    = "info"
+	AdvisoryWarning = "warning"
+)
+
+// Advisory is a non-fatal finding from a configuration diagnostic.
+type Advisory struct {
+	Severity string
+	Message  string
+}
+
+// preflightTimeout bounds the DNS lookups performed during Initialize.
+const preflightTimeout = 15 * time.Second
+
+// PreflightSenderDomain checks whether the SPF and DMARC records of the From domain permit mail sent through the
+// configured SMTP host. Misaligned setups are accepted by the relay but silently land in recipients' spam folders.
+func (s *Service) PreflightSenderDomain(ctx context.Context) []Advisory {
+	r := s.resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	from := strings.TrimSpace(s.config().From)
+	if addr, err := mail.ParseAddress(from); err == nil {
+		from = addr.Address
+	}
+	_, domain, ok := strings.Cut(from, "@")
+	if !ok || domain == "" {
+		return []Advisory{{Severity: AdvisoryWarning, Message: fmt.Sprintf("cannot determine the domain of from address %q", s.config().From)}}
+	}
+	domain = strings.ToLower(domain)
+	relay := strings.ToLower(strings.TrimSpace(s.config().Host))
+
+	var (
+		out        []Advisory
+		spfProblem bool
+	)
+	spf, err := lookupSPF(ctx, r, domain)
+	switch {
+	case err != nil:
+		out = append(out, Advisory{Severity: AdvisoryInfo, Message: fmt.Sprintf("could not look up SPF for %s: %v", domain, err)})
+	case spf == "":
+		spfProblem = true
+		out = append(out, Advisory{Severity: AdvisoryWarning, Message: fmt.Sprintf("%s publishes no SPF record; receivers may treat mail from it as spam", domain)})
+	default:
+		if !s.relayAuthorized(ctx, r, domain, relay) {
+			spfProblem = true
+			out = append(out, Advisory{Severity: AdvisoryWarning, Message: fmt.Sprintf("SPF for %s does not appear to authorize sending through %s; add the relay's include or use a from address on the relay's domain", domain, relay)})
+		}
+	}
+
+	policy, err := lookupDMARCPolicy(ctx, r, domain)
+	switch {
+	case err != nil:
+		out = append(out, Advisory{Severity: AdvisoryInfo, Message: fmt.Sprintf("could not look up DMARC for %s: %v", domain, err)})
+	case policy == "":
+		out = append(out, Advisory{Severity: AdvisoryInfo, Message: fmt.Sprintf("%s publishes no DMARC policy", domain)})
+	case (policy == "reject" || policy == "quarantine") && spfProblem:
+		out = append(out, Advisory{Severity: AdvisoryWarning, Message: fmt.Sprintf("%s has DMARC p=%s; messages failing SPF alignment will be rejected unless DKIM-signed by %s", domain, policy, domain)})
+	}
+
+	// Providers such as Gmail rewrite the Return-Path to the authenticated account
+	if _, userDomain, ok := strings.Cut(strings.TrimSpace(s.config().Username), "@"); ok && !domainsAligned(userDomain, domain) {
+		sev := AdvisoryInfo
+		if policy == "reject" || policy == "quarantine" {
+			sev = AdvisoryWarning
+		}
+		out = append(out, Advisory{Severity: sev, Message: fmt.Sprintf("the relay may set the Return-Path to the %s login, which does not align with %s; DMARC p=%s", strings.ToLower(userDomain), domain, orNone(policy))})
+	}
+	return out
+}
+
+func orNone(policy string) string {
+	if policy == "" {
+		return "none"
+	}
+	return policy
+}
+
+// relayAuthorized reports whether SPF passes for any address of the relay host. Relays usually send from other
+// addresses than they accept submissions on, so referencing the relay domain or its own SPF includes also counts.
+func (s *Service) relayAuthorized(ctx context.Context, r dnsResolver, domain, relay string) bool {
+	if relay == "" || domainsAligned(relay, domain) {
+		return true
+	}
+	if addrs, err := r.LookupIPAddr(ctx, relay); err == nil {
+		for _, a := range addrs {
+			lookups := 0
+			if evalSPF(ctx, r, domain, a.IP, &lookups) == spfPass {
+				return true
+			}
+		}
+	}
+
+	labels := strings.Split(relay, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	relayDomain := strings.Join(labels[len(labels)-2:], ".")
+	targets := []string{relayDomain}
+	if relaySPF, err := lookupSPF(ctx, r, relayDomain); err == nil {
+		targets = append(targets, spfReferences(relaySPF)...)
+	}
+	spf, _ := lookupSPF(ctx, r, domain)
+	for _, ref := range spfReferences(spf) {
+		for _, t := range targets {
+			if domainsAligned(ref, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// spfReferences returns the include and redirect targets of an SPF record.
+func spfReferences(record string) []string {
+	var out []string
+	for _, term := range strings.Fields(strings.ToLower(record)) {
+		term = strings.TrimLeft(term, "+-~?")
+		if v, ok := strings.CutPrefix(term, "include:"); ok {
+			out = append(out, v)
+		} else if v, ok = strings.CutPrefix(term, "redirect="); ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func lookupDMARCPolicy(ctx context.Context, r dnsResolver, domain string) (string, error) {
+	txts, err := r.LookupTXT(ctx, "_dmarc."+domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if stderr.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, t := range txts {
+		if !strings.HasPrefix(strings.ToLower(t), "v=dmarc1") {
+			continue
+		}
+		for _, tag := range strings.Split(t, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(tag), "=")
+			if strings.EqualFold(k, "p") {
+				return strings.ToLower(strings.TrimSpace(v)), nil
+			}
+		}
+		return "none", nil
+	}
+	return "", nil
+}
+
+// logPreflight runs the sender domain preflight in the background and logs its warnings.
+func (s *Service) logPreflight() {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+		defer cancel()
+		for _, a := range s.PreflightSenderDomain(ctx) {
+			if a.Severity == AdvisoryWarning {
+				s.LoggerService.WarnWith().Str("check", "sender-domain").Msg(a.Message)
+				continue
+			}
+			s.LoggerService.InfoWith().Str("check", "sender-domain").Msg(a.Message)
+		}
+	}()
+}
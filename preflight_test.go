@@ -0,0 +1,113 @@
+package email
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+type fakeResolver struct {
+	txt map[string][]string
+	ips map[string][]string
+	mx  map[string][]string
+}
+
+func (f fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if v, ok := f.txt[name]; ok {
+		return v, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (f fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	var out []net.IPAddr
+	for _, ip := range f.ips[host] {
+		out = append(out, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	if out == nil {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return out, nil
+}
+
+func (f fakeResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	var out []*net.MX
+	for _, h := range f.mx[name] {
+		out = append(out, &net.MX{Host: h})
+	}
+	return out, nil
+}
+
+func TestEvalSPF(t *testing.T) {
+	r := fakeResolver{
+		txt: map[string][]string{
+			"example.com":      {"v=spf1 mx include:_spf.relay.net -all"},
+			"_spf.relay.net":   {"v=spf1 ip4:192.0.2.0/24 ~all"},
+			"redirect.example": {"v=spf1 redirect=example.com"},
+			"a-cidr.example":   {"v=spf1 a/24 -all"},
+			"mx-cidr.example":  {"v=spf1 mx/24//64 -all"},
+		},
+		ips: map[string][]string{
+			"mx.example.com":  {"198.51.100.7"},
+			"a-cidr.example":  {"203.0.113.9"},
+			"mx.cidr.example": {"192.0.2.200", "2001:db8::1"},
+		},
+		mx: map[string][]string{"example.com": {"mx.example.com"}, "mx-cidr.example": {"mx.cidr.example"}},
+	}
+	cases := []struct {
+		domain string
+		ip     string
+		want   spfResult
+	}{
+		{"example.com", "198.51.100.7", spfPass},
+		{"example.com", "192.0.2.55", spfPass},
+		{"example.com", "203.0.113.1", spfFail},
+		{"redirect.example", "192.0.2.1", spfPass},
+		{"nospf.example", "192.0.2.1", spfNone},
+		{"a-cidr.example", "203.0.113.77", spfPass},
+		{"a-cidr.example", "203.0.114.9", spfFail},
+		{"mx-cidr.example", "192.0.2.1", spfPass},
+		{"mx-cidr.example", "2001:db8::ffff", spfPass},
+		{"mx-cidr.example", "2001:db9::1", spfFail},
+	}
+	for _, tc := range cases {
+		lookups := 0
+		if got := evalSPF(context.Background(), r, tc.domain, net.ParseIP(tc.ip), &lookups); got != tc.want {
+			t.Errorf("evalSPF(%s, %s) = %s, want %s", tc.domain, tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestPreflightSenderDomain(t *testing.T) {
+	r := fakeResolver{
+		txt: map[string][]string{
+			"example.com":        {"v=spf1 ip4:198.51.100.0/24 -all"},
+			"_dmarc.example.com": {"v=DMARC1; p=reject; rua=mailto:d@example.com"},
+			"good.example":       {"v=spf1 include:_spf.google.com ~all"},
+			"gmail.com":          {"v=spf1 redirect=_spf.google.com"},
+		},
+		ips: map[string][]string{"smtp.gmail.com": {"192.0.2.10"}},
+	}
+
+	s := &Service{Config: &types.EmailConfig{From: "K1ABC <k1abc@example.com>", Host: "smtp.gmail.com"}, resolver: r}
+	adv := s.PreflightSenderDomain(context.Background())
+	var warnings []string
+	for _, a := range adv {
+		if a.Severity == AdvisoryWarning {
+			warnings = append(warnings, a.Message)
+		}
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "does not appear to authorize") || !strings.Contains(warnings[1], "p=reject") {
+		t.Fatalf("expected SPF and DMARC warnings, got %v", adv)
+	}
+
+	s.Config.From = "k1abc@good.example"
+	for _, a := range s.PreflightSenderDomain(context.Background()) {
+		if a.Severity == AdvisoryWarning {
+			t.Errorf("unexpected warning for relay listed in SPF: %s", a.Message)
+		}
+	}
+}
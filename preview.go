@@ -0,0 +1,118 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// Rendered is a template rendered with data.
+type Rendered struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// templateSamples is what PreviewTemplate renders the built-in templates with when the caller supplies none.
+var templateSamples = map[string]any{
+	TemplateADIFExport: ExportMeta{
+		QSOCount:  42,
+		FirstQSO:  time.Date(2024, 6, 22, 18, 0, 0, 0, time.UTC),
+		LastQSO:   time.Date(2024, 6, 23, 17, 59, 0, 0, time.UTC),
+		DateRange: "2024-06-22..2024-06-23",
+		Callsign:  "K1ABC",
+		Contest:   "ARRL-FD",
+		Filename:  "20240623175900-export.adi",
+	},
+	TemplateNotification: Notification{Category: CategoryAlert, Subject: "Rig disconnected", Body: "The CAT connection to the IC-7300 was lost at 18:04 UTC."},
+}
+
+// PreviewTemplate renders the current version of name with sampleData, or with built-in sample data when it is
+// nil, so the UI can show a live preview and surface template errors before a real event uses the template.
+func (s *Service) PreviewTemplate(name string, sampleData any) (Rendered, error) {
+	const op errors.Op = "email.Service.PreviewTemplate"
+	t, err := s.Template(name)
+	if err != nil {
+		return Rendered{}, errors.New(op).Err(err).Msg(err.Error())
+	}
+	if sampleData == nil {
+		sampleData = templateSamples[name]
+	}
+	return t.Render(sampleData)
+}
+
+// Render executes each part of t with data. A reference to a missing map key is an error rather than "<no value>".
+func (t Template) Render(data any) (Rendered, error) {
+	const op errors.Op = "email.Template.Render"
+	ct, err := t.compile()
+	if err != nil {
+		return Rendered{}, errors.New(op).Err(err).Msg(err.Error())
+	}
+	out, err := ct.render(data)
+	if err != nil {
+		return Rendered{}, errors.New(op).Err(err).Msg(err.Error())
+	}
+	return out, nil
+}
+
+// compiledTemplate is a Template parsed once, for rendering with many sets of data.
+type compiledTemplate struct {
+	name          string
+	subject, body *template.Template
+	html          *htmltemplate.Template
+}
+
+func (t Template) compile() (*compiledTemplate, error) {
+	ct := &compiledTemplate{name: t.Name}
+	var err error
+	if ct.subject, err = template.New("subject").Option("missingkey=error").Parse(t.Subject); err != nil {
+		return nil, fmt.Errorf("template %q subject: %w", t.Name, err)
+	}
+	if ct.body, err = template.New("body").Option("missingkey=error").Parse(t.Body); err != nil {
+		return nil, fmt.Errorf("template %q body: %w", t.Name, err)
+	}
+	if t.HTML != "" {
+		if ct.html, err = htmltemplate.New("html").Option("missingkey=error").Parse(t.HTML); err != nil {
+			return nil, fmt.Errorf("template %q HTML: %w", t.Name, err)
+		}
+	}
+	return ct, nil
+}
+
+// renderBuffers are reused across renderings, which mostly produce similar sizes.
+var renderBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+func (ct *compiledTemplate) render(data any) (Rendered, error) {
+	var out Rendered
+	var err error
+	if out.Subject, err = execute(ct.subject, data); err != nil {
+		return Rendered{}, fmt.Errorf("template %q subject: %w", ct.name, err)
+	}
+	if out.Text, err = execute(ct.body, data); err != nil {
+		return Rendered{}, fmt.Errorf("template %q body: %w", ct.name, err)
+	}
+	if ct.html != nil {
+		if out.HTML, err = execute(ct.html, data); err != nil {
+			return Rendered{}, fmt.Errorf("template %q HTML: %w", ct.name, err)
+		}
+	}
+	return out, nil
+}
+
+func execute(tmpl interface{ Execute(io.Writer, any) error }, data any) (string, error) {
+	buf := renderBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		renderBuffers.Put(buf)
+	}()
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,57 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestPreviewTemplate(t *testing.T) {
+	s := &Service{Templates: &FileTemplateStore{Dir: t.TempDir()}}
+
+	r, err := s.PreviewTemplate(TemplateADIFExport, nil)
+	if err != nil || r.Subject != "K1ABC log: 42 QSOs 2024-06-22..2024-06-23" {
+		t.Fatalf("default sample preview = %+v, %v", r, err)
+	}
+
+	if _, err = s.SaveTemplate(Template{Name: TemplateNotification, Subject: "[{{.Category}}] {{.Subject}}", Body: "{{.Body}}", HTML: "<p>{{.Body}}</p>"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err = s.PreviewTemplate(TemplateNotification, map[string]string{"Category": "alert", "Subject": "SWR high", "Body": "SWR 3:1 on <40m>"})
+	if err != nil || r.Subject != "[alert] SWR high" || r.HTML != "<p>SWR 3:1 on &lt;40m&gt;</p>" {
+		t.Fatalf("custom sample preview = %+v, %v", r, err)
+	}
+	if _, err = s.PreviewTemplate(TemplateNotification, map[string]string{"Subject": "x"}); err == nil || !strings.Contains(err.Error(), "Category") {
+		t.Fatalf("expected a missing-key error naming the field, got %v", err)
+	}
+	if _, err = (Template{Name: "x", Body: "{{.NoSuchField}}"}).Render(ExportMeta{}); err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+}
+
+func TestNotifyUsesNotificationTemplate(t *testing.T) {
+	s := &Service{
+		Config:    &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com", To: "op@example.com"},
+		Templates: &FileTemplateStore{Dir: t.TempDir()},
+	}
+	s.isInitialized.Store(true)
+	if _, err := s.SaveTemplate(Template{Name: TemplateNotification, Subject: "[SM {{.Category}}] {{.Subject}}", Body: "{{.Body}}\n-- Station Manager"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent = string(msg)
+		return deliveryInfo{}, nil
+	})
+
+	if err := s.Notify(t.Context(), Notification{Category: CategoryAlert, Subject: "Rig disconnected", Body: "CAT lost"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sent, "Subject: [SM alert] Rig disconnected") || !strings.Contains(sent, "-- Station Manager") {
+		t.Fatalf("notification template not applied:\n%s", sent)
+	}
+}
@@ -0,0 +1,214 @@
+package email
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPrewarmMaxIdle stays under the five-minute idle timeout RFC 5321 allows servers.
+const defaultPrewarmMaxIdle = 4 * time.Minute
+
+// defaultKeepAlive is how often an idle warm connection is checked with NOOP.
+const defaultKeepAlive = time.Minute
+
+// healthCheckPrewarm names the prewarm entry in Health.
+const healthCheckPrewarm = "smtp_prewarm"
+
+// PrewarmConfig keeps an authenticated connection open so the first send after startup skips the dial, TLS and
+// AUTH round trips.
+type PrewarmConfig struct {
+	// Interval re-establishes the warm connection on a schedule; zero warms once at Initialize.
+	Interval time.Duration
+	// MaxIdle is how long a warm connection is trusted since it was opened or last answered a NOOP. Defaults to
+	// 4 minutes.
+	MaxIdle time.Duration
+	// KeepAlive is how often the idle warm connection is sent a NOOP; one the server has dropped is re-dialled.
+	// Defaults to 1 minute; negative disables the check.
+	KeepAlive time.Duration
+}
+
+func (c *PrewarmConfig) keepAlive() time.Duration {
+	if c.KeepAlive == 0 {
+		return defaultKeepAlive
+	}
+	return c.KeepAlive
+}
+
+func (c *PrewarmConfig) maxIdle() time.Duration {
+	if c == nil || c.MaxIdle <= 0 {
+		return defaultPrewarmMaxIdle
+	}
+	return c.MaxIdle
+}
+
+// warmPool holds at most one prewarmed session, keyed by server address and username so that a reload to a
+// different account never reuses it.
+type warmPool struct {
+	mu   sync.Mutex
+	sess *smtpSession
+	key  string
+	at   time.Time
+	last HealthCheck
+}
+
+func (p *warmPool) put(key string, sess *smtpSession, now time.Time) {
+	p.mu.Lock()
+	old := p.sess
+	p.sess, p.key, p.at = sess, key, now
+	p.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+}
+
+// take hands over the warm session if it matches key and is younger than maxIdle. A stale session is closed.
+func (p *warmPool) take(key string, maxIdle time.Duration, now time.Time) *smtpSession {
+	p.mu.Lock()
+	sess, fresh := p.sess, p.key == key && now.Sub(p.at) < maxIdle
+	p.sess = nil
+	p.mu.Unlock()
+	if sess != nil && !fresh {
+		sess.close()
+		return nil
+	}
+	return sess
+}
+
+// ping sends a NOOP on the idle warm session, refreshing its age on success and closing it on failure. It reports
+// whether a session was held.
+func (p *warmPool) ping(timeout time.Duration) (bool, error) {
+	p.mu.Lock()
+	sess, key := p.sess, p.key
+	p.sess = nil
+	p.mu.Unlock()
+	if sess == nil {
+		return false, nil
+	}
+	if err := sess.noop(timeout); err != nil {
+		sess.close()
+		return true, err
+	}
+	p.mu.Lock()
+	if p.sess == nil {
+		p.sess, p.key, p.at = sess, key, time.Now()
+		sess = nil
+	}
+	p.mu.Unlock()
+	if sess != nil {
+		// A prewarm replaced it while the NOOP was in flight
+		sess.close()
+	}
+	return true, nil
+}
+
+func (p *warmPool) record(err error, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last = HealthCheck{Name: healthCheckPrewarm, OK: err == nil, CheckedAt: now}
+	if err != nil {
+		p.last.Error = err.Error()
+	}
+}
+
+func (p *warmPool) health() (HealthCheck, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.last, !p.last.CheckedAt.IsZero()
+}
+
+func (p *warmPool) close() {
+	p.put("", nil, time.Time{})
+}
+
+// startPrewarm warms a connection in the background so Initialize does not wait on the network.
+func (s *Service) startPrewarm() {
+	if s.Prewarm == nil || s.Transport != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopPrewarm = cancel
+	s.prewarmDone = make(chan struct{})
+	go func() {
+		defer close(s.prewarmDone)
+		s.prewarm(ctx)
+		var rewarm, ping <-chan time.Time
+		if s.Prewarm.Interval > 0 {
+			t := time.NewTicker(s.Prewarm.Interval)
+			defer t.Stop()
+			rewarm = t.C
+		}
+		if d := s.Prewarm.keepAlive(); d > 0 {
+			t := time.NewTicker(d)
+			defer t.Stop()
+			ping = t.C
+		}
+		if rewarm == nil && ping == nil {
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-rewarm:
+				s.prewarm(ctx)
+			case <-ping:
+				s.keepAlive(ctx)
+			}
+		}
+	}()
+}
+
+// prewarm opens and authenticates a session for the current config. Failures are only recorded for Health; the
+// next send dials as usual.
+func (s *Service) prewarm(ctx context.Context) {
+	cfg := s.config()
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+	host := strings.TrimSpace(cfg.Host)
+	username := strings.TrimSpace(cfg.Username)
+	addr := net.JoinHostPort(host, strconv.Itoa(cfg.Port))
+	auth, err := s.smtpAuth(ctx, username, strings.TrimSpace(cfg.Password), host)
+	if err == nil {
+		err = s.conns.acquire(ctx, s.MaxConnections)
+	}
+	if err == nil {
+		var sess *smtpSession
+		if sess, err = openSession(s.sessionContext(ctx, cfg), addr, auth); err == nil {
+			s.warm.put(warmKey(addr, username), sess, time.Now())
+		}
+		s.conns.release()
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	s.warm.record(err, time.Now())
+	if err != nil {
+		s.LoggerService.WarnWith().Err(err).Str("host", host).Msg("smtp connection prewarm failed")
+	}
+}
+
+// keepAlive checks the idle warm session and re-dials if the server has dropped it.
+func (s *Service) keepAlive(ctx context.Context) {
+	held, err := s.warm.ping(dialTimeout(s.config()))
+	if !held || err == nil {
+		return
+	}
+	s.LoggerService.InfoWith().Err(err).Msg("warm smtp connection dropped; re-dialling")
+	s.prewarm(ctx)
+}
+
+// noop checks the session is still alive, giving up after timeout.
+func (sess *smtpSession) noop(timeout time.Duration) error {
+	_ = sess.conn.SetDeadline(time.Now().Add(timeout))
+	defer func() { _ = sess.conn.SetDeadline(time.Time{}) }()
+	return sess.client.Noop()
+}
+
+func warmKey(addr, username string) string {
+	return addr + "\x00" + username
+}
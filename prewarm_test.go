@@ -0,0 +1,87 @@
+package email
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestPrewarmedSessionIsUsedBySend(t *testing.T) {
+	addr, seen := startPreESMTPServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	s := &Service{
+		Config:  &types.EmailConfig{Enabled: true, Host: host, Port: p, From: "op@example.com"},
+		Prewarm: &PrewarmConfig{},
+		RootCAs: fakeRoots(),
+	}
+
+	s.prewarm(t.Context())
+	checks := s.Health()
+	if len(checks) != 1 || !checks[0].OK {
+		t.Fatalf("expected a healthy prewarm check, got %+v", checks)
+	}
+	if got := strings.Join(seen(), " "); got != "EHLO HELO" {
+		t.Fatalf("unexpected prewarm commands %q", got)
+	}
+
+	sub := Submission{Config: *s.Config, From: "op@example.com", To: []string{"dx@example.org"}, Msg: []byte("Subject: hi\r\n\r\n73\r\n")}
+	if _, err := (smtpTransport{s: s}).submit(t.Context(), sub); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if got := strings.Join(seen(), " "); got != "EHLO HELO NOOP MAIL RCPT DATA QUIT" {
+		t.Fatalf("unexpected command sequence %q", got)
+	}
+}
+
+func TestPrewarmFailureIsReportedNotFatal(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	_ = ln.Close()
+	s := &Service{
+		Config:  &types.EmailConfig{Enabled: true, Host: "127.0.0.1", Port: addr.Port, From: "op@example.com"},
+		Prewarm: &PrewarmConfig{},
+	}
+
+	s.prewarm(t.Context())
+	checks := s.Health()
+	if len(checks) != 1 || checks[0].OK || checks[0].Error == "" || checks[0].Name != healthCheckPrewarm {
+		t.Fatalf("expected a failed prewarm check, got %+v", checks)
+	}
+	if sess := s.warm.take(warmKey(addr.String(), ""), defaultPrewarmMaxIdle, checks[0].CheckedAt); sess != nil {
+		t.Fatal("no session should be held after a failed prewarm")
+	}
+}
+
+func TestKeepAliveRedialsDroppedSession(t *testing.T) {
+	addr, seen := startPreESMTPServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	s := &Service{
+		Config:  &types.EmailConfig{Enabled: true, Host: host, Port: p, From: "op@example.com"},
+		Prewarm: &PrewarmConfig{},
+		RootCAs: fakeRoots(),
+	}
+
+	s.prewarm(t.Context())
+	s.keepAlive(t.Context())
+	if got := strings.Join(seen(), " "); got != "EHLO HELO NOOP" {
+		t.Fatalf("expected a NOOP on the live session, got %q", got)
+	}
+
+	dropped := s.warm.sess
+	_ = dropped.conn.Close()
+	s.keepAlive(t.Context())
+	if s.warm.sess == nil || s.warm.sess == dropped {
+		t.Fatal("expected the dropped session to be replaced")
+	}
+	if got := strings.Join(seen(), " "); got != "EHLO HELO NOOP EHLO HELO" {
+		t.Fatalf("unexpected command sequence %q", got)
+	}
+}
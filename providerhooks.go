@@ -0,0 +1,540 @@
+package email
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"hash"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+const (
+	// maxProviderWebhookBody bounds the request bodies accepted from mail providers.
+	maxProviderWebhookBody = 1 << 20
+	// providerWebhookMaxAge is how far a request's signed timestamp may be from the Clock before it is refused.
+	providerWebhookMaxAge = 5 * time.Minute
+)
+
+// snsHost matches the hosts SNS serves signing certificates from.
+var snsHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// WebhookProvider names a mail provider whose event webhooks ProviderWebhookHandler understands.
+type WebhookProvider string
+
+const (
+	ProviderSendGrid WebhookProvider = "sendgrid"
+	ProviderMailgun  WebhookProvider = "mailgun"
+	// ProviderSES accepts SES notifications delivered through an SNS HTTP(S) subscription.
+	ProviderSES WebhookProvider = "ses"
+)
+
+// ProviderWebhookConfig configures the handler for one provider.
+type ProviderWebhookConfig struct {
+	Provider WebhookProvider
+	// SigningKey verifies requests: Mailgun's HTTP webhook signing key, or SendGrid's base64 verification public key.
+	// Either provider refuses every request without it.
+	SigningKey string
+	// Token, when set, must match the "token" query parameter of every request. SES requires it: a validly signed SNS
+	// message may come from anyone's topic.
+	Token string
+	// Client fetches SNS signing certificates and confirms SNS subscriptions; nil uses http.DefaultClient.
+	Client *http.Client
+}
+
+// authenticated reports whether c carries the secret its provider needs to tell genuine requests from forged ones.
+func (c ProviderWebhookConfig) authenticated() bool {
+	switch c.Provider {
+	case ProviderSendGrid, ProviderMailgun:
+		return c.SigningKey != ""
+	case ProviderSES:
+		return c.Token != ""
+	}
+	return true
+}
+
+func (c ProviderWebhookConfig) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return http.DefaultClient
+}
+
+// ProviderWebhookHandler returns an http.Handler, to be mounted by the Station-Manager server, that accepts
+// delivered, bounced and complained events from an API transport's webhooks and records them like DSNs read from the
+// bounce mailbox. Requests must be signed, and dated within a few minutes of the Clock; a provider configured
+// without its signing key or token refuses them all.
+func (s *Service) ProviderWebhookHandler(cfg ProviderWebhookConfig) http.Handler {
+	const op errors.Op = "email.Service.ProviderWebhookHandler"
+	replays := &replayGuard{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !cfg.authenticated() {
+			s.LoggerService.ErrorWith().Str("provider", string(cfg.Provider)).Msg("provider webhook has no signing key or token; refusing request")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if cfg.Token != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(cfg.Token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxProviderWebhookBody+1))
+		if err != nil || len(body) > maxProviderWebhookBody {
+			http.Error(w, "unreadable body", http.StatusBadRequest)
+			return
+		}
+
+		var statuses []DeliveryStatus
+		switch cfg.Provider {
+		case ProviderSendGrid:
+			if err = s.verifySendGrid(cfg.SigningKey, r.Header, body, replays); err != nil {
+				s.LoggerService.WarnWith().Err(err).Msg("rejected sendgrid webhook")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			statuses, err = parseSendGridEvents(body)
+		case ProviderMailgun:
+			statuses, err = s.parseMailgunEvent(cfg.SigningKey, body, replays)
+		case ProviderSES:
+			statuses, err = s.parseSESNotification(r.Context(), cfg.client(), body, replays)
+		default:
+			err = errors.New(op).Msgf("unsupported provider %q", cfg.Provider)
+		}
+		if err != nil {
+			s.LoggerService.WarnWith().Err(err).Str("provider", string(cfg.Provider)).Msg("rejected provider webhook")
+			http.Error(w, "invalid event", http.StatusBadRequest)
+			return
+		}
+		s.recordDeliveryStatus(statuses)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// replayGuard refuses signed webhook requests dated outside providerWebhookMaxAge of the Clock, and those whose
+// signature was already accepted within it.
+type replayGuard struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// check records key, signed at at, failing if at is stale or key was seen before.
+func (g *replayGuard) check(key string, at, now time.Time) error {
+	const op errors.Op = "email.replayGuard.check"
+	if age := now.Sub(at); age > providerWebhookMaxAge || age < -providerWebhookMaxAge {
+		return errors.New(op).Msgf("request dated %s is outside the accepted window", at.UTC().Format(time.RFC3339))
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for k, t := range g.seen {
+		// A request this old fails the window check; its key need not be kept
+		if now.Sub(t) > providerWebhookMaxAge {
+			delete(g.seen, k)
+		}
+	}
+	if _, ok := g.seen[key]; ok {
+		return errors.New(op).Msg("request replayed")
+	}
+	if g.seen == nil {
+		g.seen = map[string]time.Time{}
+	}
+	g.seen[key] = at
+	return nil
+}
+
+// unixTime parses a timestamp given in seconds since the epoch.
+func unixTime(ts string) (time.Time, error) {
+	sec, err := strconv.ParseInt(strings.TrimSpace(ts), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// verifySendGrid checks SendGrid's signed event webhook: an ECDSA signature over the timestamp followed by the body,
+// with the timestamp recent and the signature not seen before.
+func (s *Service) verifySendGrid(publicKey string, hdr http.Header, body []byte, replays *replayGuard) error {
+	const op errors.Op = "email.Service.verifySendGrid"
+	der, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("invalid verification key")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("invalid verification key")
+	}
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New(op).Msg("verification key is not an ECDSA key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(hdr.Get("X-Twilio-Email-Event-Webhook-Signature"))
+	if err != nil || len(sig) == 0 {
+		return errors.New(op).Msg("missing or malformed signature")
+	}
+	ts := hdr.Get("X-Twilio-Email-Event-Webhook-Timestamp")
+	h := sha256.New()
+	h.Write([]byte(ts))
+	h.Write(body)
+	if !ecdsa.VerifyASN1(key, h.Sum(nil), sig) {
+		return errors.New(op).Msg("signature mismatch")
+	}
+	at, err := unixTime(ts)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("malformed timestamp")
+	}
+	if err = replays.check(string(sig), at, s.now()); err != nil {
+		return errors.New(op).Err(err).Msg("stale or replayed request")
+	}
+	return nil
+}
+
+type sendGridEvent struct {
+	Email     string `json:"email"`
+	Timestamp int64  `json:"timestamp"`
+	SMTPID    string `json:"smtp-id"`
+	Event     string `json:"event"`
+	Reason    string `json:"reason"`
+	Status    string `json:"status"`
+	Response  string `json:"response"`
+}
+
+func parseSendGridEvents(body []byte) ([]DeliveryStatus, error) {
+	const op errors.Op = "email.parseSendGridEvents"
+	var events []sendGridEvent
+	if err := json.Unmarshal(body, &events); err != nil {
+		return nil, errors.New(op).Err(err).Msg("malformed event batch")
+	}
+	var out []DeliveryStatus
+	for _, ev := range events {
+		ds := DeliveryStatus{MessageID: angleID(ev.SMTPID), Recipient: ev.Email, Status: ev.Status, ReportedAt: time.Unix(ev.Timestamp, 0).UTC()}
+		switch ev.Event {
+		case "delivered":
+			ds.State, ds.Diagnostic = DeliveryStateDelivered, ev.Response
+		case "deferred":
+			ds.State, ds.Diagnostic = DeliveryStateDelayed, ev.Response
+		case "bounce", "dropped":
+			ds.State, ds.Diagnostic = DeliveryStateBounced, ev.Reason
+		case "spamreport":
+			ds.State = DeliveryStateComplained
+		default:
+			continue
+		}
+		out = append(out, ds)
+	}
+	return out, nil
+}
+
+type mailgunEvent struct {
+	Signature struct {
+		Timestamp string `json:"timestamp"`
+		Token     string `json:"token"`
+		Signature string `json:"signature"`
+	} `json:"signature"`
+	EventData struct {
+		Event          string  `json:"event"`
+		Timestamp      float64 `json:"timestamp"`
+		Recipient      string  `json:"recipient"`
+		Severity       string  `json:"severity"`
+		Reason         string  `json:"reason"`
+		DeliveryStatus struct {
+			Code        int    `json:"code"`
+			Message     string `json:"message"`
+			Description string `json:"description"`
+		} `json:"delivery-status"`
+		Message struct {
+			Headers struct {
+				MessageID string `json:"message-id"`
+			} `json:"headers"`
+		} `json:"message"`
+	} `json:"event-data"`
+}
+
+// parseMailgunEvent verifies the HMAC Mailgun embeds in the payload, and that its timestamp is recent and its token
+// unused, and maps the event.
+func (s *Service) parseMailgunEvent(signingKey string, body []byte, replays *replayGuard) ([]DeliveryStatus, error) {
+	const op errors.Op = "email.Service.parseMailgunEvent"
+	var ev mailgunEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return nil, errors.New(op).Err(err).Msg("malformed event")
+	}
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte(ev.Signature.Timestamp + ev.Signature.Token))
+	want := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(want), []byte(strings.ToLower(ev.Signature.Signature))) {
+		return nil, errors.New(op).Msg("signature mismatch")
+	}
+	at, err := unixTime(ev.Signature.Timestamp)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("malformed timestamp")
+	}
+	if err = replays.check(ev.Signature.Token, at, s.now()); err != nil {
+		return nil, errors.New(op).Err(err).Msg("stale or replayed event")
+	}
+	data := ev.EventData
+	sec, frac := math.Modf(data.Timestamp)
+	ds := DeliveryStatus{
+		MessageID:  angleID(data.Message.Headers.MessageID),
+		Recipient:  data.Recipient,
+		ReportedAt: time.Unix(int64(sec), int64(frac*1e9)).UTC(),
+	}
+	if data.DeliveryStatus.Code != 0 {
+		ds.Status = strconv.Itoa(data.DeliveryStatus.Code)
+	}
+	ds.Diagnostic = data.DeliveryStatus.Description
+	if ds.Diagnostic == "" {
+		ds.Diagnostic = data.DeliveryStatus.Message
+	}
+	if ds.Diagnostic == "" {
+		ds.Diagnostic = data.Reason
+	}
+	switch data.Event {
+	case "delivered":
+		ds.State = DeliveryStateDelivered
+	case "failed":
+		ds.State = DeliveryStateDelayed
+		if data.Severity == "permanent" {
+			ds.State = DeliveryStateBounced
+		}
+	case "complained":
+		ds.State = DeliveryStateComplained
+	default:
+		return nil, nil
+	}
+	return []DeliveryStatus{ds}, nil
+}
+
+type snsEnvelope struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	SubscribeURL     string `json:"SubscribeURL"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+}
+
+// stringToSign returns the canonical form of e that SNS signs.
+func (e snsEnvelope) stringToSign() string {
+	var b strings.Builder
+	field := func(name, value string) {
+		b.WriteString(name + "\n" + value + "\n")
+	}
+	field("Message", e.Message)
+	field("MessageId", e.MessageID)
+	if e.Type == "Notification" {
+		if e.Subject != "" {
+			field("Subject", e.Subject)
+		}
+	} else {
+		field("SubscribeURL", e.SubscribeURL)
+	}
+	field("Timestamp", e.Timestamp)
+	if e.Type != "Notification" {
+		field("Token", e.Token)
+	}
+	field("TopicArn", e.TopicArn)
+	field("Type", e.Type)
+	return b.String()
+}
+
+// verifySNS checks the signature of e against the certificate at its SigningCertURL, which SNS must serve over https.
+func verifySNS(ctx context.Context, client *http.Client, e snsEnvelope) error {
+	const op errors.Op = "email.verifySNS"
+	u, err := url.Parse(e.SigningCertURL)
+	if err != nil || u.Scheme != "https" || !snsHost.MatchString(strings.ToLower(u.Hostname())) || !strings.HasSuffix(u.Path, ".pem") {
+		return errors.New(op).Msgf("refusing signing certificate url %q", e.SigningCertURL)
+	}
+	var h hash.Hash
+	var alg crypto.Hash
+	switch e.SignatureVersion {
+	case "1":
+		h, alg = sha1.New(), crypto.SHA1
+	case "2":
+		h, alg = sha256.New(), crypto.SHA256
+	default:
+		return errors.New(op).Msgf("unsupported signature version %q", e.SignatureVersion)
+	}
+	sig, err := base64.StdEncoding.DecodeString(e.Signature)
+	if err != nil || len(sig) == 0 {
+		return errors.New(op).Msg("missing or malformed signature")
+	}
+	certPEM, err := snsGet(ctx, client, e.SigningCertURL)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to fetch signing certificate")
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return errors.New(op).Msg("signing certificate is not PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("invalid signing certificate")
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New(op).Msg("signing certificate does not hold an RSA key")
+	}
+	h.Write([]byte(e.stringToSign()))
+	if err = rsa.VerifyPKCS1v15(key, alg, h.Sum(nil), sig); err != nil {
+		return errors.New(op).Err(err).Msg("signature mismatch")
+	}
+	return nil
+}
+
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"`
+	Mail             struct {
+		CommonHeaders struct {
+			MessageID string `json:"messageId"`
+		} `json:"commonHeaders"`
+	} `json:"mail"`
+	Bounce struct {
+		BounceType        string    `json:"bounceType"`
+		Timestamp         time.Time `json:"timestamp"`
+		BouncedRecipients []struct {
+			EmailAddress   string `json:"emailAddress"`
+			Status         string `json:"status"`
+			DiagnosticCode string `json:"diagnosticCode"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint struct {
+		Timestamp            time.Time `json:"timestamp"`
+		ComplainedRecipients []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+	Delivery struct {
+		Timestamp    time.Time `json:"timestamp"`
+		Recipients   []string  `json:"recipients"`
+		SMTPResponse string    `json:"smtpResponse"`
+	} `json:"delivery"`
+}
+
+// parseSESNotification verifies and unwraps an SNS message. Subscription confirmations are answered by visiting the
+// SubscribeURL, which must be an https amazonaws.com address.
+func (s *Service) parseSESNotification(ctx context.Context, client *http.Client, body []byte, replays *replayGuard) ([]DeliveryStatus, error) {
+	const op errors.Op = "email.Service.parseSESNotification"
+	var env snsEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, errors.New(op).Err(err).Msg("malformed sns message")
+	}
+	if err := verifySNS(ctx, client, env); err != nil {
+		return nil, errors.New(op).Err(err).Msg("unverified sns message")
+	}
+	at, err := time.Parse(time.RFC3339, env.Timestamp)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("malformed timestamp")
+	}
+	if err = replays.check(env.MessageID, at, s.now()); err != nil {
+		return nil, errors.New(op).Err(err).Msg("stale or replayed sns message")
+	}
+	switch env.Type {
+	case "SubscriptionConfirmation":
+		return nil, s.confirmSNSSubscription(ctx, client, env.SubscribeURL)
+	case "Notification":
+	default:
+		return nil, nil
+	}
+	var n sesNotification
+	if err := json.Unmarshal([]byte(env.Message), &n); err != nil {
+		return nil, errors.New(op).Err(err).Msg("malformed ses notification")
+	}
+	kind := n.NotificationType
+	if kind == "" {
+		kind = n.EventType
+	}
+	mid := angleID(n.Mail.CommonHeaders.MessageID)
+	var out []DeliveryStatus
+	switch kind {
+	case "Delivery":
+		for _, rcpt := range n.Delivery.Recipients {
+			out = append(out, DeliveryStatus{MessageID: mid, Recipient: rcpt, State: DeliveryStateDelivered, Diagnostic: n.Delivery.SMTPResponse, ReportedAt: n.Delivery.Timestamp})
+		}
+	case "Bounce":
+		state := DeliveryStateDelayed
+		if n.Bounce.BounceType == "Permanent" {
+			state = DeliveryStateBounced
+		}
+		for _, rcpt := range n.Bounce.BouncedRecipients {
+			out = append(out, DeliveryStatus{MessageID: mid, Recipient: rcpt.EmailAddress, State: state, Status: rcpt.Status, Diagnostic: rcpt.DiagnosticCode, ReportedAt: n.Bounce.Timestamp})
+		}
+	case "Complaint":
+		for _, rcpt := range n.Complaint.ComplainedRecipients {
+			out = append(out, DeliveryStatus{MessageID: mid, Recipient: rcpt.EmailAddress, State: DeliveryStateComplained, ReportedAt: n.Complaint.Timestamp})
+		}
+	}
+	return out, nil
+}
+
+// snsGet fetches rawURL with client, returning at most maxProviderWebhookBody bytes of a successful response.
+func snsGet(ctx context.Context, client *http.Client, rawURL string) ([]byte, error) {
+	const op errors.Op = "email.snsGet"
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to create sns request")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("sns request failed")
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, errors.New(op).Msgf("sns returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProviderWebhookBody))
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to read sns response")
+	}
+	return body, nil
+}
+
+func (s *Service) confirmSNSSubscription(ctx context.Context, client *http.Client, rawURL string) error {
+	const op errors.Op = "email.Service.confirmSNSSubscription"
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || !strings.HasSuffix(strings.ToLower(u.Hostname()), ".amazonaws.com") {
+		return errors.New(op).Msgf("refusing subscription url %q", rawURL)
+	}
+	if _, err = snsGet(ctx, client, rawURL); err != nil {
+		return errors.New(op).Err(err).Msg("failed to confirm sns subscription")
+	}
+	s.LoggerService.InfoWith().Str("host", u.Hostname()).Msg("confirmed sns subscription")
+	return nil
+}
+
+// angleID normalises a Message-ID reported without its angle brackets.
+func angleID(id string) string {
+	id = strings.TrimSpace(id)
+	if id == "" || strings.HasPrefix(id, "<") {
+		return id
+	}
+	return "<" + id + ">"
+}
@@ -0,0 +1,221 @@
+package email
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postWebhook(h http.Handler, target, body string, hdr map[string]string) int {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// roundTripFunc serves a test's HTTP client requests without a network.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestProviderWebhookRefusesUnauthenticatedConfig(t *testing.T) {
+	s := &Service{}
+	for _, p := range []WebhookProvider{ProviderSendGrid, ProviderMailgun, ProviderSES} {
+		h := s.ProviderWebhookHandler(ProviderWebhookConfig{Provider: p})
+		if code := postWebhook(h, "/hooks/"+string(p), "{}", nil); code != http.StatusUnauthorized {
+			t.Errorf("%s without a signing key or token answered %d", p, code)
+		}
+	}
+}
+
+func TestProviderWebhookSendGrid(t *testing.T) {
+	now := time.Unix(1700000300, 0)
+	s := &Service{Clock: ClockFunc(func() time.Time { return now })}
+	s.history.add(HistoryRecord{MessageID: "<sg-1@example.com>", State: DeliveryStateSent})
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	h := s.ProviderWebhookHandler(ProviderWebhookConfig{Provider: ProviderSendGrid, SigningKey: base64.StdEncoding.EncodeToString(der)})
+
+	body := `[{"email":"op@example.net","timestamp":1700000000,"smtp-id":"<sg-1@example.com>","event":"delivered","response":"250 OK"},` +
+		`{"email":"op@example.net","timestamp":1700000100,"smtp-id":"<sg-1@example.com>","event":"spamreport"},` +
+		`{"email":"op@example.net","timestamp":1700000200,"event":"open"}]`
+	signed := func(ts, body string) map[string]string {
+		digest := sha256.Sum256([]byte(ts + body))
+		sig, _ := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+		return map[string]string{
+			"X-Twilio-Email-Event-Webhook-Signature": base64.StdEncoding.EncodeToString(sig),
+			"X-Twilio-Email-Event-Webhook-Timestamp": ts,
+		}
+	}
+	hdr := signed("1700000300", body)
+
+	if code := postWebhook(h, "/hooks/sendgrid", body+" ", hdr); code != http.StatusUnauthorized {
+		t.Fatalf("tampered body accepted with status %d", code)
+	}
+	if code := postWebhook(h, "/hooks/sendgrid", body, signed("1699990000", body)); code != http.StatusUnauthorized {
+		t.Fatalf("stale request accepted with status %d", code)
+	}
+	if code := postWebhook(h, "/hooks/sendgrid", body, hdr); code != http.StatusNoContent {
+		t.Fatalf("signed batch rejected with status %d", code)
+	}
+	if code := postWebhook(h, "/hooks/sendgrid", body, hdr); code != http.StatusUnauthorized {
+		t.Fatalf("replayed batch accepted with status %d", code)
+	}
+	rec := s.History()[0]
+	if rec.State != DeliveryStateComplained || len(rec.Deliveries) != 2 {
+		t.Fatalf("expected delivered then complained, got %q with %d reports", rec.State, len(rec.Deliveries))
+	}
+}
+
+func TestProviderWebhookMailgun(t *testing.T) {
+	now := time.Unix(1700000060, 0)
+	s := &Service{Clock: ClockFunc(func() time.Time { return now })}
+	s.history.add(HistoryRecord{MessageID: "<mg-1@example.com>", State: DeliveryStateSent})
+	h := s.ProviderWebhookHandler(ProviderWebhookConfig{Provider: ProviderMailgun, SigningKey: "key-123"})
+
+	mac := hmac.New(sha256.New, []byte("key-123"))
+	mac.Write([]byte("1700000000" + "tok"))
+	payload := func(sig string) string {
+		return `{"signature":{"timestamp":"1700000000","token":"tok","signature":"` + sig + `"},` +
+			`"event-data":{"event":"failed","severity":"permanent","timestamp":1700000000.5,"recipient":"nobody@example.net",` +
+			`"delivery-status":{"code":550,"description":"mailbox unavailable"},"message":{"headers":{"message-id":"mg-1@example.com"}}}}`
+	}
+	if code := postWebhook(h, "/hooks/mailgun", payload("00"), nil); code != http.StatusBadRequest {
+		t.Fatalf("bad signature accepted with status %d", code)
+	}
+	if code := postWebhook(h, "/hooks/mailgun", payload(hex.EncodeToString(mac.Sum(nil))), nil); code != http.StatusNoContent {
+		t.Fatalf("signed event rejected with status %d", code)
+	}
+	if code := postWebhook(h, "/hooks/mailgun", payload(hex.EncodeToString(mac.Sum(nil))), nil); code != http.StatusBadRequest {
+		t.Fatalf("replayed event accepted with status %d", code)
+	}
+	rec := s.History()[0]
+	if rec.State != DeliveryStateBounced || rec.Deliveries[0].Status != "550" || rec.Deliveries[0].Diagnostic != "mailbox unavailable" {
+		t.Fatalf("unexpected history record %+v", rec)
+	}
+
+	// An event signed long ago is refused even with a fresh token
+	now = now.Add(time.Hour)
+	mac = hmac.New(sha256.New, []byte("key-123"))
+	mac.Write([]byte("1700000000" + "tok2"))
+	stale := strings.Replace(payload(hex.EncodeToString(mac.Sum(nil))), `"token":"tok"`, `"token":"tok2"`, 1)
+	if code := postWebhook(h, "/hooks/mailgun", stale, nil); code != http.StatusBadRequest {
+		t.Fatalf("stale event accepted with status %d", code)
+	}
+}
+
+// snsSigner signs SNS messages with a self-signed certificate served at certURL by its client.
+type snsSigner struct {
+	key     *rsa.PrivateKey
+	certURL string
+	certPEM []byte
+}
+
+func newSNSSigner(t *testing.T) *snsSigner {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &snsSigner{key: key, certURL: "https://sns.eu-west-1.amazonaws.com/SimpleNotificationService-test.pem",
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (g *snsSigner) sign(e snsEnvelope) string {
+	e.SignatureVersion, e.SigningCertURL = "2", g.certURL
+	digest := sha256.Sum256([]byte(e.stringToSign()))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, g.key, crypto.SHA256, digest[:])
+	e.Signature = base64.StdEncoding.EncodeToString(sig)
+	out, _ := json.Marshal(e)
+	return string(out)
+}
+
+func TestProviderWebhookSES(t *testing.T) {
+	now := time.Date(2024, 6, 22, 10, 1, 0, 0, time.UTC)
+	s := &Service{Clock: ClockFunc(func() time.Time { return now })}
+	s.history.add(HistoryRecord{MessageID: "<ses-1@example.com>", To: []string{"op@example.net"}, State: DeliveryStateSent})
+
+	signer := newSNSSigner(t)
+	var confirmed string
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body := ""
+		if r.URL.String() == signer.certURL {
+			body = string(signer.certPEM)
+		} else {
+			confirmed = r.URL.String()
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: r}, nil
+	})}
+	h := s.ProviderWebhookHandler(ProviderWebhookConfig{Provider: ProviderSES, Token: "t0k", Client: client})
+
+	ts := "2024-06-22T10:00:30Z"
+	sub := signer.sign(snsEnvelope{Type: "SubscriptionConfirmation", MessageID: "sub-1", Token: "confirm-token", Timestamp: ts,
+		TopicArn: "arn:aws:sns:eu-west-1:123456789012:ses", SubscribeURL: "https://sns.eu-west-1.amazonaws.com/?Action=ConfirmSubscription"})
+	if code := postWebhook(h, "/hooks/ses", sub, nil); code != http.StatusUnauthorized {
+		t.Fatalf("request without token accepted with status %d", code)
+	}
+	if code := postWebhook(h, "/hooks/ses?token=t0k", sub, nil); code != http.StatusNoContent || !strings.HasPrefix(confirmed, "https://sns.") {
+		t.Fatalf("subscription not confirmed: status %d, url %q", code, confirmed)
+	}
+	evil := signer.sign(snsEnvelope{Type: "SubscriptionConfirmation", MessageID: "sub-2", Timestamp: ts, SubscribeURL: "http://169.254.169.254/latest"})
+	if code := postWebhook(h, "/hooks/ses?token=t0k", evil, nil); code != http.StatusBadRequest {
+		t.Fatalf("non-SNS subscription url accepted with status %d", code)
+	}
+
+	msg, _ := json.Marshal(map[string]any{
+		"notificationType": "Complaint",
+		"mail":             map[string]any{"commonHeaders": map[string]any{"messageId": "<ses-1@example.com>"}},
+		"complaint":        map[string]any{"timestamp": "2024-06-22T10:00:00Z", "complainedRecipients": []map[string]string{{"emailAddress": "op@example.net"}}},
+	})
+	note := snsEnvelope{Type: "Notification", MessageID: "note-1", Timestamp: ts, TopicArn: "arn:aws:sns:eu-west-1:123456789012:ses", Message: string(msg)}
+	unsigned, _ := json.Marshal(note)
+	if code := postWebhook(h, "/hooks/ses?token=t0k", string(unsigned), nil); code != http.StatusBadRequest {
+		t.Fatalf("unsigned notification accepted with status %d", code)
+	}
+	forged := signer.sign(note)
+	forged = strings.Replace(forged, "note-1", "note-2", 1)
+	if code := postWebhook(h, "/hooks/ses?token=t0k", forged, nil); code != http.StatusBadRequest {
+		t.Fatalf("tampered notification accepted with status %d", code)
+	}
+	if code := postWebhook(h, "/hooks/ses?token=t0k", signer.sign(note), nil); code != http.StatusNoContent {
+		t.Fatalf("notification rejected with status %d", code)
+	}
+	if code := postWebhook(h, "/hooks/ses?token=t0k", signer.sign(note), nil); code != http.StatusBadRequest {
+		t.Fatalf("replayed notification accepted with status %d", code)
+	}
+	if rec := s.History()[0]; rec.State != DeliveryStateComplained || rec.Deliveries[0].Recipient != "op@example.net" {
+		t.Fatalf("unexpected history record %+v", rec)
+	}
+}
@@ -0,0 +1,573 @@
+package email
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGreylistDelay    = 5 * time.Minute
+	defaultQueueMaxAttempts = 10
+	queueBaseBackoff        = time.Minute
+	queueMaxBackoff         = time.Hour
+	// queuePollInterval bounds how long a due message can wait when no wake-up signal arrives
+	queuePollInterval = 30 * time.Second
+)
+
+// QueueConfig tunes the outbound queue. The zero value uses the defaults.
+type QueueConfig struct {
+	// GreylistDelay is how long to wait before retrying a greylisted message; defaults to five minutes.
+	GreylistDelay time.Duration
+	// MaxAttempts bounds the attempts made for a message, foreground and queued combined; defaults to 10.
+	MaxAttempts int
+	// HandOffTransient queues a message after its first transient failure rather than retrying in the caller.
+	HandOffTransient bool
+	// DefaultTTL applies to messages without their own MsgDef.TTL; zero keeps them until MaxAttempts is reached.
+	DefaultTTL time.Duration
+	// MaxDepth is the queue depth at which new sends are refused with a BackpressureError; zero is unbounded.
+	MaxDepth int
+	// MemoryBudget caps the bytes of queued message bodies held in memory; bodies beyond it are written to SpillDir
+	// and read back when their attempt is due. Zero keeps every body in memory.
+	MemoryBudget int64
+	// SpillDir holds spilled bodies, unencrypted and readable only by the owner; defaults to a directory under
+	// os.TempDir.
+	SpillDir string
+	// BlockWhenFull makes sends wait, bounded by their context, for room in a full queue instead of failing.
+	BlockWhenFull bool
+	// AdaptiveRetry times queued retries from each SMTP host's history of outages instead of the static backoff,
+	// once the host has recovered from one. Service.RetryProfiles shows what has been learned.
+	AdaptiveRetry bool
+	// UnconfirmedDelay is how long to wait before resending a message whose connection dropped before the server
+	// replied to it, for a delivery report showing it was accepted to arrive; defaults to 15 minutes.
+	UnconfirmedDelay time.Duration
+	// SpoolOffline queues a message whose SMTP host cannot be reached, as in portable operation without internet,
+	// instead of failing it. It is tried every ProbeInterval without spending its attempts, and every spooled
+	// message is flushed once the host answers again. Set a QueueStore for the spool to survive a restart.
+	SpoolOffline bool
+	// ProbeInterval is how often spooled messages are tried while their host is unreachable; defaults to a minute.
+	ProbeInterval time.Duration
+}
+
+// Priority classes order queued messages when several are due at once; the zero value is PriorityNormal.
+type Priority int
+
+const (
+	PriorityBulk   Priority = -1
+	PriorityNormal Priority = 0
+	PriorityUrgent Priority = 1
+)
+
+// SendStatus reports the outcome of SendWithResult.
+type SendStatus string
+
+const (
+	SendStatusSent   SendStatus = "sent"
+	SendStatusQueued SendStatus = "queued"
+	SendStatusFailed SendStatus = "failed"
+)
+
+// SendResult describes the outcome of a successful SendWithResult; QueueID is set when the message was queued.
+type SendResult struct {
+	Status SendStatus
+	// Reason is ReasonOK, ReasonQueued or the Reason of the failure; ReasonDisabled when the service is disabled.
+	Reason    Reason
+	MessageID string
+	QueueID   string
+	// Recipients reports each recipient's outcome. It is also returned with the error of a send that failed after
+	// some recipients were sent the message, which happens when they are split across several SMTP transactions.
+	Recipients []RecipientResult
+}
+
+// RecipientResult is the outcome of a send for one recipient.
+type RecipientResult struct {
+	Address string
+	Status  SendStatus
+	Reason  Reason
+	Error   string
+}
+
+func (c QueueConfig) greylistDelay() time.Duration {
+	if c.GreylistDelay > 0 {
+		return c.GreylistDelay
+	}
+	return defaultGreylistDelay
+}
+
+func (c QueueConfig) maxAttempts() int {
+	if c.MaxAttempts > 0 {
+		return c.MaxAttempts
+	}
+	return defaultQueueMaxAttempts
+}
+
+// QueuedMessage is a message awaiting a deferred delivery attempt.
+type QueuedMessage struct {
+	ID        string
+	MessageID string
+	Msg       MsgDef
+	// Delivered lists the recipients an earlier attempt already delivered to; Msg.To holds only the rest.
+	Delivered   []string
+	TraceID     string
+	Attempts    int
+	EnqueuedAt  time.Time
+	NextAttempt time.Time
+	// ExpiresAt is when the message is moved to the dead-letter queue undelivered; zero means never.
+	ExpiresAt time.Time
+	LastError string
+	// Unconfirmed is set when an attempt may have been accepted despite failing; the message is dropped instead of
+	// resent if a delivery report for its Message-ID arrives.
+	Unconfirmed bool
+	// spilled is set while the body is on disk rather than in Msg.Msg
+	spilled bool
+	// due and expires are NextAttempt and ExpiresAt on the monotonic clock, which the schedule runs on so that
+	// setting the wall clock, for DST or a GPS fix, neither fires every retry at once nor holds them back
+	due     time.Time
+	expires time.Time
+}
+
+// isDue reports whether m's next attempt is due at now, a reading of time.Now.
+func (m *QueuedMessage) isDue(now time.Time) bool {
+	if !m.due.IsZero() {
+		return !m.due.After(now)
+	}
+	return !m.NextAttempt.After(now)
+}
+
+func (m *QueuedMessage) expired(now time.Time) bool {
+	if !m.expires.IsZero() {
+		return !now.Before(m.expires)
+	}
+	return !m.ExpiresAt.IsZero() && !now.Before(m.ExpiresAt)
+}
+
+// nextEvent returns when m is next due or expires, whichever is sooner.
+func (m *QueuedMessage) nextEvent() time.Time {
+	at, exp := m.NextAttempt, m.ExpiresAt
+	if !m.due.IsZero() {
+		at = m.due
+	}
+	if !m.expires.IsZero() {
+		exp = m.expires
+	}
+	if !exp.IsZero() && exp.Before(at) {
+		return exp
+	}
+	return at
+}
+
+type outboundQueue struct {
+	mu    sync.Mutex
+	items []*QueuedMessage
+	wake  chan struct{}
+	// shrunk is closed when a message leaves the queue
+	shrunk chan struct{}
+	// bodyBytes is the size of the queued bodies held in memory
+	bodyBytes int64
+}
+
+func (q *outboundQueue) push(m *QueuedMessage) {
+	q.mu.Lock()
+	q.items = append(q.items, m)
+	q.bodyBytes += int64(len(m.Msg.Msg))
+	if q.wake == nil {
+		q.wake = make(chan struct{}, 1)
+	}
+	wake := q.wake
+	q.mu.Unlock()
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// popDue removes and returns the messages due or expired at now, highest priority first and oldest first within a
+// priority.
+func (q *outboundQueue) popDue(now time.Time) []*QueuedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var due []*QueuedMessage
+	kept := q.items[:0]
+	for _, m := range q.items {
+		if m.isDue(now) || m.expired(now) {
+			due = append(due, m)
+			q.bodyBytes -= int64(len(m.Msg.Msg))
+			continue
+		}
+		kept = append(kept, m)
+	}
+	q.items = kept
+	if len(due) > 0 {
+		q.signalShrunk()
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		if due[i].Msg.Priority != due[j].Msg.Priority {
+			return due[i].Msg.Priority > due[j].Msg.Priority
+		}
+		return due[i].EnqueuedAt.Before(due[j].EnqueuedAt)
+	})
+	return due
+}
+
+// nextDue returns the earliest scheduled attempt, or false when the queue is empty.
+func (q *outboundQueue) nextDue() (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var next time.Time
+	for i, m := range q.items {
+		at := m.nextEvent()
+		if i == 0 || at.Before(next) {
+			next = at
+		}
+	}
+	return next, len(q.items) > 0
+}
+
+func (q *outboundQueue) wakeChan() chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.wake == nil {
+		q.wake = make(chan struct{}, 1)
+	}
+	return q.wake
+}
+
+// remove drops and returns the message whose queue ID or Message-ID equals id.
+func (q *outboundQueue) remove(id string) *QueuedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, m := range q.items {
+		if m.ID == id || m.MessageID == id {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			q.bodyBytes -= int64(len(m.Msg.Msg))
+			q.signalShrunk()
+			return m
+		}
+	}
+	return nil
+}
+
+// makeDue brings every retry forward to now, a reading of time.Now; wall is the same moment by the Clock.
+func (q *outboundQueue) makeDue(now, wall time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, m := range q.items {
+		if !m.isDue(now) {
+			m.due, m.NextAttempt = now, wall
+		}
+	}
+}
+
+// shift moves the wall-clock schedule of every queued message by d, keeping what Queued shows and the QueueStore
+// holds in line with a clock that jumped. When attempts are made follows the monotonic clock regardless.
+func (q *outboundQueue) shift(d time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, m := range q.items {
+		m.NextAttempt = m.NextAttempt.Add(d)
+		if !m.ExpiresAt.IsZero() {
+			m.ExpiresAt = m.ExpiresAt.Add(d)
+		}
+	}
+}
+
+func (q *outboundQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// resident returns the size of the queued bodies held in memory.
+func (q *outboundQueue) resident() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.bodyBytes
+}
+
+func (q *outboundQueue) snapshot() []QueuedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]QueuedMessage, len(q.items))
+	for i, m := range q.items {
+		out[i] = *m
+		out[i].Msg.To = append([]string(nil), m.Msg.To...)
+	}
+	return out
+}
+
+// QueueDepth returns the number of messages awaiting a deferred attempt.
+func (s *Service) QueueDepth() int {
+	return s.queue.depth()
+}
+
+// Queued returns a copy of the messages awaiting a deferred attempt. Bodies spilled to disk are read back; one
+// that cannot be read is returned empty.
+func (s *Service) Queued() []QueuedMessage {
+	out := s.queue.snapshot()
+	for i := range out {
+		if err := s.loadBody(&out[i]); err != nil {
+			s.LoggerService.ErrorWith().Err(err).Str("queue_id", out[i].ID).Msg("failed to read spilled message body")
+		}
+	}
+	return out
+}
+
+// deferDelivery queues d for another attempt after delay and returns the queue ID.
+func (s *Service) deferDelivery(d *delivery, attempts int, delay time.Duration, cause error) string {
+	now := s.now()
+	m := &QueuedMessage{
+		ID:          newQueueID(),
+		MessageID:   d.rec.MessageID,
+		Msg:         d.msg,
+		Delivered:   d.delivered,
+		TraceID:     d.traceID,
+		Attempts:    attempts,
+		EnqueuedAt:  now,
+		NextAttempt: now.Add(delay),
+		Unconfirmed: d.unconfirmed,
+	}
+	if m.Unconfirmed {
+		s.unconfirmed.watch(m.MessageID, m.Msg.To)
+	}
+	if cause != nil {
+		m.LastError = cause.Error()
+	}
+	ttl := d.msg.TTL
+	if ttl <= 0 {
+		ttl = s.QueueConfig.DefaultTTL
+	}
+	if ttl > 0 {
+		m.ExpiresAt = now.Add(ttl)
+	}
+	s.enqueue(m)
+	return m.ID
+}
+
+// enqueue persists m to the QueueStore, if any, and adds it to the in-memory queue, spilling its body to disk when
+// the memory budget is used up.
+func (s *Service) enqueue(m *QueuedMessage) {
+	s.anchor(m)
+	if s.QueueStore != nil {
+		if err := s.QueueStore.Save(*m); err != nil {
+			s.LoggerService.ErrorWith().Err(err).Str("queue_id", m.ID).Msg("failed to persist queued message")
+		}
+	}
+	s.spillBody(m)
+	s.queue.push(m)
+}
+
+// anchor places m's NextAttempt, and its ExpiresAt the first time, on the monotonic clock.
+func (s *Service) anchor(m *QueuedMessage) {
+	now, wall := time.Now(), s.now()
+	m.due = now.Add(m.NextAttempt.Sub(wall))
+	if m.expires.IsZero() && !m.ExpiresAt.IsZero() {
+		m.expires = now.Add(m.ExpiresAt.Sub(wall))
+	}
+}
+
+// unstore removes a message that has left the queue for good from the QueueStore and the spill directory.
+func (s *Service) unstore(id string) {
+	s.dropSpill(id)
+	if s.QueueStore == nil {
+		return
+	}
+	if err := s.QueueStore.Delete(id); err != nil {
+		s.LoggerService.ErrorWith().Err(err).Str("queue_id", id).Msg("failed to remove message from queue store")
+	}
+}
+
+// restoreQueue reloads messages persisted by a previous run.
+func (s *Service) restoreQueue() {
+	if s.QueueStore == nil {
+		return
+	}
+	msgs, err := s.QueueStore.Load()
+	if err != nil {
+		s.LoggerService.ErrorWith().Err(err).Msg("failed to load queued messages")
+		return
+	}
+	for i := range msgs {
+		s.anchor(&msgs[i])
+		s.spillBody(&msgs[i])
+		s.queue.push(&msgs[i])
+		if msgs[i].Unconfirmed {
+			s.unconfirmed.watch(msgs[i].MessageID, msgs[i].Msg.To)
+		}
+	}
+	if len(msgs) > 0 {
+		s.LoggerService.InfoWith().Int("messages", len(msgs)).Msg("restored outbound queue")
+	}
+}
+
+// startQueue restores any persisted messages and runs the queue flusher until Shutdown is called.
+func (s *Service) startQueue() {
+	s.restoreQueue()
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopQueue = cancel
+	s.queueDone = make(chan struct{})
+	go func() {
+		defer close(s.queueDone)
+		s.runQueue(ctx)
+	}()
+}
+
+// Shutdown stops the background queue flusher, closes any prewarmed connection and stops every EventSink that is a
+// Stopper. Messages still queued stay in memory and are not delivered.
+func (s *Service) Shutdown() {
+	if !s.isInitialized.Load() || s.stopQueue == nil {
+		return
+	}
+	s.shutdownOnce.Do(func() {
+		s.stopQueue()
+		<-s.queueDone
+		if s.stopPrewarm != nil {
+			s.stopPrewarm()
+			<-s.prewarmDone
+		}
+		s.warm.close()
+		for _, sink := range s.EventSinks {
+			if st, ok := sink.(Stopper); ok {
+				st.Stop()
+			}
+		}
+	})
+}
+
+func (s *Service) runQueue(ctx context.Context) {
+	wake := s.queue.wakeChan()
+	wall, mono := s.now(), time.Now()
+	for {
+		if jump := s.clockJump(wall, mono); jump > s.maxClockSkew() || jump < -s.maxClockSkew() {
+			s.LoggerService.WarnWith().Dur("jump", jump).Msg("clock jumped; moving the queue's schedule with it")
+			s.queue.shift(jump)
+		}
+		wall, mono = s.now(), time.Now()
+		s.flushQueue(ctx, mono)
+
+		wait := queuePollInterval
+		if next, ok := s.queue.nextDue(); ok {
+			wait = min(max(time.Until(next), 0), queuePollInterval)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-wake:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
+
+// FlushQueue attempts every queued message now rather than at its scheduled retry, returning when each has been
+// tried once.
+func (s *Service) FlushQueue(ctx context.Context) {
+	now := time.Now()
+	s.queue.makeDue(now, s.now())
+	s.flushQueue(ctx, now)
+}
+
+// flushQueue attempts every due message once, rescheduling failures with exponential backoff.
+func (s *Service) flushQueue(ctx context.Context, now time.Time) {
+	// unreachable holds the hosts found unreachable in this flush; their other messages wait for the next probe
+	unreachable := map[string]bool{}
+	for _, m := range s.queue.popDue(now) {
+		if err := s.loadBody(m); err != nil {
+			s.LoggerService.ErrorWith().Err(err).Str("queue_id", m.ID).Msg("giving up on queued message")
+			m.LastError = err.Error()
+			s.deadLetters.add(m, DeadReasonFailed, s.now())
+			s.applyRetention()
+			s.unstore(m.ID)
+			continue
+		}
+		if m.expired(now) {
+			s.expire(m)
+			s.unstore(m.ID)
+			continue
+		}
+		if s.alreadyAccepted(m) {
+			s.unstore(m.ID)
+			continue
+		}
+		if ctx.Err() != nil {
+			s.spillBody(m)
+			s.queue.push(m)
+			continue
+		}
+		d, err := s.prepareDelivery(WithTraceID(ctx, m.TraceID), m.Msg)
+		if err == nil && unreachable[d.cfg.Host] {
+			d.cancel()
+			m.NextAttempt = s.now().Add(s.QueueConfig.probeInterval())
+			s.enqueue(m)
+			continue
+		}
+		if err == nil {
+			d.queueID = m.ID
+			if len(m.Delivered) > 0 {
+				d.delivered = slices.Clone(m.Delivered)
+				d.rec.To = headerRecipients(append(slices.Clone(m.Delivered), d.msg.To...), d.msg.Bcc)
+			}
+			m.Attempts++
+			err = s.attempt(d, m.Attempts)
+			cancelled := d.cancelled()
+			d.cancel()
+			if err == nil {
+				s.unconfirmed.forget(m.MessageID)
+				s.unstore(m.ID)
+				continue
+			}
+			// Recipients this attempt reached must not be sent the message again by the next
+			m.Msg.To, m.Delivered = d.msg.To, d.delivered
+			if d.unconfirmed && !m.Unconfirmed {
+				m.Unconfirmed = true
+				s.unconfirmed.watch(m.MessageID, m.Msg.To)
+			}
+			if cancelled && ctx.Err() == nil {
+				// Cancelled by ID; drop it
+				s.unstore(m.ID)
+				continue
+			}
+		}
+		m.LastError = err.Error()
+		if d != nil && s.spools(err) {
+			m.Attempts--
+			unreachable[d.cfg.Host] = true
+		}
+		if d == nil || m.Attempts >= s.QueueConfig.maxAttempts() || !isTransient(err) {
+			s.LoggerService.ErrorWith().Err(err).Str("queue_id", m.ID).Int("attempts", m.Attempts).Msg("giving up on queued message")
+			if d != nil {
+				s.deliveryFailed(d, err)
+			}
+			s.deadLetters.add(m, DeadReasonFailed, s.now())
+			s.applyRetention()
+			s.unstore(m.ID)
+			continue
+		}
+		m.NextAttempt = s.now().Add(s.retryDelay(d.cfg.Host, m.Attempts, err))
+		s.enqueue(m)
+	}
+}
+
+// retryDelay returns the wait before attempt n+1: the greylist window for greylisted failures and exponential
+// backoff otherwise.
+func (c QueueConfig) retryDelay(attempts int, err error) time.Duration {
+	if isGreylisted(err) {
+		return c.greylistDelay()
+	}
+	d := queueBaseBackoff
+	for i := 1; i < attempts && d < queueMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, queueMaxBackoff)
+}
+
+func newQueueID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,72 @@
+package email
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+
+	"github.com/Station-Manager/errors"
+)
+
+// sealedQueuePrefix marks a queue entry encrypted with AES-GCM; unprefixed entries are plain JSON.
+var sealedQueuePrefix = []byte("SMQ1")
+
+// encodeQueued serializes m, sealing it with key when one is set. key is the host application's master key
+// (16, 24 or 32 bytes) - the same one it uses to protect stored credentials.
+func encodeQueued(m QueuedMessage, key []byte) ([]byte, error) {
+	const op errors.Op = "email.encodeQueued"
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to encode queued message")
+	}
+	if len(key) == 0 {
+		return data, nil
+	}
+	aead, err := queueAEAD(key)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("invalid queue encryption key")
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to generate nonce")
+	}
+	out := append(append([]byte(nil), sealedQueuePrefix...), nonce...)
+	return aead.Seal(out, nonce, data, nil), nil
+}
+
+// decodeQueued reverses encodeQueued. Plain entries are accepted even when key is set so that an existing queue
+// is migrated as its entries are rewritten.
+func decodeQueued(data, key []byte) (QueuedMessage, error) {
+	const op errors.Op = "email.decodeQueued"
+	var m QueuedMessage
+	if bytes.HasPrefix(data, sealedQueuePrefix) {
+		if len(key) == 0 {
+			return m, errors.New(op).Msg("queued message is encrypted but no key is configured")
+		}
+		aead, err := queueAEAD(key)
+		if err != nil {
+			return m, errors.New(op).Err(err).Msg("invalid queue encryption key")
+		}
+		data = data[len(sealedQueuePrefix):]
+		if len(data) < aead.NonceSize() {
+			return m, errors.New(op).Msg("queued message is truncated")
+		}
+		if data, err = aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil); err != nil {
+			return m, errors.New(op).Err(err).Msg("failed to decrypt queued message")
+		}
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, errors.New(op).Err(err).Msg("failed to decode queued message")
+	}
+	return m, nil
+}
+
+func queueAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
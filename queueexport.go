@@ -0,0 +1,67 @@
+package email
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// queueExportVersion is bumped when the export format changes incompatibly.
+const queueExportVersion = 1
+
+// queueExport is the portable file written by ExportQueue.
+type queueExport struct {
+	Version    int             `json:"version"`
+	ExportedAt time.Time       `json:"exported_at"`
+	Messages   []QueuedMessage `json:"messages"`
+}
+
+// ExportQueue writes the pending queue to w as a portable JSON file for ImportQueue on another machine. The queue
+// itself is left untouched. The file holds full message bodies in clear text and should be handled accordingly.
+func (s *Service) ExportQueue(w io.Writer) error {
+	const op errors.Op = "email.Service.ExportQueue"
+	doc := queueExport{Version: queueExportVersion, ExportedAt: s.now().UTC(), Messages: s.Queued()}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return errors.New(op).Err(err).Msg("failed to write queue export")
+	}
+	return nil
+}
+
+// ImportQueue adds the messages of a file written by ExportQueue to the queue, due immediately, and returns how
+// many were added. Messages already queued here (by queue ID or Message-ID) are skipped.
+func (s *Service) ImportQueue(r io.Reader) (int, error) {
+	const op errors.Op = "email.Service.ImportQueue"
+	var doc queueExport
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return 0, errors.New(op).Err(err).Msg("failed to read queue export")
+	}
+	if doc.Version != queueExportVersion {
+		return 0, errors.New(op).Msgf("unsupported queue export version %d", doc.Version)
+	}
+
+	known := map[string]bool{}
+	for _, m := range s.queue.snapshot() {
+		known[m.ID] = true
+		if m.MessageID != "" {
+			known[m.MessageID] = true
+		}
+	}
+	now := s.now()
+	added := 0
+	for i := range doc.Messages {
+		m := doc.Messages[i]
+		if m.ID == "" || known[m.ID] || (m.MessageID != "" && known[m.MessageID]) {
+			continue
+		}
+		m.NextAttempt = now
+		s.enqueue(&m)
+		known[m.ID] = true
+		added++
+	}
+	s.LoggerService.InfoWith().Int("imported", added).Int("skipped", len(doc.Messages)-added).Msg("imported queued messages")
+	return added, nil
+}
@@ -0,0 +1,55 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"net/smtp"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestExportImportQueue(t *testing.T) {
+	cfg := &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}
+	shack := &Service{Config: cfg}
+	shack.isInitialized.Store(true)
+
+	shack.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		return deliveryInfo{}, &textproto.Error{Code: 451, Msg: "4.7.1 Greylisted"}
+	})
+
+	for _, id := range []string{"<1@example.com>", "<2@example.com>"} {
+		if err := shack.Send(MsgDef{To: []string{"contest@example.com"}, Msg: "Message-Id: " + id + "\r\nSubject: log\r\n\r\nbody"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := shack.ExportQueue(&buf); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if shack.QueueDepth() != 2 {
+		t.Fatalf("export should leave the queue intact")
+	}
+
+	laptop := &Service{Config: cfg}
+	laptop.isInitialized.Store(true)
+	data := buf.Bytes()
+	n, err := laptop.ImportQueue(bytes.NewReader(data))
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 imported messages, got %d, %v", n, err)
+	}
+	for _, m := range laptop.Queued() {
+		if m.NextAttempt.After(time.Now()) {
+			t.Fatalf("imported message should be due immediately: %+v", m)
+		}
+	}
+	if n, _ = laptop.ImportQueue(bytes.NewReader(data)); n != 0 {
+		t.Fatalf("re-importing should skip known messages, got %d", n)
+	}
+	if _, err = laptop.ImportQueue(bytes.NewReader([]byte(`{"version":99}`))); err == nil {
+		t.Fatalf("expected unsupported version to be rejected")
+	}
+}
@@ -0,0 +1,222 @@
+package email
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Station-Manager/errors"
+)
+
+// QueueStore persists queued messages so they survive a restart. Save inserts or replaces by ID.
+type QueueStore interface {
+	Save(m QueuedMessage) error
+	Delete(id string) error
+	Load() ([]QueuedMessage, error)
+}
+
+// FileQueueStore keeps one JSON file per queued message in Dir, encrypted with Key when it is set.
+type FileQueueStore struct {
+	Dir string
+	Key []byte
+}
+
+func (f *FileQueueStore) path(id string) string {
+	return filepath.Join(f.Dir, filepath.Base(id)+".json")
+}
+
+func (f *FileQueueStore) Save(m QueuedMessage) error {
+	const op errors.Op = "email.FileQueueStore.Save"
+	if err := os.MkdirAll(f.Dir, 0o700); err != nil {
+		return errors.New(op).Err(err).Msg("failed to create queue directory")
+	}
+	data, err := encodeQueued(m, f.Key)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to encode queued message")
+	}
+	if err = writeFileAtomic(f.path(m.ID), data, 0o600); err != nil {
+		return errors.New(op).Err(err).Msg("failed to write queued message")
+	}
+	return nil
+}
+
+// queueTempSuffix marks a queue file still being written; Load ignores and removes leftovers from a crash.
+const queueTempSuffix = ".tmp"
+
+// writeFileAtomic writes data to a temporary file in the same directory, syncs it and renames it over path, so a
+// power loss leaves either the old or the new contents, never a torn file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*"+queueTempSuffix)
+	if err != nil {
+		return err
+	}
+	name := tmp.Name()
+	cleanup := func(err error) error {
+		_ = tmp.Close()
+		_ = os.Remove(name)
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		return cleanup(err)
+	}
+	if _, err = tmp.Write(data); err != nil {
+		return cleanup(err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return cleanup(err)
+	}
+	if err = tmp.Close(); err != nil {
+		return cleanup(err)
+	}
+	if err = os.Rename(name, path); err != nil {
+		_ = os.Remove(name)
+		return err
+	}
+	// Persist the rename itself; not every platform can sync a directory, so this is best effort
+	if dir, derr := os.Open(filepath.Dir(path)); derr == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+	return nil
+}
+
+func (f *FileQueueStore) Delete(id string) error {
+	const op errors.Op = "email.FileQueueStore.Delete"
+	if err := os.Remove(f.path(id)); err != nil && !os.IsNotExist(err) {
+		return errors.New(op).Err(err).Msg("failed to remove queued message")
+	}
+	return nil
+}
+
+func (f *FileQueueStore) Load() ([]QueuedMessage, error) {
+	const op errors.Op = "email.FileQueueStore.Load"
+	entries, err := os.ReadDir(f.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to read queue directory")
+	}
+	var out []QueuedMessage
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), queueTempSuffix) {
+			// Left behind by a write interrupted before its rename
+			_ = os.Remove(filepath.Join(f.Dir, e.Name()))
+			continue
+		}
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(f.Dir, e.Name()))
+		if err != nil {
+			return nil, errors.New(op).Err(err).Msgf("failed to read %s", e.Name())
+		}
+		m, err := decodeQueued(data, f.Key)
+		if err != nil {
+			return nil, errors.New(op).Err(err).Msgf("failed to decode %s", e.Name())
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// defaultQueueTable is the table used by SQLiteQueueStore when Table is empty.
+const defaultQueueTable = "email_outbox"
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLiteQueueStore keeps the queue in a table of an existing SQLite database, such as the Station-Manager logbook.
+// Each write is a single statement, which SQLite's journal makes atomic.
+// The driver is registered by the host application; the table is created on first use. Entries are encrypted with
+// Key when it is set.
+type SQLiteQueueStore struct {
+	DB    *sql.DB
+	Table string
+	Key   []byte
+
+	once    sync.Once
+	initErr error
+}
+
+func (s *SQLiteQueueStore) table() string {
+	if s.Table == "" {
+		return defaultQueueTable
+	}
+	return s.Table
+}
+
+func (s *SQLiteQueueStore) ensure() error {
+	const op errors.Op = "email.SQLiteQueueStore.ensure"
+	s.once.Do(func() {
+		if s.DB == nil {
+			s.initErr = errors.New(op).Msg("queue store database has not been set")
+			return
+		}
+		if !sqlIdentifier.MatchString(s.table()) {
+			s.initErr = errors.New(op).Msgf("invalid queue table name %q", s.table())
+			return
+		}
+		_, err := s.DB.Exec(`CREATE TABLE IF NOT EXISTS ` + s.table() + ` (id TEXT PRIMARY KEY, data BLOB NOT NULL)`)
+		if err != nil {
+			s.initErr = errors.New(op).Err(err).Msg("failed to create queue table")
+		}
+	})
+	return s.initErr
+}
+
+func (s *SQLiteQueueStore) Save(m QueuedMessage) error {
+	const op errors.Op = "email.SQLiteQueueStore.Save"
+	if err := s.ensure(); err != nil {
+		return err
+	}
+	data, err := encodeQueued(m, s.Key)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to encode queued message")
+	}
+	if _, err = s.DB.Exec(`INSERT OR REPLACE INTO `+s.table()+` (id, data) VALUES (?, ?)`, m.ID, data); err != nil {
+		return errors.New(op).Err(err).Msg("failed to save queued message")
+	}
+	return nil
+}
+
+func (s *SQLiteQueueStore) Delete(id string) error {
+	const op errors.Op = "email.SQLiteQueueStore.Delete"
+	if err := s.ensure(); err != nil {
+		return err
+	}
+	if _, err := s.DB.Exec(`DELETE FROM `+s.table()+` WHERE id = ?`, id); err != nil {
+		return errors.New(op).Err(err).Msg("failed to delete queued message")
+	}
+	return nil
+}
+
+func (s *SQLiteQueueStore) Load() ([]QueuedMessage, error) {
+	const op errors.Op = "email.SQLiteQueueStore.Load"
+	if err := s.ensure(); err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.Query(`SELECT data FROM ` + s.table())
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to query queued messages")
+	}
+	defer func() { _ = rows.Close() }()
+	var out []QueuedMessage
+	for rows.Next() {
+		var data []byte
+		if err = rows.Scan(&data); err != nil {
+			return nil, errors.New(op).Err(err).Msg("failed to read queued message")
+		}
+		m, err := decodeQueued(data, s.Key)
+		if err != nil {
+			return nil, errors.New(op).Err(err).Msg("failed to decode queued message")
+		}
+		out = append(out, m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to read queued messages")
+	}
+	return out, nil
+}
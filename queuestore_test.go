@@ -0,0 +1,144 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestFileQueueStoreRoundTrip(t *testing.T) {
+	store := &FileQueueStore{Dir: filepath.Join(t.TempDir(), "outbox")}
+	m := QueuedMessage{ID: "abc", Msg: MsgDef{From: "a@example.com", To: []string{"b@example.com"}, Msg: "body", Priority: PriorityUrgent}, Attempts: 2}
+	if err := store.Save(m); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	got, err := store.Load()
+	if err != nil || len(got) != 1 || got[0].ID != "abc" || got[0].Msg.Priority != PriorityUrgent || got[0].Attempts != 2 {
+		t.Fatalf("unexpected load result %+v, %v", got, err)
+	}
+	if err = store.Delete("abc"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err = store.Delete("abc"); err != nil {
+		t.Fatalf("deleting a missing message should not fail: %v", err)
+	}
+	if got, _ = store.Load(); len(got) != 0 {
+		t.Fatalf("expected empty store, got %+v", got)
+	}
+}
+
+func TestQueueSurvivesRestartViaStore(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}
+	first := &Service{Config: cfg, QueueStore: &FileQueueStore{Dir: dir}}
+	first.isInitialized.Store(true)
+
+	failing := true
+	deliver := smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		if failing {
+			return deliveryInfo{}, &textproto.Error{Code: 451, Msg: "4.7.1 Greylisted"}
+		}
+		return deliveryInfo{}, nil
+	})
+	first.Transport = deliver
+
+	if err := first.Send(MsgDef{To: []string{"to@example.com"}, Msg: "Subject: hi\r\n\r\nbody"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	second := &Service{Config: cfg, QueueStore: &FileQueueStore{Dir: dir}, Transport: deliver}
+	second.isInitialized.Store(true)
+	second.restoreQueue()
+	if second.QueueDepth() != 1 {
+		t.Fatalf("expected the queued message to be restored, depth=%d", second.QueueDepth())
+	}
+
+	failing = false
+	second.flushQueue(t.Context(), time.Now().Add(time.Hour))
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected delivered message to be removed from the store, %d left", len(entries))
+	}
+}
+
+func TestFileQueueStoreEncryptsEntries(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{7}, 32)
+	store := &FileQueueStore{Dir: dir, Key: key}
+	m := QueuedMessage{ID: "abc", Msg: MsgDef{To: []string{"b@example.com"}, Msg: "Subject: contest log\r\n\r\nQSO data"}}
+	if err := store.Save(m); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "abc.json"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if bytes.Contains(raw, []byte("QSO data")) || bytes.Contains(raw, []byte("b@example.com")) {
+		t.Fatalf("queued message persisted in clear text: %q", raw)
+	}
+	got, err := store.Load()
+	if err != nil || len(got) != 1 || got[0].Msg.Msg != m.Msg.Msg {
+		t.Fatalf("unexpected load result %+v, %v", got, err)
+	}
+
+	if _, err = (&FileQueueStore{Dir: dir}).Load(); err == nil {
+		t.Fatalf("expected loading encrypted entries without a key to fail")
+	}
+	if _, err = (&FileQueueStore{Dir: dir, Key: bytes.Repeat([]byte{8}, 32)}).Load(); err == nil {
+		t.Fatalf("expected loading with the wrong key to fail")
+	}
+
+	// Plain entries from before encryption was enabled are still readable
+	if err = (&FileQueueStore{Dir: dir}).Save(QueuedMessage{ID: "plain"}); err != nil {
+		t.Fatalf("save plain: %v", err)
+	}
+	if got, err = store.Load(); err != nil || len(got) != 2 {
+		t.Fatalf("expected mixed entries to load, got %d, %v", len(got), err)
+	}
+}
+
+func TestFileQueueStoreSurvivesInterruptedWrite(t *testing.T) {
+	dir := t.TempDir()
+	store := &FileQueueStore{Dir: dir}
+	if err := store.Save(QueuedMessage{ID: "abc", Attempts: 1}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	good, err := encodeQueued(QueuedMessage{ID: "abc", Attempts: 2}, nil)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	// Simulate power loss at every point of a rewrite: the torn temp file never replaces the committed entry
+	for n := 0; n < len(good); n += 7 {
+		if err = os.WriteFile(filepath.Join(dir, "abc.json.123"+queueTempSuffix), good[:n], 0o600); err != nil {
+			t.Fatalf("write temp: %v", err)
+		}
+		got, err := store.Load()
+		if err != nil || len(got) != 1 || got[0].Attempts != 1 {
+			t.Fatalf("torn write at %d bytes corrupted the store: %+v, %v", n, got, err)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("expected leftover temp files to be cleaned up, got %d entries", len(entries))
+	}
+}
+
+func FuzzDecodeQueued(f *testing.F) {
+	key := bytes.Repeat([]byte{7}, 32)
+	plain, _ := encodeQueued(QueuedMessage{ID: "abc", Msg: MsgDef{To: []string{"b@example.com"}}}, nil)
+	sealed, _ := encodeQueued(QueuedMessage{ID: "abc"}, key)
+	f.Add(plain)
+	f.Add(sealed)
+	f.Add(sealed[:len(sealedQueuePrefix)+3])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Must reject or decode arbitrary (torn, bit-flipped) entries without panicking
+		_, _ = decodeQueued(data, key)
+		_, _ = decodeQueued(data, nil)
+	})
+}
@@ -0,0 +1,104 @@
+package email
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/logging"
+)
+
+const (
+	quotaWindow           = 24 * time.Hour
+	defaultQuotaWarnRatio = 0.8
+)
+
+// QuotaConfig describes the sending limit imposed by the mail provider, e.g. Gmail's 500 per day.
+type QuotaConfig struct {
+	// DailyLimit is the provider's rolling 24-hour limit; zero disables tracking.
+	DailyLimit int
+	// PerRecipient counts each recipient rather than each message, as most providers do.
+	PerRecipient bool
+	// WarnRatio is the fraction of DailyLimit at which a warning is logged; defaults to 0.8.
+	WarnRatio float64
+}
+
+// QuotaUsage reports consumption against the configured quota for the active profile.
+type QuotaUsage struct {
+	Profile string
+	Used    int
+	Limit   int
+}
+
+type quotaEntry struct {
+	at   time.Time
+	cost int
+}
+
+// quotaTracker keeps a rolling 24-hour ledger of sends per profile.
+type quotaTracker struct {
+	mu      sync.Mutex
+	ledgers map[string][]quotaEntry
+}
+
+func (q *QuotaConfig) cost(recipients int) int {
+	if q.PerRecipient {
+		return recipients
+	}
+	return 1
+}
+
+func (q *QuotaConfig) warnAt() int {
+	ratio := q.WarnRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = defaultQuotaWarnRatio
+	}
+	return int(float64(q.DailyLimit) * ratio)
+}
+
+// used returns the consumption of profile within the window ending at now, pruning older entries.
+func (t *quotaTracker) used(profile string, now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ledger := t.ledgers[profile]
+	cut := 0
+	for cut < len(ledger) && now.Sub(ledger[cut].at) >= quotaWindow {
+		cut++
+	}
+	ledger = ledger[cut:]
+	if t.ledgers != nil {
+		t.ledgers[profile] = ledger
+	}
+	total := 0
+	for _, e := range ledger {
+		total += e.cost
+	}
+	return total
+}
+
+func (t *quotaTracker) record(profile string, now time.Time, cost int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ledgers == nil {
+		t.ledgers = map[string][]quotaEntry{}
+	}
+	t.ledgers[profile] = append(t.ledgers[profile], quotaEntry{at: now, cost: cost})
+}
+
+// QuotaUsage returns the rolling 24-hour consumption of the active profile.
+func (s *Service) QuotaUsage() QuotaUsage {
+	u := QuotaUsage{Profile: s.config().Name, Used: s.quota.used(s.config().Name, time.Now())}
+	if s.Quota != nil {
+		u.Limit = s.Quota.DailyLimit
+	}
+	return u
+}
+
+// recordQuota books a successful send and warns once usage crosses the warning threshold.
+func (s *Service) recordQuota(log logging.Logger, cost int) {
+	now := time.Now()
+	before := s.quota.used(s.config().Name, now)
+	s.quota.record(s.config().Name, now, cost)
+	if warnAt := s.Quota.warnAt(); before < warnAt && before+cost >= warnAt {
+		log.WarnWith().Str("profile", s.config().Name).Int("used", before+cost).Int("limit", s.Quota.DailyLimit).Msg("approaching the provider's daily sending quota")
+	}
+}
@@ -0,0 +1,43 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+func TestQuotaRefusesSendsBeyondDailyLimit(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Name: "gmail", Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}}
+	s.Quota = &QuotaConfig{DailyLimit: 3, PerRecipient: true}
+	s.isInitialized.Store(true)
+
+	calls := 0
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		calls++
+		return deliveryInfo{}, nil
+	})
+
+	if err := s.Send(MsgDef{To: []string{"a@example.com", "b@example.com"}, Msg: "hi"}); err != nil {
+		t.Fatalf("first send failed: %v", err)
+	}
+	err := s.Send(MsgDef{To: []string{"c@example.com", "d@example.com"}, Msg: "hi"})
+	if err == nil || !strings.Contains(errors.Root(err).Error(), "quota") {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected the over-quota send to be refused before dialing, got %d calls", calls)
+	}
+	if u := s.QuotaUsage(); u.Used != 2 || u.Limit != 3 || u.Profile != "gmail" {
+		t.Fatalf("unexpected usage: %+v", u)
+	}
+
+	// Entries older than the window no longer count
+	if used := s.quota.used("gmail", time.Now().Add(25*time.Hour)); used != 0 {
+		t.Fatalf("expected usage to roll off after 24h, got %d", used)
+	}
+}
@@ -0,0 +1,86 @@
+package email
+
+import (
+	"context"
+	stderr "errors"
+	"math"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+type rcptLimitKey struct{}
+
+// withRcptLimit carries Service.MaxRecipientsPerTransaction to the SMTP session.
+func withRcptLimit(ctx context.Context, limit int) context.Context {
+	if limit <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, rcptLimitKey{}, limit)
+}
+
+func rcptLimitFromContext(ctx context.Context) int {
+	limit, _ := ctx.Value(rcptLimitKey{}).(int)
+	return limit
+}
+
+// rcptLimit returns the most recipients to give one transaction: the lower of configured and the RCPTMAX the server
+// advertises with the LIMITS extension (RFC 9422), either being zero when unset.
+func rcptLimit(c *smtp.Client, configured int) int {
+	limit := math.MaxInt
+	if configured > 0 {
+		limit = configured
+	}
+	if ok, params := c.Extension("LIMITS"); ok {
+		for _, p := range strings.Fields(params) {
+			k, v, _ := strings.Cut(p, "=")
+			if n, err := strconv.Atoi(v); err == nil && n > 0 && strings.EqualFold(k, "RCPTMAX") {
+				limit = min(limit, n)
+			}
+		}
+	}
+	return limit
+}
+
+// isTooManyRecipients reports whether err is the 452 reply a server gives a RCPT beyond its limit.
+func isTooManyRecipients(err error) bool {
+	var tpErr *textproto.Error
+	return stderr.As(err, &tpErr) && tpErr.Code == 452
+}
+
+// acceptRecipients moves the recipients a failed attempt was nonetheless accepted for from the delivery's pending
+// list to its delivered one, so that a retry sends the message only to the rest.
+func (d *delivery) acceptRecipients(accepted []string) {
+	if len(accepted) == 0 {
+		return
+	}
+	done := make(map[string]bool, len(accepted))
+	for _, addr := range accepted {
+		done[addr] = true
+	}
+	rest := d.msg.To[:0:0]
+	for _, addr := range d.msg.To {
+		if !done[addr] {
+			rest = append(rest, addr)
+		}
+	}
+	d.msg.To = rest
+	d.delivered = append(d.delivered, accepted...)
+}
+
+// recipientResults reports the outcome for every recipient of d: delivered ones as sent and the rest as status.
+func (d *delivery) recipientResults(status SendStatus, err error) []RecipientResult {
+	out := make([]RecipientResult, 0, len(d.delivered)+len(d.msg.To))
+	for _, addr := range d.delivered {
+		out = append(out, RecipientResult{Address: addr, Status: SendStatusSent, Reason: ReasonOK})
+	}
+	for _, addr := range d.msg.To {
+		r := RecipientResult{Address: addr, Status: status, Reason: statusReason(status, err)}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		out = append(out, r)
+	}
+	return out
+}
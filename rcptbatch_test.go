@@ -0,0 +1,133 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"net/textproto"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestRecipientsSplitAcrossTransactions(t *testing.T) {
+	to := []string{"a@example.org", "b@example.org", "c@example.org", "d@example.org", "e@example.org"}
+	cases := []struct {
+		name       string
+		ehlo       []string
+		configured int
+		want       int
+	}{
+		{"advertised", []string{"fake.example.com", "LIMITS MAILMAX=10 RCPTMAX=2"}, 0, 3},
+		{"configured", []string{"fake.example.com", "8BITMIME"}, 4, 2},
+		{"lower of both", []string{"fake.example.com", "LIMITS RCPTMAX=3"}, 1, 5},
+		{"unlimited", []string{"fake.example.com", "8BITMIME"}, 0, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addr, lines := startScriptedSMTPServer(t, c.ehlo)
+			info, err := sendMailWithTLS(withRcptLimit(withRootCAs(t.Context(), fakeRoots()), c.configured), addr, nil, "op@example.com", to, []byte("Subject: hi\r\n\r\n73\r\n"))
+			if err != nil {
+				t.Fatalf("send failed: %v", err)
+			}
+			mails := 0
+			for _, l := range lines() {
+				if strings.HasPrefix(l, "MAIL FROM:") {
+					mails++
+				}
+			}
+			if mails != c.want || info.Transactions != c.want || !slices.Equal(info.Accepted, to) {
+				t.Fatalf("%d MAIL commands, info %+v; want %d transactions", mails, info, c.want)
+			}
+		})
+	}
+}
+
+func TestPartialDeliveryRetriesOnlyRemainingRecipients(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.org", Port: 587, From: "op@example.org", SmtpRetryCount: 1}}
+	s.isInitialized.Store(true)
+
+	var calls [][]string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		calls = append(calls, slices.Clone(to))
+		if len(calls) == 1 {
+			return deliveryInfo{Accepted: to[:2], Transactions: 1}, &textproto.Error{Code: 421, Msg: "closing connection"}
+		}
+		return deliveryInfo{Accepted: to, Transactions: 1}, nil
+	})
+
+	to := []string{"a@example.org", "b@example.org", "c@example.org", "d@example.org"}
+	res, err := s.SendWithResult(t.Context(), MsgDef{From: "op@example.org", To: to, Msg: "Subject: net\r\n\r\n73\r\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || !slices.Equal(calls[1], to[2:]) {
+		t.Fatalf("retry went to %v, want only %v", calls, to[2:])
+	}
+	if len(res.Recipients) != 4 {
+		t.Fatalf("recipients = %+v", res.Recipients)
+	}
+	for _, r := range res.Recipients {
+		if r.Status != SendStatusSent {
+			t.Errorf("%s: status %s", r.Address, r.Status)
+		}
+	}
+
+	// A failure after a partial delivery still reports who got the message; each of the two attempts got one more
+	calls = nil
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		calls = append(calls, to)
+		return deliveryInfo{Accepted: to[:1]}, &textproto.Error{Code: 451, Msg: "transaction failed"}
+	})
+	res, err = s.SendWithResult(t.Context(), MsgDef{From: "op@example.org", To: to, Msg: "Subject: net\r\n\r\n73\r\n"})
+	if err == nil || len(calls) != 2 || res.Status != SendStatusFailed || len(res.Recipients) != 4 {
+		t.Fatalf("err=%v calls=%d res=%+v", err, len(calls), res)
+	}
+	if res.Recipients[1] != (RecipientResult{Address: "b@example.org", Status: SendStatusSent, Reason: ReasonOK}) || res.Recipients[2].Status != SendStatusFailed {
+		t.Errorf("recipients = %+v", res.Recipients)
+	}
+}
+
+func TestQueuedPartialDeliveryIsNotResent(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &types.EmailConfig{Enabled: true, Host: "smtp.example.org", Port: 587, From: "op@example.org"}
+	s := &Service{Config: cfg, QueueStore: &FileQueueStore{Dir: dir}}
+	s.isInitialized.Store(true)
+
+	var calls [][]string
+	got := map[string]int{}
+	deliver := smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		calls = append(calls, slices.Clone(to))
+		// The first batch goes through and the second fails, as with RCPTMAX 1 and a server that drops the session
+		got[to[0]]++
+		return deliveryInfo{Accepted: to[:1], Transactions: 1}, &textproto.Error{Code: 451, Msg: "transaction failed"}
+	})
+	s.Transport = deliver
+
+	to := []string{"a@example.org", "b@example.org", "c@example.org", "d@example.org"}
+	s.enqueue(&QueuedMessage{ID: newQueueID(), MessageID: "<m1@example.org>", NextAttempt: s.now(),
+		Msg: MsgDef{From: "op@example.org", To: to, Msg: "Subject: net\r\n\r\n73\r\n"}})
+	for range 2 {
+		s.flushQueue(t.Context(), time.Now().Add(time.Hour))
+	}
+	want := [][]string{to, to[1:]}
+	if !slices.EqualFunc(calls, want, slices.Equal[[]string]) {
+		t.Fatalf("deliveries went to %v, want %v", calls, want)
+	}
+	for addr, n := range got {
+		if n > 1 {
+			t.Errorf("%s was sent the message %d times", addr, n)
+		}
+	}
+
+	// The narrowed recipients survive a restart
+	restarted := &Service{Config: cfg, QueueStore: &FileQueueStore{Dir: dir}, Transport: deliver}
+	restarted.isInitialized.Store(true)
+	restarted.restoreQueue()
+	queued := restarted.Queued()
+	if len(queued) != 1 || !slices.Equal(queued[0].Msg.To, to[2:]) || !slices.Equal(queued[0].Delivered, to[:2]) {
+		t.Fatalf("restored queue = %+v", queued)
+	}
+}
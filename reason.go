@@ -0,0 +1,101 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	stderr "errors"
+	"net/textproto"
+	"slices"
+	"strings"
+)
+
+// Reason classifies the outcome of a send, so that a UI can show a message of its own, e.g. a translated one,
+// instead of the error text. See ReasonOf.
+type Reason string
+
+const (
+	ReasonOK                Reason = "ok"
+	ReasonAuthFailed        Reason = "auth-failed"
+	ReasonTLSFailed         Reason = "tls-failed"
+	ReasonRecipientRejected Reason = "recipient-rejected"
+	ReasonRateLimited       Reason = "rate-limited"
+	ReasonOversize          Reason = "oversize"
+	ReasonDisabled          Reason = "disabled"
+	ReasonQueued            Reason = "queued"
+	ReasonCancelled         Reason = "cancelled"
+	// ReasonUnknown is any other failure, such as a lost connection or an invalid message.
+	ReasonUnknown Reason = "unknown"
+)
+
+var (
+	// errDeliveryCancelled marks a send aborted by CancelDelivery or its caller's context.
+	errDeliveryCancelled = stderr.New(errMsgDeliveryCancelled)
+	errQuotaExceeded     = stderr.New(errMsgQuotaExceeded)
+	// errTLSFailed marks a connection that could not be secured.
+	errTLSFailed = stderr.New("TLS negotiation failed")
+)
+
+// rateLimitPhrases mark a reply refusing mail for the sender's volume, such as Gmail's 421 4.7.28 and 550 5.4.5.
+var rateLimitPhrases = []string{"rate limit", "rate-limit", "ratelimit", "too many", "quota", "4.7.28"}
+
+// ReasonOf returns the Reason for err, as returned by the Send methods; ReasonOK for nil.
+func ReasonOf(err error) Reason {
+	if err == nil {
+		return ReasonOK
+	}
+	var se *SendError
+	if stderr.As(err, &se) && se.Reason != "" {
+		return se.Reason
+	}
+	return classifyReason(err)
+}
+
+func classifyReason(err error) Reason {
+	var bp *BackpressureError
+	switch {
+	case stderr.Is(err, errDeliveryCancelled), stderr.Is(err, context.Canceled):
+		return ReasonCancelled
+	case stderr.Is(err, errQuotaExceeded), stderr.As(err, &bp):
+		return ReasonRateLimited
+	case isAuthFailure(err):
+		return ReasonAuthFailed
+	case isTLSFailure(err):
+		return ReasonTLSFailed
+	}
+	var tpErr *textproto.Error
+	if !stderr.As(err, &tpErr) {
+		return ReasonUnknown
+	}
+	msg := strings.ToLower(tpErr.Msg)
+	switch {
+	case tpErr.Code == 552 || strings.HasPrefix(msg, "5.3.4"):
+		return ReasonOversize
+	case slices.ContainsFunc(rateLimitPhrases, func(p string) bool { return strings.Contains(msg, p) }):
+		return ReasonRateLimited
+	case tpErr.Code == 550 || tpErr.Code == 551 || tpErr.Code == 553 || strings.HasPrefix(msg, "5.1."):
+		return ReasonRecipientRejected
+	}
+	return ReasonUnknown
+}
+
+func isTLSFailure(err error) bool {
+	var certErr *tls.CertificateVerificationError
+	var hostErr x509.HostnameError
+	var authErr x509.UnknownAuthorityError
+	var recErr tls.RecordHeaderError
+	var alert tls.AlertError
+	return stderr.Is(err, errTLSFailed) || stderr.As(err, &certErr) || stderr.As(err, &hostErr) ||
+		stderr.As(err, &authErr) || stderr.As(err, &recErr) || stderr.As(err, &alert)
+}
+
+// statusReason returns the Reason reported with status, err being the failure of a SendStatusFailed send.
+func statusReason(status SendStatus, err error) Reason {
+	switch status {
+	case SendStatusSent:
+		return ReasonOK
+	case SendStatusQueued:
+		return ReasonQueued
+	}
+	return ReasonOf(err)
+}
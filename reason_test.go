@@ -0,0 +1,71 @@
+package email
+
+import (
+	"context"
+	"io"
+	"net/smtp"
+	"net/textproto"
+	"testing"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+func TestReasonOf(t *testing.T) {
+	cases := []struct {
+		err  error
+		want Reason
+	}{
+		{nil, ReasonOK},
+		{&textproto.Error{Code: 535, Msg: "5.7.8 Username and Password not accepted"}, ReasonAuthFailed},
+		{&textproto.Error{Code: 552, Msg: "5.3.4 Message size exceeds fixed limit"}, ReasonOversize},
+		{&textproto.Error{Code: 421, Msg: "4.7.28 Our system has detected an unusual rate of mail"}, ReasonRateLimited},
+		{&textproto.Error{Code: 550, Msg: "5.4.5 Daily user sending quota exceeded"}, ReasonRateLimited},
+		{&textproto.Error{Code: 550, Msg: "5.1.1 The email account that you tried to reach does not exist"}, ReasonRecipientRejected},
+		{&textproto.Error{Code: 451, Msg: "4.3.0 Temporary server error"}, ReasonUnknown},
+		{errors.New("email.test").Err(errTLSFailed).Msg("smtp server does not support STARTTLS; TLS required"), ReasonTLSFailed},
+		{errors.New("email.test").Err(errQuotaExceeded).Msg(errMsgQuotaExceeded), ReasonRateLimited},
+		{&BackpressureError{Depth: 10, MaxDepth: 10}, ReasonRateLimited},
+		{context.Canceled, ReasonCancelled},
+		{io.EOF, ReasonUnknown},
+	}
+	for _, c := range cases {
+		if got := ReasonOf(c.err); got != c.want {
+			t.Errorf("ReasonOf(%v) = %s, want %s", c.err, got, c.want)
+		}
+	}
+}
+
+func TestSendResultReasons(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "op@example.org"}}
+	s.isInitialized.Store(true)
+
+	reply := error(nil)
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		return deliveryInfo{}, reply
+	})
+
+	res, err := s.SendWithResult(t.Context(), MsgDef{To: []string{"dx@example.com"}, Msg: "Subject: qsl\r\n\r\n73\r\n"})
+	if err != nil || res.Reason != ReasonOK || res.Recipients[0].Reason != ReasonOK {
+		t.Fatalf("err=%v res=%+v", err, res)
+	}
+
+	reply = &textproto.Error{Code: 550, Msg: "5.1.1 mailbox does not exist"}
+	_, err = s.SendWithResult(t.Context(), MsgDef{To: []string{"nobody@example.com"}, Msg: "Subject: qsl\r\n\r\n73\r\n"})
+	if got := ReasonOf(err); got != ReasonRecipientRejected {
+		t.Fatalf("reason %s for %v", got, err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	reply = nil
+	_, err = s.SendWithResult(ctx, MsgDef{To: []string{"dx@example.com"}, Msg: "Subject: qsl\r\n\r\n73\r\n"})
+	if got := ReasonOf(err); err == nil || got != ReasonCancelled {
+		t.Fatalf("reason %s for %v", got, err)
+	}
+
+	s.Config.Enabled = false
+	if res, err = s.SendWithResult(t.Context(), MsgDef{To: []string{"dx@example.com"}, Msg: "Subject: qsl\r\n\r\n73\r\n"}); err != nil || res.Reason != ReasonDisabled {
+		t.Fatalf("err=%v res=%+v", err, res)
+	}
+}
@@ -0,0 +1,98 @@
+package email
+
+import (
+	"context"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// receivedStamp is set by Forward on mail the service relays rather than originates. The Received field is written
+// by the SMTP session itself, since only then are the protocol and TLS parameters of the onward hop known.
+type receivedStamp struct {
+	// from is the host the message was received from, taken from its topmost Received field; empty when unknown
+	from string
+	id   string
+	// now tells the time of the hop; nil uses the system clock
+	now func() time.Time
+}
+
+type receivedStampKey struct{}
+
+func withReceivedStamp(ctx context.Context, st *receivedStamp) context.Context {
+	if st == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, receivedStampKey{}, st)
+}
+
+func receivedStampFromContext(ctx context.Context) *receivedStamp {
+	st, _ := ctx.Value(receivedStampKey{}).(*receivedStamp)
+	return st
+}
+
+// stampFor returns the stamp of msg relayed under the message ID id, timed by now.
+func stampFor(msg *InboundMessage, id string, now func() time.Time) *receivedStamp {
+	st := &receivedStamp{id: id, now: now}
+	if top := msg.Header["Received"]; len(top) > 0 {
+		fields := strings.Fields(strings.ReplaceAll(top[0], ";", " ; "))
+		for i := 0; i+1 < len(fields) && fields[i] != ";"; i++ {
+			if strings.EqualFold(fields[i], "by") {
+				st.from = fields[i+1]
+				break
+			}
+		}
+	}
+	return st
+}
+
+// at returns the time of the hop.
+func (st *receivedStamp) at() time.Time {
+	if st.now != nil {
+		return st.now()
+	}
+	return time.Now()
+}
+
+// header returns the Received field (RFC 5321 section 4.4) for the hop described by info. The with keyword follows
+// RFC 3848, and a single recipient is named with for, as MTAs do to avoid disclosing the other recipients.
+func (st *receivedStamp) header(info deliveryInfo, to []string, now time.Time) string {
+	var b strings.Builder
+	b.WriteString("Received:")
+	if st.from != "" {
+		b.WriteString(" from " + st.from + "\r\n\t")
+	} else {
+		b.WriteString(" ")
+	}
+	b.WriteString("by " + resolveHostname() + " (Station-Manager) with " + info.protocol())
+	if info.TLSVersion != "" {
+		b.WriteString(" (version=" + info.TLSVersion)
+		if info.TLSCipher != "" {
+			b.WriteString(" cipher=" + info.TLSCipher)
+		}
+		b.WriteString(")")
+	}
+	if st.id != "" {
+		b.WriteString("\r\n\tid " + st.id)
+	}
+	if len(to) == 1 {
+		b.WriteString("\r\n\tfor " + (&mail.Address{Address: to[0]}).String())
+	}
+	b.WriteString("; " + now.UTC().Format(time.RFC1123Z) + "\r\n")
+	return b.String()
+}
+
+// protocol names the transmission type of the session for the with clause of Received.
+func (info deliveryInfo) protocol() string {
+	switch {
+	case info.Legacy:
+		return "SMTP"
+	case info.TLSVersion != "" && info.Authenticated:
+		return "ESMTPSA"
+	case info.TLSVersion != "":
+		return "ESMTPS"
+	case info.Authenticated:
+		return "ESMTPA"
+	}
+	return "ESMTP"
+}
@@ -0,0 +1,72 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestReceivedHeader(t *testing.T) {
+	in, err := ParseInbound(strings.NewReader("Received: from relay.example.org by mx.club.example with ESMTPS id 1;\r\n" +
+		" Wed, 14 Oct 2026 09:00:00 +0000\r\n" +
+		"Received: from laptop by relay.example.org; Wed, 14 Oct 2026 08:59:59 +0000\r\n" +
+		"From: w1aw@example.org\r\n\r\n73\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := stampFor(in, "<r1@club.example>", nil)
+	if st.from != "mx.club.example" {
+		t.Fatalf("from = %q, want the topmost by-domain", st.from)
+	}
+
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	info := deliveryInfo{TLSVersion: "TLS 1.3", TLSCipher: "TLS_AES_128_GCM_SHA256", Authenticated: true}
+	got := st.header(info, []string{"president@club.example"}, now)
+	want := "Received: from mx.club.example\r\n\tby " + resolveHostname() +
+		" (Station-Manager) with ESMTPSA (version=TLS 1.3 cipher=TLS_AES_128_GCM_SHA256)\r\n" +
+		"\tid <r1@club.example>\r\n\tfor <president@club.example>; Thu, 15 Oct 2026 12:00:00 +0000\r\n"
+	if got != want {
+		t.Fatalf("header =\n%q\nwant\n%q", got, want)
+	}
+
+	got = (&receivedStamp{}).header(deliveryInfo{Legacy: true}, []string{"a@club.example", "b@club.example"}, now)
+	if got != "Received: by "+resolveHostname()+" (Station-Manager) with SMTP; Thu, 15 Oct 2026 12:00:00 +0000\r\n" {
+		t.Fatalf("minimal header = %q", got)
+	}
+	if _, err = ParseInbound(strings.NewReader(want + "\r\n")); err != nil {
+		t.Fatalf("stamped header does not parse: %v", err)
+	}
+}
+
+func TestForwardStampsReceived(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.club.example", Port: 587, From: "relay@club.example"}}
+	s.isInitialized.Store(true)
+
+	var stamp *receivedStamp
+	s.Transport = smtpFunc(func(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		stamp = receivedStampFromContext(ctx)
+		return deliveryInfo{}, nil
+	})
+
+	if _, err := s.SendWithResult(t.Context(), MsgDef{From: "op@club.example", To: []string{"a@club.example"}, Msg: "Subject: hi\r\n\r\n73\r\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if stamp != nil {
+		t.Fatal("originated mail must not be stamped")
+	}
+
+	in, err := ParseInbound(strings.NewReader("From: w1aw@example.org\r\nSubject: hi\r\n\r\n73\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Forward(t.Context(), in, []string{"president@club.example"}); err != nil {
+		t.Fatal(err)
+	}
+	if stamp == nil || !strings.HasPrefix(stamp.id, "<") {
+		t.Fatalf("relayed mail not stamped: %+v", stamp)
+	}
+}
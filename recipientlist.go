@@ -0,0 +1,151 @@
+package email
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// undisclosedRecipients is the To header of mail whose every recipient is blind.
+const undisclosedRecipients = "undisclosed-recipients:;"
+
+// Recipient is an entry of the configured recipient list, see Service.Recipients.
+type Recipient struct {
+	// Name is shown with the address in the To header; optional.
+	Name string
+	// Address is an email address, or an alias token for the RecipientResolver.
+	Address string
+	// DigestOnly sends the recipient notification digests only, leaving it out of other mail to the configured list.
+	DigestOnly bool
+	// Bcc leaves the recipient out of the To header of every message sent to it.
+	Bcc bool
+}
+
+// ParseRecipients parses a recipient list in the form of EmailConfig.To. An RFC 5322 address list, which may
+// contain groups, is parsed as net/mail.ParseAddressList does. Otherwise the addresses, optionally with display
+// names, may be separated by commas, semicolons or whitespace; separators within a quoted name, a comment or angle
+// brackets do not split, so `"Smith, John" <k1abc@example.org>` is one recipient.
+func ParseRecipients(list string) []Recipient {
+	if addrs, err := mail.ParseAddressList(list); err == nil {
+		out := make([]Recipient, len(addrs))
+		for i, a := range addrs {
+			out[i] = Recipient{Name: a.Name, Address: a.Address}
+		}
+		return out
+	}
+	var out []Recipient
+	for _, entry := range splitRecipientList(list) {
+		if r, ok := parseRecipient(entry); ok {
+			out = append(out, r)
+			continue
+		}
+		// Older configs separate bare addresses with spaces alone
+		for _, f := range strings.Fields(entry) {
+			r, _ := parseRecipient(f)
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// parseRecipient parses one address; ok is false when entry is not one, in which case it is returned as given, as
+// for an alias token.
+func parseRecipient(entry string) (Recipient, bool) {
+	a, err := mail.ParseAddress(entry)
+	if err != nil {
+		return Recipient{Address: entry}, false
+	}
+	return Recipient{Name: a.Name, Address: a.Address}, true
+}
+
+// splitRecipientList splits list at the commas and semicolons outside quoted strings, comments and angle brackets.
+func splitRecipientList(list string) []string {
+	var out []string
+	var quoted, escaped bool
+	var depth, angle, start int
+	for i, c := range list {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && (quoted || depth > 0):
+			escaped = true
+		case c == '"' && depth == 0:
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case depth > 0:
+		case c == '<':
+			angle++
+		case c == '>' && angle > 0:
+			angle--
+		case (c == ',' || c == ';') && angle == 0:
+			if entry := strings.TrimSpace(list[start:i]); entry != "" {
+				out = append(out, entry)
+			}
+			start = i + 1
+		}
+	}
+	if entry := strings.TrimSpace(list[start:]); entry != "" {
+		out = append(out, entry)
+	}
+	return out
+}
+
+// splitAndTrim returns the addresses of a recipient list in the form of EmailConfig.To.
+func splitAndTrim(list string) []string {
+	rs := ParseRecipients(list)
+	if len(rs) == 0 {
+		return nil
+	}
+	out := make([]string, len(rs))
+	for i, r := range rs {
+		out[i] = r.Address
+	}
+	return out
+}
+
+// configRecipients returns Service.Recipients, or the list parsed from EmailConfig.To when that is empty.
+func (s *Service) configRecipients() []Recipient {
+	if len(s.Recipients) > 0 {
+		return s.Recipients
+	}
+	return ParseRecipients(s.config().To)
+}
+
+// defaultTo returns the addresses mail is sent to when it names none; digest includes the DigestOnly recipients.
+func (s *Service) defaultTo(digest bool) []string {
+	var out []string
+	for _, r := range s.configRecipients() {
+		if r.DigestOnly && !digest {
+			continue
+		}
+		out = append(out, r.Address)
+	}
+	return out
+}
+
+// headerTo returns the To header for mail to the addresses to: those of configured Bcc recipients are left out and
+// configured display names added.
+func (s *Service) headerTo(to []string) string {
+	configured := make(map[string]Recipient)
+	for _, r := range s.configRecipients() {
+		configured[strings.ToLower(r.Address)] = r
+	}
+	list := make([]string, 0, len(to))
+	for _, addr := range to {
+		r, ok := configured[strings.ToLower(addr)]
+		switch {
+		case ok && r.Bcc:
+		case ok && r.Name != "":
+			list = append(list, (&mail.Address{Name: r.Name, Address: r.Address}).String())
+		default:
+			list = append(list, addr)
+		}
+	}
+	if len(list) == 0 {
+		return undisclosedRecipients
+	}
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,84 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestParseRecipients(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []Recipient
+	}{
+		{"", nil},
+		{`"Smith, John" <j@x.com>; k1abc@y.com`, []Recipient{{Name: "Smith, John", Address: "j@x.com"}, {Address: "k1abc@y.com"}}},
+		{"Committee: a@x.com, b@y.com;, c@z.com", []Recipient{{Address: "a@x.com"}, {Address: "b@y.com"}, {Address: "c@z.com"}}},
+		{"=?utf-8?q?J=C3=B6rg?= <dl1abc@example.de>", []Recipient{{Name: "Jörg", Address: "dl1abc@example.de"}}},
+		{"a@x.com; b@y.com c@z.com, d@w.com", []Recipient{{Address: "a@x.com"}, {Address: "b@y.com"}, {Address: "c@z.com"}, {Address: "d@w.com"}}},
+		{`"Smith, John" <k1abc@example.org>; QSL Manager <qsl@example.org>`,
+			[]Recipient{{Name: "Smith, John", Address: "k1abc@example.org"}, {Name: "QSL Manager", Address: "qsl@example.org"}}},
+		{`"Club \"Net; Control\"" <net@example.org>,,`, []Recipient{{Name: `Club "Net; Control"`, Address: "net@example.org"}}},
+		{"op@example.org (Ops, nights), club-committee", []Recipient{{Name: "Ops, nights", Address: "op@example.org"}, {Address: "club-committee"}}},
+	}
+	for _, c := range cases {
+		if got := ParseRecipients(c.in); !slices.Equal(got, c.want) {
+			t.Errorf("ParseRecipients(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestRecipientFlags(t *testing.T) {
+	s := &Service{
+		Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com", To: "ignored@example.com"},
+		Recipients: []Recipient{
+			{Name: "Operator", Address: "op@example.com"},
+			{Address: "log@example.com", Bcc: true},
+			{Address: "weekly@example.com", DigestOnly: true},
+		},
+		Notifications: &NotificationConfig{Categories: map[NotificationCategory]CategorySettings{
+			CategoryAlert:  {Enabled: true},
+			CategoryDigest: {Enabled: true, Schedule: ScheduleDaily},
+		}},
+	}
+	s.isInitialized.Store(true)
+
+	type sent struct {
+		to  []string
+		msg string
+	}
+	var got []sent
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		got = append(got, sent{to: to, msg: string(msg)})
+		return deliveryInfo{}, nil
+	})
+
+	if err := s.Notify(t.Context(), Notification{Category: CategoryAlert, Subject: "Rig disconnected"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Notify(t.Context(), Notification{Category: CategoryDigest, Subject: "QSO summary"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FlushDigests(t.Context(), time.Now().Add(25*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("sent %d messages", len(got))
+	}
+	if !slices.Equal(got[0].to, []string{"op@example.com", "log@example.com"}) {
+		t.Errorf("alert sent to %v", got[0].to)
+	}
+	if !slices.Equal(got[1].to, []string{"op@example.com", "log@example.com", "weekly@example.com"}) {
+		t.Errorf("digest sent to %v", got[1].to)
+	}
+	for _, m := range got {
+		if !strings.Contains(m.msg, "To: \"Operator\" <op@example.com>") || strings.Contains(m.msg, "log@example.com") {
+			t.Errorf("To header wrong in %q", m.msg)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+// RecipientAliasConfig is the built-in alias for the configured recipient list, see Service.Recipients.
+const RecipientAliasConfig = "config"
+
+// RecipientResolver expands an alias token (a group name such as "club-committee") into addresses at send time, so
+// recipient lists maintained elsewhere in Station-Manager are bound late. A nil slice means the alias is unknown.
+type RecipientResolver interface {
+	ResolveRecipients(ctx context.Context, alias string) ([]string, error)
+}
+
+// resolveRecipients replaces alias tokens in to - entries without an '@' - with the addresses they stand for,
+// dropping duplicates. Plain addresses pass through unchanged.
+func (s *Service) resolveRecipients(ctx context.Context, to []string) ([]string, error) {
+	const op errors.Op = "email.Service.resolveRecipients"
+	out := make([]string, 0, len(to))
+	seen := make(map[string]bool, len(to))
+	add := func(addrs ...string) {
+		for _, a := range addrs {
+			a = strings.TrimSpace(a)
+			if a == "" || seen[strings.ToLower(a)] {
+				continue
+			}
+			seen[strings.ToLower(a)] = true
+			out = append(out, a)
+		}
+	}
+	for _, entry := range to {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.Contains(entry, "@") {
+			add(entry)
+			continue
+		}
+		if strings.EqualFold(entry, RecipientAliasConfig) {
+			add(s.defaultTo(false)...)
+			continue
+		}
+		if s.RecipientResolver == nil {
+			return nil, errors.New(op).Msgf("recipient %q is not an address and no resolver is configured", entry)
+		}
+		addrs, err := s.RecipientResolver.ResolveRecipients(ctx, entry)
+		if err != nil {
+			return nil, errors.New(op).Err(err).Msgf("failed to resolve recipient alias %q", entry)
+		}
+		if addrs == nil {
+			return nil, errors.New(op).Msgf("unknown recipient alias %q", entry)
+		}
+		add(addrs...)
+	}
+	return out, nil
+}
+
+// applyCopies adds the Cc and Bcc recipients of email to its envelope, listing the Cc recipients in the Cc field
+// and removing any Bcc field, so blind copies stay blind. Bcc is kept, resolved, to leave those recipients out of
+// the history and delivery events.
+func (s *Service) applyCopies(ctx context.Context, email MsgDef) (MsgDef, error) {
+	const op errors.Op = "email.Service.applyCopies"
+	if len(email.Cc) == 0 && len(email.Bcc) == 0 {
+		return email, nil
+	}
+	cc, err := s.resolveRecipients(ctx, email.Cc)
+	if err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msg("failed to resolve the cc recipients")
+	}
+	bcc, err := s.resolveRecipients(ctx, email.Bcc)
+	if err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msg("failed to resolve the bcc recipients")
+	}
+	if len(cc) > 0 {
+		email.Msg = mergeCc(email.Msg, cc)
+	}
+	email.Msg = removeHeader(email.Msg, "Bcc")
+	email.To = append(append(append([]string(nil), email.To...), cc...), bcc...)
+	email.Cc, email.Bcc = nil, bcc
+	return email, nil
+}
+
+// mergeCc adds the addresses of cc that the Cc field of msg does not list yet to it.
+func mergeCc(msg string, cc []string) string {
+	head, _, _ := strings.Cut(msg, "\r\n\r\n")
+	hdr, _ := textproto.NewReader(bufio.NewReader(strings.NewReader(head + "\r\n\r\n"))).ReadMIMEHeader()
+	existing := strings.TrimSpace(hdr.Get("Cc"))
+	listed := map[string]bool{}
+	if list, err := mail.ParseAddressList(existing); err == nil {
+		for _, a := range list {
+			listed[strings.ToLower(a.Address)] = true
+		}
+	}
+	var fields []string
+	if existing != "" {
+		fields = append(fields, existing)
+	}
+	for _, addr := range cc {
+		if !listed[strings.ToLower(addr)] {
+			fields = append(fields, addr)
+		}
+	}
+	return setHeader(msg, "Cc", strings.Join(fields, ", "))
+}
+
+// headerRecipients returns the recipients of to listed in the message, leaving out the blind copies bcc.
+func headerRecipients(to, bcc []string) []string {
+	if len(bcc) == 0 {
+		return to
+	}
+	blind := make(map[string]bool, len(bcc))
+	for _, addr := range bcc {
+		blind[strings.ToLower(addr)] = true
+	}
+	out := make([]string, 0, len(to))
+	for _, addr := range to {
+		if !blind[strings.ToLower(addr)] {
+			out = append(out, addr)
+		}
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+type mapResolver map[string][]string
+
+func (m mapResolver) ResolveRecipients(_ context.Context, alias string) ([]string, error) {
+	return m[alias], nil
+}
+
+func TestSendResolvesRecipientAliases(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com", To: "me@example.com; log@example.com"}}
+	s.RecipientResolver = mapResolver{"committee": {"chair@example.com", "ME@example.com"}}
+	s.isInitialized.Store(true)
+
+	var got []string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		got = to
+		return deliveryInfo{}, nil
+	})
+
+	if err := s.Send(MsgDef{To: []string{"config", "committee", "dx@example.com"}, Msg: "Subject: hi\r\n\r\nbody"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	want := "me@example.com,log@example.com,chair@example.com,dx@example.com"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("unexpected envelope recipients %v", got)
+	}
+
+	err := s.Send(MsgDef{To: []string{"nosuchgroup"}, Msg: "Subject: hi\r\n\r\nbody"})
+	if err == nil || !strings.Contains(errors.Root(err).Error(), "unknown recipient alias") {
+		t.Fatalf("expected unknown alias error, got %v", err)
+	}
+}
+
+func TestSendCopiesCcAndBcc(t *testing.T) {
+	sink := &recordingSink{}
+	s := &Service{
+		Config:     &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "op@example.org"},
+		EventSinks: []EventSink{sink},
+	}
+	s.RecipientResolver = mapResolver{
+		"committee": {"chair@club.example.org", "awards@club.example.org"},
+		"secretary": {"secretary@club.example.org"},
+	}
+	s.isInitialized.Store(true)
+
+	var rcpts []string
+	var sent string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		rcpts, sent = to, string(msg)
+		return deliveryInfo{}, nil
+	})
+
+	def := MsgDef{To: []string{"awards@arrl.example"}, Cc: []string{"committee"}, Bcc: []string{"secretary"},
+		Msg: "Subject: DXCC application\r\nCc: Chair <Chair@club.example.org>\r\nBcc: secretary@club.example.org\r\n\r\n73\r\n"}
+	if err := s.Send(def); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rcpts, " ") != "awards@arrl.example chair@club.example.org awards@club.example.org secretary@club.example.org" {
+		t.Errorf("envelope %v", rcpts)
+	}
+	if !strings.Contains(sent, "\r\nCc: Chair <Chair@club.example.org>, awards@club.example.org\r\n") || strings.Contains(sent, "secretary@") {
+		t.Errorf("message %q", sent)
+	}
+	// Blind copies stay out of what the history and events show
+	if h := s.History(); len(h) != 1 || strings.Join(h[0].To, " ") != "awards@arrl.example chair@club.example.org awards@club.example.org" {
+		t.Errorf("history %+v", h)
+	}
+	if len(sink.events) != 1 || len(sink.events[0].Recipients) != 3 {
+		t.Errorf("events %+v", sink.events)
+	}
+
+	def.Bcc = []string{"treasurer"}
+	if err := s.Send(def); err == nil || !strings.Contains(errors.Root(err).Error(), "unknown recipient alias") {
+		t.Errorf("expected an unknown Bcc alias to fail, got %v", err)
+	}
+	if len(def.Cc) != 1 || len(def.Bcc) != 1 {
+		t.Errorf("caller's MsgDef modified: %+v", def)
+	}
+}
@@ -0,0 +1,160 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+	"regexp"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+// RedactRule masks every match of Pattern in outgoing text bodies.
+type RedactRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// Replace is the replacement text, with $1-style expansion; empty uses "[redacted]".
+	Replace string
+}
+
+// Built-in redaction rules.
+var (
+	// RedactGridSquares truncates Maidenhead locators to four characters (about 100 km) so a published grid no
+	// longer pinpoints the operator's home.
+	RedactGridSquares = RedactRule{Name: "grid", Pattern: regexp.MustCompile(`(?i)\b([A-R]{2}[0-9]{2})[A-X]{2}(?:[0-9]{2}(?:[A-X]{2})?)?\b`), Replace: "$1"}
+	// RedactPhoneNumbers masks North American and "+"-prefixed international numbers.
+	RedactPhoneNumbers = RedactRule{Name: "phone", Pattern: regexp.MustCompile(`(?:\+\d{1,3}(?:[ .-]?\(?\d{1,4}\)?){2,5}\d|\(?\b\d{3}\)?[ .-]\d{3}[ .-]\d{4})\b`), Replace: "[phone]"}
+	// RedactSecrets masks values assigned to key, token, secret or password names, and well-known API key shapes.
+	RedactSecrets = RedactRule{Name: "secret", Pattern: regexp.MustCompile(`(?i)\b((?:api[_-]?key|token|secret|password)\s*[:=]\s*)\S+|\b(?:sk|pk|ghp|xox[abp])[-_][A-Za-z0-9_-]{16,}`), Replace: "${1}[redacted]"}
+)
+
+// Redact returns a middleware applying rules to every text part that is not an attachment. Quoted-printable and
+// base64 parts are decoded first and re-encoded afterwards; headers, including Subject, are left alone.
+func Redact(rules ...RedactRule) MessageMiddleware {
+	return func(m MsgDef) (MsgDef, error) {
+		const op errors.Op = "email.Redact"
+		out, err := rewriteText(m.Msg, redactWith(rules))
+		if err != nil {
+			return m, errors.New(op).Err(err).Msg("redacting message")
+		}
+		m.Msg = out
+		return m, nil
+	}
+}
+
+func redactWith(rules []RedactRule) textRewrite {
+	return func(_, text string) string {
+		for _, r := range rules {
+			repl := r.Replace
+			if repl == "" {
+				repl = "[redacted]"
+			}
+			text = r.Pattern.ReplaceAllString(text, repl)
+		}
+		return text
+	}
+}
+
+// textRewrite returns the new content of a decoded text part of the given media type.
+type textRewrite func(mediaType, text string) string
+
+// rewriteText passes every text part of raw that is not an attachment through fn, decoding and re-encoding its
+// transfer encoding. Parts fn leaves unchanged are kept byte for byte.
+func rewriteText(raw string, fn textRewrite) (string, error) {
+	head, body, ok := strings.Cut(raw, "\r\n\r\n")
+	if !ok {
+		return raw, nil
+	}
+	hdr, err := textproto.NewReader(bufio.NewReader(strings.NewReader(head + "\r\n\r\n"))).ReadMIMEHeader()
+	if err != nil {
+		return "", err
+	}
+	body, err = rewriteEntity(hdr, body, fn)
+	if err != nil {
+		return "", err
+	}
+	return head + "\r\n\r\n" + body, nil
+}
+
+func rewriteEntity(hdr textproto.MIMEHeader, body string, fn textRewrite) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(hdr.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain" // RFC 2045 default
+	}
+	if disp, _, _ := mime.ParseMediaType(hdr.Get("Content-Disposition")); disp == "attachment" {
+		return body, nil
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "":
+		return rewriteMultipart(body, params["boundary"], fn)
+	case strings.HasPrefix(mediaType, "text/"):
+		return rewritePart(hdr.Get("Content-Transfer-Encoding"), mediaType, body, fn)
+	}
+	return body, nil
+}
+
+// rewriteMultipart rewrites each part in place, keeping the delimiters, preamble and epilogue byte for byte.
+func rewriteMultipart(body, boundary string, fn textRewrite) (string, error) {
+	segments := strings.Split("\r\n"+body, "\r\n--"+boundary)
+	for i := 1; i < len(segments); i++ {
+		seg := segments[i]
+		if strings.HasPrefix(seg, "--") {
+			break // close delimiter; the rest is epilogue
+		}
+		line, rest, _ := strings.Cut(seg, "\r\n")
+		var head, partBody string
+		if strings.HasPrefix(rest, "\r\n") {
+			partBody = rest[2:]
+		} else {
+			head, partBody, _ = strings.Cut(rest, "\r\n\r\n")
+		}
+		hdr, err := textproto.NewReader(bufio.NewReader(strings.NewReader(head + "\r\n\r\n"))).ReadMIMEHeader()
+		if err != nil && head != "" {
+			return "", err
+		}
+		if partBody, err = rewriteEntity(hdr, partBody, fn); err != nil {
+			return "", err
+		}
+		if head == "" {
+			segments[i] = line + "\r\n\r\n" + partBody
+		} else {
+			segments[i] = line + "\r\n" + head + "\r\n\r\n" + partBody
+		}
+	}
+	return strings.Join(segments, "\r\n--"+boundary)[2:], nil
+}
+
+func rewritePart(cte, mediaType, body string, fn textRewrite) (string, error) {
+	decoded, err := decodeTransferEncoding(cte, []byte(body))
+	if err != nil {
+		return "", err
+	}
+	text := fn(mediaType, string(decoded))
+	if text == string(decoded) {
+		return body, nil
+	}
+
+	var buf bytes.Buffer
+	switch strings.ToLower(strings.TrimSpace(cte)) {
+	case "base64":
+		lw := &lineWrapper{w: &buf, width: 76}
+		enc := base64.NewEncoder(base64.StdEncoding, lw)
+		_, _ = enc.Write([]byte(text))
+		_ = enc.Close()
+		_ = lw.Close()
+	case "quoted-printable":
+		qp := quotedprintable.NewWriter(&buf)
+		_, _ = qp.Write([]byte(text))
+		_ = qp.Close()
+		if strings.HasSuffix(body, "\r\n") && !bytes.HasSuffix(buf.Bytes(), []byte("\r\n")) {
+			buf.WriteString("\r\n")
+		}
+	default:
+		buf.WriteString(text)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,80 @@
+package email
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestRedactRules(t *testing.T) {
+	cases := []struct {
+		rule     RedactRule
+		in, want string
+	}{
+		{RedactGridSquares, "QTH FN31pr, rover at fn42ab12", "QTH FN31, rover at fn42"},
+		{RedactGridSquares, "worked JO22 on 20m", "worked JO22 on 20m"},
+		{RedactPhoneNumbers, "call (555) 123-4567 or +44 20 7946 0958", "call [phone] or [phone]"},
+		{RedactPhoneNumbers, "on 2024-05-01 at 14.074 MHz", "on 2024-05-01 at 14.074 MHz"},
+		{RedactSecrets, "api_key=abc123 and token: xyz", "api_key=[redacted] and token: [redacted]"},
+		{RedactSecrets, "key ghp_0123456789abcdefABCDEF here", "key [redacted] here"},
+	}
+	for _, c := range cases {
+		got, err := rewritePart("", "text/plain", c.in, redactWith([]RedactRule{c.rule}))
+		if err != nil || got != c.want {
+			t.Errorf("%s(%q) = %q, %v; want %q", c.rule.Name, c.in, got, err, c.want)
+		}
+	}
+}
+
+func TestRedactMiddlewareRewritesTextParts(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{From: "from@example.com", To: "to@example.com"}}
+	body := "Operating from FN31pr this weekend, phone 555-123-4567. " + strings.Repeat("73 ", 40)
+	built, err := s.BuildEmailWithADIFAttachment("", "Log", body, nil, []types.Qso{{LogbookID: 1}})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	origAttachment := partBodies(t, built.Msg)[1]
+
+	out, err := Redact(RedactGridSquares, RedactPhoneNumbers)(built)
+	if err != nil {
+		t.Fatalf("redact: %v", err)
+	}
+	parts := partBodies(t, out.Msg)
+	if !strings.Contains(parts[0], "Operating from FN31 this weekend, phone [phone].") {
+		t.Fatalf("text part not redacted: %q", parts[0])
+	}
+	if parts[1] != origAttachment {
+		t.Fatal("attachment must be left alone")
+	}
+	if v := (MsgDef{Msg: out.Msg}).Validate(); len(v) != 0 {
+		t.Fatalf("redacted message is not compliant: %v", v)
+	}
+}
+
+// partBodies returns the decoded bodies of a multipart message's parts.
+func partBodies(t *testing.T, raw string) []string {
+	t.Helper()
+	m, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	_, params, _ := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	var out []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("next part: %v", err)
+		}
+		b, _ := io.ReadAll(p)
+		out = append(out, string(b))
+	}
+}
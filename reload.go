@@ -0,0 +1,68 @@
+package email
+
+import (
+	"context"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+// config returns the live configuration. Each send takes one snapshot, so a concurrent Reload never mixes the
+// settings of two configurations within a send.
+func (s *Service) config() *types.EmailConfig {
+	if c := s.cfg.Load(); c != nil {
+		return c
+	}
+	return s.Config
+}
+
+// CurrentConfig returns a copy of the configuration in effect, reflecting any Reload.
+func (s *Service) CurrentConfig() types.EmailConfig {
+	return *s.config()
+}
+
+// Reload re-reads the email config from the config service and swaps it in. Sends already underway finish with
+// the configuration they started with. An invalid config is rejected and the current one kept.
+func (s *Service) Reload() error {
+	const op errors.Op = "email.Service.Reload"
+	if !s.isInitialized.Load() {
+		return errors.New(op).Msg(errMsgNotInitialized)
+	}
+	if s.ConfigService == nil {
+		return errors.New(op).Msg("application config has not been set/injected")
+	}
+	cfg, err := s.ConfigService.EmailConfig()
+	if err != nil {
+		return errors.New(op).Err(err).Msg("getting email config")
+	}
+	return s.setConfig(context.Background(), op, cfg, "reload")
+}
+
+// SetConfig validates cfg and swaps it in, as Reload does for a config from another source.
+func (s *Service) SetConfig(cfg types.EmailConfig) error {
+	const op errors.Op = "email.Service.SetConfig"
+	return s.setConfig(context.Background(), op, cfg, "set")
+}
+
+// SetConfigContext is SetConfig, recording the Caller in ctx as the author of the change in the audit log.
+func (s *Service) SetConfigContext(ctx context.Context, cfg types.EmailConfig) error {
+	const op errors.Op = "email.Service.SetConfig"
+	return s.setConfig(ctx, op, cfg, "set")
+}
+
+func (s *Service) setConfig(ctx context.Context, op errors.Op, cfg types.EmailConfig, action string) error {
+	if err := validateEmailConfig(op, &cfg); err != nil {
+		return err
+	}
+	old := s.cfg.Swap(&cfg)
+	if old == nil {
+		old = s.Config
+	}
+	if old != nil {
+		s.audited(ctx, AuditEntry{Kind: AuditConfig, Action: action, Subject: cfg.Name, Detail: configChanges(*old, cfg)})
+	}
+	// The warm connection was authenticated with the old settings
+	s.warm.close()
+	s.LoggerService.InfoWith().Str("profile", cfg.Name).Bool("enabled", cfg.Enabled).Msg("email config reloaded")
+	return nil
+}
@@ -0,0 +1,186 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Retention limits how long personal data, i.e. addresses and subjects, is kept by the send history, the
+// dead-letter queue and Inbound's store of received messages.
+type Retention struct {
+	// MaxAge drops records older than this; zero keeps them until evicted by MaxRecords.
+	MaxAge time.Duration
+	// MaxRecords caps each store, below its built-in capacity; zero leaves only the capacity.
+	MaxRecords int
+}
+
+func (r Retention) limit(capacity int) int {
+	if r.MaxRecords > 0 && r.MaxRecords < capacity {
+		return r.MaxRecords
+	}
+	return capacity
+}
+
+// cutoff returns the time before which records are dropped; zero when MaxAge is unset.
+func (r Retention) cutoff(now time.Time) time.Time {
+	if r.MaxAge <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-r.MaxAge)
+}
+
+// retain keeps the items satisfying keep, then the newest limit of those, and returns how many were dropped.
+// items is ordered oldest first.
+func retain[T any](items []T, limit int, keep func(T) bool) ([]T, int) {
+	n := len(items)
+	items = slices.DeleteFunc(items, func(it T) bool { return !keep(it) })
+	if extra := len(items) - limit; extra > 0 {
+		items = append(items[:0], items[extra:]...)
+	}
+	return items, n - len(items)
+}
+
+func (h *sendHistory) prune(limit int, keep func(HistoryRecord) bool) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var n int
+	h.records, n = retain(h.records, limit, keep)
+	return n
+}
+
+func (q *deadLetterQueue) prune(limit int, keep func(DeadLetter) bool) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var n int
+	q.items, n = retain(q.items, limit, keep)
+	return n
+}
+
+// applyRetention drops what Service.Retention no longer allows to be kept.
+func (s *Service) applyRetention() {
+	if s.Retention == (Retention{}) {
+		return
+	}
+	cutoff := s.Retention.cutoff(s.now())
+	s.history.prune(s.Retention.limit(historyCapacity), func(rec HistoryRecord) bool { return !rec.SentAt.Before(cutoff) })
+	s.deadLetters.prune(s.Retention.limit(deadLetterCapacity), func(dl DeadLetter) bool { return !dl.DiedAt.Before(cutoff) })
+}
+
+// Purge drops the send history and dead letters from before t, returning how many records were dropped. Messages
+// still queued are kept.
+func (s *Service) Purge(t time.Time) int {
+	n := s.history.prune(historyCapacity, func(rec HistoryRecord) bool { return !rec.SentAt.Before(t) })
+	n += s.deadLetters.prune(deadLetterCapacity, func(dl DeadLetter) bool { return !dl.DiedAt.Before(t) })
+	s.LoggerService.InfoWith().Int("records", n).Time("before", t).Msg("send history purged")
+	return n
+}
+
+// PurgeAddress drops every history record and dead letter sent from or to addr, returning how many were dropped.
+// The suppression list is kept, so that an address asking to be forgotten is still not mailed again; see
+// Unsuppress. The change is recorded in the audit log, without the address.
+func (s *Service) PurgeAddress(ctx context.Context, addr string) int {
+	key := suppressionKey(addr)
+	n := s.history.prune(historyCapacity, func(rec HistoryRecord) bool { return !s.mentions(key, rec.From, rec.To) })
+	n += s.deadLetters.prune(deadLetterCapacity, func(dl DeadLetter) bool { return !s.mentions(key, dl.Msg.From, dl.Msg.To) })
+	s.audited(ctx, AuditEntry{Kind: AuditRecipients, Action: "purge", Detail: fmt.Sprintf("%d records erased on request", n)})
+	return n
+}
+
+// PersonalData is everything the Service holds about one address, see ExportData.
+type PersonalData struct {
+	Address     string
+	History     []HistoryRecord
+	Queued      []QueuedMessage
+	DeadLetters []DeadLetter
+	// Suppression is the address's entry on the suppression list, if any.
+	Suppression *Suppression
+}
+
+// ExportData returns what the Service holds about addr, for a data subject's access request. Received mail is
+// held by Inbound; see Inbound.ExportData.
+func (s *Service) ExportData(addr string) PersonalData {
+	key := suppressionKey(addr)
+	out := PersonalData{Address: key}
+	for _, rec := range s.History() {
+		if s.mentions(key, rec.From, rec.To) {
+			out.History = append(out.History, rec)
+		}
+	}
+	for _, m := range s.Queued() {
+		if s.mentions(key, m.Msg.From, m.Msg.To) {
+			out.Queued = append(out.Queued, m)
+		}
+	}
+	for _, dl := range s.DeadLetters() {
+		if s.mentions(key, dl.Msg.From, dl.Msg.To) {
+			out.DeadLetters = append(out.DeadLetters, dl)
+		}
+	}
+	for _, sup := range s.Suppressions() {
+		if sup.Address == key {
+			out.Suppression = &sup
+			break
+		}
+	}
+	return out
+}
+
+// mentions reports whether key, as returned by suppressionKey, is from or among to.
+func mentions(key, from string, to []string) bool {
+	if suppressionKey(from) == key {
+		return true
+	}
+	return slices.ContainsFunc(to, func(addr string) bool { return suppressionKey(addr) == key })
+}
+
+// mentions also matches key as the history records it when Anonymize is set.
+func (s *Service) mentions(key, from string, to []string) bool {
+	return mentions(key, from, to) || (s.Anonymize != nil && mentions(s.recordedAddress(key), from, to))
+}
+
+// Purge drops the received messages from before t, returning how many were dropped.
+func (in *Inbound) Purge(t time.Time) int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	var n int
+	in.recent, n = retain(in.recent, inboundStoreCapacity, func(m *InboundMessage) bool { return !m.Received.Before(t) })
+	return n
+}
+
+// PurgeAddress drops every received message from or to addr, returning how many were dropped.
+func (in *Inbound) PurgeAddress(addr string) int {
+	key := suppressionKey(addr)
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	var n int
+	in.recent, n = retain(in.recent, inboundStoreCapacity, func(m *InboundMessage) bool { return !m.mentions(key) })
+	return n
+}
+
+// ExportData returns the received messages from or to addr, oldest first.
+func (in *Inbound) ExportData(addr string) []*InboundMessage {
+	key := suppressionKey(addr)
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	var out []*InboundMessage
+	for _, m := range in.recent {
+		if m.mentions(key) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func (m *InboundMessage) mentions(key string) bool {
+	var to []string
+	for _, field := range []string{"To", "Cc"} {
+		if list, err := m.Header.AddressList(field); err == nil {
+			for _, a := range list {
+				to = append(to, a.Address)
+			}
+		}
+	}
+	return mentions(key, m.Header.Get("From"), to)
+}
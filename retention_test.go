@@ -0,0 +1,73 @@
+package email
+
+import (
+	"context"
+	"net/mail"
+	"testing"
+	"time"
+)
+
+func TestRetentionDropsOldAndExcessRecords(t *testing.T) {
+	s := &Service{Retention: Retention{MaxAge: 24 * time.Hour, MaxRecords: 2}}
+	now := time.Now().UTC()
+	for i, age := range []time.Duration{48 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour} {
+		s.history.add(HistoryRecord{MessageID: string(rune('a' + i)), SentAt: now.Add(-age)})
+	}
+	got := s.History()
+	if len(got) != 2 || got[0].MessageID != "c" || got[1].MessageID != "d" {
+		t.Fatalf("history = %+v", got)
+	}
+}
+
+func TestPurgeAndExportByAddress(t *testing.T) {
+	s := &Service{}
+	now := time.Now().UTC()
+	s.history.add(HistoryRecord{MessageID: "old", From: "op@example.org", To: []string{"dx@example.com"}, SentAt: now.Add(-72 * time.Hour)})
+	s.history.add(HistoryRecord{MessageID: "one", From: "op@example.org", To: []string{"Ham <Ham@Example.com>"}, SentAt: now})
+	s.history.add(HistoryRecord{MessageID: "two", From: "op@example.org", To: []string{"dx@example.com"}, SentAt: now})
+	s.deadLetters.add(&QueuedMessage{ID: "q1", Msg: MsgDef{From: "op@example.org", To: []string{"ham@example.com"}}}, DeadReasonFailed, time.Now())
+	if err := s.Suppress(Suppression{Address: "ham@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := s.ExportData("HAM@example.com")
+	if len(data.History) != 1 || data.History[0].MessageID != "one" || len(data.DeadLetters) != 1 || data.Suppression == nil {
+		t.Fatalf("export = %+v", data)
+	}
+
+	if n := s.Purge(now.Add(-24 * time.Hour)); n != 1 {
+		t.Fatalf("purged %d records before the cutoff, want 1", n)
+	}
+	if n := s.PurgeAddress(context.Background(), "ham@example.com"); n != 2 {
+		t.Fatalf("purged %d records of the address, want 2", n)
+	}
+	if h := s.History(); len(h) != 1 || h[0].MessageID != "two" {
+		t.Fatalf("history after purge = %+v", h)
+	}
+	if len(s.DeadLetters()) != 0 {
+		t.Fatal("dead letter to the address survived the purge")
+	}
+	if len(s.Suppressions()) != 1 {
+		t.Fatal("the suppression must outlive the purge")
+	}
+	log, _ := s.AuditLog()
+	if last := log[len(log)-1]; last.Action != "purge" || last.Subject != "" {
+		t.Fatalf("audit entry = %+v", last)
+	}
+}
+
+func TestInboundRetentionAndExport(t *testing.T) {
+	in := &Inbound{Retention: Retention{MaxRecords: 2}}
+	for _, from := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		in.remember(&InboundMessage{ID: from, Header: mail.Header{"From": {from}, "To": {"op@example.org"}}})
+	}
+	if got := in.ExportData("op@example.org"); len(got) != 2 || got[0].ID != "b@example.com" {
+		t.Fatalf("export = %+v", got)
+	}
+	if n := in.PurgeAddress("B@example.com"); n != 1 {
+		t.Fatalf("purged %d, want 1", n)
+	}
+	if n := in.Purge(time.Now().Add(time.Minute)); n != 1 {
+		t.Fatalf("purged %d, want 1", n)
+	}
+}
@@ -0,0 +1,226 @@
+package email
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+const (
+	// retryProfileWeight is the weight of the newest sample in the moving averages of a RetryProfile
+	retryProfileWeight = 0.25
+	// adaptiveMinBackoff is the shortest queued retry delay a learned profile can produce
+	adaptiveMinBackoff = 15 * time.Second
+)
+
+// RetryProfile is what has been learned about an SMTP host from past attempts, used by QueueConfig.AdaptiveRetry
+// to time queued retries to it.
+type RetryProfile struct {
+	Host              string
+	Successes         int
+	TransientFailures int
+	// Latency is the moving average time to hand a message to the host.
+	Latency time.Duration
+	// Recovery is the moving average time from a transient failure to the next success, i.e. how long the host's
+	// outages last.
+	Recovery time.Duration
+	// FailingSince is when the current run of transient failures began; zero after a success.
+	FailingSince time.Time
+	Updated      time.Time
+}
+
+// RetryProfileStore persists the learned retry profiles across restarts.
+type RetryProfileStore interface {
+	Save(profiles []RetryProfile) error
+	Load() ([]RetryProfile, error)
+}
+
+type retryProfiles struct {
+	mu     sync.Mutex
+	byHost map[string]*RetryProfile
+}
+
+func ewma(avg, sample time.Duration) time.Duration {
+	if avg == 0 {
+		return sample
+	}
+	return avg + time.Duration(retryProfileWeight*float64(sample-avg))
+}
+
+func (r *retryProfiles) profile(host string) *RetryProfile {
+	if r.byHost == nil {
+		r.byHost = map[string]*RetryProfile{}
+	}
+	p, ok := r.byHost[host]
+	if !ok {
+		p = &RetryProfile{Host: host}
+		r.byHost[host] = p
+	}
+	return p
+}
+
+func (r *retryProfiles) success(host string, latency time.Duration, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.profile(host)
+	p.Successes++
+	p.Latency = ewma(p.Latency, latency)
+	if !p.FailingSince.IsZero() {
+		p.Recovery = ewma(p.Recovery, now.Sub(p.FailingSince))
+		p.FailingSince = time.Time{}
+	}
+	p.Updated = now
+}
+
+func (r *retryProfiles) failure(host string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.profile(host)
+	p.TransientFailures++
+	if p.FailingSince.IsZero() {
+		p.FailingSince = now
+	}
+	p.Updated = now
+}
+
+// delay returns the learned wait before attempt n+1 to host: half the typical outage, so that a retry usually
+// lands soon after the host recovers, doubling with each attempt. ok is false until an outage has been seen.
+func (r *retryProfiles) delay(host string, attempts int) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, found := r.byHost[host]
+	if !found || p.Recovery == 0 {
+		return 0, false
+	}
+	d := min(max(p.Recovery/2, adaptiveMinBackoff), queueMaxBackoff)
+	for i := 1; i < attempts && d < queueMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, queueMaxBackoff), true
+}
+
+func (r *retryProfiles) snapshot() []RetryProfile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]RetryProfile, 0, len(r.byHost))
+	for _, p := range r.byHost {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+func (r *retryProfiles) restore(list []RetryProfile) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range list {
+		*r.profile(p.Host) = p
+	}
+}
+
+// RetryProfiles returns what has been learned about each SMTP host, ordered by host.
+func (s *Service) RetryProfiles() []RetryProfile {
+	return s.retryProfiles.snapshot()
+}
+
+// retryDelay returns the wait before queued attempt n+1 of a message to host: QueueConfig.UnconfirmedDelay after an
+// attempt that may have been accepted, QueueConfig.ProbeInterval while a spooled message's host is unreachable,
+// otherwise learned from the host's history when QueueConfig.AdaptiveRetry is
+// set and the history allows, or the static backoff.
+func (s *Service) retryDelay(host string, attempts int, err error) time.Duration {
+	if isAcceptanceUnknown(err) {
+		return s.QueueConfig.unconfirmedDelay()
+	}
+	if s.spools(err) {
+		return s.QueueConfig.probeInterval()
+	}
+	if s.QueueConfig.AdaptiveRetry && !isGreylisted(err) {
+		if d, ok := s.retryProfiles.delay(strings.ToLower(host), attempts); ok {
+			return d
+		}
+	}
+	return s.QueueConfig.retryDelay(attempts, err)
+}
+
+// learnOutcome feeds an attempt's outcome into the host's retry profile and persists the profiles. Permanent
+// failures say nothing about when to retry and are ignored.
+func (s *Service) learnOutcome(host string, latency time.Duration, err error) {
+	if !s.QueueConfig.AdaptiveRetry {
+		return
+	}
+	host = strings.ToLower(host)
+	switch {
+	case err == nil:
+		s.retryProfiles.success(host, latency, time.Now())
+	case isTransient(err) && !isGreylisted(err):
+		s.retryProfiles.failure(host, time.Now())
+	default:
+		return
+	}
+	if s.RetryProfileStore != nil {
+		if serr := s.RetryProfileStore.Save(s.retryProfiles.snapshot()); serr != nil {
+			s.LoggerService.ErrorWith().Err(serr).Msg("failed to persist retry profiles")
+		}
+	}
+}
+
+// restoreRetryProfiles loads the profiles learned by a previous run.
+func (s *Service) restoreRetryProfiles() {
+	if s.RetryProfileStore == nil {
+		return
+	}
+	list, err := s.RetryProfileStore.Load()
+	if err != nil {
+		s.LoggerService.ErrorWith().Err(err).Msg("failed to load retry profiles")
+		return
+	}
+	s.retryProfiles.restore(list)
+}
+
+// FileRetryProfileStore keeps the retry profiles in a single JSON file.
+type FileRetryProfileStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (f *FileRetryProfileStore) Save(profiles []RetryProfile) error {
+	const op errors.Op = "email.FileRetryProfileStore.Save"
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to encode retry profiles")
+	}
+	if err = os.MkdirAll(filepath.Dir(f.Path), 0o700); err != nil {
+		return errors.New(op).Err(err).Msg("failed to create retry profile directory")
+	}
+	if err = writeFileAtomic(f.Path, data, 0o600); err != nil {
+		return errors.New(op).Err(err).Msg("failed to write retry profiles")
+	}
+	return nil
+}
+
+func (f *FileRetryProfileStore) Load() ([]RetryProfile, error) {
+	const op errors.Op = "email.FileRetryProfileStore.Load"
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, err := os.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to read retry profiles")
+	}
+	var out []RetryProfile
+	if err = json.Unmarshal(data, &out); err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to decode retry profiles")
+	}
+	return out, nil
+}
@@ -0,0 +1,74 @@
+package email
+
+import (
+	"net/textproto"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetryProfileLearnsOutages(t *testing.T) {
+	var r retryProfiles
+	t0 := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	if _, ok := r.delay("smtp.gmail.com", 1); ok {
+		t.Fatal("delay learned without history")
+	}
+
+	// A flaky relay is down for ten minutes at a time; the second failure of an outage does not restart it
+	r.failure("relay.club.example", t0)
+	r.failure("relay.club.example", t0.Add(time.Minute))
+	r.success("relay.club.example", 2*time.Second, t0.Add(10*time.Minute))
+	for attempts, want := range map[int]time.Duration{1: 5 * time.Minute, 2: 10 * time.Minute, 10: queueMaxBackoff} {
+		if got, _ := r.delay("relay.club.example", attempts); got != want {
+			t.Errorf("delay after %d attempts = %v, want %v", attempts, got, want)
+		}
+	}
+
+	// Gmail recovers within seconds, so retries are not held back for the static minute
+	r.failure("smtp.gmail.com", t0)
+	r.success("smtp.gmail.com", time.Second, t0.Add(4*time.Second))
+	if got, _ := r.delay("smtp.gmail.com", 1); got != adaptiveMinBackoff {
+		t.Errorf("gmail delay = %v, want %v", got, adaptiveMinBackoff)
+	}
+
+	// A further outage moves the average rather than replacing it
+	r.failure("relay.club.example", t0.Add(time.Hour))
+	r.success("relay.club.example", 2*time.Second, t0.Add(time.Hour+30*time.Minute))
+	p := r.snapshot()[0]
+	if p.Host != "relay.club.example" || p.Recovery != 15*time.Minute || p.TransientFailures != 3 || p.Successes != 2 {
+		t.Errorf("profile = %+v", p)
+	}
+}
+
+func TestAdaptiveRetryDelay(t *testing.T) {
+	transient := &textproto.Error{Code: 421, Msg: "service not available"}
+	greylisted := &textproto.Error{Code: 451, Msg: "4.7.1 greylisted, try again later"}
+	store := &FileRetryProfileStore{Path: filepath.Join(t.TempDir(), "retry.json")}
+	s := &Service{QueueConfig: QueueConfig{AdaptiveRetry: true}, RetryProfileStore: store}
+
+	s.learnOutcome("Relay.Club.Example", 0, transient)
+	s.learnOutcome("relay.club.example", 0, &textproto.Error{Code: 550, Msg: "no such user"})
+	s.retryProfiles.byHost["relay.club.example"].FailingSince = time.Now().Add(-20 * time.Minute)
+	s.learnOutcome("relay.club.example", time.Second, nil)
+	if got := s.retryDelay("relay.club.example", 1, transient); got < 9*time.Minute || got > 11*time.Minute {
+		t.Errorf("adaptive delay = %v, want about ten minutes", got)
+	}
+	if got := s.retryDelay("relay.club.example", 1, greylisted); got != s.QueueConfig.greylistDelay() {
+		t.Errorf("greylisted delay = %v, want the greylist window", got)
+	}
+	if got := s.retryDelay("other.example", 1, transient); got != queueBaseBackoff {
+		t.Errorf("unknown host delay = %v, want the static backoff", got)
+	}
+
+	restored := &Service{QueueConfig: QueueConfig{AdaptiveRetry: true}, RetryProfileStore: store}
+	restored.restoreRetryProfiles()
+	got := restored.RetryProfiles()
+	if len(got) != 1 || got[0].TransientFailures != 1 || got[0].Successes != 1 || got[0].Recovery == 0 {
+		t.Fatalf("restored profiles = %+v", got)
+	}
+
+	s.QueueConfig.AdaptiveRetry = false
+	if got := s.retryDelay("relay.club.example", 1, transient); got != queueBaseBackoff {
+		t.Errorf("delay with adaptive retry off = %v, want the static backoff", got)
+	}
+}
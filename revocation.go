@@ -0,0 +1,153 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	stderr "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Station-Manager/types"
+	"golang.org/x/crypto/ocsp"
+)
+
+// defaultOCSPTimeout bounds a query of the OCSP responder when RevocationConfig.Timeout is unset.
+const defaultOCSPTimeout = 5 * time.Second
+
+// maxOCSPResponse bounds the responses read from OCSP responders.
+const maxOCSPResponse = 64 << 10
+
+// errCertRevocation marks a server certificate refused by the revocation check.
+var errCertRevocation = stderr.New("certificate revocation check failed")
+
+// RevocationPolicy selects what a RevocationConfig does when the revocation status of the SMTP server's certificate
+// cannot be determined. A certificate known to be revoked is always refused.
+type RevocationPolicy int
+
+const (
+	// RevocationSoftFail connects when the status cannot be determined, e.g. when the OCSP responder is down.
+	RevocationSoftFail RevocationPolicy = iota
+	// RevocationHardFail refuses the connection unless the certificate is confirmed good.
+	RevocationHardFail
+)
+
+// RevocationConfig checks the SMTP server's certificate against OCSP (RFC 6960), preferring the response the
+// server staples to the handshake and querying the certificate's responder otherwise.
+type RevocationConfig struct {
+	Policy RevocationPolicy
+	// RequireStapled refuses servers that do not staple an OCSP response, rather than querying the responder.
+	RequireStapled bool
+	// Timeout bounds a query of the OCSP responder; defaults to 5 seconds.
+	Timeout time.Duration
+	Client  *http.Client
+}
+
+type revocationKey struct{}
+
+// withRevocation carries Service.Revocation to the TLS handshakes of the SMTP session.
+func withRevocation(ctx context.Context, rc *RevocationConfig) context.Context {
+	if rc == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, revocationKey{}, rc)
+}
+
+// sessionContext carries the settings of an SMTP session for cfg: the dial timeout, trusted CAs, crypto
+// restrictions and revocation check.
+func (s *Service) sessionContext(ctx context.Context, cfg *types.EmailConfig) context.Context {
+	ctx = withStrictCrypto(withRootCAs(withDialTimeout(ctx, dialTimeout(cfg)), s.RootCAs), s.StrictCrypto)
+	return withRevocation(ctx, s.Revocation)
+}
+
+// tlsConfig returns the TLS config for host, restricted under strict crypto and checking revocation when ctx
+// carries a RevocationConfig.
+func tlsConfig(ctx context.Context, host string) *tls.Config {
+	cfg := &tls.Config{ServerName: host, RootCAs: rootCAsFromContext(ctx)}
+	if strictCryptoFromContext(ctx) {
+		restrictTLS(cfg)
+	}
+	if rc, ok := ctx.Value(revocationKey{}).(*RevocationConfig); ok {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return rc.check(ctx, host, cs)
+		}
+	}
+	return cfg
+}
+
+// check runs after the chain has been verified.
+func (rc *RevocationConfig) check(ctx context.Context, host string, cs tls.ConnectionState) error {
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) < 2 {
+		return rc.undetermined(host, stderr.New("no issuer to check the certificate against"))
+	}
+	leaf, issuer := cs.VerifiedChains[0][0], cs.VerifiedChains[0][1]
+	raw := cs.OCSPResponse
+	if len(raw) == 0 {
+		if rc.RequireStapled {
+			return fmt.Errorf("%w: %w: %s did not staple an OCSP response", errTLSFailed, errCertRevocation, host)
+		}
+		var err error
+		if raw, err = rc.query(ctx, leaf, issuer); err != nil {
+			return rc.undetermined(host, err)
+		}
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return rc.undetermined(host, err)
+	}
+	switch {
+	case resp.Status == ocsp.Revoked:
+		return fmt.Errorf("%w: %w: the certificate of %s was revoked on %s", errTLSFailed, errCertRevocation, host,
+			resp.RevokedAt.Format(time.DateOnly))
+	case resp.Status != ocsp.Good:
+		return rc.undetermined(host, stderr.New("the responder does not know the certificate"))
+	case !resp.NextUpdate.IsZero() && time.Now().After(resp.NextUpdate):
+		return rc.undetermined(host, stderr.New("the OCSP response is out of date"))
+	}
+	return nil
+}
+
+func (rc *RevocationConfig) undetermined(host string, err error) error {
+	if rc.Policy != RevocationHardFail {
+		return nil
+	}
+	return fmt.Errorf("%w: %w: revocation status of %s unknown: %w", errTLSFailed, errCertRevocation, host, err)
+}
+
+// query asks the certificate's OCSP responder for its status.
+func (rc *RevocationConfig) query(ctx context.Context, leaf, issuer *x509.Certificate) ([]byte, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, stderr.New("the certificate names no OCSP responder")
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	timeout := rc.Timeout
+	if timeout <= 0 {
+		timeout = defaultOCSPTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, leaf.OCSPServer[0], bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/ocsp-request")
+	client := rc.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder answered HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxOCSPResponse))
+}
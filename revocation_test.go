@@ -0,0 +1,113 @@
+package email
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	stderr "errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testChain issues a CA and a leaf for smtp.example.com whose OCSP responder is ocspURL.
+func testChain(t *testing.T, ocspURL string) (leaf, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey) {
+	t.Helper()
+	issuerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Test CA"}, NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour), IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &issuerKey.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issuer, err = x509.ParseCertificate(caDER); err != nil {
+		t.Fatal(err)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTmpl := &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "smtp.example.com"}, DNSNames: []string{"smtp.example.com"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour), OCSPServer: []string{ocspURL}}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, issuer, &leafKey.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf, err = x509.ParseCertificate(leafDER); err != nil {
+		t.Fatal(err)
+	}
+	return leaf, issuer, issuerKey
+}
+
+func TestRevocationCheck(t *testing.T) {
+	status := ocsp.Good
+	var leaf, issuer *x509.Certificate
+	var issuerKey *ecdsa.PrivateKey
+	respond := func() []byte {
+		resp, err := ocsp.CreateResponse(issuer, issuer, ocsp.Response{Status: status, SerialNumber: leaf.SerialNumber,
+			ThisUpdate: time.Now().Add(-time.Minute), NextUpdate: time.Now().Add(time.Hour), RevokedAt: time.Now().Add(-time.Minute)}, issuerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	queries, down := 0, false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		if _, err := io.ReadAll(r.Body); err != nil || down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(respond())
+	}))
+	t.Cleanup(ts.Close)
+	leaf, issuer, issuerKey = testChain(t, ts.URL)
+	cs := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, issuer}}}
+	ctx := t.Context()
+
+	soft := &RevocationConfig{}
+	hard := &RevocationConfig{Policy: RevocationHardFail}
+	if err := hard.check(ctx, "smtp.example.com", cs); err != nil || queries != 1 {
+		t.Fatalf("good certificate refused: %v (queries %d)", err, queries)
+	}
+
+	// A revoked certificate is refused under either policy, and reported as a TLS failure
+	status = ocsp.Revoked
+	for _, rc := range []*RevocationConfig{soft, hard} {
+		err := rc.check(ctx, "smtp.example.com", cs)
+		if !stderr.Is(err, errCertRevocation) || ReasonOf(err) != ReasonTLSFailed {
+			t.Fatalf("revoked certificate gave %v", err)
+		}
+	}
+
+	// An unreachable responder only fails the hard policy
+	down = true
+	if err := soft.check(ctx, "smtp.example.com", cs); err != nil {
+		t.Fatalf("soft fail refused: %v", err)
+	}
+	if err := hard.check(ctx, "smtp.example.com", cs); !stderr.Is(err, errCertRevocation) {
+		t.Fatalf("hard fail allowed: %v", err)
+	}
+
+	// A stapled response is used without asking the responder
+	status, queries = ocsp.Good, 0
+	stapled := cs
+	stapled.OCSPResponse = respond()
+	if err := hard.check(ctx, "smtp.example.com", stapled); err != nil || queries != 0 {
+		t.Fatalf("stapled response: %v (queries %d)", err, queries)
+	}
+	if err := (&RevocationConfig{RequireStapled: true}).check(ctx, "smtp.example.com", cs); !stderr.Is(err, errCertRevocation) || queries != 0 {
+		t.Fatalf("missing staple allowed: %v", err)
+	}
+}
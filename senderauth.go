@@ -0,0 +1,159 @@
+package email
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+// Sender authentication modes for inbound command emails.
+const (
+	// SenderAuthDKIMOrSPF accepts a DKIM or SPF pass aligned with the From domain (default).
+	SenderAuthDKIMOrSPF = "dkim-or-spf"
+	// SenderAuthDKIM accepts only an aligned DKIM pass.
+	SenderAuthDKIM = "dkim"
+	// SenderAuthNone disables verification; only AllowedSenders is enforced.
+	SenderAuthNone = "none"
+)
+
+// SenderAuthPolicy decides whether an inbound command email may be acted upon.
+type SenderAuthPolicy struct {
+	Mode string
+	// AuthServID is the authserv-id used by the receiving MTA in Authentication-Results headers. Results from any
+	// other authserv-id are ignored since the sender could have forged them.
+	AuthServID string
+	// AllowedSenders restricts commands to these From addresses; empty allows any authenticated sender.
+	AllowedSenders []string
+}
+
+// Verify returns an error describing why msg failed the policy, or nil when it may be acted upon.
+func (p *SenderAuthPolicy) Verify(msg *InboundMessage) error {
+	const op errors.Op = "email.SenderAuthPolicy.Verify"
+	sender := msg.Sender()
+	if sender == "" {
+		return errors.New(op).Msg("command email has no valid From address")
+	}
+	if len(p.AllowedSenders) > 0 {
+		allowed := false
+		for _, a := range p.AllowedSenders {
+			if strings.EqualFold(strings.TrimSpace(a), sender) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return errors.New(op).Msgf("sender %s is not allowed to send commands", sender)
+		}
+	}
+
+	_, fromDomain, _ := strings.Cut(sender, "@")
+	switch strings.ToLower(strings.TrimSpace(p.Mode)) {
+	case SenderAuthNone:
+		return nil
+	case "", SenderAuthDKIMOrSPF:
+		res := p.authResults(msg)
+		if res.dkimPass(fromDomain) || res.spfPass(fromDomain) {
+			return nil
+		}
+		return errors.New(op).Msgf("no aligned DKIM or SPF pass for %s", sender)
+	case SenderAuthDKIM:
+		if p.authResults(msg).dkimPass(fromDomain) {
+			return nil
+		}
+		return errors.New(op).Msgf("no aligned DKIM pass for %s", sender)
+	default:
+		return errors.New(op).Msgf("unknown sender authentication mode %q", p.Mode)
+	}
+}
+
+// Guard wraps an inbound handler so that only messages passing the policy reach it.
+func (p *SenderAuthPolicy) Guard(next func(*InboundMessage) error) func(*InboundMessage) error {
+	return func(msg *InboundMessage) error {
+		if err := p.Verify(msg); err != nil {
+			return err
+		}
+		return next(msg)
+	}
+}
+
+// authResult is one method result from an Authentication-Results header (RFC 8601).
+type authResult struct {
+	method string
+	result string
+	props  map[string]string
+}
+
+type authResults []authResult
+
+var authResultsComment = regexp.MustCompile(`\([^()]*\)`)
+
+// authResults collects the results stamped by the trusted authserv-id.
+func (p *SenderAuthPolicy) authResults(msg *InboundMessage) authResults {
+	var out authResults
+	for _, raw := range msg.Header["Authentication-Results"] {
+		v := authResultsComment.ReplaceAllString(raw, "")
+		specs := strings.Split(v, ";")
+		if len(specs) < 2 {
+			continue
+		}
+		id := strings.Fields(specs[0])
+		if len(id) == 0 || p.AuthServID == "" || !strings.EqualFold(id[0], p.AuthServID) {
+			continue
+		}
+		for _, spec := range specs[1:] {
+			fields := strings.Fields(spec)
+			if len(fields) == 0 {
+				continue
+			}
+			method, result, ok := strings.Cut(fields[0], "=")
+			if !ok {
+				continue
+			}
+			r := authResult{method: strings.ToLower(method), result: strings.ToLower(result), props: map[string]string{}}
+			for _, f := range fields[1:] {
+				if k, val, ok := strings.Cut(f, "="); ok {
+					r.props[strings.ToLower(k)] = strings.Trim(val, `"`)
+				}
+			}
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func (rs authResults) dkimPass(fromDomain string) bool {
+	for _, r := range rs {
+		if r.method == "dkim" && r.result == "pass" && domainsAligned(r.props["header.d"], fromDomain) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rs authResults) spfPass(fromDomain string) bool {
+	for _, r := range rs {
+		if r.method != "spf" || r.result != "pass" {
+			continue
+		}
+		d := r.props["smtp.mailfrom"]
+		if _, after, ok := strings.Cut(d, "@"); ok {
+			d = after
+		}
+		if domainsAligned(d, fromDomain) {
+			return true
+		}
+	}
+	return false
+}
+
+// domainsAligned implements DMARC relaxed alignment without a public-suffix list: one domain must equal or be a
+// subdomain of the other.
+func domainsAligned(a, b string) bool {
+	a = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(a), "."))
+	b = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(b), "."))
+	if a == "" || b == "" {
+		return false
+	}
+	return a == b || strings.HasSuffix(a, "."+b) || strings.HasSuffix(b, "."+a)
+}
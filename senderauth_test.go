@@ -0,0 +1,42 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSenderAuthPolicyAuthenticationResults(t *testing.T) {
+	p := &SenderAuthPolicy{AuthServID: "mx.club.example.org"}
+	mk := func(results string) *InboundMessage {
+		raw := "From: K1ABC <k1abc@example.com>\r\n" + results + "Subject: export my log\r\n\r\n"
+		msg, err := ParseInbound(strings.NewReader(raw))
+		if err != nil {
+			t.Fatalf("ParseInbound failed: %v", err)
+		}
+		return msg
+	}
+
+	cases := []struct {
+		name    string
+		results string
+		ok      bool
+	}{
+		{"dkim pass", "Authentication-Results: mx.club.example.org; dkim=pass (good sig) header.d=mail.example.com header.s=s1\r\n", true},
+		{"spf pass", "Authentication-Results: mx.club.example.org; spf=pass smtp.mailfrom=bounce@example.com\r\n", true},
+		{"misaligned dkim", "Authentication-Results: mx.club.example.org; dkim=pass header.d=evil.example.net\r\n", false},
+		{"forged authserv-id", "Authentication-Results: mx.evil.example.net; dkim=pass header.d=example.com\r\n", false},
+		{"dkim fail", "Authentication-Results: mx.club.example.org; dkim=fail header.d=example.com\r\n", false},
+		{"no results", "", false},
+	}
+	for _, tc := range cases {
+		err := p.Verify(mk(tc.results))
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: expected ok=%v, got err=%v", tc.name, tc.ok, err)
+		}
+	}
+
+	p.AllowedSenders = []string{"w1aw@example.com"}
+	if err := p.Verify(mk(cases[0].results)); err == nil {
+		t.Errorf("expected sender outside allow-list to be rejected")
+	}
+}
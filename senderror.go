@@ -0,0 +1,60 @@
+package email
+
+import (
+	stderr "errors"
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+// SendError describes a send that failed, for callers to react to without parsing messages. The Send methods
+// return it wrapped; use errors.As to get it.
+type SendError struct {
+	// Code is the SMTP reply code, e.g. 550 for a mailbox that does not exist; zero when the failure was not a
+	// reply, such as a lost connection.
+	Code int
+	// Permanent is set when sending the message again will fail the same way: a 5xx reply, which is not retried,
+	// or a local fault such as an invalid address. Otherwise a later attempt may succeed.
+	Permanent bool
+	// Recipients are the addresses the message was not delivered to.
+	Recipients []string
+	Reason     Reason
+	// Hints suggest what the user can change to make the send succeed.
+	Hints []Hint
+	Err   error
+}
+
+func (e *SendError) Error() string {
+	kind := "transient"
+	if e.Permanent {
+		kind = "permanent"
+	}
+	return fmt.Sprintf("%s failure sending to %s: %v", kind, strings.Join(e.Recipients, ", "), e.Err)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// newSendError classifies err, the failure of the last attempt to send to recipients.
+func newSendError(err error, recipients []string) *SendError {
+	se := &SendError{Permanent: !isTransient(err), Recipients: append([]string(nil), recipients...), Reason: classifyReason(err),
+		Hints: remediate(err), Err: err}
+	var tpErr *textproto.Error
+	if stderr.As(err, &tpErr) {
+		se.Code = tpErr.Code
+	}
+	return se
+}
+
+// isAuthFailure reports whether err is the server rejecting the credentials.
+func isAuthFailure(err error) bool {
+	var tpErr *textproto.Error
+	return stderr.As(err, &tpErr) && (tpErr.Code == 530 || tpErr.Code == 534 || tpErr.Code == 535)
+}
+
+// isPermanentReply reports whether err is a 5xx SMTP reply, which the server would give again.
+func isPermanentReply(err error) bool {
+	var tpErr *textproto.Error
+	return stderr.As(err, &tpErr) && tpErr.Code >= 500
+}
@@ -0,0 +1,55 @@
+package email
+
+import (
+	"context"
+	stderr "errors"
+	"io"
+	"net/smtp"
+	"net/textproto"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestPermanentReplyIsNotRetried(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "op@example.org", SmtpRetryCount: 3}}
+	s.isInitialized.Store(true)
+
+	calls := 0
+	reply := error(&textproto.Error{Code: 550, Msg: "5.1.1 mailbox does not exist"})
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		calls++
+		return deliveryInfo{}, reply
+	})
+
+	err := s.Send(MsgDef{To: []string{"nobody@example.com"}, Msg: "Subject: qsl\r\n\r\n73\r\n"})
+	var se *SendError
+	if !stderr.As(err, &se) {
+		t.Fatalf("expected a SendError, got %v", err)
+	}
+	if calls != 1 || se.Code != 550 || !se.Permanent || len(se.Recipients) != 1 || se.Recipients[0] != "nobody@example.com" {
+		t.Fatalf("calls=%d error=%+v", calls, se)
+	}
+
+	// A lost connection is retried, and reported as transient once the retries run out
+	calls, reply = 0, io.EOF
+	err = s.Send(MsgDef{To: []string{"dx@example.com"}, Msg: "Subject: qsl\r\n\r\n73\r\n"})
+	if !stderr.As(err, &se) || calls != 4 || se.Permanent || se.Code != 0 || !stderr.Is(err, io.EOF) {
+		t.Fatalf("calls=%d err=%v", calls, err)
+	}
+}
+
+func TestSendErrorCarriesHints(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.gmail.com", Port: 587, From: "op@example.org"}}
+	s.isInitialized.Store(true)
+
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		return deliveryInfo{}, &textproto.Error{Code: 534, Msg: "5.7.9 Application-specific password required."}
+	})
+
+	err := s.Send(MsgDef{To: []string{"dx@example.com"}, Msg: "Subject: qsl\r\n\r\n73\r\n"})
+	var se *SendError
+	if !stderr.As(err, &se) || len(se.Hints) != 1 || se.Hints[0].Code != HintAppPassword || se.Reason != ReasonAuthFailed {
+		t.Fatalf("err=%v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+// SendGridMailSendURL is the endpoint of SendGrid's v3 Mail Send API.
+const SendGridMailSendURL = "https://api.sendgrid.com/v3/mail/send"
+
+// sendGridReservedHeaders are set by SendGrid from the request's fields, or may not be set at all.
+var sendGridReservedHeaders = map[string]bool{
+	"X-Sg-Id": true, "X-Sg-Eid": true, "Received": true, "Dkim-Signature": true, "Content-Type": true,
+	"Content-Transfer-Encoding": true, "Mime-Version": true, "To": true, "From": true, "Subject": true,
+	"Reply-To": true, "Cc": true, "Bcc": true, "Date": true,
+}
+
+// SendGridTransport delivers through SendGrid's v3 Mail Send API over HTTPS, for stations whose ISP blocks
+// outbound SMTP. The composed message is taken apart into the API's fields: addresses, subject, text and HTML
+// bodies, attachments and the remaining headers, including the Message-ID that SendGrid's event webhook reports.
+type SendGridTransport struct {
+	// APIKey authenticates to SendGrid. Empty uses the EmailConfig password of the send, which is where the key
+	// goes when SendGrid is used as an SMTP relay with the username "apikey".
+	APIKey string
+	// Endpoint overrides SendGridMailSendURL, e.g. with "https://api.eu.sendgrid.com/v3/mail/send" for an EU
+	// regional subuser.
+	Endpoint string
+	Client   *http.Client
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridPersonalization struct {
+	To  []sendGridAddress `json:"to"`
+	Cc  []sendGridAddress `json:"cc,omitempty"`
+	Bcc []sendGridAddress `json:"bcc,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridAttachment struct {
+	Content     string `json:"content"`
+	Type        string `json:"type,omitempty"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition,omitempty"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+type sendGridMail struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	ReplyTo          *sendGridAddress          `json:"reply_to,omitempty"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+func (t *SendGridTransport) Deliver(ctx context.Context, sub Submission) error {
+	const op errors.Op = "email.SendGridTransport.Deliver"
+	from, to, msg := sub.From, sub.To, sub.Msg
+	key := t.APIKey
+	if key == "" {
+		key = strings.TrimSpace(sub.Config.Password)
+	}
+	if key == "" {
+		return errors.New(op).Msg("no SendGrid API key configured")
+	}
+	body, err := sendGridRequest(from, to, msg)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to convert message for SendGrid")
+	}
+	endpoint := t.Endpoint
+	if endpoint == "" {
+		endpoint = SendGridMailSendURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to build SendGrid request")
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("Content-Type", "application/json")
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("SendGrid request failed")
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	var failure struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, maxAPIResponseBody)).Decode(&failure)
+	reasons := make([]string, 0, len(failure.Errors))
+	for _, e := range failure.Errors {
+		reasons = append(reasons, e.Message)
+	}
+	return errors.New(op).Err(apiReply(resp.StatusCode, strings.Join(reasons, "; "))).Msgf("SendGrid refused the message (HTTP %d)", resp.StatusCode)
+}
+
+// sendGridRequest converts a composed message into a Mail Send request. Envelope recipients missing from the To
+// and Cc headers are sent as Bcc.
+func sendGridRequest(from string, to []string, msg []byte) ([]byte, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(m.Body)
+	if err != nil {
+		return nil, err
+	}
+	req := sendGridMail{From: sendGridAddress{Email: from}, Headers: map[string]string{}}
+	if a, perr := mail.ParseAddress(m.Header.Get("From")); perr == nil {
+		req.From = sendGridAddress{Email: a.Address, Name: a.Name}
+	}
+	if a, perr := mail.ParseAddress(m.Header.Get("Reply-To")); perr == nil {
+		req.ReplyTo = &sendGridAddress{Email: a.Address, Name: a.Name}
+	}
+	dec := new(mime.WordDecoder)
+	req.Subject = m.Header.Get("Subject")
+	if s, derr := dec.DecodeHeader(req.Subject); derr == nil {
+		req.Subject = s
+	}
+
+	listed := map[string]sendGridAddress{}
+	inCc := map[string]bool{}
+	for _, field := range []string{"To", "Cc"} {
+		list, _ := m.Header.AddressList(field)
+		for _, a := range list {
+			listed[strings.ToLower(a.Address)] = sendGridAddress{Email: a.Address, Name: a.Name}
+			inCc[strings.ToLower(a.Address)] = field == "Cc"
+		}
+	}
+	var p sendGridPersonalization
+	for _, addr := range to {
+		a, ok := listed[strings.ToLower(addr)]
+		switch {
+		case !ok:
+			p.Bcc = append(p.Bcc, sendGridAddress{Email: addr})
+		case inCc[strings.ToLower(addr)]:
+			p.Cc = append(p.Cc, a)
+		default:
+			p.To = append(p.To, a)
+		}
+	}
+	if len(p.To) == 0 && len(p.Bcc) > 0 {
+		// The API needs one To recipient; the first blind one is as good as any
+		p.To, p.Bcc = p.Bcc[:1], p.Bcc[1:]
+	}
+	req.Personalizations = []sendGridPersonalization{p}
+
+	for k, v := range m.Header {
+		if !sendGridReservedHeaders[textproto.CanonicalMIMEHeaderKey(k)] && len(v) > 0 {
+			req.Headers[k] = v[0]
+		}
+	}
+
+	var text, html *sendGridContent
+	var partErr error
+	err = walkParts(m.Header.Get("Content-Type"), textproto.MIMEHeader(m.Header), body, 0, func(hdr textproto.MIMEHeader, data []byte) bool {
+		decoded, derr := decodeTransferEncoding(hdr.Get("Content-Transfer-Encoding"), data)
+		if derr != nil {
+			partErr = derr
+			return false
+		}
+		mediaType, _, _ := mime.ParseMediaType(hdr.Get("Content-Type"))
+		switch {
+		case isAttachmentPart(hdr):
+		case (mediaType == "" || mediaType == "text/plain") && text == nil:
+			text = &sendGridContent{Type: "text/plain", Value: string(decoded)}
+			return true
+		case mediaType == "text/html" && html == nil:
+			html = &sendGridContent{Type: "text/html", Value: string(decoded)}
+			return true
+		}
+		att := sendGridAttachment{Content: base64.StdEncoding.EncodeToString(decoded), Type: mediaType, Filename: attachmentFilename(hdr),
+			Disposition: "attachment"}
+		if cid := strings.Trim(hdr.Get("Content-Id"), "<> "); cid != "" && !isAttachmentPart(hdr) {
+			att.Disposition, att.ContentID = "inline", cid
+		}
+		if att.Filename == "" {
+			att.Filename = "attachment"
+		}
+		req.Attachments = append(req.Attachments, att)
+		return true
+	})
+	if err == nil {
+		err = partErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The API requires text/plain, when present, to come first, and some content
+	if text != nil {
+		req.Content = append(req.Content, *text)
+	}
+	if html != nil {
+		req.Content = append(req.Content, *html)
+	}
+	if len(req.Content) == 0 {
+		req.Content = []sendGridContent{{Type: "text/plain", Value: " "}}
+	}
+	return json.Marshal(req)
+}
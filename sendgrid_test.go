@@ -0,0 +1,75 @@
+package email
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	stderr "errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestSendGridTransport(t *testing.T) {
+	var auth string
+	var got sendGridMail
+	status := http.StatusAccepted
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+		if status != http.StatusAccepted {
+			_, _ = w.Write([]byte(`{"errors":[{"message":"The from address does not match a verified Sender Identity."}]}`))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	s := &Service{
+		Config:    &types.EmailConfig{Enabled: true, Host: "smtp.sendgrid.net", Port: 587, From: "op@example.org", Username: "apikey", Password: "SG.key"},
+		Transport: &SendGridTransport{Endpoint: ts.URL, Client: ts.Client()},
+	}
+	s.isInitialized.Store(true)
+
+	def, err := s.BuildEmailWithAttachments("Station <op@example.org>", "Log export", "73 de K1ABC",
+		[]string{"qsl@example.org", "archive@example.org"}, Attachment{Filename: "log.adi", ContentType: "text/plain", Data: []byte("<EOH>")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	def.To = append(def.To, "blind@example.org")
+	res, err := s.SendWithResult(t.Context(), def)
+	if err != nil || res.Status != SendStatusSent {
+		t.Fatalf("err=%v res=%+v", err, res)
+	}
+	if auth != "Bearer SG.key" {
+		t.Errorf("authorization %q", auth)
+	}
+	p := got.Personalizations[0]
+	if got.From != (sendGridAddress{Email: "op@example.org", Name: "Station"}) || got.Subject != "Log export" ||
+		len(p.To) != 2 || len(p.Bcc) != 1 || p.Bcc[0].Email != "blind@example.org" {
+		t.Errorf("request %+v", got)
+	}
+	if len(got.Content) == 0 || got.Content[0].Type != "text/plain" || got.Content[0].Value != "73 de K1ABC" {
+		t.Errorf("content %+v", got.Content)
+	}
+	if len(got.Attachments) != 1 || got.Attachments[0].Filename != "log.adi" || got.Attachments[0].Content != base64.StdEncoding.EncodeToString([]byte("<EOH>")) {
+		t.Errorf("attachments %+v", got.Attachments)
+	}
+	if got.Headers["Message-Id"] != res.MessageID || got.Headers["Date"] != "" {
+		t.Errorf("headers %+v, message ID %s", got.Headers, res.MessageID)
+	}
+
+	// A refused request is a permanent failure, not retried
+	status = http.StatusBadRequest
+	err = s.Send(def)
+	var se *SendError
+	if !stderr.As(err, &se) || !se.Permanent || se.Code != 554 {
+		t.Fatalf("err=%v", err)
+	}
+	status = http.StatusTooManyRequests
+	if err = s.Send(def); !stderr.As(err, &se) || se.Permanent || se.Reason != ReasonRateLimited {
+		t.Fatalf("err=%v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	stderr "errors"
+	"net/textproto"
+	"os/exec"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+// DefaultSendmailPath is where sendmail-compatible binaries (Postfix, Exim, OpenSMTPD, msmtp, ...) are installed.
+const DefaultSendmailPath = "/usr/sbin/sendmail"
+
+// Exit codes of sendmail from sysexits.h that bear on retries.
+const (
+	sendmailExitNoUser   = 67
+	sendmailExitTempFail = 75
+)
+
+// maxSendmailOutput bounds the output of sendmail kept for the error of a failed delivery.
+const maxSendmailOutput = 4 << 10
+
+// SendmailTransport pipes messages to a local sendmail-compatible MTA, for stations already running one that
+// hands mail on, so no SMTP credentials need be stored. EmailConfig.Host and Port are not used.
+//
+// The envelope is passed on the command line, as "-i -f <from> -- <to>...", rather than with -t, so Bcc and
+// batched recipients are delivered as they would be over SMTP.
+type SendmailTransport struct {
+	// Path is the sendmail binary; defaults to DefaultSendmailPath.
+	Path string
+	// Args are passed before the envelope, e.g. "-C", "/etc/msmtprc".
+	Args []string
+}
+
+func (t *SendmailTransport) Deliver(ctx context.Context, sub Submission) error {
+	const op errors.Op = "email.SendmailTransport.Deliver"
+	from, to, msg := sub.From, sub.To, sub.Msg
+	path := t.Path
+	if path == "" {
+		path = DefaultSendmailPath
+	}
+	args := append(append([]string{}, t.Args...), "-i", "-f", from, "--")
+	args = append(args, to...)
+	cmd := exec.CommandContext(ctx, path, args...)
+	// sendmail expects local line endings on its input
+	cmd.Stdin = bytes.NewReader(bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n")))
+	var out limitedBuffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	var exit *exec.ExitError
+	if ctx.Err() != nil || !stderr.As(err, &exit) {
+		return errors.New(op).Err(err).Msgf("failed to run %s", path)
+	}
+	detail := strings.TrimSpace(out.String())
+	if detail == "" {
+		detail = exit.String()
+	}
+	return errors.New(op).Err(sendmailReply(exit.ExitCode(), detail)).Msgf("%s refused the message (exit status %d)", path, exit.ExitCode())
+}
+
+// sendmailReply expresses the exit status of sendmail as the SMTP reply that has the same effect on retries and
+// the send's Reason.
+func sendmailReply(code int, msg string) *textproto.Error {
+	switch code {
+	case sendmailExitTempFail:
+		return &textproto.Error{Code: 451, Msg: "4.3.0 " + msg}
+	case sendmailExitNoUser:
+		return &textproto.Error{Code: 550, Msg: "5.1.1 " + msg}
+	}
+	return &textproto.Error{Code: 554, Msg: "5.3.0 " + msg}
+}
+
+// limitedBuffer keeps the first maxSendmailOutput bytes written to it.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxSendmailOutput - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
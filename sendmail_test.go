@@ -0,0 +1,65 @@
+package email
+
+import (
+	stderr "errors"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestSendmailTransport(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sendmail")
+	script := `#!/bin/sh
+echo "$@" > "$0.args"
+cat > "$0.msg"
+status=$(cat "$0.status" 2>/dev/null)
+[ -n "$status" ] && echo "mail queue full" >&2
+exit ${status:-0}
+`
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{
+		Config:    &types.EmailConfig{Enabled: true, Host: "localhost", Port: 25, From: "op@example.org", SmtpRetryCount: 1},
+		Transport: &SendmailTransport{Path: path, Args: []string{"-C", "/etc/msmtprc"}},
+	}
+	s.isInitialized.Store(true)
+
+	def := MsgDef{To: []string{"qsl@example.com", "blind@example.com"}, Msg: "Subject: qsl\r\n\r\n73\r\n"}
+	if err := s.Send(def); err != nil {
+		t.Fatal(err)
+	}
+	args, _ := os.ReadFile(path + ".args")
+	if got := strings.TrimSpace(string(args)); got != "-C /etc/msmtprc -i -f op@example.org -- qsl@example.com blind@example.com" {
+		t.Errorf("args %q", got)
+	}
+	msg, _ := os.ReadFile(path + ".msg")
+	if strings.Contains(string(msg), "\r") || !strings.Contains(string(msg), "Subject: qsl\n") || !strings.HasSuffix(string(msg), "\n73\n") {
+		t.Errorf("message %q", msg)
+	}
+
+	// EX_TEMPFAIL is transient, other failures permanent
+	for status, permanent := range map[string]bool{"75": false, "69": true} {
+		if err := os.WriteFile(path+".status", []byte(status), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var se *SendError
+		err := s.Send(def)
+		if !stderr.As(err, &se) || se.Permanent != permanent {
+			t.Fatalf("status %s: %v", status, err)
+		}
+		var tpErr *textproto.Error
+		if !stderr.As(err, &tpErr) || !strings.Contains(tpErr.Msg, "mail queue full") {
+			t.Errorf("status %s: reply %v", status, tpErr)
+		}
+	}
+}
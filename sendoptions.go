@@ -0,0 +1,108 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"slices"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+// SendOptions tunes how a message is handed to the server.
+type SendOptions struct {
+	// DSN requests delivery status notifications (RFC 3461) per recipient, keyed by address after alias
+	// expansion. The parameters are dropped when the server does not advertise DSN.
+	DSN map[string]RecipientDSN `json:",omitempty"`
+}
+
+// RecipientDSN holds the DSN parameters sent with one RCPT TO.
+type RecipientDSN struct {
+	// Notify is "NEVER", or any of "SUCCESS", "FAILURE" and "DELAY". Empty leaves the choice to the server.
+	Notify []string `json:",omitempty"`
+	// ORCPT is the original recipient to report back, e.g. the address before forwarding; empty omits it.
+	ORCPT string `json:",omitempty"`
+}
+
+var dsnNotifyValues = []string{"NEVER", "SUCCESS", "FAILURE", "DELAY"}
+
+func (o SendOptions) validate(op errors.Op) error {
+	for addr, p := range o.DSN {
+		for _, n := range p.Notify {
+			if !slices.Contains(dsnNotifyValues, strings.ToUpper(n)) {
+				return errors.New(op).Msgf("invalid DSN NOTIFY value %q for %s", n, addr)
+			}
+		}
+		if len(p.Notify) > 1 && slices.ContainsFunc(p.Notify, func(n string) bool { return strings.EqualFold(n, "NEVER") }) {
+			return errors.New(op).Msgf("DSN NOTIFY=NEVER cannot be combined with other values for %s", addr)
+		}
+		if strings.ContainsAny(p.ORCPT, "\r\n") {
+			return errors.New(op).Msgf("invalid DSN ORCPT for %s", addr)
+		}
+	}
+	return nil
+}
+
+// params renders the RCPT TO parameters, or "" if none are set.
+func (p RecipientDSN) params() string {
+	var parts []string
+	if len(p.Notify) > 0 {
+		notify := make([]string, len(p.Notify))
+		for i, n := range p.Notify {
+			notify[i] = strings.ToUpper(n)
+		}
+		parts = append(parts, "NOTIFY="+strings.Join(notify, ","))
+	}
+	if p.ORCPT != "" {
+		parts = append(parts, "ORCPT=rfc822;"+xtext(p.ORCPT))
+	}
+	return strings.Join(parts, " ")
+}
+
+// xtext encodes s as RFC 3461 xtext.
+func xtext(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '!' || c > '~' || c == '+' || c == '=' {
+			fmt.Fprintf(&b, "+%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+type recipientDSNKey struct{}
+
+// withRecipientDSN carries the per-recipient DSN parameters to the SMTP session.
+func withRecipientDSN(ctx context.Context, dsn map[string]RecipientDSN) context.Context {
+	if len(dsn) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, recipientDSNKey{}, dsn)
+}
+
+func recipientDSNFromContext(ctx context.Context) map[string]RecipientDSN {
+	dsn, _ := ctx.Value(recipientDSNKey{}).(map[string]RecipientDSN)
+	return dsn
+}
+
+// rcpt issues RCPT TO with params, which net/smtp's Client.Rcpt cannot send.
+func rcpt(c *smtp.Client, addr, params string) error {
+	if params == "" {
+		return c.Rcpt(addr)
+	}
+	if strings.ContainsAny(addr+params, "\r\n") {
+		return errors.New("email.rcpt").Msg("smtp: A line must not contain CR or LF")
+	}
+	id, err := c.Text.Cmd("RCPT TO:<%s> %s", addr, params)
+	if err != nil {
+		return err
+	}
+	c.Text.StartResponse(id)
+	defer c.Text.EndResponse(id)
+	_, _, err = c.Text.ReadResponse(25)
+	return err
+}
@@ -0,0 +1,56 @@
+package email
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRecipientDSNParameters(t *testing.T) {
+	addr, lines := startScriptedSMTPServer(t, []string{"fake.example.com", "DSN"})
+	ctx := withRecipientDSN(withRootCAs(t.Context(), fakeRoots()), map[string]RecipientDSN{
+		"dx@example.org": {Notify: []string{"success", "failure"}, ORCPT: "dx+club@example.org"},
+	})
+
+	if _, err := sendMailWithTLS(ctx, addr, nil, "op@example.com", []string{"dx@example.org", "qsl@example.net"}, []byte("Subject: hi\r\n\r\n73\r\n")); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	got := lines()
+	if !slices.Contains(got, "RCPT TO:<dx@example.org> NOTIFY=SUCCESS,FAILURE ORCPT=rfc822;dx+2Bclub@example.org") {
+		t.Fatalf("missing DSN parameters in %q", got)
+	}
+	if !slices.Contains(got, "RCPT TO:<qsl@example.net>") {
+		t.Fatalf("recipient without DSN options should be sent plainly: %q", got)
+	}
+}
+
+func TestRecipientDSNDroppedWithoutServerSupport(t *testing.T) {
+	addr, lines := startScriptedSMTPServer(t, []string{"fake.example.com", "8BITMIME"})
+	ctx := withRecipientDSN(withRootCAs(context.Background(), fakeRoots()), map[string]RecipientDSN{"dx@example.org": {Notify: []string{"NEVER"}}})
+
+	if _, err := sendMailWithTLS(ctx, addr, nil, "op@example.com", []string{"dx@example.org"}, []byte("Subject: hi\r\n\r\n73\r\n")); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	for _, l := range lines() {
+		if strings.Contains(l, "NOTIFY") {
+			t.Fatalf("DSN parameters sent to a server without DSN: %q", l)
+		}
+	}
+}
+
+func TestSendOptionsValidate(t *testing.T) {
+	bad := []SendOptions{
+		{DSN: map[string]RecipientDSN{"a@example.com": {Notify: []string{"SOMETIMES"}}}},
+		{DSN: map[string]RecipientDSN{"a@example.com": {Notify: []string{"NEVER", "FAILURE"}}}},
+		{DSN: map[string]RecipientDSN{"a@example.com": {ORCPT: "a@example.com\r\nDATA"}}},
+	}
+	for i, o := range bad {
+		if err := o.validate("test"); err == nil {
+			t.Errorf("case %d: expected validation error", i)
+		}
+	}
+	if err := (SendOptions{DSN: map[string]RecipientDSN{"a@example.com": {Notify: []string{"delay"}}}}).validate("test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package email
+
+import (
+	"context"
+	"slices"
+
+	"github.com/Station-Manager/errors"
+)
+
+// Caller identifies who asked for a send, for SendPolicy.
+type Caller struct {
+	ID   string
+	Role string
+}
+
+type callerKey struct{}
+
+// WithCaller returns a context carrying the caller of the sends made with it.
+func WithCaller(ctx context.Context, c Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, c)
+}
+
+// CallerFromContext returns the caller carried by ctx, if any.
+func CallerFromContext(ctx context.Context) (Caller, bool) {
+	if ctx == nil {
+		return Caller{}, false
+	}
+	c, ok := ctx.Value(callerKey{}).(Caller)
+	return c, ok
+}
+
+// SendPolicy restricts the categories of mail a caller's role may send, e.g. letting guests mail their own log
+// export but not club-wide digests. The caller is taken from the send's context, see WithCaller.
+type SendPolicy struct {
+	// Roles lists the categories each restricted role may send; a message without a category counts as "". Roles
+	// not listed are unrestricted.
+	Roles map[string][]NotificationCategory
+	// RequireCaller refuses sends whose context carries no Caller; otherwise they are unrestricted.
+	RequireCaller bool
+}
+
+// checkSendPolicy refuses email if the caller in ctx may not send its category.
+func (s *Service) checkSendPolicy(ctx context.Context, email MsgDef) error {
+	const op errors.Op = "email.Service.checkSendPolicy"
+	if s.SendPolicy == nil {
+		return nil
+	}
+	c, ok := CallerFromContext(ctx)
+	if !ok {
+		if s.SendPolicy.RequireCaller {
+			s.LoggerService.WarnWith().Str("category", string(email.Category)).Msg("send refused; no caller identified")
+			return errors.New(op).Msg(errMsgNoCaller)
+		}
+		return nil
+	}
+	allowed, restricted := s.SendPolicy.Roles[c.Role]
+	if !restricted || slices.Contains(allowed, email.Category) {
+		return nil
+	}
+	s.LoggerService.WarnWith().Str("caller", c.ID).Str("role", c.Role).Str("category", string(email.Category)).
+		Msg("send refused by the send policy")
+	return errors.New(op).Msgf("role %q may not send %q mail", c.Role, email.Category)
+}
@@ -0,0 +1,55 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"slices"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestSendPolicyByRole(t *testing.T) {
+	s := &Service{
+		Config:     &types.EmailConfig{Enabled: true, Host: "smtp.example.org", Port: 587, From: "club@example.org", To: "members@example.org"},
+		SendPolicy: &SendPolicy{Roles: map[string][]NotificationCategory{"guest": {CategoryExport}}},
+	}
+	s.isInitialized.Store(true)
+	sent := 0
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent++
+		return deliveryInfo{Accepted: to}, nil
+	})
+
+	guest := WithCaller(t.Context(), Caller{ID: "M0XYZ", Role: "guest"})
+	var q types.Qso
+	q.Call, q.QsoDate, q.TimeOn = "DL1XYZ", "20261015", "1200"
+	export, err := s.BuildEmailWithADIFStream("", "", "", []string{"m0xyz@example.org"}, slices.Values([]types.Qso{q}), ADIFOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.SendWithResult(guest, export); err != nil {
+		t.Fatalf("guest export refused: %v", err)
+	}
+
+	digest := MsgDef{To: []string{"members@example.org"}, Category: CategoryDigest, Msg: "Subject: digest\r\n\r\n73\r\n"}
+	if _, err = s.SendWithResult(guest, digest); err == nil {
+		t.Fatal("guest was allowed to send a digest")
+	}
+	if _, err = s.SendWithResult(guest, MsgDef{To: []string{"x@example.org"}, Msg: "Subject: hi\r\n\r\n73\r\n"}); err == nil {
+		t.Fatal("guest was allowed to send uncategorized mail")
+	}
+	if _, err = s.SendWithResult(WithCaller(t.Context(), Caller{ID: "DL1ABC", Role: "admin"}), digest); err != nil {
+		t.Fatalf("admin digest refused: %v", err)
+	}
+	if _, err = s.SendWithResult(t.Context(), digest); err != nil {
+		t.Fatalf("send without caller refused: %v", err)
+	}
+	s.SendPolicy.RequireCaller = true
+	if _, err = s.SendWithResult(t.Context(), digest); err == nil {
+		t.Fatal("send without caller allowed under RequireCaller")
+	}
+	if sent != 3 {
+		t.Fatalf("%d messages sent, want 3", sent)
+	}
+}
@@ -2,19 +2,18 @@ package email
 
 import (
 	"bytes"
-	"encoding/base64"
+	"context"
+	"crypto/x509"
 	"fmt"
-	"mime/multipart"
-	"mime/quotedprintable"
-	"net"
-	"net/smtp"
+	"io"
+	"iter"
 	"net/textproto"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/Station-Manager/adif"
 	"github.com/Station-Manager/config"
 	"github.com/Station-Manager/errors"
 	"github.com/Station-Manager/logging"
@@ -23,22 +22,168 @@ import (
 
 const ServiceName = types.EmailServiceName
 
-// sendMailFn is a package-level indirection to smtp.SendMail to enable testing without network.
-var sendMailFn = sendMailWithTLS
-
 type Service struct {
 	ConfigService *config.Service  `di.inject:"configservice"`
 	LoggerService *logging.Service `di.inject:"loggingservice"`
-	Config        *types.EmailConfig
+	// Config is the configuration loaded by Initialize. Reload swaps in a new copy without modifying it.
+	//
+	// Deprecated: Config goes stale after a Reload or SetConfig; use CurrentConfig for the live value.
+	Config *types.EmailConfig
+
+	// AutoReplyConfig enables automatic replies to inbound messages; nil disables the feature.
+	AutoReplyConfig *AutoReplyConfig
+	// EventSinks receive delivery lifecycle events (sent, failed, bounced, ...).
+	EventSinks []EventSink
+	// Notifications tunes the per-category behaviour of Notify.
+	Notifications *NotificationConfig
+	// Quota tracks sends against the provider's daily limit; nil disables tracking.
+	Quota *QuotaConfig
+	// QueueConfig tunes the outbound queue used for deferred retries.
+	QueueConfig QueueConfig
+	// AuthMechanism selects the SMTP auth mechanism: "plain" (the default), "login", "cram-md5", "xoauth2" or a key
+	// of AuthMechanisms.
+	AuthMechanism string
+	// AuthMechanisms registers custom smtp.Auth implementations, e.g. provider-specific SASL mechanisms.
+	AuthMechanisms map[string]AuthFactory
+	// OAuth2 supplies the access tokens for AuthXOAUTH2, refreshing them as they expire.
+	OAuth2 *OAuth2Config
+	// Compliance selects whether messages are checked against RFC 5322 before sending.
+	Compliance ComplianceMode
+	// Alignment selects whether a send whose envelope sender domain does not align with the From header domain is
+	// logged or refused.
+	Alignment ComplianceMode
+	// Middleware transforms each message, in order, before it is sent.
+	Middleware []MessageMiddleware
+	// RecipientResolver expands alias tokens in MsgDef.To; nil allows only addresses and the "config" alias.
+	RecipientResolver RecipientResolver
+	// Recipients is the list mail is sent to when it names none, in place of EmailConfig.To; when empty, the
+	// recipients are parsed from EmailConfig.To.
+	Recipients []Recipient
+	// QueueStore persists the outbound queue across restarts; nil keeps it in memory only.
+	QueueStore QueueStore
+	// SRS rewrites envelope senders on foreign domains, for forwarding; nil sends them unchanged.
+	SRS *SRSConfig
+	// Templates stores user edits of message templates; nil leaves only the built-in defaults.
+	Templates TemplateStore
+	// AppVersion is reported in test messages; empty uses the main module version from the build info.
+	AppVersion string
+	// MaxConnections caps simultaneous SMTP connections across profiles. Defaults to 3.
+	MaxConnections int
+	// Prewarm opens an authenticated connection at Initialize for the first send to use; nil disables it.
+	Prewarm *PrewarmConfig
+	// DKIM signs messages that do not select an identity with its own DKIM key; nil leaves them unsigned.
+	DKIM *DKIMConfig
+	// ARC seals messages re-sent by Forward; nil forwards them unsealed.
+	ARC *ARCConfig
+	// ForwardHeaders decides which original headers Forward keeps; nil strips only Return-Path and Bcc.
+	ForwardHeaders *HeaderPolicy
+	// MaxForwardHops caps how often a message may have been re-sent, counted by its Resent-Message-ID fields, before
+	// Forward refuses it, breaking loops between instances forwarding to each other. Defaults to 5.
+	MaxForwardHops int
+	// Identities are the sender identities a message can select with MsgDef.Identity.
+	Identities map[string]SenderIdentity
+	// SuppressionStore persists the suppression list of addresses never mailed; nil keeps it in memory only.
+	SuppressionStore SuppressionStore
+	// FilenamePolicies constrains attachment filenames by recipient domain, e.g. "arrl.org", when messages are
+	// built.
+	FilenamePolicies map[string]FilenamePolicy
+	// ASCIIOnlyDomains lists recipient domains, e.g. SMS gateways and log robots, that cannot handle anything but
+	// ASCII; the subject and text of mail to them is transliterated.
+	ASCIIOnlyDomains []string
+	// RetryProfileStore persists the retry profiles learned with QueueConfig.AdaptiveRetry; nil keeps them in memory
+	// only.
+	RetryProfileStore RetryProfileStore
+	// MaxRecipientsPerTransaction caps the recipients of one SMTP transaction; a message to more is sent in several.
+	// The server's own limit, when it advertises one with LIMITS RCPTMAX (RFC 9422), applies when lower. Zero leaves
+	// only the server's limit.
+	MaxRecipientsPerTransaction int
+	// Sync exchanges QSOs with other Station-Manager instances by email; nil disables log sync.
+	Sync *SyncConfig
+	// SendPolicy restricts which categories of mail each caller role may send; nil lets every caller send anything.
+	SendPolicy *SendPolicy
+	// AuditStore persists the audit log of config, template and suppression changes; nil keeps it in memory only.
+	AuditStore AuditStore
+	// Retention limits how long the send history and dead letters keep personal data; zero keeps them until evicted.
+	Retention Retention
+	// Anonymize records hashed recipients and redacted subjects in the history and delivery events; nil records
+	// them as sent.
+	Anonymize *AnonymizeConfig
+	// Clock is the time source for Date headers, Message-IDs and the queue's schedule; nil uses the system clock.
+	Clock Clock
+	// MaxClockSkew is how far in the future a message's Date may be before it is stamped afresh at send time;
+	// defaults to two minutes.
+	MaxClockSkew time.Duration
+	// Random is the entropy source of Message-IDs and multipart boundaries, e.g. a fixed one for reproducible
+	// messages in tests; nil uses crypto/rand.
+	Random io.Reader
+	// Transport delivers messages in place of SMTP submission to the configured host, e.g. through a provider's
+	// HTTP API; nil sends over SMTP.
+	Transport Transport
+	// RootCAs are the certificate authorities trusted for the SMTP server's certificate, e.g. a private CA of a
+	// club's relay; nil uses the system roots.
+	RootCAs *x509.CertPool
+	// Revocation checks the SMTP server's certificate for revocation with OCSP; nil skips the check.
+	Revocation *RevocationConfig
+	// StrictCrypto restricts SMTP connections to TLS 1.2 or later with ECDHE, AES-GCM and NIST curves, and refuses
+	// MD5-based auth such as CRAM-MD5, for deployments held to a FIPS-style crypto policy.
+	StrictCrypto bool
 
 	isInitialized atomic.Bool
 	initOnce      sync.Once
+	cfg           atomic.Pointer[types.EmailConfig]
+	shutdownOnce  sync.Once
+	history       sendHistory
+	stats         sendStats
+	digests       digestStore
+	quota         quotaTracker
+	queue         outboundQueue
+	inflight      inflightSet
+	deadLetters   deadLetterQueue
+	resolver      dnsResolver
+	stopQueue     context.CancelFunc
+	queueDone     chan struct{}
+	warm          warmPool
+	conns         connLimiter
+	templatesMu   sync.Mutex
+	stopPrewarm   context.CancelFunc
+	prewarmDone   chan struct{}
+	suppressions  suppressionList
+	syncs         syncState
+	retryProfiles retryProfiles
+	unconfirmed   unconfirmedSet
+	audit         auditLog
+	offline       offlineHosts
 }
 
 type MsgDef struct {
 	From string
 	To   []string
-	Msg  string
+	// Cc and Bcc are copied in as well. Cc is listed in the Cc field, merged with any the message has; Bcc is only
+	// added to the envelope, and a Bcc field in the message is removed. Once sent or queued, To holds the whole
+	// envelope and Bcc the blind copies among it, which the history and delivery events leave out.
+	Cc  []string
+	Bcc []string
+	Msg string
+	// TTL bounds how long the message may wait in the outbound queue; zero uses QueueConfig.DefaultTTL.
+	TTL time.Duration
+	// Priority orders the message against others due in the outbound queue.
+	Priority Priority
+	// Options tunes the SMTP transaction, e.g. per-recipient DSN requests.
+	Options SendOptions
+	// Identity names the Service.Identities entry to send as; empty sends as built with the active configuration.
+	Identity string
+	// Category is the kind of mail, checked against the SendPolicy; set by Notify, FlushDigests and the ADIF
+	// builders.
+	Category NotificationCategory
+	// ExportHash identifies the set of QSOs in an ADIF export, regardless of their order; set by the ADIF builders.
+	// See ExportUnchanged.
+	ExportHash string
+	// Structure describes the message as built; set by the builders and not persisted with queued messages.
+	Structure *Message `json:"-"`
+	// arc is set by Forward to have the send ARC seal the message
+	arc *arcSeal
+	// received is set by Forward to have the SMTP session stamp a Received field on relayed mail
+	received *receivedStamp
 }
 
 func (s *Service) Initialize() error {
@@ -64,201 +209,310 @@ func (s *Service) Initialize() error {
 			initErr = errors.New(op).Err(err).Msg("getting email config")
 			return
 		}
-		s.Config = &cfg
+		initErr = s.initialize(op, cfg)
+	})
 
-		if err = s.validateConfig(op); err != nil {
-			initErr = err
-			s.Config.Enabled = false
-			return
-		}
+	return initErr
+}
 
-		// Configure SMTP dial timeout from config, with sane bounds
-		if cfg.SmtpDialTimeoutSec > 0 {
-			d := time.Duration(cfg.SmtpDialTimeoutSec) * time.Second
-			if d < time.Second {
-				d = time.Second
-			}
-			if d > 60*time.Second {
-				d = 60 * time.Second
-			}
-			smtpDialTimeout = d
-		} else {
-			smtpDialTimeout = 10 * time.Second
-		}
+// initialize brings the service up with cfg, for Initialize and for the services of Tenants.
+func (s *Service) initialize(op errors.Op, cfg types.EmailConfig) error {
+	s.Config = &cfg
+	s.cfg.Store(&cfg)
 
-		s.isInitialized.Store(true)
-	})
+	if err := s.validateConfig(op); err != nil {
+		s.Config.Enabled = false
+		return err
+	}
+	if _, err := s.authFactory(); err != nil {
+		return err
+	}
 
-	return initErr
+	s.restoreSuppressions()
+	s.restoreRetryProfiles()
+	// The queue must be running before isInitialized publishes it to Shutdown
+	s.startQueue()
+	s.isInitialized.Store(true)
+	if cfg.Enabled {
+		s.startPrewarm()
+		s.logPreflight()
+	}
+	return nil
 }
 
-// Send sends an email message using SMTP configuration, with support for retries and error handling.
+// Send sends an email message using SMTP configuration, with support for retries and error handling. It cannot be
+// cancelled; use SendContext to bound it.
 func (s *Service) Send(email MsgDef) error {
+	return s.SendContext(context.Background(), email)
+}
+
+// SendContext is Send with a context. Cancelling ctx, or its deadline passing, aborts the send wherever it is:
+// waiting for a connection slot, dialing, mid SMTP transaction or sleeping between retries. A trace ID attached
+// with WithTraceID is stamped into the message headers and every log line of the send. Greylisted messages are
+// handed to the outbound queue and retried once the greylist window has passed.
+func (s *Service) SendContext(ctx context.Context, email MsgDef) error {
+	_, err := s.SendWithResult(ctx, email)
+	return err
+}
+
+// SendWithResult sends email and reports whether it was delivered or handed to the outbound queue. When
+// QueueConfig.HandOffTransient is set, a transient failure queues the message immediately instead of blocking the
+// caller through the retry schedule; foreground and queued attempts share the QueueConfig.MaxAttempts budget. A
+// permanent failure, such as a 5xx reply, is not retried. A failed delivery returns a *SendError.
+func (s *Service) SendWithResult(ctx context.Context, email MsgDef) (SendResult, error) {
 	const op errors.Op = "email.Service.Send"
 	if !s.isInitialized.Load() {
-		return errors.New(op).Msg(errMsgNotInitialized)
+		return SendResult{}, errors.New(op).Msg(errMsgNotInitialized)
 	}
-	if !s.Config.Enabled {
+	cfg := s.config()
+	if !cfg.Enabled {
 		s.LoggerService.WarnWith().Msg("email service is disabled in the config")
-		return nil
+		return SendResult{Reason: ReasonDisabled}, nil
 	}
-
-	host := strings.TrimSpace(s.Config.Host)
-	username := strings.TrimSpace(s.Config.Username)
-	password := strings.TrimSpace(s.Config.Password)
-	from := strings.TrimSpace(email.From)
-	if from == "" {
-		from = strings.TrimSpace(s.Config.From)
+	if err := s.checkSendPolicy(ctx, email); err != nil {
+		return SendResult{}, errors.New(op).Err(err).Msg("rejected by the send policy")
 	}
-	if from == "" {
-		return errors.New(op).Msg("email from address cannot be empty")
+	if err := s.admit(ctx); err != nil {
+		return SendResult{}, errors.New(op).Err(err).Msg("outbound queue has no room for the message")
 	}
-
-	addr := net.JoinHostPort(host, fmt.Sprintf("%d", s.Config.Port))
-
-	var auth smtp.Auth
-	if username != "" {
-		// Use PLAIN auth when username provided
-		auth = smtp.PlainAuth("", username, password, host)
+	email, err := s.applyCopies(ctx, email)
+	if err != nil {
+		return SendResult{}, errors.New(op).Err(err).Msg("failed to add the copy recipients")
+	}
+	if email, err = s.applyIdentity(email); err != nil {
+		return SendResult{}, errors.New(op).Err(err).Msg("failed to apply the sending identity")
+	}
+	if email, err = s.applyMiddleware(email); err != nil {
+		return SendResult{}, errors.New(op).Err(err).Msg("rejected by message middleware")
+	}
+	if email, err = s.applyASCII(ctx, email); err != nil {
+		return SendResult{}, errors.New(op).Err(err).Msg("failed to convert the message to ASCII")
+	}
+	email.Msg = s.fixDate(email.Msg)
+	if k := s.dkimFor(email); k != nil {
+		if email.Msg, err = signDKIM(email.Msg, k, s.now()); err != nil {
+			return SendResult{}, errors.New(op).Err(err).Msg("failed to sign the message with DKIM")
+		}
 	}
+	if email.arc != nil && s.ARC != nil {
+		if email.Msg, err = sealARC(email.Msg, s.ARC, *email.arc, s.now()); err != nil {
+			return SendResult{}, errors.New(op).Err(err).Msg("failed to seal the message with ARC")
+		}
+	}
+	d, err := s.prepareDelivery(ctx, email)
+	if err != nil {
+		return SendResult{}, errors.New(op).Err(err).Msg("failed to prepare the delivery")
+	}
+	defer d.cancel()
+	cfg = d.cfg
 
 	// Simple retry loop based on config
-	retries := s.Config.SmtpRetryCount
+	retries := cfg.SmtpRetryCount
 	if retries < 0 {
 		retries = 0
 	}
-	delay := time.Duration(s.Config.SmtpRetryDelaySec) * time.Second
+	if s.QueueConfig.HandOffTransient {
+		retries = 0
+	}
+	retries = min(retries, s.QueueConfig.maxAttempts()-1)
+	delay := time.Duration(cfg.SmtpRetryDelaySec) * time.Second
 	if delay <= 0 {
 		delay = 0
 	}
 	var lastErr error
+	attempts := 0
 	for attempt := 0; attempt <= retries; attempt++ {
-		if attempt > 0 && delay > 0 {
-			time.Sleep(delay)
+		if attempt > 0 && delay > 0 && !sleepContext(d.ctx, delay) {
+			d.log.WarnWith().Err(d.ctx.Err()).Int("attempts", attempts).Msg("send cancelled between retries")
+			return SendResult{}, errors.New(op).Err(fmt.Errorf("%w: %w", errDeliveryCancelled, d.ctx.Err())).Msg(errMsgDeliveryCancelled)
+		}
+		attempts++
+		if lastErr = s.attempt(d, attempts); lastErr == nil {
+			return SendResult{Status: SendStatusSent, Reason: ReasonOK, MessageID: d.rec.MessageID, Recipients: d.recipientResults(SendStatusSent, nil)}, nil
+		}
+		if d.cancelled() {
+			return SendResult{}, errors.New(op).Err(fmt.Errorf("%w: %w", errDeliveryCancelled, lastErr)).Msg(errMsgDeliveryCancelled)
 		}
-		if err := sendMailFn(addr, auth, from, email.To, []byte(email.Msg)); err != nil {
-			lastErr = err
-			s.LoggerService.ErrorWith().Err(err).Str("host", host).Str("addr", addr).Int("attempt", attempt+1).Msg("email send failed")
-			continue
+		if isPermanentReply(lastErr) {
+			// Such as 550 for a mailbox that does not exist; another attempt would fail the same way
+			break
+		}
+		// Resending at once after a drop that may have delivered the message risks a duplicate
+		if isGreylisted(lastErr) || isAcceptanceUnknown(lastErr) || s.spools(lastErr) ||
+			(s.QueueConfig.HandOffTransient && isTransient(lastErr)) {
+			break
 		}
-		s.LoggerService.InfoWith().Str("host", host).Str("addr", addr).Msg("email sent")
-		lastErr = nil
-		break
 	}
-	if lastErr != nil {
-		return errors.New(op).Err(lastErr).Msg("failed to send email")
+	if s.spools(lastErr) {
+		// An attempt that never reached the host does not count
+		attempts--
 	}
-
-	return nil
+	if attempts < s.QueueConfig.maxAttempts() && (isGreylisted(lastErr) || isAcceptanceUnknown(lastErr) || s.spools(lastErr) ||
+		(s.QueueConfig.HandOffTransient && isTransient(lastErr))) {
+		wait := s.retryDelay(cfg.Host, attempts, lastErr)
+		id := s.deferDelivery(d, attempts, wait, lastErr)
+		d.log.WarnWith().Err(lastErr).Str("queue_id", id).Dur("retry_in", wait).Msg("transient failure; queued for retry")
+		return SendResult{Status: SendStatusQueued, Reason: ReasonQueued, MessageID: d.rec.MessageID, QueueID: id, Recipients: d.recipientResults(SendStatusQueued, nil)}, nil
+	}
+	s.deliveryFailed(d, lastErr)
+	var res SendResult
+	if len(d.delivered) > 0 {
+		res = SendResult{Status: SendStatusFailed, Reason: ReasonOf(lastErr), MessageID: d.rec.MessageID, Recipients: d.recipientResults(SendStatusFailed, lastErr)}
+	}
+	return res, errors.New(op).Err(newSendError(lastErr, d.msg.To)).Msg("failed to send email")
 }
 
 func (s *Service) BuildEmailWithADIFAttachment(from, subject, msg string, to []string, slice []types.Qso) (MsgDef, error) {
 	const op errors.Op = "email.Service.BuildEmailWithADIFAttachment"
+	if len(slice) == 0 {
+		return MsgDef{}, errors.New(op).Msg("QSO slice cannot be empty")
+	}
+	def, err := s.BuildEmailWithADIFStream(from, subject, msg, to, slices.Values(slice), ADIFOptions{})
+	if err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msg(err.Error())
+	}
+	return def, nil
+}
+
+// BuildEmailWithADIFStream is BuildEmailWithADIFAttachment for QSOs produced one at a time, e.g. from a database
+// cursor. The QSOs are not collected into a slice, and the ADIF is composed in chunks on several cores while earlier
+// records are encoded, but the encoded message is still built in memory in full and returned in the MsgDef. opts
+// selects which of the QSOs are exported, and what is built when none are. The subject may be a text/template
+// rendered with the export's ExportMeta.
+func (s *Service) BuildEmailWithADIFStream(from, subject, msg string, to []string, qsos iter.Seq[types.Qso], opts ADIFOptions) (MsgDef, error) {
+	const op errors.Op = "email.Service.BuildEmailWithADIFStream"
+	cfg := s.config()
 
 	from = strings.TrimSpace(from)
 	if from == "" {
-		from = s.Config.From
+		from = cfg.From
 	}
-	// Resolve recipients: use a provided list or fallback to config (split by comma/semicolon/space)
+	// Resolve recipients: use a provided list or fallback to the configured list
 	tos := to
 	if len(tos) == 0 {
-		tos = splitAndTrim(s.Config.To)
+		tos = s.defaultTo(false)
+	}
+	tos, err := s.resolveRecipients(context.Background(), tos)
+	if err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msg("failed to resolve recipients")
 	}
 	if len(tos) == 0 {
 		return MsgDef{}, errors.New(op).Msg("email TO address cannot be empty")
 	}
 	subject = strings.TrimSpace(subject)
 	if subject == "" {
-		subject = s.Config.Subject
+		subject = cfg.Subject
 	}
 	msg = strings.TrimSpace(msg)
 	if msg == "" {
-		msg = s.Config.Body
+		msg = cfg.Body
 	}
-	if len(slice) == 0 {
-		return MsgDef{}, errors.New(op).Msg("QSO slice cannot be empty")
+	if qsos == nil {
+		return MsgDef{}, errors.New(op).Msg("QSO source cannot be nil")
 	}
-
-	adifContent, err := adif.ComposeToAdifString(slice)
+	subjectTmpl, err := parseSubject(subject)
 	if err != nil {
-		return MsgDef{}, errors.New(op).Err(err).Msg("failed to compose ADIF string")
+		return MsgDef{}, errors.New(op).Err(err).Msg("invalid subject template")
 	}
+	// The QSOs are converted while the headers and body are built, and while earlier records are encoded
+	chunks, stop := composeADIF(opts.Filter(qsos))
+	defer stop()
 
-	filename := fmt.Sprintf("%s-export.adi", time.Now().Format("20060102150405"))
-	adifB64 := base64.StdEncoding.EncodeToString([]byte(adifContent))
+	filename := s.attachmentName(tos, fmt.Sprintf("%s-export.adi", s.now().Format("20060102150405")))
+	meta := ExportMeta{Filename: filename}
+	var set qsoSetHash
 
 	// Prepare headers
 	hdr := make(textproto.MIMEHeader)
 	hdr.Set("From", from)
-	hdr.Set("To", strings.Join(tos, ", "))
-	hdr.Set("Subject", subject)
-	hdr.Set("Date", time.Now().UTC().Format(time.RFC1123Z))
+	hdr.Set("To", s.headerTo(tos))
+	hdr.Set("Date", s.now().UTC().Format(time.RFC1123Z))
 	// Generate a simple message-id
-	mid := generateMessageID()
+	mid := generateMessageID(s.random(), s.now())
 	hdr.Set("Message-ID", mid)
 	hdr.Set("MIME-Version", "1.0")
 
-	var buf bytes.Buffer
+	// The parts are written first: a templated subject depends on the QSOs streamed into the attachment
+	var parts bytes.Buffer
 	// Create a multipart / mixed writer
-	mw := multipart.NewWriter(&buf)
+	mw := newMultipartWriter(&parts, s.random())
 	boundary := mw.Boundary()
 	hdr.Set("Content-Type", fmt.Sprintf("multipart/mixed; boundary=%q", boundary))
 
-	// Write headers
-	for k, v := range hdr {
-		if len(v) == 0 {
-			continue
-		}
-		buf.WriteString(k)
-		buf.WriteString(": ")
-		buf.WriteString(strings.Join(v, ", "))
-		buf.WriteString("\r\n")
-	}
-	buf.WriteString("\r\n")
-
-	// Body part (text/plain; quoted-printable)
-	wp, err := mw.CreatePart(mapToMIMEHeader(map[string]string{
-		"Content-Type":              "text/plain; charset=utf-8",
-		"Content-Transfer-Encoding": "quoted-printable",
-	}))
-	if err != nil {
-		return MsgDef{}, errors.New(op).Err(err).Msg("create body part")
-	}
-
-	qp := quotedprintable.NewWriter(wp)
-	if _, err = qp.Write([]byte(msg)); err != nil {
+	// Body part (text/plain)
+	bodyHdr := mapToMIMEHeader(map[string]string{"Content-Type": "text/plain; charset=utf-8"})
+	wp := newPart(mw, bodyHdr, false)
+	if _, err = io.WriteString(wp, msg); err != nil {
 		return MsgDef{}, errors.New(op).Err(err).Msg("write body")
 	}
-	if err = qp.Close(); err != nil {
-		return MsgDef{}, errors.New(op).Err(err).Msg("close qp")
+	if err = wp.Close(); err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msg("write body")
 	}
 
-	// Attachment part
-	ap, err := mw.CreatePart(mapToMIMEHeader(map[string]string{
-		"Content-Type":              fmt.Sprintf("application/octet-stream; name=%q", filename),
-		"Content-Transfer-Encoding": "base64",
-		"Content-Disposition":       fmt.Sprintf("attachment; filename=%q", filename),
-	}))
-	if err != nil {
-		return MsgDef{}, errors.New(op).Err(err).Msg("create attachment part")
+	// Attachment part; mostly-ASCII ADIF usually goes quoted-printable
+	attHdr := mapToMIMEHeader(map[string]string{
+		"Content-Type":        fmt.Sprintf("application/octet-stream; name=%q", filename),
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", filename),
+	})
+	ap := newPart(mw, attHdr, true)
+	var raw countingWriter
+	aw := io.MultiWriter(ap, &raw)
+	if _, err = io.WriteString(aw, s.adifHeader().String()); err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msg("write attachment part")
 	}
-
-	// 76-chunked base64 with CRLF
-	for i := 0; i < len(adifB64); i += 76 {
-		end := i + 76
-		if end > len(adifB64) {
-			end = len(adifB64)
-		}
-		if _, err := ap.Write([]byte(adifB64[i:end])); err != nil {
-			return MsgDef{}, errors.New(op).Err(err).Msg("write attachment part")
+	for res := range chunks {
+		for _, rec := range <-res {
+			if rec.err != nil {
+				return MsgDef{}, errors.New(op).Err(rec.err).Msg("failed to compose ADIF record")
+			}
+			if _, err = io.WriteString(aw, rec.text); err != nil {
+				return MsgDef{}, errors.New(op).Err(err).Msg("write attachment part")
+			}
+			meta.observe(rec.qso)
+			set.add(rec.text)
 		}
-		if _, err := ap.Write([]byte("\r\n")); err != nil {
-			return MsgDef{}, errors.New(op).Err(err).Msg("write attachment newline")
+	}
+	if meta.QSOCount == 0 {
+		switch opts.WhenEmpty {
+		case EmptyExportNote, EmptyExportADIF:
+		default:
+			if opts.active() {
+				return MsgDef{}, errors.New(op).Err(ErrNoQSOs).Msg("no QSOs match the export options")
+			}
+			return MsgDef{}, errors.New(op).Err(ErrNoQSOs).Msg("QSO slice cannot be empty")
 		}
 	}
+	if err = ap.Close(); err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msg("write attachment part")
+	}
 	if err := mw.Close(); err != nil {
 		return MsgDef{}, errors.New(op).Err(err).Msg("finalize multipart")
 	}
 
-	return MsgDef{From: from, To: tos, Msg: buf.String()}, nil
+	meta.finish()
+	if subjectTmpl != nil {
+		if subject, err = renderSubject(subjectTmpl, meta); err != nil {
+			return MsgDef{}, errors.New(op).Err(err).Msg("failed to render subject template")
+		}
+	}
+	hdr.Set("Subject", subject)
+
+	if meta.QSOCount == 0 && opts.WhenEmpty == EmptyExportNote {
+		note, structure, cerr := composeTextMessage(hdr, emptyExportNote(opts))
+		if cerr != nil {
+			return MsgDef{}, errors.New(op).Err(cerr).Msg("failed to compose no-QSOs note")
+		}
+		return MsgDef{From: from, To: tos, Msg: note, Category: CategoryExport, Structure: structure}, nil
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(parts.Len() + 512)
+	writeHeaders(&buf, hdr)
+	buf.Write(parts.Bytes())
+
+	structure := &Message{Header: cloneHeader(hdr), Boundary: boundary, Parts: []MessagePart{
+		{Header: bodyHdr, Body: msg, Size: len(msg)},
+		{Header: attHdr, Filename: filename, Size: raw.n},
+	}}
+	return MsgDef{From: from, To: tos, Msg: buf.String(), Category: CategoryExport, ExportHash: set.String(), Structure: structure}, nil
 }
@@ -0,0 +1,124 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Draft describes a message to be built, for EstimateSize. Empty fields take the same config defaults as
+// BuildEmailWithADIFAttachment.
+type Draft struct {
+	From        string
+	To          []string
+	Subject     string
+	Body        string
+	Attachments []DraftAttachment
+}
+
+// DraftAttachment describes an attachment by its raw (pre-encoding) size.
+type DraftAttachment struct {
+	Filename string
+	Size     int
+	// Data, if set, is the attachment itself, so that its transfer encoding is known rather than assumed to be
+	// base64.
+	Data []byte
+}
+
+// EstimateSize returns the wire size in bytes of the message BuildEmailWithADIFAttachment would produce for msg.
+// Attachments without Data are counted base64 encoded, which is what an attachment of arbitrary content takes.
+func (s *Service) EstimateSize(msg Draft) int {
+	cfg := s.config()
+	from := strings.TrimSpace(msg.From)
+	if from == "" {
+		from = cfg.From
+	}
+	tos := msg.To
+	if len(tos) == 0 {
+		tos = s.defaultTo(false)
+	}
+	if resolved, err := s.resolveRecipients(context.Background(), tos); err == nil {
+		tos = resolved
+	}
+	subject := strings.TrimSpace(msg.Subject)
+	if subject == "" {
+		subject = cfg.Subject
+	}
+	body := strings.TrimSpace(msg.Body)
+	if body == "" {
+		body = cfg.Body
+	}
+
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("From", from)
+	hdr.Set("To", s.headerTo(tos))
+	hdr.Set("Subject", subject)
+	hdr.Set("Date", s.now().UTC().Format(time.RFC1123Z))
+	hdr.Set("Message-ID", generateMessageID(s.random(), s.now()))
+	hdr.Set("MIME-Version", "1.0")
+
+	var cw countingWriter
+	if len(msg.Attachments) == 0 {
+		hdr.Set("Content-Type", "text/plain; charset=utf-8")
+		pw := &partWriter{create: func(cte string) (io.Writer, error) {
+			hdr.Set("Content-Transfer-Encoding", cte)
+			return &cw, nil
+		}}
+		_, _ = io.WriteString(pw, body)
+		_ = pw.Close()
+		return headerSize(hdr) + cw.n
+	}
+
+	mw := newMultipartWriter(&cw, s.random())
+	hdr.Set("Content-Type", fmt.Sprintf("multipart/mixed; boundary=%q", mw.Boundary()))
+	wp := newPart(mw, mapToMIMEHeader(map[string]string{"Content-Type": "text/plain; charset=utf-8"}), false)
+	_, _ = io.WriteString(wp, body)
+	_ = wp.Close()
+	for _, a := range msg.Attachments {
+		filename := a.Filename
+		if filename == "" {
+			filename = fmt.Sprintf("%s-export.adi", s.now().Format("20060102150405"))
+		}
+		attHdr := mapToMIMEHeader(map[string]string{
+			"Content-Type":        fmt.Sprintf("application/octet-stream; name=%q", filename),
+			"Content-Disposition": fmt.Sprintf("attachment; filename=%q", filename),
+		})
+		if a.Data != nil {
+			ap := newPart(mw, attHdr, true)
+			_, _ = ap.Write(a.Data)
+			_ = ap.Close()
+			continue
+		}
+		attHdr.Set("Content-Transfer-Encoding", "base64")
+		_, _ = mw.CreatePart(attHdr)
+		cw.n += base64WrappedSize(a.Size)
+	}
+	_ = mw.Close()
+	return headerSize(hdr) + cw.n
+}
+
+// base64WrappedSize is the size of n bytes base64 encoded in 76-character CRLF-terminated lines.
+func base64WrappedSize(n int) int {
+	enc := (n + 2) / 3 * 4
+	lines := (enc + 75) / 76
+	return enc + 2*lines
+}
+
+func headerSize(hdr textproto.MIMEHeader) int {
+	var buf bytes.Buffer
+	writeHeaders(&buf, hdr)
+	return buf.Len()
+}
+
+type countingWriter struct {
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return len(p), nil
+}
@@ -0,0 +1,47 @@
+package email
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/adif"
+	"github.com/Station-Manager/types"
+)
+
+func TestEstimateSizeMatchesBuiltMessage(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{From: "from@example.com", To: "alice@example.com, bob@example.org", Subject: "Log export", Body: "Body"}}
+	qs := make([]types.Qso, 250)
+	for i := range qs {
+		qs[i] = types.Qso{LogbookID: 1, SessionID: int64(i)}
+	}
+	body := strings.Repeat("Contest log attached. ", 20) + "Süd 73"
+
+	built, err := s.BuildEmailWithADIFAttachment("", "", body, nil, qs)
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	adifContent, err := adif.ComposeToAdifString(qs)
+	if err != nil {
+		t.Fatalf("compose failed: %v", err)
+	}
+
+	got := s.EstimateSize(Draft{Body: body, Attachments: []DraftAttachment{{Size: len(adifContent), Data: []byte(adifContent)}}})
+	if got != len(built.Msg) {
+		t.Fatalf("estimate %d != built size %d", got, len(built.Msg))
+	}
+	// Without the data the attachment is counted as base64, which the ADIF beats
+	if got = s.EstimateSize(Draft{Body: body, Attachments: []DraftAttachment{{Size: len(adifContent)}}}); got <= len(built.Msg) {
+		t.Fatalf("estimate %d without data should exceed built size %d", got, len(built.Msg))
+	}
+}
+
+func TestBase64WrappedSize(t *testing.T) {
+	for _, n := range []int{0, 1, 56, 57, 58, 1000} {
+		enc := base64.StdEncoding.EncodedLen(n)
+		want := enc + 2*((enc+75)/76)
+		if got := base64WrappedSize(n); got != want {
+			t.Errorf("base64WrappedSize(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
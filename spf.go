@@ -0,0 +1,191 @@
+package email
+
+import (
+	"context"
+	stderr "errors"
+	"net"
+	"strings"
+)
+
+// dnsResolver is the subset of net.Resolver used by the DNS-based diagnostics.
+type dnsResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+type spfResult string
+
+const (
+	spfPass      spfResult = "pass"
+	spfFail      spfResult = "fail"
+	spfSoftFail  spfResult = "softfail"
+	spfNeutral   spfResult = "neutral"
+	spfNone      spfResult = "none"
+	spfPermError spfResult = "permerror"
+	spfTempError spfResult = "temperror"
+)
+
+// spfMaxLookups is the RFC 7208 limit on DNS-querying terms per evaluation.
+const spfMaxLookups = 10
+
+// lookupSPF returns the SPF record published by domain, or an empty string when there is none.
+func lookupSPF(ctx context.Context, r dnsResolver, domain string) (string, error) {
+	txts, err := r.LookupTXT(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if stderr.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, t := range txts {
+		if strings.HasPrefix(strings.ToLower(t), "v=spf1") {
+			return t, nil
+		}
+	}
+	return "", nil
+}
+
+// evalSPF evaluates a useful subset of RFC 7208 (ip4, ip6, a, mx, include, redirect, all) for ip.
+// exists and ptr terms are skipped, which can only make the result more conservative.
+func evalSPF(ctx context.Context, r dnsResolver, domain string, ip net.IP, lookups *int) spfResult {
+	record, err := lookupSPF(ctx, r, domain)
+	if err != nil {
+		return spfTempError
+	}
+	if record == "" {
+		return spfNone
+	}
+
+	redirect := ""
+	for _, term := range strings.Fields(record)[1:] {
+		term = strings.ToLower(term)
+		if v, ok := strings.CutPrefix(term, "redirect="); ok {
+			redirect = v
+			continue
+		}
+		if strings.Contains(term, "=") {
+			continue
+		}
+
+		qualifier := spfPass
+		switch term[0] {
+		case '+':
+			term = term[1:]
+		case '-':
+			qualifier, term = spfFail, term[1:]
+		case '~':
+			qualifier, term = spfSoftFail, term[1:]
+		case '?':
+			qualifier, term = spfNeutral, term[1:]
+		}
+		mech, arg, hasArg := strings.Cut(term, ":")
+		var cidr string
+		if hasArg {
+			arg, cidr, _ = strings.Cut(arg, "/")
+		} else {
+			// "a/24" and "mx/24//64" carry the prefix lengths straight after the mechanism
+			mech, cidr, _ = strings.Cut(mech, "/")
+		}
+
+		matched := false
+		switch mech {
+		case "all":
+			matched = true
+		case "ip4", "ip6":
+			matched = cidrContains(arg, cidr, ip)
+		case "a", "mx", "include":
+			*lookups++
+			if *lookups > spfMaxLookups {
+				return spfPermError
+			}
+			target := arg
+			if target == "" {
+				target = domain
+			}
+			switch mech {
+			case "a":
+				matched = hostMatches(ctx, r, target, cidr, ip)
+			case "mx":
+				if mxs, merr := r.LookupMX(ctx, target); merr == nil {
+					for _, mx := range mxs {
+						if hostMatches(ctx, r, mx.Host, cidr, ip) {
+							matched = true
+							break
+						}
+					}
+				}
+			case "include":
+				switch evalSPF(ctx, r, target, ip, lookups) {
+				case spfPass:
+					matched = true
+				case spfPermError, spfNone:
+					return spfPermError
+				case spfTempError:
+					return spfTempError
+				}
+			}
+		}
+		if matched {
+			return qualifier
+		}
+	}
+
+	if redirect != "" {
+		*lookups++
+		if *lookups > spfMaxLookups {
+			return spfPermError
+		}
+		return evalSPF(ctx, r, redirect, ip, lookups)
+	}
+	return spfNeutral
+}
+
+func hostMatches(ctx context.Context, r dnsResolver, host, cidr string, ip net.IP) bool {
+	addrs, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return false
+	}
+	v4, v6 := splitDualCIDR(cidr)
+	for _, a := range addrs {
+		prefix := v4
+		if a.IP.To4() == nil {
+			prefix = v6
+		}
+		if cidrContains(a.IP.String(), prefix, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitDualCIDR splits the dual-cidr-length of an a or mx mechanism, such as "24", "24//64" or "/64" (RFC 7208
+// section 5.6), into its IPv4 and IPv6 prefix lengths.
+func splitDualCIDR(cidr string) (v4, v6 string) {
+	if rest, ok := strings.CutPrefix(cidr, "/"); ok {
+		return "", rest
+	}
+	v4, v6, _ = strings.Cut(cidr, "//")
+	return v4, v6
+}
+
+func cidrContains(network, prefix string, ip net.IP) bool {
+	base := net.ParseIP(network)
+	if base == nil {
+		return false
+	}
+	bits := 32
+	if base.To4() == nil {
+		bits = 128
+	}
+	if prefix == "" {
+		return base.Equal(ip)
+	}
+	_, n, err := net.ParseCIDR(network + "/" + prefix)
+	if err != nil {
+		return false
+	}
+	ones, _ := n.Mask.Size()
+	return ones <= bits && n.Contains(ip)
+}
@@ -0,0 +1,68 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/Station-Manager/errors"
+)
+
+// defaultSpillDirName is created under os.TempDir when QueueConfig.SpillDir is empty.
+const defaultSpillDirName = "station-manager-email-spill"
+
+func (c QueueConfig) spillDir() string {
+	if c.SpillDir != "" {
+		return c.SpillDir
+	}
+	return filepath.Join(os.TempDir(), defaultSpillDirName)
+}
+
+func (c QueueConfig) spillPath(id string) string {
+	return filepath.Join(c.spillDir(), filepath.Base(id)+".eml")
+}
+
+// spillBody moves the body of m to disk when keeping it would take the queued bodies held in memory past
+// QueueConfig.MemoryBudget. A body that cannot be written stays in memory.
+func (s *Service) spillBody(m *QueuedMessage) {
+	budget := s.QueueConfig.MemoryBudget
+	if budget <= 0 || m.spilled || s.queue.resident()+int64(len(m.Msg.Msg)) <= budget {
+		return
+	}
+	if err := os.MkdirAll(s.QueueConfig.spillDir(), 0o700); err != nil {
+		s.LoggerService.ErrorWith().Err(err).Str("queue_id", m.ID).Msg("failed to create spill directory; keeping queued body in memory")
+		return
+	}
+	if err := writeFileAtomic(s.QueueConfig.spillPath(m.ID), []byte(m.Msg.Msg), 0o600); err != nil {
+		s.LoggerService.ErrorWith().Err(err).Str("queue_id", m.ID).Msg("failed to spill queued body; keeping it in memory")
+		return
+	}
+	m.Msg.Msg = ""
+	m.Msg.Structure = nil
+	m.spilled = true
+}
+
+// loadBody reads back a body moved to disk by spillBody. The spill file is kept until the message leaves the queue
+// for good, so a failed attempt can drop the body again without rewriting it.
+func (s *Service) loadBody(m *QueuedMessage) error {
+	const op errors.Op = "email.Service.loadBody"
+	if !m.spilled {
+		return nil
+	}
+	data, err := os.ReadFile(s.QueueConfig.spillPath(m.ID))
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to read spilled message body")
+	}
+	m.Msg.Msg = string(data)
+	m.spilled = false
+	return nil
+}
+
+// dropSpill removes the spill file of a message that has left the queue.
+func (s *Service) dropSpill(id string) {
+	if s.QueueConfig.MemoryBudget <= 0 {
+		return
+	}
+	if err := os.Remove(s.QueueConfig.spillPath(id)); err != nil && !os.IsNotExist(err) {
+		s.LoggerService.ErrorWith().Err(err).Str("queue_id", id).Msg("failed to remove spilled message body")
+	}
+}
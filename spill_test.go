@@ -0,0 +1,54 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestQueueSpillsBodiesBeyondMemoryBudget(t *testing.T) {
+	dir := t.TempDir()
+	s := &Service{
+		Config:      &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"},
+		QueueConfig: QueueConfig{MemoryBudget: 64, SpillDir: dir},
+	}
+	s.isInitialized.Store(true)
+
+	var delivered []string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		delivered = append(delivered, string(msg))
+		return deliveryInfo{}, nil
+	})
+
+	small := "Subject: small\r\n\r\nhi"
+	large := "Subject: export\r\n\r\n" + strings.Repeat("<CALL:5>K1ABC<EOR>\r\n", 10)
+	s.enqueue(&QueuedMessage{ID: "small", Msg: MsgDef{To: []string{"to@example.com"}, Msg: small}})
+	s.enqueue(&QueuedMessage{ID: "large", Msg: MsgDef{To: []string{"to@example.com"}, Msg: large}})
+
+	if got := s.queue.resident(); got != int64(len(small)) {
+		t.Fatalf("expected only the small body in memory, got %d bytes", got)
+	}
+	if data, err := os.ReadFile(s.QueueConfig.spillPath("large")); err != nil || string(data) != large {
+		t.Fatalf("large body not spilled: %v", err)
+	}
+	for _, m := range s.Queued() {
+		if m.ID == "large" && m.Msg.Msg != large {
+			t.Fatal("Queued should read spilled bodies back")
+		}
+	}
+
+	s.FlushQueue(t.Context())
+	if len(delivered) != 2 || !strings.Contains(delivered[0]+delivered[1], "<CALL:5>K1ABC<EOR>") {
+		t.Fatalf("spilled body not delivered in full: %d messages", len(delivered))
+	}
+	if _, err := os.Stat(s.QueueConfig.spillPath("large")); !os.IsNotExist(err) {
+		t.Errorf("spill file should be removed once delivered, stat err=%v", err)
+	}
+	if s.QueueDepth() != 0 || s.queue.resident() != 0 {
+		t.Errorf("queue not drained: depth=%d resident=%d", s.QueueDepth(), s.queue.resident())
+	}
+}
@@ -0,0 +1,97 @@
+package email
+
+import (
+	stderr "errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultProbeInterval is how often a spooled message is tried while its SMTP host is unreachable.
+const defaultProbeInterval = time.Minute
+
+func (c QueueConfig) probeInterval() time.Duration {
+	if c.ProbeInterval > 0 {
+		return c.ProbeInterval
+	}
+	return defaultProbeInterval
+}
+
+// isUnreachable reports whether err means the SMTP host could not be reached at all, as when the station has lost
+// its internet connection, rather than that the host refused or failed the message.
+func isUnreachable(err error) bool {
+	var dnsErr *net.DNSError
+	if stderr.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return stderr.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// spools reports whether a message that failed with err is held for QueueConfig.SpoolOffline.
+func (s *Service) spools(err error) bool {
+	return s.QueueConfig.SpoolOffline && isUnreachable(err)
+}
+
+// offlineHosts tracks the SMTP hosts found unreachable, and since when.
+type offlineHosts struct {
+	mu    sync.Mutex
+	since map[string]time.Time
+}
+
+func (o *offlineHosts) mark(host string, now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.since == nil {
+		o.since = map[string]time.Time{}
+	}
+	if _, ok := o.since[host]; !ok {
+		o.since[host] = now
+	}
+}
+
+// clear forgets host and returns since when it was unreachable; ok is false if it was not.
+func (o *offlineHosts) clear(host string) (time.Time, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	since, ok := o.since[host]
+	delete(o.since, host)
+	return since, ok
+}
+
+// snapshot returns a copy of the unreachable hosts, or nil if there are none.
+func (o *offlineHosts) snapshot() map[string]time.Time {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.since) == 0 {
+		return nil
+	}
+	out := make(map[string]time.Time, len(o.since))
+	for host, since := range o.since {
+		out[host] = since
+	}
+	return out
+}
+
+// trackReachability notes whether an attempt to host reached it. When the host answers again after being
+// unreachable, the messages spooled meanwhile are made due at once rather than at their next probe.
+func (s *Service) trackReachability(host string, err error) {
+	if !s.QueueConfig.SpoolOffline {
+		return
+	}
+	if isUnreachable(err) {
+		s.offline.mark(host, time.Now())
+		return
+	}
+	since, ok := s.offline.clear(host)
+	if !ok {
+		return
+	}
+	s.LoggerService.InfoWith().Str("host", host).Dur("offline_for", time.Since(since)).
+		Int("spooled", s.queue.depth()).Msg("SMTP host reachable again; flushing spooled mail")
+	s.queue.makeDue(time.Now(), s.now())
+	select {
+	case s.queue.wakeChan() <- struct{}{}:
+	default:
+	}
+}
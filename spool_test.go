@@ -0,0 +1,68 @@
+package email
+
+import (
+	"context"
+	stderr "errors"
+	"net"
+	"net/smtp"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestOfflineSpoolSurvivesRestartAndFlushesOnReconnect(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "op@example.org", SmtpRetryCount: 3}
+	qc := QueueConfig{SpoolOffline: true, ProbeInterval: time.Minute}
+	first := &Service{Config: cfg, QueueConfig: qc, QueueStore: &FileQueueStore{Dir: dir}}
+	first.isInitialized.Store(true)
+
+	online := false
+	calls := 0
+	deliver := smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		calls++
+		if !online {
+			return deliveryInfo{}, &net.OpError{Op: "dial", Net: "tcp", Err: stderr.New("network is unreachable")}
+		}
+		return deliveryInfo{}, nil
+	})
+	first.Transport = deliver
+
+	for _, to := range []string{"dx1@example.com", "dx2@example.com"} {
+		res, err := first.SendWithResult(t.Context(), MsgDef{To: []string{to}, Msg: "Subject: qsl\r\n\r\n73\r\n"})
+		if err != nil || res.Status != SendStatusQueued {
+			t.Fatalf("send while offline = %+v, %v", res, err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("expected one attempt per send while offline, got %d", calls)
+	}
+
+	// The process restarts while still offline
+	second := &Service{Config: cfg, QueueConfig: qc, QueueStore: &FileQueueStore{Dir: dir}, Transport: deliver}
+	second.isInitialized.Store(true)
+	second.restoreQueue()
+	calls = 0
+	for range 20 {
+		second.FlushQueue(t.Context())
+	}
+	if calls != 20 {
+		t.Fatalf("expected one probe per flush for the unreachable host, got %d", calls)
+	}
+	for _, m := range second.Queued() {
+		if m.Attempts != 0 {
+			t.Fatalf("offline attempts must not count against MaxAttempts: %+v", m)
+		}
+	}
+
+	online = true
+	second.flushQueue(t.Context(), time.Now().Add(time.Hour))
+	if second.QueueDepth() != 0 {
+		t.Fatalf("expected the spool to be flushed once the host answered, depth=%d", second.QueueDepth())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected delivered messages to leave the store, %d left", len(entries))
+	}
+}
@@ -0,0 +1,157 @@
+package email
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+const (
+	srsHashLen       = 4
+	srsDefaultMaxAge = 21 * 24 * time.Hour
+	srsTimeAlphabet  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	srsTimePrecision = 24 * time.Hour
+	srsTimeSlots     = 1024
+)
+
+// SRSConfig enables the Sender Rewriting Scheme for envelopes whose sender is on a foreign domain, e.g. forwarded
+// club mail, so that SPF at the destination checks this relay's domain rather than the original sender's.
+type SRSConfig struct {
+	// Secret keys the address hashes. Changing it invalidates bounces to addresses rewritten earlier.
+	Secret []byte
+	// Domain is the domain rewritten addresses use; defaults to the domain of the configured From address.
+	Domain string
+	// MaxAge bounds how long a rewritten address accepts bounces. Defaults to 21 days.
+	MaxAge time.Duration
+}
+
+func (c *SRSConfig) domain(from string) string {
+	if d := strings.TrimSpace(c.Domain); d != "" {
+		return strings.ToLower(d)
+	}
+	_, d, _ := strings.Cut(strings.TrimSpace(from), "@")
+	return strings.ToLower(d)
+}
+
+// srsRewrite returns the envelope sender to use for from: unchanged when SRS is off, the address is empty (a
+// bounce) or already on the rewriting domain, and SRS-encoded otherwise.
+func (s *Service) srsRewrite(from, configFrom string, now time.Time) string {
+	if s.SRS == nil || len(s.SRS.Secret) == 0 {
+		return from
+	}
+	at := strings.LastIndexByte(from, '@')
+	ours := s.SRS.domain(configFrom)
+	if at <= 0 || ours == "" || strings.EqualFold(from[at+1:], ours) {
+		return from
+	}
+	local, host := from[:at], from[at+1:]
+
+	if rest, ok := cutPrefixFold(local, "SRS1="); ok {
+		// Already rewritten twice: keep the first forwarder, re-sign for ourselves
+		if parts := strings.SplitN(rest, "=", 3); len(parts) == 3 && strings.HasPrefix(parts[2], "=") {
+			firstHost, user := parts[1], parts[2]
+			return "SRS1=" + s.SRS.hash(firstHost, user) + "=" + firstHost + "=" + user + "@" + ours
+		}
+	}
+	if rest, ok := cutPrefixFold(local, "SRS0"); ok && strings.HasPrefix(rest, "=") {
+		return "SRS1=" + s.SRS.hash(host, rest) + "=" + host + "=" + rest + "@" + ours
+	}
+
+	ts := srsTimestamp(now)
+	return "SRS0=" + s.SRS.hash(ts, host, local) + "=" + ts + "=" + host + "=" + local + "@" + ours
+}
+
+// SRSReverse decodes an address produced by SRS rewriting back to the address bounces should go to. SRS1
+// addresses decode to the previous forwarder's SRS0 address.
+func (s *Service) SRSReverse(addr string) (string, error) {
+	const op errors.Op = "email.Service.SRSReverse"
+	if s.SRS == nil || len(s.SRS.Secret) == 0 {
+		return "", errors.New(op).Msg("SRS is not configured")
+	}
+	at := strings.LastIndexByte(addr, '@')
+	if at <= 0 {
+		return "", errors.New(op).Msgf("invalid address %q", addr)
+	}
+	local := addr[:at]
+
+	if rest, ok := cutPrefixFold(local, "SRS1="); ok {
+		parts := strings.SplitN(rest, "=", 3)
+		if len(parts) != 3 || !strings.HasPrefix(parts[2], "=") {
+			return "", errors.New(op).Msgf("malformed SRS1 address %q", addr)
+		}
+		hash, host, user := parts[0], parts[1], parts[2]
+		if !s.SRS.validHash(hash, host, user) {
+			return "", errors.New(op).Msgf("SRS hash mismatch for %q", addr)
+		}
+		return "SRS0" + user + "@" + host, nil
+	}
+	if rest, ok := cutPrefixFold(local, "SRS0="); ok {
+		parts := strings.SplitN(rest, "=", 4)
+		if len(parts) != 4 {
+			return "", errors.New(op).Msgf("malformed SRS0 address %q", addr)
+		}
+		hash, ts, host, user := parts[0], parts[1], parts[2], parts[3]
+		if !s.SRS.validHash(hash, ts, host, user) {
+			return "", errors.New(op).Msgf("SRS hash mismatch for %q", addr)
+		}
+		if !s.SRS.fresh(ts, time.Now()) {
+			return "", errors.New(op).Msgf("SRS address %q has expired", addr)
+		}
+		return user + "@" + host, nil
+	}
+	return "", errors.New(op).Msgf("%q is not an SRS address", addr)
+}
+
+func (c *SRSConfig) hash(parts ...string) string {
+	mac := hmac.New(sha1.New, c.Secret)
+	for _, p := range parts {
+		mac.Write([]byte(strings.ToLower(p)))
+	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))[:srsHashLen]
+}
+
+// validHash compares case-insensitively, since some MTAs lowercase local parts.
+func (c *SRSConfig) validHash(hash string, parts ...string) bool {
+	return hmac.Equal([]byte(strings.ToLower(hash)), []byte(strings.ToLower(c.hash(parts...))))
+}
+
+func (c *SRSConfig) fresh(ts string, now time.Time) bool {
+	if len(ts) != 2 {
+		return false
+	}
+	hi := strings.IndexByte(srsTimeAlphabet, upper(ts[0]))
+	lo := strings.IndexByte(srsTimeAlphabet, upper(ts[1]))
+	if hi < 0 || lo < 0 {
+		return false
+	}
+	maxAge := c.MaxAge
+	if maxAge <= 0 {
+		maxAge = srsDefaultMaxAge
+	}
+	today := int(now.Unix() / int64(srsTimePrecision/time.Second))
+	age := (today - (hi<<5 | lo) + srsTimeSlots) % srsTimeSlots
+	return time.Duration(age)*srsTimePrecision <= maxAge
+}
+
+func srsTimestamp(now time.Time) string {
+	day := int(now.Unix()/int64(srsTimePrecision/time.Second)) % srsTimeSlots
+	return string([]byte{srsTimeAlphabet[day>>5], srsTimeAlphabet[day&31]})
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+func upper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
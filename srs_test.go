@@ -0,0 +1,65 @@
+package email
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSRSRoundTrip(t *testing.T) {
+	s := &Service{SRS: &SRSConfig{Secret: []byte("club-secret")}}
+	now := time.Now()
+
+	if got := s.srsRewrite("op@club.example", "op@club.example", now); got != "op@club.example" {
+		t.Fatalf("own-domain sender should be unchanged, got %q", got)
+	}
+	if got := s.srsRewrite("", "op@club.example", now); got != "" {
+		t.Fatalf("null sender should be unchanged, got %q", got)
+	}
+
+	rewritten := s.srsRewrite("dx@foreign.example", "op@club.example", now)
+	if !strings.HasPrefix(rewritten, "SRS0=") || !strings.HasSuffix(rewritten, "=foreign.example=dx@club.example") {
+		t.Fatalf("unexpected SRS0 address %q", rewritten)
+	}
+	orig, err := s.SRSReverse(rewritten)
+	if err != nil || orig != "dx@foreign.example" {
+		t.Fatalf("reverse = %q, %v", orig, err)
+	}
+	if _, err = s.SRSReverse(strings.ToLower(rewritten)); err != nil {
+		t.Fatalf("lowercased address should still verify: %v", err)
+	}
+
+	tampered := strings.Replace(rewritten, "=dx@", "=dy@", 1)
+	if _, err = s.SRSReverse(tampered); err == nil {
+		t.Fatal("expected hash mismatch for a tampered address")
+	}
+}
+
+func TestSRSExpiry(t *testing.T) {
+	s := &Service{SRS: &SRSConfig{Secret: []byte("k"), MaxAge: 48 * time.Hour}}
+	old := s.srsRewrite("dx@foreign.example", "op@club.example", time.Now().Add(-5*24*time.Hour))
+	if _, err := s.SRSReverse(old); err == nil {
+		t.Fatal("expected an expired SRS address to be rejected")
+	}
+}
+
+func TestSRSChainsForwarders(t *testing.T) {
+	first := &Service{SRS: &SRSConfig{Secret: []byte("a"), Domain: "hop1.example"}}
+	second := &Service{SRS: &SRSConfig{Secret: []byte("b"), Domain: "hop2.example"}}
+
+	srs0 := first.srsRewrite("dx@foreign.example", "", time.Now())
+	srs1 := second.srsRewrite(srs0, "", time.Now())
+	if !strings.HasPrefix(srs1, "SRS1=") || !strings.Contains(srs1, "=hop1.example==") {
+		t.Fatalf("unexpected SRS1 address %q", srs1)
+	}
+	back, err := second.SRSReverse(srs1)
+	if err != nil || back != srs0 {
+		t.Fatalf("SRS1 reverse = %q, %v; want %q", back, err, srs0)
+	}
+
+	third := &Service{SRS: &SRSConfig{Secret: []byte("c"), Domain: "hop3.example"}}
+	again := third.srsRewrite(srs1, "", time.Now())
+	if !strings.HasPrefix(again, "SRS1=") || !strings.Contains(again, "=hop1.example==") {
+		t.Fatalf("SRS1 should keep the first forwarder, got %q", again)
+	}
+}
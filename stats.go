@@ -0,0 +1,109 @@
+package email
+
+import (
+	"sync"
+	"time"
+)
+
+// statsBuckets holds one bucket per minute for a rolling day.
+const statsBuckets = 24 * 60
+
+// OutcomeCounts aggregates send outcomes over a window.
+type OutcomeCounts struct {
+	Sent    int
+	Failed  int
+	Bounced int
+}
+
+// Stats summarises recent activity for the Station-Manager status page.
+type Stats struct {
+	LastHour OutcomeCounts
+	LastDay  OutcomeCounts
+	// AvgLatency is the mean time to hand a message to the server over the last hour.
+	AvgLatency time.Duration
+	// QueueDepth is the number of messages awaiting a deferred attempt.
+	QueueDepth int
+	// OfflineHosts is the breaker state: the SMTP hosts found unreachable under QueueConfig.SpoolOffline, and since
+	// when. Their mail is held and only probed until they answer again.
+	OfflineHosts map[string]time.Time
+}
+
+type statsBucket struct {
+	minute  int64
+	counts  OutcomeCounts
+	latency time.Duration
+}
+
+type sendStats struct {
+	mu      sync.Mutex
+	buckets [statsBuckets]statsBucket
+}
+
+// bucket returns the bucket for t, resetting it if it still holds data from a previous day.
+func (st *sendStats) bucket(t time.Time) *statsBucket {
+	minute := t.Unix() / 60
+	b := &st.buckets[minute%statsBuckets]
+	if b.minute != minute {
+		*b = statsBucket{minute: minute}
+	}
+	return b
+}
+
+func (st *sendStats) recordSent(latency time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	b := st.bucket(time.Now())
+	b.counts.Sent++
+	b.latency += latency
+}
+
+func (st *sendStats) recordFailed() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.bucket(time.Now()).counts.Failed++
+}
+
+func (st *sendStats) recordBounced() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.bucket(time.Now()).counts.Bounced++
+}
+
+func (st *sendStats) snapshot(now time.Time) Stats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var (
+		out     Stats
+		latency time.Duration
+	)
+	current := now.Unix() / 60
+	for _, b := range st.buckets {
+		age := current - b.minute
+		if age < 0 || age >= statsBuckets {
+			continue
+		}
+		addCounts(&out.LastDay, b.counts)
+		if age < 60 {
+			addCounts(&out.LastHour, b.counts)
+			latency += b.latency
+		}
+	}
+	if out.LastHour.Sent > 0 {
+		out.AvgLatency = latency / time.Duration(out.LastHour.Sent)
+	}
+	return out
+}
+
+func addCounts(dst *OutcomeCounts, c OutcomeCounts) {
+	dst.Sent += c.Sent
+	dst.Failed += c.Failed
+	dst.Bounced += c.Bounced
+}
+
+// Stats returns rolling hour and day aggregates of send outcomes, with the current queue depth and offline hosts.
+func (s *Service) Stats() Stats {
+	out := s.stats.snapshot(time.Now())
+	out.QueueDepth = s.queue.depth()
+	out.OfflineHosts = s.offline.snapshot()
+	return out
+}
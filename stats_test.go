@@ -0,0 +1,73 @@
+package email
+
+import (
+	"context"
+	stderr "errors"
+	"net"
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestSendStatsRollingWindows(t *testing.T) {
+	var st sendStats
+	now := time.Now()
+
+	// Two hours ago: counts towards the day only
+	old := st.bucket(now.Add(-2 * time.Hour))
+	old.counts.Sent = 3
+	old.latency = 3 * time.Second
+
+	st.recordSent(200 * time.Millisecond)
+	st.recordSent(400 * time.Millisecond)
+	st.recordFailed()
+	st.recordBounced()
+
+	got := st.snapshot(now)
+	if got.LastHour != (OutcomeCounts{Sent: 2, Failed: 1, Bounced: 1}) {
+		t.Errorf("unexpected hour counts: %+v", got.LastHour)
+	}
+	if got.LastDay != (OutcomeCounts{Sent: 5, Failed: 1, Bounced: 1}) {
+		t.Errorf("unexpected day counts: %+v", got.LastDay)
+	}
+	if got.AvgLatency != 300*time.Millisecond {
+		t.Errorf("unexpected average latency: %v", got.AvgLatency)
+	}
+
+	// Data older than a day is ignored
+	if got = st.snapshot(now.Add(25 * time.Hour)); got.LastDay != (OutcomeCounts{}) {
+		t.Errorf("expected empty day after 25h, got %+v", got.LastDay)
+	}
+}
+
+func TestServiceStatsReportsQueueAndOfflineHosts(t *testing.T) {
+	cfg := &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "op@example.org", SmtpRetryCount: 3}
+	s := &Service{Config: cfg, QueueConfig: QueueConfig{SpoolOffline: true}}
+	s.isInitialized.Store(true)
+	online := false
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		if !online {
+			return deliveryInfo{}, &net.OpError{Op: "dial", Net: "tcp", Err: stderr.New("network is unreachable")}
+		}
+		return deliveryInfo{}, nil
+	})
+
+	if res, err := s.SendWithResult(t.Context(), MsgDef{To: []string{"dx@example.com"}, Msg: "Subject: qsl\r\n\r\n73\r\n"}); err != nil || res.Status != SendStatusQueued {
+		t.Fatalf("send while offline = %+v, %v", res, err)
+	}
+	got := s.Stats()
+	if got.QueueDepth != 1 {
+		t.Errorf("expected a queue depth of 1, got %d", got.QueueDepth)
+	}
+	if _, ok := got.OfflineHosts["smtp.example.com"]; !ok || len(got.OfflineHosts) != 1 {
+		t.Errorf("expected the SMTP host to be reported offline, got %v", got.OfflineHosts)
+	}
+
+	online = true
+	s.FlushQueue(t.Context())
+	if got = s.Stats(); got.QueueDepth != 0 || got.OfflineHosts != nil {
+		t.Errorf("expected an empty queue and no offline hosts once delivered, got %d and %v", got.QueueDepth, got.OfflineHosts)
+	}
+}
@@ -0,0 +1,68 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// strictCipherSuites are the TLS 1.2 suites allowed under Service.StrictCrypto: ECDHE key exchange with AES-GCM.
+// TLS 1.3 suites are not configurable; Go negotiates only AES-GCM ones when built in FIPS 140 mode.
+var strictCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// strictCurves are the key exchange groups allowed under Service.StrictCrypto.
+var strictCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// weakAuthMechanisms are the SASL mechanisms refused under Service.StrictCrypto, being built on MD5.
+var weakAuthMechanisms = []string{"CRAM-MD5", "DIGEST-MD5"}
+
+type strictCryptoKey struct{}
+
+// withStrictCrypto carries Service.StrictCrypto to the TLS handshakes of the SMTP session.
+func withStrictCrypto(ctx context.Context, strict bool) context.Context {
+	if !strict {
+		return ctx
+	}
+	return context.WithValue(ctx, strictCryptoKey{}, true)
+}
+
+func strictCryptoFromContext(ctx context.Context) bool {
+	strict, _ := ctx.Value(strictCryptoKey{}).(bool)
+	return strict
+}
+
+// restrictTLS limits cfg to TLS 1.2 or later with the strict cipher suites and curves.
+func restrictTLS(cfg *tls.Config) {
+	cfg.MinVersion = tls.VersionTLS12
+	cfg.CipherSuites = strictCipherSuites
+	cfg.CurvePreferences = strictCurves
+}
+
+func isWeakAuthMechanism(name string) bool {
+	for _, m := range weakAuthMechanisms {
+		if strings.EqualFold(strings.TrimSpace(name), m) {
+			return true
+		}
+	}
+	return false
+}
+
+// strictAuth refuses to start an exchange with a weak mechanism, catching custom AuthMechanisms that choose one.
+type strictAuth struct {
+	smtp.Auth
+}
+
+func (a strictAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	mech, resp, err := a.Auth.Start(server)
+	if err == nil && isWeakAuthMechanism(mech) {
+		return "", nil, fmt.Errorf("%s authentication is not allowed in strict crypto mode", mech)
+	}
+	return mech, resp, err
+}
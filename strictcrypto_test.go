@@ -0,0 +1,49 @@
+package email
+
+import (
+	"crypto/tls"
+	"net/smtp"
+	"slices"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestStrictCrypto(t *testing.T) {
+	cfg := &types.EmailConfig{Host: "smtp.example.com", Port: 587}
+	s := &Service{StrictCrypto: true}
+	tc := tlsConfig(s.sessionContext(t.Context(), cfg), "smtp.example.com")
+	if tc.MinVersion != tls.VersionTLS12 || !slices.Equal(tc.CipherSuites, strictCipherSuites) || !slices.Equal(tc.CurvePreferences, strictCurves) {
+		t.Fatalf("unrestricted TLS config %+v", tc)
+	}
+	if tc = tlsConfig((&Service{}).sessionContext(t.Context(), cfg), "smtp.example.com"); tc.CipherSuites != nil {
+		t.Fatalf("restricted TLS config outside strict mode")
+	}
+
+	s.AuthMechanism = AuthCRAMMD5
+	if _, err := s.authFactory(); err == nil {
+		t.Fatalf("CRAM-MD5 allowed in strict mode")
+	}
+
+	// A custom mechanism is refused once it turns out to be MD5-based
+	s.AuthMechanism = "legacy"
+	s.AuthMechanisms = map[string]AuthFactory{
+		"legacy": func(username, password, _ string) (smtp.Auth, error) {
+			return smtp.CRAMMD5Auth(username, password), nil
+		},
+	}
+	auth, err := s.smtpAuth(t.Context(), "op", "secret", "smtp.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = auth.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: true}); err == nil {
+		t.Fatalf("MD5-based custom mechanism allowed in strict mode")
+	}
+	s.AuthMechanism = AuthPlain
+	if auth, err = s.smtpAuth(t.Context(), "op", "secret", "smtp.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if mech, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: true}); err != nil || mech != "PLAIN" {
+		t.Fatalf("PLAIN refused: %q, %v", mech, err)
+	}
+}
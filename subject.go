@@ -0,0 +1,82 @@
+package email
+
+import (
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+// ExportMeta describes the QSOs of an ADIF export. It is the data for subject templates such as
+// "{{.Callsign}} log: {{.QSOCount}} QSOs {{.DateRange}}".
+type ExportMeta struct {
+	QSOCount int
+	// FirstQSO and LastQSO are the earliest and latest QSO start times; zero when no QSO carried a date.
+	FirstQSO time.Time
+	LastQSO  time.Time
+	// DateRange is "2006-01-02" for a single day or "2006-01-02..2006-01-05"; empty without dates.
+	DateRange string
+	// Callsign is the logging station's callsign, taken from the first QSO that has one.
+	Callsign string
+	// Contest is the CONTEST_ID shared by the QSOs; empty when none or when they differ.
+	Contest  string
+	Filename string
+
+	contestMixed bool
+}
+
+func (m *ExportMeta) observe(q types.Qso) {
+	m.QSOCount++
+	if at, ok := qsoStart(q); ok {
+		if m.FirstQSO.IsZero() || at.Before(m.FirstQSO) {
+			m.FirstQSO = at
+		}
+		if at.After(m.LastQSO) {
+			m.LastQSO = at
+		}
+	}
+	if m.Callsign == "" {
+		m.Callsign = strings.TrimSpace(q.StationCallsign)
+		if m.Callsign == "" {
+			m.Callsign = strings.TrimSpace(q.Operator)
+		}
+	}
+	contest := strings.TrimSpace(q.ContestId)
+	switch {
+	case m.contestMixed:
+	case m.QSOCount == 1:
+		m.Contest = contest
+	case !strings.EqualFold(contest, m.Contest):
+		m.Contest = ""
+		m.contestMixed = true
+	}
+}
+
+func (m *ExportMeta) finish() {
+	if m.FirstQSO.IsZero() {
+		return
+	}
+	first, last := m.FirstQSO.Format(time.DateOnly), m.LastQSO.Format(time.DateOnly)
+	m.DateRange = first
+	if last != first {
+		m.DateRange = first + ".." + last
+	}
+}
+
+// parseSubject parses subject as a template when it contains an action; plain subjects yield a nil template.
+func parseSubject(subject string) (*template.Template, error) {
+	if !strings.Contains(subject, "{{") {
+		return nil, nil
+	}
+	return template.New("subject").Option("missingkey=error").Parse(subject)
+}
+
+// renderSubject executes t, flattening any line breaks so the result is a single header line.
+func renderSubject(t *template.Template, meta ExportMeta) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, meta); err != nil {
+		return "", err
+	}
+	return strings.Join(strings.Fields(b.String()), " "), nil
+}
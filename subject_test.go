@@ -0,0 +1,52 @@
+package email
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestBuildEmailRendersSubjectTemplate(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{
+		From:    "from@example.com",
+		To:      "alice@example.com",
+		Subject: "{{.Callsign}} {{.Contest}} log: {{.QSOCount}} QSOs {{.DateRange}}",
+	}}
+	mk := func(date, contest string) types.Qso {
+		q := types.Qso{}
+		q.QsoDate, q.TimeOn, q.ContestId, q.StationCallsign = date, "1200", contest, "ZS6XYZ"
+		return q
+	}
+	qs := []types.Qso{mk("20240302", "ARRL-DX-SSB"), mk("20240303", "ARRL-DX-SSB"), mk("20240302", "ARRL-DX-SSB")}
+
+	def, err := s.BuildEmailWithADIFStream("", "", "", nil, slices.Values(qs), ADIFOptions{})
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	in, err := ParseInbound(strings.NewReader(def.Msg))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if got, want := in.Subject(), "ZS6XYZ ARRL-DX-SSB log: 3 QSOs 2024-03-02..2024-03-03"; got != want {
+		t.Fatalf("subject = %q, want %q", got, want)
+	}
+
+	// Mixed contests render an empty contest name
+	qs[1].ContestId = "CQ-WW-CW"
+	def, err = s.BuildEmailWithADIFStream("", "", "", nil, slices.Values(qs), ADIFOptions{})
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if in, _ = ParseInbound(strings.NewReader(def.Msg)); in.Subject() != "ZS6XYZ log: 3 QSOs 2024-03-02..2024-03-03" {
+		t.Fatalf("unexpected subject %q", in.Subject())
+	}
+
+	if _, err = s.BuildEmailWithADIFStream("", "{{.Nope", "", nil, slices.Values(qs), ADIFOptions{}); err == nil {
+		t.Fatalf("expected an invalid template to be rejected")
+	}
+	if _, err = s.BuildEmailWithADIFStream("", "{{.Nope}}", "", nil, slices.Values(qs), ADIFOptions{}); err == nil {
+		t.Fatalf("expected an unknown field to be rejected")
+	}
+}
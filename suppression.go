@@ -0,0 +1,279 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// SuppressionReason records why an address was put on the suppression list.
+type SuppressionReason string
+
+const (
+	SuppressionComplaint SuppressionReason = "complaint"
+	SuppressionManual    SuppressionReason = "manual"
+)
+
+// Suppression is an address that no message is sent to until it is removed with Unsuppress.
+type Suppression struct {
+	Address string
+	Reason  SuppressionReason
+	// MessageID is the message that was complained about, when known.
+	MessageID string
+	Detail    string
+	At        time.Time
+}
+
+// SuppressionStore persists the suppression list. Save inserts or replaces by address.
+type SuppressionStore interface {
+	Save(sup Suppression) error
+	Delete(address string) error
+	Load() ([]Suppression, error)
+}
+
+type suppressionList struct {
+	mu     sync.Mutex
+	byAddr map[string]Suppression
+}
+
+func (l *suppressionList) add(sup Suppression) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.byAddr == nil {
+		l.byAddr = map[string]Suppression{}
+	}
+	_, existed := l.byAddr[sup.Address]
+	l.byAddr[sup.Address] = sup
+	return !existed
+}
+
+func (l *suppressionList) remove(addr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.byAddr[addr]
+	delete(l.byAddr, addr)
+	return ok
+}
+
+func (l *suppressionList) has(addr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.byAddr[addr]
+	return ok
+}
+
+func (l *suppressionList) snapshot() []Suppression {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]Suppression, 0, len(l.byAddr))
+	for _, sup := range l.byAddr {
+		out = append(out, sup)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Address < out[j].Address })
+	return out
+}
+
+// suppressionKey reduces a recipient, possibly with a display name, to the lower-case address it is listed under.
+func suppressionKey(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if a, err := mail.ParseAddress(addr); err == nil {
+		addr = a.Address
+	}
+	return strings.ToLower(addr)
+}
+
+// Suppress adds sup.Address to the suppression list, persisting it to the SuppressionStore if one is set.
+func (s *Service) Suppress(sup Suppression) error {
+	return s.SuppressContext(context.Background(), sup)
+}
+
+// SuppressContext is Suppress, recording the Caller in ctx as the author of the change in the audit log.
+func (s *Service) SuppressContext(ctx context.Context, sup Suppression) error {
+	const op errors.Op = "email.Service.Suppress"
+	sup.Address = suppressionKey(sup.Address)
+	if !strings.Contains(sup.Address, "@") {
+		return errors.New(op).Msgf("invalid address %q", sup.Address)
+	}
+	if sup.Reason == "" {
+		sup.Reason = SuppressionManual
+	}
+	if sup.At.IsZero() {
+		sup.At = s.now().UTC()
+	}
+	if s.SuppressionStore != nil {
+		if err := s.SuppressionStore.Save(sup); err != nil {
+			return errors.New(op).Err(err).Msg("failed to persist suppression")
+		}
+	}
+	s.suppressions.add(sup)
+	s.audited(ctx, AuditEntry{Kind: AuditSuppression, Action: "suppress", Subject: sup.Address, Detail: string(sup.Reason)})
+	return nil
+}
+
+// Unsuppress removes addr from the suppression list.
+func (s *Service) Unsuppress(addr string) error {
+	return s.UnsuppressContext(context.Background(), addr)
+}
+
+// UnsuppressContext is Unsuppress, recording the Caller in ctx as the author of the change in the audit log.
+func (s *Service) UnsuppressContext(ctx context.Context, addr string) error {
+	const op errors.Op = "email.Service.Unsuppress"
+	addr = suppressionKey(addr)
+	if s.SuppressionStore != nil {
+		if err := s.SuppressionStore.Delete(addr); err != nil {
+			return errors.New(op).Err(err).Msg("failed to remove suppression")
+		}
+	}
+	if !s.suppressions.remove(addr) {
+		return errors.New(op).Msgf("%s is not suppressed", addr)
+	}
+	s.audited(ctx, AuditEntry{Kind: AuditSuppression, Action: "unsuppress", Subject: addr})
+	return nil
+}
+
+// Suppressions returns the suppression list ordered by address.
+func (s *Service) Suppressions() []Suppression {
+	return s.suppressions.snapshot()
+}
+
+// restoreSuppressions loads the list persisted by a previous run.
+func (s *Service) restoreSuppressions() {
+	if s.SuppressionStore == nil {
+		return
+	}
+	list, err := s.SuppressionStore.Load()
+	if err != nil {
+		s.LoggerService.ErrorWith().Err(err).Msg("failed to load suppression list")
+		return
+	}
+	for _, sup := range list {
+		s.suppressions.add(sup)
+	}
+}
+
+// dropSuppressed removes suppressed addresses from to.
+func (s *Service) dropSuppressed(d *delivery, to []string) []string {
+	out := to[:0:0]
+	for _, addr := range to {
+		if s.suppressions.has(suppressionKey(addr)) {
+			d.log.InfoWith().Str("recipient", addr).Msg("skipping suppressed recipient")
+			continue
+		}
+		out = append(out, addr)
+	}
+	return out
+}
+
+// suppressComplainant suppresses the recipient of a spam complaint and tells the operator the first time an
+// address is suppressed. Only an address the send history shows the reported message went to is suppressed, so a
+// forged report cannot silence an arbitrary address.
+func (s *Service) suppressComplainant(ds DeliveryStatus) {
+	addr := suppressionKey(ds.Recipient)
+	if addr == "" || s.suppressions.has(addr) {
+		return
+	}
+	if !s.wasRecipient(ds) {
+		s.LoggerService.WarnWith().Str("recipient", addr).Str("message_id", ds.MessageID).
+			Msg("ignoring spam complaint for an address the message was not sent to")
+		return
+	}
+	sup := Suppression{Address: addr, Reason: SuppressionComplaint, MessageID: ds.MessageID, Detail: ds.Status, At: ds.ReportedAt}
+	if err := s.Suppress(sup); err != nil {
+		s.LoggerService.ErrorWith().Err(err).Str("recipient", addr).Msg("failed to suppress complainant")
+		return
+	}
+	s.LoggerService.WarnWith().Str("recipient", addr).Str("message_id", ds.MessageID).Msg("recipient suppressed after spam complaint")
+	if !s.isInitialized.Load() {
+		return
+	}
+	body := fmt.Sprintf("%s reported a message from Station-Manager as spam and will not be mailed again until it is "+
+		"removed from the suppression list.\n\nMessage-ID: %s", addr, orNone(ds.MessageID))
+	n := Notification{Category: CategoryAlert, Subject: "Recipient suppressed after spam complaint: " + addr, Body: body}
+	if err := s.Notify(context.Background(), n); err != nil {
+		s.LoggerService.ErrorWith().Err(err).Str("recipient", addr).Msg("failed to notify operator of suppression")
+	}
+}
+
+// FileSuppressionStore keeps the suppression list in a single JSON file.
+type FileSuppressionStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (f *FileSuppressionStore) Save(sup Suppression) error {
+	const op errors.Op = "email.FileSuppressionStore.Save"
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list, err := f.load()
+	if err != nil {
+		return errors.New(op).Err(err).Msg(err.Error())
+	}
+	list[sup.Address] = sup
+	return f.write(op, list)
+}
+
+func (f *FileSuppressionStore) Delete(address string) error {
+	const op errors.Op = "email.FileSuppressionStore.Delete"
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list, err := f.load()
+	if err != nil {
+		return errors.New(op).Err(err).Msg(err.Error())
+	}
+	delete(list, address)
+	return f.write(op, list)
+}
+
+func (f *FileSuppressionStore) Load() ([]Suppression, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Suppression, 0, len(list))
+	for _, sup := range list {
+		out = append(out, sup)
+	}
+	return out, nil
+}
+
+func (f *FileSuppressionStore) load() (map[string]Suppression, error) {
+	const op errors.Op = "email.FileSuppressionStore.load"
+	list := map[string]Suppression{}
+	data, err := os.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to read suppression list")
+	}
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, errors.New(op).Err(err).Msg("failed to decode suppression list")
+	}
+	return list, nil
+}
+
+func (f *FileSuppressionStore) write(op errors.Op, list map[string]Suppression) error {
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to encode suppression list")
+	}
+	if err = os.MkdirAll(filepath.Dir(f.Path), 0o700); err != nil {
+		return errors.New(op).Err(err).Msg("failed to create suppression directory")
+	}
+	if err = writeFileAtomic(f.Path, data, 0o600); err != nil {
+		return errors.New(op).Err(err).Msg("failed to write suppression list")
+	}
+	return nil
+}
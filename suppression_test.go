@@ -0,0 +1,109 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+const testComplaint = "From: fbl@isp.example.net\r\n" +
+	"To: abuse@example.com\r\n" +
+	"Subject: FW: Log export\r\n" +
+	"Date: Mon, 02 Jun 2025 10:00:00 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=feedback-report; boundary=\"f1\"\r\n" +
+	"\r\n" +
+	"--f1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"This is an email abuse report.\r\n" +
+	"--f1\r\n" +
+	"Content-Type: message/feedback-report\r\n" +
+	"\r\n" +
+	"Feedback-Type: abuse\r\n" +
+	"User-Agent: ExampleFBL/1.0\r\n" +
+	"Version: 1\r\n" +
+	"\r\n" +
+	"--f1\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"Message-ID: <sent-1@example.com>\r\n" +
+	"To: K1ABC <K1ABC@example.net>\r\n" +
+	"Subject: Log export\r\n" +
+	"\r\n" +
+	"body\r\n" +
+	"--f1--\r\n"
+
+func TestComplaintSuppressesRecipient(t *testing.T) {
+	store := &FileSuppressionStore{Path: filepath.Join(t.TempDir(), "suppressions.json")}
+	s := &Service{
+		Config:           &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com", To: "op@example.com"},
+		SuppressionStore: store,
+	}
+	s.isInitialized.Store(true)
+
+	var sent []string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent = append(sent, strings.Join(to, ",")+"\n"+string(msg))
+		return deliveryInfo{}, nil
+	})
+
+	// A complaint is acted on only for an address the reported message was sent to
+	s.history.add(HistoryRecord{MessageID: "<sent-1@example.com>", To: []string{"k1abc@example.net"}, State: DeliveryStateSent})
+	forged, err := ParseInbound(strings.NewReader(strings.Replace(testComplaint, "K1ABC <K1ABC@example.net>", "w1aw@example.org", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.ApplyDeliveryStatus(forged); err != nil || len(s.Suppressions()) != 0 || len(sent) != 0 {
+		t.Fatalf("complaint for an address the message was not sent to: err=%v, suppressions %+v", err, s.Suppressions())
+	}
+
+	arf, err := ParseInbound(strings.NewReader(testComplaint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := s.ApplyDeliveryStatus(arf)
+	if err != nil || len(statuses) != 1 || statuses[0].State != DeliveryStateComplained || statuses[0].Status != "abuse" {
+		t.Fatalf("unexpected statuses %+v, err=%v", statuses, err)
+	}
+	sups := s.Suppressions()
+	if len(sups) != 1 || sups[0].Address != "k1abc@example.net" || sups[0].Reason != SuppressionComplaint || sups[0].MessageID != "<sent-1@example.com>" {
+		t.Fatalf("unexpected suppression list %+v", sups)
+	}
+	if len(sent) != 1 || !strings.HasPrefix(sent[0], "op@example.com\n") || !strings.Contains(sent[0], "k1abc@example.net") {
+		t.Fatalf("expected one operator notification, got %q", sent)
+	}
+
+	// A repeated complaint neither re-notifies nor errors
+	if _, err = s.ApplyDeliveryStatus(arf); err != nil || len(sent) != 1 {
+		t.Fatalf("repeat complaint: err=%v, %d messages", err, len(sent))
+	}
+
+	err = s.Send(MsgDef{To: []string{"k1abc@example.net"}, Msg: "Subject: hi\r\n\r\nbody"})
+	if err == nil || !strings.Contains(errors.Root(err).Error(), errMsgAllSuppressed) {
+		t.Fatalf("expected a send to only suppressed recipients to fail, got %v", err)
+	}
+	if err = s.Send(MsgDef{To: []string{"K1ABC@example.net", "w1aw@example.org"}, Msg: "Subject: hi\r\n\r\nbody"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sent[len(sent)-1], "w1aw@example.org\n") {
+		t.Fatalf("suppressed recipient not dropped: %q", sent[len(sent)-1])
+	}
+
+	restored := &Service{SuppressionStore: store}
+	restored.restoreSuppressions()
+	if got := restored.Suppressions(); len(got) != 1 || got[0].Address != "k1abc@example.net" {
+		t.Fatalf("suppression not persisted: %+v", got)
+	}
+	if err = restored.Unsuppress("K1ABC@example.net"); err != nil || len(restored.Suppressions()) != 0 {
+		t.Fatalf("Unsuppress failed: %v", err)
+	}
+	if list, _ := store.Load(); len(list) != 0 {
+		t.Fatalf("suppression still stored: %+v", list)
+	}
+}
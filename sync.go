@@ -0,0 +1,233 @@
+package email
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Station-Manager/adif"
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+const (
+	// syncHeader marks a log sync message so that HandleInbound can recognise it without opening the attachments
+	syncHeader       = "X-Station-Manager-Sync"
+	syncVersion      = 1
+	syncManifestName = "sync-manifest.json"
+	syncADIFName     = "sync.adi"
+)
+
+// SyncConfig enables log sync with other Station-Manager instances over email, for stations whose only link is a
+// mail gateway, e.g. Winlink. Each sync message carries the QSOs as ADIF and a manifest signed with Ed25519, so it
+// is authenticated even when the gateway breaks DKIM.
+type SyncConfig struct {
+	// Station names this instance to its peers, e.g. its callsign.
+	Station string
+	Key     ed25519.PrivateKey
+	// Peers are the instances synced with, keyed by station name.
+	Peers map[string]SyncPeer
+	// Apply stores the QSOs of a verified sync message. A QSO may arrive again, changed or not, so it must update
+	// rather than duplicate.
+	Apply func(station string, qsos []types.Qso) error
+}
+
+// SyncPeer is a remote instance: where its sync messages are sent and the key they are verified with.
+type SyncPeer struct {
+	Address   string
+	PublicKey ed25519.PublicKey
+}
+
+// SyncManifest describes the ADIF attachment of a sync message. Signature covers the manifest's JSON encoding with
+// Signature empty.
+type SyncManifest struct {
+	Version  int       `json:"version"`
+	Station  string    `json:"station"`
+	Created  time.Time `json:"created"`
+	QSOCount int       `json:"qso_count"`
+	// ADIFSHA256 is the hex SHA-256 of the ADIF attachment.
+	ADIFSHA256 string `json:"adif_sha256"`
+	Signature  string `json:"signature,omitempty"`
+}
+
+// syncState remembers the newest manifest applied from each peer, so that a replayed or reordered message
+// cannot roll back newer changes. It is not persisted; Apply being idempotent covers a restart.
+type syncState struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func (st *syncState) accept(station string, created time.Time) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !created.After(st.last[station]) {
+		return false
+	}
+	if st.last == nil {
+		st.last = map[string]time.Time{}
+	}
+	st.last[station] = created
+	return true
+}
+
+// SendSync mails qsos, typically those new or changed since the last sync, to the peer station.
+func (s *Service) SendSync(ctx context.Context, station string, qsos []types.Qso) (SendResult, error) {
+	const op errors.Op = "email.Service.SendSync"
+	cfg := s.Sync
+	if cfg == nil || cfg.Key == nil || cfg.Station == "" {
+		return SendResult{}, errors.New(op).Msg("log sync is not configured")
+	}
+	peer, ok := cfg.Peers[station]
+	if !ok {
+		return SendResult{}, errors.New(op).Msgf("unknown sync peer %q", station)
+	}
+	if len(qsos) == 0 {
+		return SendResult{}, errors.New(op).Msg("QSO slice cannot be empty")
+	}
+	recs := make([]adif.Record, 0, len(qsos))
+	for _, q := range qsos {
+		recs = append(recs, adif.QsoToRecord(q))
+	}
+	data := (&adif.Adif{HeaderSection: *s.adifHeader(), Records: recs}).String()
+	sum := sha256.Sum256([]byte(data))
+	manifest := SyncManifest{Version: syncVersion, Station: cfg.Station, Created: s.now().UTC(), QSOCount: len(qsos),
+		ADIFSHA256: hex.EncodeToString(sum[:])}
+	signed, err := json.Marshal(manifest)
+	if err != nil {
+		return SendResult{}, errors.New(op).Err(err).Msg("failed to encode sync manifest")
+	}
+	manifest.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(cfg.Key, signed))
+	mdata, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return SendResult{}, errors.New(op).Err(err).Msg("failed to encode sync manifest")
+	}
+
+	from := strings.TrimSpace(s.config().From)
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("From", from)
+	hdr.Set("To", peer.Address)
+	hdr.Set("Subject", fmt.Sprintf("Station-Manager log sync from %s (%d QSOs)", cfg.Station, len(qsos)))
+	hdr.Set("Date", s.now().UTC().Format(time.RFC1123Z))
+	hdr.Set("Message-ID", generateMessageID(s.random(), s.now()))
+	hdr.Set("Auto-Submitted", "auto-generated")
+	hdr.Set(syncHeader, fmt.Sprint(syncVersion))
+	body := fmt.Sprintf("Log sync from %s: %d QSOs. This message is processed automatically by Station-Manager.", cfg.Station, len(qsos))
+	msg, structure, err := composeMixedMessage(s.random(), hdr, body, "", []Attachment{
+		{Filename: syncManifestName, ContentType: "application/json", Data: mdata},
+		{Filename: syncADIFName, ContentType: "application/octet-stream", Data: []byte(data)},
+	})
+	if err != nil {
+		return SendResult{}, errors.New(op).Err(err).Msg("failed to compose message")
+	}
+	res, err := s.SendWithResult(ctx, MsgDef{From: from, To: []string{peer.Address}, Msg: msg, Structure: structure})
+	if err != nil {
+		return res, errors.New(op).Err(err).Msg(err.Error())
+	}
+	return res, nil
+}
+
+// ApplySync verifies a sync message from a peer and hands its QSOs to SyncConfig.Apply. ok is false when msg is
+// not a sync message or sync is not configured.
+func (s *Service) ApplySync(msg *InboundMessage) (ok bool, err error) {
+	const op errors.Op = "email.Service.ApplySync"
+	cfg := s.Sync
+	if cfg == nil || msg.Header.Get(syncHeader) == "" {
+		return false, nil
+	}
+	atts, err := msg.Attachments()
+	if err != nil {
+		return true, errors.New(op).Err(err).Msg("failed to read sync attachments")
+	}
+	var mdata, data []byte
+	for _, a := range atts {
+		switch a.Filename {
+		case syncManifestName:
+			mdata = a.Data
+		case syncADIFName:
+			data = a.Data
+		}
+	}
+	if mdata == nil || data == nil {
+		return true, errors.New(op).Msg("sync message lacks its manifest or ADIF attachment")
+	}
+
+	var manifest SyncManifest
+	if err = json.Unmarshal(mdata, &manifest); err != nil {
+		return true, errors.New(op).Err(err).Msg("failed to decode sync manifest")
+	}
+	if manifest.Version != syncVersion {
+		return true, errors.New(op).Msgf("unsupported sync manifest version %d", manifest.Version)
+	}
+	peer, known := cfg.Peers[manifest.Station]
+	if !known {
+		return true, errors.New(op).Msgf("sync message from unknown station %q", manifest.Station)
+	}
+	sig, _ := base64.StdEncoding.DecodeString(manifest.Signature)
+	manifest.Signature = ""
+	signed, err := json.Marshal(manifest)
+	if err != nil {
+		return true, errors.New(op).Err(err).Msg("failed to encode sync manifest")
+	}
+	if len(peer.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(peer.PublicKey, signed, sig) {
+		return true, errors.New(op).Msgf("sync manifest from %s has an invalid signature", manifest.Station)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != manifest.ADIFSHA256 {
+		return true, errors.New(op).Msgf("sync ADIF from %s does not match its manifest", manifest.Station)
+	}
+
+	parsed, err := adif.Marshal(data)
+	if err != nil {
+		return true, errors.New(op).Err(err).Msg("failed to parse sync ADIF")
+	}
+	if len(parsed.Records) != manifest.QSOCount {
+		return true, errors.New(op).Msgf("sync ADIF has %d QSOs, manifest lists %d", len(parsed.Records), manifest.QSOCount)
+	}
+	if !s.syncs.accept(manifest.Station, manifest.Created) {
+		s.LoggerService.WarnWith().Str("station", manifest.Station).Str("message_id", msg.ID).Msg("ignoring stale or replayed sync message")
+		return true, nil
+	}
+	qsos := make([]types.Qso, 0, len(parsed.Records))
+	for _, r := range parsed.Records {
+		qsos = append(qsos, recordToQso(r))
+	}
+	if cfg.Apply != nil {
+		if err = cfg.Apply(manifest.Station, qsos); err != nil {
+			return true, errors.New(op).Err(err).Msg("failed to apply synced QSOs")
+		}
+	}
+	s.LoggerService.InfoWith().Str("station", manifest.Station).Int("qsos", len(qsos)).Msg("applied log sync")
+	return true, nil
+}
+
+// recordToQso reverses adif.QsoToRecord.
+func recordToQso(r adif.Record) types.Qso {
+	return types.Qso{
+		QsoDetails:       r.QsoDetails,
+		ContactedStation: r.ContactedStation,
+		LoggingStation:   r.LoggingStation,
+		Qsl: types.Qsl{
+			QslMsg:     r.QslMsg,
+			QslMsgRcvd: r.QslMsgIntl,
+			QslRDate:   r.QslRDate,
+			QslSDate:   r.QslSDate,
+			QslRcvd:    r.QslRcvd,
+			QslSent:    r.QslSent,
+			QslSendVia: r.QslSentVia,
+			QslVia:     r.QslVia,
+		},
+		SmQsoUploadDate:     r.SmQsoUploadDate,
+		SmQsoUploadStatus:   r.SmQsoUploadStatus,
+		SmFwrdByEmailDate:   r.SmFwrdByEmailDate,
+		SmFwrdByEmailStatus: r.SmFwrdByEmailStatus,
+		QrzComUploadDate:    r.QrzComQsoUploadDate,
+		QrzComUploadStatus:  r.QrzComQsoUploadStatus,
+	}
+}
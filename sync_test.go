@@ -0,0 +1,95 @@
+package email
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestLogSyncRoundTrip(t *testing.T) {
+	pubA, keyA, _ := ed25519.GenerateKey(rand.Reader)
+	pubB, keyB, _ := ed25519.GenerateKey(rand.Reader)
+	newStation := func(call string, key ed25519.PrivateKey, peer string, peerKey ed25519.PublicKey) *Service {
+		s := &Service{
+			Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.org", Port: 587, From: strings.ToLower(call) + "@winlink.org"},
+			Sync: &SyncConfig{Station: call, Key: key, Peers: map[string]SyncPeer{
+				peer: {Address: strings.ToLower(peer) + "@winlink.org", PublicKey: peerKey},
+			}},
+		}
+		s.isInitialized.Store(true)
+		return s
+	}
+	a := newStation("K1ABC", keyA, "W1AW", pubB)
+	b := newStation("W1AW", keyB, "K1ABC", pubA)
+	var applied []types.Qso
+	b.Sync.Apply = func(station string, qsos []types.Qso) error {
+		if station != "K1ABC" {
+			t.Errorf("station = %q", station)
+		}
+		applied = append(applied, qsos...)
+		return nil
+	}
+
+	var sent []string
+	deliver := smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent = append(sent, string(msg))
+		return deliveryInfo{}, nil
+	})
+	a.Transport, b.Transport = deliver, deliver
+
+	q := types.Qso{}
+	q.Call, q.Band, q.Mode, q.QsoDate, q.TimeOn = "DL1XYZ", "20m", "CW", "20261015", "1200"
+	q.QslSent = "Y"
+	if _, err := a.SendSync(t.Context(), "W1AW", []types.Qso{q}); err != nil {
+		t.Fatal(err)
+	}
+	in, err := ParseInbound(strings.NewReader(sent[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isAutomatedMessage(in) {
+		t.Error("sync messages must be marked as automated")
+	}
+	if err = b.HandleInbound(in); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0].Call != "DL1XYZ" || applied[0].Band != "20m" || applied[0].QslSent != "Y" {
+		t.Fatalf("applied = %+v", applied)
+	}
+
+	// A replay is ignored rather than applied again
+	if err = b.HandleInbound(in); err != nil || len(applied) != 1 {
+		t.Fatalf("replay applied: err=%v, %d QSOs", err, len(applied))
+	}
+
+	// Changing the ADIF in transit breaks the manifest hash
+	if _, err = a.SendSync(t.Context(), "W1AW", []types.Qso{q, q}); err != nil {
+		t.Fatal(err)
+	}
+	tampered, _ := ParseInbound(strings.NewReader(sent[1]))
+	atts, _ := tampered.Attachments()
+	for i := range atts {
+		if atts[i].Filename == syncADIFName {
+			atts[i].Data = []byte(strings.Replace(string(atts[i].Data), "DL1XYZ", "DL9BAD", 1))
+		}
+	}
+	forged, _ := b.composeNotification(t.Context(), []string{"w1aw@winlink.org"}, "sync", "", atts, map[string][]string{syncHeader: {"1"}})
+	in, _ = ParseInbound(strings.NewReader(forged.Msg))
+	if _, err = b.ApplySync(in); err == nil || !strings.Contains(err.Error(), "does not match its manifest") {
+		t.Fatalf("tampered sync accepted: %v", err)
+	}
+
+	// Messages from stations that are not peers are refused
+	if _, err = b.SendSync(t.Context(), "K1ABC", []types.Qso{q}); err != nil {
+		t.Fatal(err)
+	}
+	in, _ = ParseInbound(strings.NewReader(sent[len(sent)-1]))
+	if _, err = b.ApplySync(in); err == nil || !strings.Contains(err.Error(), "unknown station") {
+		t.Fatalf("sync from a non-peer accepted: %v", err)
+	}
+}
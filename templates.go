@@ -0,0 +1,240 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Station-Manager/errors"
+)
+
+// Built-in template names.
+const (
+	TemplateADIFExport   = "adif-export"
+	TemplateTest         = "test-message"
+	TemplateNotification = "notification"
+)
+
+// Template is a user-editable message template. Subject and Body are text/template sources and HTML an
+// html/template source; HTML is optional.
+type Template struct {
+	Name    string
+	Subject string
+	Body    string
+	HTML    string `json:",omitempty"`
+	// Version counts saved edits from 1; the built-in default is version 0.
+	Version   int
+	UpdatedAt time.Time
+}
+
+// defaultTemplates ship with the application. Edits are stored separately, so upgrading these never overwrites
+// a user's template; RestoreDefault brings them back.
+var defaultTemplates = map[string]Template{
+	TemplateADIFExport: {
+		Name:    TemplateADIFExport,
+		Subject: "{{.Callsign}} log: {{.QSOCount}} QSOs {{.DateRange}}",
+		Body:    "Attached is {{.Filename}} with {{.QSOCount}} QSOs.",
+	},
+	TemplateTest: {
+		Name:    TemplateTest,
+		Subject: testMessageSubject,
+		Body:    "This is a test message from Station Manager. If you can read it, your email settings work.",
+	},
+	TemplateNotification: {
+		Name:    TemplateNotification,
+		Subject: "{{.Subject}}",
+		Body:    "{{.Body}}",
+	},
+}
+
+// TemplateStore persists every saved version of each template.
+type TemplateStore interface {
+	// SaveVersion appends t to the history of t.Name.
+	SaveVersion(t Template) error
+	// Versions returns the saved versions of name, oldest first.
+	Versions(name string) ([]Template, error)
+}
+
+var templateName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// Template returns the current version of name: the latest saved edit, or the built-in default.
+func (s *Service) Template(name string) (Template, error) {
+	const op errors.Op = "email.Service.Template"
+	versions, err := s.TemplateVersions(name)
+	if err != nil {
+		return Template{}, errors.New(op).Err(err).Msg(err.Error())
+	}
+	if len(versions) > 0 {
+		return versions[len(versions)-1], nil
+	}
+	if t, ok := defaultTemplates[name]; ok {
+		return t, nil
+	}
+	return Template{}, errors.New(op).Msgf("unknown template %q", name)
+}
+
+// TemplateVersions returns the saved versions of name, oldest first.
+func (s *Service) TemplateVersions(name string) ([]Template, error) {
+	const op errors.Op = "email.Service.TemplateVersions"
+	if !templateName.MatchString(name) {
+		return nil, errors.New(op).Msgf("invalid template name %q", name)
+	}
+	if s.Templates == nil {
+		return nil, nil
+	}
+	versions, err := s.Templates.Versions(name)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msgf("loading template %q", name)
+	}
+	return versions, nil
+}
+
+// SaveTemplate validates t and stores it as the next version of t.Name.
+func (s *Service) SaveTemplate(t Template) (Template, error) {
+	const op errors.Op = "email.Service.SaveTemplate"
+	return s.saveTemplate(context.Background(), op, t, "save")
+}
+
+// SaveTemplateContext is SaveTemplate, recording the Caller in ctx as the author of the change in the audit log.
+func (s *Service) SaveTemplateContext(ctx context.Context, t Template) (Template, error) {
+	const op errors.Op = "email.Service.SaveTemplate"
+	return s.saveTemplate(ctx, op, t, "save")
+}
+
+func (s *Service) saveTemplate(ctx context.Context, op errors.Op, t Template, action string) (Template, error) {
+	if s.Templates == nil {
+		return Template{}, errors.New(op).Msg("no template store configured")
+	}
+	if err := t.parse(); err != nil {
+		return Template{}, errors.New(op).Err(err).Msgf("invalid template %q: %v", t.Name, err)
+	}
+	s.templatesMu.Lock()
+	defer s.templatesMu.Unlock()
+	versions, err := s.TemplateVersions(t.Name)
+	if err != nil {
+		return Template{}, errors.New(op).Err(err).Msg(err.Error())
+	}
+	t.Version = 1
+	if n := len(versions); n > 0 {
+		t.Version = versions[n-1].Version + 1
+	}
+	t.UpdatedAt = s.now().UTC()
+	if err = s.Templates.SaveVersion(t); err != nil {
+		return Template{}, errors.New(op).Err(err).Msgf("saving template %q", t.Name)
+	}
+	s.audited(ctx, AuditEntry{Kind: AuditTemplate, Action: action, Subject: t.Name, Detail: fmt.Sprintf("version %d", t.Version)})
+	return t, nil
+}
+
+// RollbackTemplate saves a copy of an earlier version as the newest, keeping the history intact.
+func (s *Service) RollbackTemplate(name string, version int) (Template, error) {
+	return s.RollbackTemplateContext(context.Background(), name, version)
+}
+
+// RollbackTemplateContext is RollbackTemplate, recording the Caller in ctx as the author of the change in the audit
+// log.
+func (s *Service) RollbackTemplateContext(ctx context.Context, name string, version int) (Template, error) {
+	const op errors.Op = "email.Service.RollbackTemplate"
+	versions, err := s.TemplateVersions(name)
+	if err != nil {
+		return Template{}, errors.New(op).Err(err).Msg(err.Error())
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return s.saveTemplate(ctx, op, v, fmt.Sprintf("rollback to version %d", version))
+		}
+	}
+	return Template{}, errors.New(op).Msgf("template %q has no version %d", name, version)
+}
+
+// RestoreDefault saves the built-in default of name as its newest version.
+func (s *Service) RestoreDefault(name string) (Template, error) {
+	return s.RestoreDefaultContext(context.Background(), name)
+}
+
+// RestoreDefaultContext is RestoreDefault, recording the Caller in ctx as the author of the change in the audit log.
+func (s *Service) RestoreDefaultContext(ctx context.Context, name string) (Template, error) {
+	const op errors.Op = "email.Service.RestoreDefault"
+	def, ok := defaultTemplates[name]
+	if !ok {
+		return Template{}, errors.New(op).Msgf("template %q has no default", name)
+	}
+	return s.saveTemplate(ctx, op, def, "restore default")
+}
+
+// parse checks that every part of t compiles.
+func (t Template) parse() error {
+	if !templateName.MatchString(t.Name) {
+		return errors.New("email.Template.parse").Msgf("invalid template name %q", t.Name)
+	}
+	if _, err := template.New("subject").Parse(t.Subject); err != nil {
+		return err
+	}
+	if _, err := template.New("body").Parse(t.Body); err != nil {
+		return err
+	}
+	_, err := htmltemplate.New("html").Parse(t.HTML)
+	return err
+}
+
+// FileTemplateStore keeps the history of each template in a JSON file in Dir, typically next to the
+// application's config file.
+type FileTemplateStore struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+func (f *FileTemplateStore) path(name string) string {
+	return filepath.Join(f.Dir, filepath.Base(name)+".json")
+}
+
+func (f *FileTemplateStore) SaveVersion(t Template) error {
+	const op errors.Op = "email.FileTemplateStore.SaveVersion"
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	versions, err := f.load(t.Name)
+	if err != nil {
+		return errors.New(op).Err(err).Msg(err.Error())
+	}
+	data, err := json.MarshalIndent(append(versions, t), "", "  ")
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to encode template")
+	}
+	if err = os.MkdirAll(f.Dir, 0o700); err != nil {
+		return errors.New(op).Err(err).Msg("failed to create template directory")
+	}
+	if err = writeFileAtomic(f.path(t.Name), data, 0o600); err != nil {
+		return errors.New(op).Err(err).Msg("failed to write template")
+	}
+	return nil
+}
+
+func (f *FileTemplateStore) Versions(name string) ([]Template, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.load(name)
+}
+
+func (f *FileTemplateStore) load(name string) ([]Template, error) {
+	const op errors.Op = "email.FileTemplateStore.load"
+	data, err := os.ReadFile(f.path(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msgf("failed to read template %q", name)
+	}
+	var versions []Template
+	if err = json.Unmarshal(data, &versions); err != nil {
+		return nil, errors.New(op).Err(err).Msgf("failed to decode template %q", name)
+	}
+	return versions, nil
+}
@@ -0,0 +1,64 @@
+package email
+
+import (
+	"testing"
+)
+
+func TestTemplateVersioning(t *testing.T) {
+	dir := t.TempDir()
+	s := &Service{Templates: &FileTemplateStore{Dir: dir}}
+
+	def, err := s.Template(TemplateADIFExport)
+	if err != nil || def.Version != 0 || def.Subject != defaultTemplates[TemplateADIFExport].Subject {
+		t.Fatalf("expected the built-in default, got %+v, %v", def, err)
+	}
+
+	edit := def
+	edit.Subject = "{{.Callsign}} Field Day log"
+	v1, err := s.SaveTemplate(edit)
+	if err != nil || v1.Version != 1 {
+		t.Fatalf("first save = %+v, %v", v1, err)
+	}
+	edit.Subject = "{{.Callsign}} contest log"
+	if _, err = s.SaveTemplate(edit); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh store on the same directory sees the edits, as after an upgrade
+	s = &Service{Templates: &FileTemplateStore{Dir: dir}}
+	if cur, _ := s.Template(TemplateADIFExport); cur.Version != 2 || cur.Subject != "{{.Callsign}} contest log" {
+		t.Fatalf("current = %+v", cur)
+	}
+
+	back, err := s.RollbackTemplate(TemplateADIFExport, 1)
+	if err != nil || back.Version != 3 || back.Subject != "{{.Callsign}} Field Day log" {
+		t.Fatalf("rollback = %+v, %v", back, err)
+	}
+	restored, err := s.RestoreDefault(TemplateADIFExport)
+	if err != nil || restored.Version != 4 || restored.Subject != def.Subject {
+		t.Fatalf("restore = %+v, %v", restored, err)
+	}
+	if versions, _ := s.TemplateVersions(TemplateADIFExport); len(versions) != 4 {
+		t.Fatalf("expected the full history to be kept, got %d versions", len(versions))
+	}
+}
+
+func TestSaveTemplateRejectsInvalid(t *testing.T) {
+	s := &Service{Templates: &FileTemplateStore{Dir: t.TempDir()}}
+	bad := []Template{
+		{Name: "adif-export", Subject: "{{.Callsign"},
+		{Name: "adif-export", HTML: "<p>{{if}}</p>"},
+		{Name: "../etc/passwd", Body: "x"},
+	}
+	for i, tpl := range bad {
+		if _, err := s.SaveTemplate(tpl); err == nil {
+			t.Errorf("case %d: expected an error", i)
+		}
+	}
+	if _, err := s.RollbackTemplate("adif-export", 7); err == nil {
+		t.Fatal("expected an error for a missing version")
+	}
+	if _, err := (&Service{}).SaveTemplate(Template{Name: "x"}); err == nil {
+		t.Fatal("expected an error without a store")
+	}
+}
@@ -0,0 +1,128 @@
+package email
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+// TenantConfig is what sets one tenant of a club station apart: its email settings and where its state is kept.
+// A nil store keeps that state in memory only; stores are never shared between tenants.
+type TenantConfig struct {
+	Config            types.EmailConfig
+	Identities        map[string]SenderIdentity
+	Templates         TemplateStore
+	QueueStore        QueueStore
+	SuppressionStore  SuppressionStore
+	RetryProfileStore RetryProfileStore
+	Sync              *SyncConfig
+}
+
+// Tenants serves a multi-operator club station from one instance. Each station or user ID gets a Service of its
+// own, so that one operator's profile, templates, queue and history never mix with another's.
+type Tenants struct {
+	// Base supplies what the tenants share, e.g. LoggerService, EventSinks, Middleware and QueueConfig: its exported
+	// settings are copied into each tenant's Service. Its config, identities and stores are not.
+	Base *Service
+	// Lookup returns the configuration of the tenant id, when its Service is first needed and on Reload.
+	Lookup func(id string) (TenantConfig, error)
+
+	mu       sync.Mutex
+	services map[string]*Service
+}
+
+// Service returns the initialized Service of the tenant id, starting it on first use.
+func (t *Tenants) Service(id string) (*Service, error) {
+	const op errors.Op = "email.Tenants.Service"
+	if id == "" {
+		return nil, errors.New(op).Msg("tenant id cannot be empty")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.services[id]; ok {
+		return s, nil
+	}
+	if t.Lookup == nil {
+		return nil, errors.New(op).Msg("tenant lookup has not been set")
+	}
+	tc, err := t.Lookup(id)
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msgf("failed to look up tenant %q", id)
+	}
+
+	s := &Service{}
+	if t.Base != nil {
+		copyExported(s, t.Base)
+	}
+	s.ConfigService = nil // Reload goes through Tenants.Reload
+	s.Identities = tc.Identities
+	s.Templates = tc.Templates
+	s.QueueStore = tc.QueueStore
+	s.SuppressionStore = tc.SuppressionStore
+	s.RetryProfileStore = tc.RetryProfileStore
+	s.Sync = tc.Sync
+	s.initOnce.Do(func() { err = s.initialize(op, tc.Config) })
+	if err != nil {
+		return nil, errors.New(op).Err(err).Msgf("failed to start tenant %q", id)
+	}
+
+	if t.services == nil {
+		t.services = make(map[string]*Service)
+	}
+	t.services[id] = s
+	s.LoggerService.InfoWith().Str("tenant", id).Str("profile", tc.Config.Name).Msg("tenant email service started")
+	return s, nil
+}
+
+// Reload looks up the configuration of a started tenant again and swaps it in, as Service.Reload does.
+func (t *Tenants) Reload(id string) error {
+	const op errors.Op = "email.Tenants.Reload"
+	t.mu.Lock()
+	s, ok := t.services[id]
+	t.mu.Unlock()
+	if !ok {
+		return errors.New(op).Msgf("tenant %q has not been started", id)
+	}
+	tc, err := t.Lookup(id)
+	if err != nil {
+		return errors.New(op).Err(err).Msgf("failed to look up tenant %q", id)
+	}
+	return s.SetConfig(tc.Config)
+}
+
+// IDs returns the started tenants, sorted.
+func (t *Tenants) IDs() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]string, 0, len(t.services))
+	for id := range t.services {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Shutdown stops every tenant's Service; a later Service call starts the tenant afresh.
+func (t *Tenants) Shutdown() {
+	t.mu.Lock()
+	services := t.services
+	t.services = nil
+	t.mu.Unlock()
+	for _, s := range services {
+		s.Shutdown()
+	}
+}
+
+// copyExported copies the exported fields of src to dst. Unexported fields hold per-service state and locks, which
+// must not be shared.
+func copyExported(dst, src *Service) {
+	d, v := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for i := range v.NumField() {
+		if v.Type().Field(i).IsExported() {
+			d.Field(i).Set(v.Field(i))
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"net/textproto"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestTenantsAreIsolated(t *testing.T) {
+	var sentFrom []string
+	deliver := smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		if from == "g4abc@example.org" {
+			return deliveryInfo{}, &textproto.Error{Code: 451, Msg: "4.7.1 greylisted"}
+		}
+		sentFrom = append(sentFrom, from)
+		return deliveryInfo{Accepted: to}, nil
+	})
+
+	hosts := map[string]string{"DL1ABC": "smtp.example.de", "G4ABC": "smtp.example.co.uk"}
+	tenants := &Tenants{
+		Base: &Service{MaxForwardHops: 3, Transport: deliver},
+		Lookup: func(id string) (TenantConfig, error) {
+			host, ok := hosts[id]
+			if !ok {
+				return TenantConfig{}, fmt.Errorf("unknown operator %s", id)
+			}
+			from := map[string]string{"DL1ABC": "dl1abc@example.org", "G4ABC": "g4abc@example.org"}[id]
+			return TenantConfig{Config: types.EmailConfig{Enabled: true, Name: id, Host: host, Port: 587, From: from}}, nil
+		},
+	}
+	t.Cleanup(tenants.Shutdown)
+
+	dl, err := tenants.Service("DL1ABC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g4, err := tenants.Service("G4ABC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := tenants.Service("DL1ABC"); again != dl {
+		t.Fatal("tenant service was started twice")
+	}
+	if _, err = tenants.Service("W1AW"); err == nil {
+		t.Fatal("expected an unknown tenant to fail")
+	}
+	if dl.MaxForwardHops != 3 || dl.CurrentConfig().Host != "smtp.example.de" || g4.CurrentConfig().Host != "smtp.example.co.uk" {
+		t.Fatalf("settings not applied: %d %s %s", dl.MaxForwardHops, dl.CurrentConfig().Host, g4.CurrentConfig().Host)
+	}
+
+	msg := MsgDef{To: []string{"qsl@club.example"}, Msg: "Subject: log\r\n\r\n73\r\n"}
+	if _, err = dl.SendWithResult(t.Context(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := g4.SendWithResult(t.Context(), msg); err != nil || res.Status != SendStatusQueued {
+		t.Fatalf("res %+v, err %v", res, err)
+	}
+	if len(dl.History()) != 1 || len(g4.History()) != 0 || dl.QueueDepth() != 0 || g4.QueueDepth() != 1 {
+		t.Fatalf("history %d/%d, queue %d/%d", len(dl.History()), len(g4.History()), dl.QueueDepth(), g4.QueueDepth())
+	}
+	if len(sentFrom) != 1 || sentFrom[0] != "dl1abc@example.org" {
+		t.Fatalf("sent from %v", sentFrom)
+	}
+
+	hosts["DL1ABC"] = "mail.example.de"
+	if err = tenants.Reload("DL1ABC"); err != nil || dl.CurrentConfig().Host != "mail.example.de" {
+		t.Fatalf("reload: %v, host %s", err, dl.CurrentConfig().Host)
+	}
+	if ids := tenants.IDs(); len(ids) != 2 || ids[0] != "DL1ABC" {
+		t.Fatalf("ids = %v", ids)
+	}
+}
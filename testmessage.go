@@ -0,0 +1,103 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+const testMessageSubject = "Station Manager test message"
+
+// SendTestMessage sends a message describing the live configuration (server, TLS version, auth mechanism, app
+// version) to to, or the configured To when empty, through the normal send path. It backs the settings screen's
+// "Send test email" button.
+func (s *Service) SendTestMessage(ctx context.Context, to string) (SendResult, error) {
+	const op errors.Op = "email.Service.SendTestMessage"
+	if !s.isInitialized.Load() {
+		return SendResult{}, errors.New(op).Msg(errMsgNotInitialized)
+	}
+	cfg := s.config()
+	report, perr := s.probe(ctx, cfg, false)
+	def, err := s.testMessage(cfg, to, &report, perr)
+	if err != nil {
+		return SendResult{}, errors.New(op).Err(err).Msg(err.Error())
+	}
+	return s.SendWithResult(ctx, def)
+}
+
+// testMessage composes the diagnostic test message. report is the server probe, if one was made.
+func (s *Service) testMessage(cfg *types.EmailConfig, to string, report *ConnectionReport, probeErr error) (MsgDef, error) {
+	const op errors.Op = "email.Service.testMessage"
+	to = strings.TrimSpace(to)
+	if to == "" {
+		to = strings.TrimSpace(cfg.To)
+	}
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msgf("invalid recipient %q", to)
+	}
+	to = rcpt.Address
+
+	var b strings.Builder
+	b.WriteString("This is a test message from Station Manager. If you can read it, your email settings work.\r\n\r\n")
+	line := func(k, v string) { fmt.Fprintf(&b, "%-16s %s\r\n", k+":", v) }
+	line("Server", fmt.Sprintf("%s:%d", strings.TrimSpace(cfg.Host), cfg.Port))
+	switch {
+	case probeErr != nil:
+		line("Transport", "unknown (probe failed: "+probeErr.Error()+")")
+	case report != nil:
+		tr := report.Transport
+		if report.TLSVersion != "" {
+			tr += " (" + report.TLSVersion + ")"
+		}
+		line("Transport", tr)
+	}
+	if user := strings.TrimSpace(cfg.Username); user != "" {
+		mech := strings.ToLower(strings.TrimSpace(s.AuthMechanism))
+		if mech == "" {
+			mech = AuthPlain
+		}
+		line("Authentication", mech+" as "+user)
+	} else {
+		line("Authentication", "none")
+	}
+	if cfg.Name != "" {
+		line("Profile", cfg.Name)
+	}
+	line("App version", s.appVersion())
+	line("Sent", s.now().UTC().Format(time.RFC1123Z))
+
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("From", cfg.From)
+	hdr.Set("To", to)
+	hdr.Set("Subject", testMessageSubject)
+	hdr.Set("Date", s.now().UTC().Format(time.RFC1123Z))
+	hdr.Set("Message-ID", generateMessageID(s.random(), s.now()))
+	msg, structure, err := composeTextMessage(hdr, b.String())
+	if err != nil {
+		return MsgDef{}, errors.New(op).Err(err).Msg("composing test message")
+	}
+	from := cfg.From
+	if a, perr := mail.ParseAddress(from); perr == nil {
+		from = a.Address
+	}
+	return MsgDef{From: from, To: []string{to}, Msg: msg, Structure: structure}, nil
+}
+
+// appVersion is AppVersion, or the main module's version from the build info.
+func (s *Service) appVersion() string {
+	if s.AppVersion != "" {
+		return s.AppVersion
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		return bi.Main.Version
+	}
+	return "unknown"
+}
@@ -0,0 +1,43 @@
+package email
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestSendTestMessageCarriesDiagnostics(t *testing.T) {
+	addr := startFakeSMTPS(t, []string{"fake.example.com", "AUTH PLAIN"})
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	cfg := &types.EmailConfig{Name: "home", Enabled: true, Host: host, Port: p, From: "K1ABC <k1abc@example.com>", To: "log@example.org", Username: "k1abc", Password: "hunter2"}
+	s := &Service{Config: cfg, AuthMechanism: AuthLogin, AppVersion: "v1.4.0", RootCAs: fakeRoots()}
+	s.isInitialized.Store(true)
+
+	var sent string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent = string(msg)
+		return deliveryInfo{}, nil
+	})
+
+	res, err := s.SendTestMessage(t.Context(), "")
+	if err != nil || res.Status != SendStatusSent || res.MessageID == "" {
+		t.Fatalf("SendTestMessage = %+v, %v", res, err)
+	}
+	for _, want := range []string{"To: log@example.org", "Subject: " + testMessageSubject, host + ":" + port, "smtps (TLS 1.", "login as k1abc", "Profile:", "v1.4.0"} {
+		if !strings.Contains(sent, want) {
+			t.Errorf("test message is missing %q:\n%s", want, sent)
+		}
+	}
+	if strings.Contains(sent, "hunter2") {
+		t.Fatal("the password must not appear in the test message")
+	}
+	if h := s.History(); len(h) != 1 || h[0].Subject != testMessageSubject {
+		t.Fatalf("test message should go through the normal send path, history %+v", h)
+	}
+}
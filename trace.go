@@ -0,0 +1,54 @@
+package email
+
+import (
+	"context"
+	"strings"
+)
+
+// TraceHeader carries the correlation ID of the flow that produced a message.
+const TraceHeader = "X-StationManager-Trace"
+
+// maxTraceIDLen keeps the trace header well within line-length limits.
+const maxTraceIDLen = 128
+
+type traceIDKey struct{}
+
+// WithTraceID returns a context carrying a correlation ID for SendContext to stamp into headers and logs.
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+// TraceIDFromContext returns the sanitized correlation ID carried by ctx, or an empty string.
+func TraceIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return sanitizeTraceID(id)
+}
+
+// sanitizeTraceID keeps only visible ASCII so a caller-supplied ID can never inject headers.
+func sanitizeTraceID(id string) string {
+	var b strings.Builder
+	for _, r := range id {
+		if r > ' ' && r < 0x7f {
+			b.WriteRune(r)
+		}
+		if b.Len() >= maxTraceIDLen {
+			break
+		}
+	}
+	return b.String()
+}
+
+// withTraceHeader prepends the trace header to a rendered message unless it already carries one.
+func withTraceHeader(msg, traceID string) string {
+	if traceID == "" {
+		return msg
+	}
+	head, _, _ := strings.Cut(msg, "\r\n\r\n")
+	if strings.Contains(strings.ToLower("\r\n"+head), "\r\n"+strings.ToLower(TraceHeader)+":") {
+		return msg
+	}
+	return TraceHeader + ": " + traceID + "\r\n" + msg
+}
@@ -0,0 +1,34 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestSendContextStampsTraceHeader(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}}
+	s.isInitialized.Store(true)
+
+	var sent string
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		sent = string(msg)
+		return deliveryInfo{}, nil
+	})
+
+	ctx := WithTraceID(context.Background(), "abc-123\r\nBcc: evil@example.com")
+	if err := s.SendContext(ctx, MsgDef{To: []string{"to@example.com"}, Msg: "Subject: hi\r\n\r\nbody"}); err != nil {
+		t.Fatalf("SendContext failed: %v", err)
+	}
+	if !strings.HasPrefix(sent, "X-StationManager-Trace: abc-123Bcc:evil@example.com\r\nSubject: hi\r\n") {
+		t.Fatalf("trace header missing or unsanitized: %q", sent)
+	}
+
+	// An existing header is left alone
+	if got := withTraceHeader("X-StationManager-Trace: x\r\n\r\nbody", "y"); got != "X-StationManager-Trace: x\r\n\r\nbody" {
+		t.Fatalf("existing trace header was modified: %q", got)
+	}
+}
@@ -0,0 +1,142 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+)
+
+// encodingSample is how much of a part is examined to choose its transfer encoding. A larger part is streamed,
+// encoded as its first encodingSample bytes suggest.
+const encodingSample = 32 << 10
+
+// sevenBit reports whether data can be sent without encoding: ASCII without NULs, in lines of at most
+// maxLineLength, with no CR outside a CRLF. When exact is set every line break must already be a CRLF, since SMTP
+// would not carry a bare LF through unchanged; otherwise it is sent as a CRLF, as for a text body.
+func sevenBit(data []byte, exact bool) bool {
+	line := 0
+	for i, c := range data {
+		switch {
+		case c == '\n':
+			if exact && (i == 0 || data[i-1] != '\r') {
+				return false
+			}
+			line = 0
+			continue
+		case c == '\r':
+			if i+1 == len(data) || data[i+1] != '\n' {
+				return false
+			}
+			continue
+		case c == 0 || c >= 0x80:
+			return false
+		}
+		if line++; line > maxLineLength {
+			return false
+		}
+	}
+	return true
+}
+
+// transferEncoding chooses the Content-Transfer-Encoding for a part starting with sample: 7bit when sample is the
+// whole part and needs no encoding, otherwise whichever of quoted-printable and base64 encodes sample smaller.
+// Mostly-ASCII text such as ADIF comes out about a quarter smaller as quoted-printable.
+func transferEncoding(sample []byte, exact, whole bool) string {
+	if whole && sevenBit(sample, exact) {
+		return "7bit"
+	}
+	var cw countingWriter
+	qp := quotedprintable.NewWriter(&cw)
+	qp.Binary = exact
+	_, _ = qp.Write(sample)
+	_ = qp.Close()
+	if cw.n < base64WrappedSize(len(sample)) {
+		return "quoted-printable"
+	}
+	return "base64"
+}
+
+// toCRLF turns every line break in text into a CRLF.
+func toCRLF(text []byte) []byte {
+	return bytes.ReplaceAll(bytes.ReplaceAll(text, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+}
+
+// partWriter writes the body of a MIME part in the transfer encoding its content calls for. create writes the
+// part's header once the encoding is known: after encodingSample bytes, or on Close for a smaller part. An exact
+// part, i.e. an attachment, decodes to the bytes written; a text part may have its line breaks turned into CRLFs.
+type partWriter struct {
+	create  func(cte string) (io.Writer, error)
+	exact   bool
+	sample  []byte
+	w       io.Writer
+	closers []io.Closer
+}
+
+// newPart returns a partWriter for a part of mw with header hdr, to which the chosen encoding is added.
+func newPart(mw *multipart.Writer, hdr textproto.MIMEHeader, exact bool) *partWriter {
+	return &partWriter{exact: exact, create: func(cte string) (io.Writer, error) {
+		hdr.Set("Content-Transfer-Encoding", cte)
+		return mw.CreatePart(hdr)
+	}}
+}
+
+func (p *partWriter) Write(b []byte) (int, error) {
+	if p.w != nil {
+		return p.w.Write(b)
+	}
+	n := min(len(b), encodingSample-len(p.sample))
+	p.sample = append(p.sample, b[:n]...)
+	if len(p.sample) < encodingSample {
+		return len(b), nil
+	}
+	if err := p.start(false); err != nil {
+		return 0, err
+	}
+	m, err := p.w.Write(b[n:])
+	return n + m, err
+}
+
+func (p *partWriter) start(whole bool) error {
+	cte := transferEncoding(p.sample, p.exact, whole)
+	w, err := p.create(cte)
+	if err != nil {
+		return err
+	}
+	sample := p.sample
+	p.sample = nil
+	switch cte {
+	case "7bit":
+		if !p.exact {
+			sample = toCRLF(sample)
+		}
+		p.w = w
+	case "quoted-printable":
+		qp := quotedprintable.NewWriter(w)
+		qp.Binary = p.exact
+		p.w, p.closers = qp, []io.Closer{qp}
+	default:
+		// 76-chunked base64 with CRLF
+		lw := &lineWrapper{w: w, width: 76}
+		enc := base64.NewEncoder(base64.StdEncoding, lw)
+		p.w, p.closers = enc, []io.Closer{enc, lw}
+	}
+	_, err = p.w.Write(sample)
+	return err
+}
+
+func (p *partWriter) Close() error {
+	if p.w == nil {
+		if err := p.start(true); err != nil {
+			return err
+		}
+	}
+	for _, c := range p.closers {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package email
+
+import (
+	"bytes"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+func TestTransferEncodingChoice(t *testing.T) {
+	adifText := strings.Repeat("<CALL:5>DL1AB\n<QSO_DATE:8>20240101\n<BAND:3>20m\n<MODE:2>CW\n<EOR>\n", 50)
+	cases := []struct {
+		name  string
+		data  string
+		exact bool
+		whole bool
+		want  string
+	}{
+		{"plain text", "73 de DL1ABC\nsee you on 20m", false, true, "7bit"},
+		{"attachment with bare LF", adifText, true, true, "quoted-printable"},
+		{"attachment with CRLF", strings.ReplaceAll(adifText, "\n", "\r\n"), true, true, "7bit"},
+		{"streamed ASCII", adifText, true, false, "quoted-printable"},
+		{"long line", strings.Repeat("x", maxLineLength+1), false, true, "quoted-printable"},
+		{"mostly accented", strings.Repeat("äöüß", 20), false, true, "base64"},
+		{"binary", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x01\x00", true, true, "base64"},
+	}
+	for _, c := range cases {
+		if got := transferEncoding([]byte(c.data), c.exact, c.whole); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPartWriterRoundTrip(t *testing.T) {
+	big := bytes.Repeat([]byte("<CALL:5>DL1AB\n<EOR>\n"), 2*encodingSample/20)
+	big = append(big, 0xff, 0x00, '\r')
+	for _, data := range [][]byte{[]byte("short\r\nascii\r\n"), big} {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		hdr := mapToMIMEHeader(map[string]string{"Content-Type": "application/octet-stream"})
+		pw := newPart(mw, hdr, true)
+		// Written in uneven pieces, as a stream would be
+		for rest := data; len(rest) > 0; {
+			n := min(len(rest), 7777)
+			if _, err := pw.Write(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+		if err := pw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		_ = mw.Close()
+
+		part, err := multipart.NewReader(&buf, mw.Boundary()).NextRawPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw := new(bytes.Buffer)
+		_, _ = raw.ReadFrom(part)
+		got, err := decodeTransferEncoding(part.Header.Get("Content-Transfer-Encoding"), raw.Bytes())
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%s part of %d bytes did not round-trip (%v)", hdr.Get("Content-Transfer-Encoding"), len(data), err)
+		}
+	}
+}
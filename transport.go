@@ -0,0 +1,133 @@
+package email
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+const (
+	// transportCustom is the transport name reported for sends through Service.Transport.
+	transportCustom = "custom"
+	// maxAPIResponseBody bounds the error responses read from provider APIs.
+	maxAPIResponseBody = 64 << 10
+)
+
+// Submission is a message handed to a Transport, with the config it is sent with.
+type Submission struct {
+	// Config is the email config of the send: the SMTP server and account, or the provider's credentials.
+	Config types.EmailConfig
+	// Auth logs in to the SMTP server; nil when no username is configured. Only the SMTP transport uses it.
+	Auth smtp.Auth
+	From string
+	To   []string
+	// Msg is the complete message, with its headers, as it is sent over SMTP.
+	Msg []byte
+}
+
+// Transport hands a message to the provider. By default messages are submitted over SMTP to the host of the send's
+// config; setting Service.Transport replaces that, e.g. with a provider's HTTP API such as SES, SendGrid or Mailgun.
+//
+// Deliver is retried, queued and recorded like an SMTP send. To have a failure classified as an SMTP reply would
+// be, e.g. a rejected recipient as permanent and throttling as transient, return a *textproto.Error with the
+// equivalent reply code.
+type Transport interface {
+	Deliver(ctx context.Context, sub Submission) error
+}
+
+// TransportFunc adapts a function to a Transport.
+type TransportFunc func(ctx context.Context, sub Submission) error
+
+func (f TransportFunc) Deliver(ctx context.Context, sub Submission) error {
+	return f(ctx, sub)
+}
+
+// sessionTransport is a Transport that speaks SMTP: it needs Submission.Auth and reports how the message was
+// handed over, and which recipients were accepted before a failure.
+type sessionTransport interface {
+	Transport
+	submit(ctx context.Context, sub Submission) (deliveryInfo, error)
+}
+
+// smtpTransport submits messages to the SMTP server of the send's config; it is the default Transport.
+type smtpTransport struct {
+	s *Service
+}
+
+func (t smtpTransport) Deliver(ctx context.Context, sub Submission) error {
+	_, err := t.submit(ctx, sub)
+	return err
+}
+
+// submit sends over the prewarmed session when one is ready for this server and account, and dials otherwise.
+func (t smtpTransport) submit(ctx context.Context, sub Submission) (deliveryInfo, error) {
+	addr := net.JoinHostPort(strings.TrimSpace(sub.Config.Host), strconv.Itoa(sub.Config.Port))
+	if t.s.Prewarm != nil {
+		key := warmKey(addr, strings.TrimSpace(sub.Config.Username))
+		if sess := t.s.warm.take(key, t.s.Prewarm.maxIdle(), time.Now()); sess != nil {
+			// The server may have dropped the idle connection; a NOOP finds out before MAIL FROM commits to it
+			if err := sess.noop(dialTimeoutFromContext(ctx)); err == nil {
+				return sess.send(ctx, sub.From, sub.To, sub.Msg)
+			}
+			sess.close()
+		}
+	}
+	return sendMailWithTLS(ctx, addr, sub.Auth, sub.From, sub.To, sub.Msg)
+}
+
+// transport returns Service.Transport, or the SMTP transport when none is set.
+func (s *Service) transport() Transport {
+	if s.Transport != nil {
+		return s.Transport
+	}
+	return smtpTransport{s: s}
+}
+
+// speaksSMTP reports whether sends go over SMTP, and so need an SMTP login.
+func (s *Service) speaksSMTP() bool {
+	_, ok := s.transport().(sessionTransport)
+	return ok
+}
+
+// deliver hands msg, sent with cfg, to the transport within the connection limit.
+func (s *Service) deliver(ctx context.Context, cfg *types.EmailConfig, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+	if err := s.conns.acquire(ctx, s.MaxConnections); err != nil {
+		return deliveryInfo{}, err
+	}
+	defer s.conns.release()
+	sub := Submission{Config: *cfg, Auth: auth, From: from, To: to, Msg: msg}
+	t := s.transport()
+	if st, ok := t.(sessionTransport); ok {
+		return st.submit(ctx, sub)
+	}
+	if err := t.Deliver(ctx, sub); err != nil {
+		return deliveryInfo{Transport: transportCustom}, err
+	}
+	return deliveryInfo{Transport: transportCustom, Accepted: to, Transactions: 1}, nil
+}
+
+// apiReply expresses the HTTP status of a provider API's response as the SMTP reply that has the same effect on
+// retries and the send's Reason.
+func apiReply(status int, msg string) *textproto.Error {
+	if msg == "" {
+		msg = http.StatusText(status)
+	}
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return &textproto.Error{Code: 535, Msg: "5.7.8 " + msg}
+	case status == http.StatusRequestEntityTooLarge:
+		return &textproto.Error{Code: 552, Msg: "5.3.4 " + msg}
+	case status == http.StatusTooManyRequests:
+		return &textproto.Error{Code: 421, Msg: "4.7.0 rate limit exceeded: " + msg}
+	case status >= 500:
+		return &textproto.Error{Code: 451, Msg: "4.3.0 " + msg}
+	}
+	return &textproto.Error{Code: 554, Msg: "5.6.0 " + msg}
+}
@@ -0,0 +1,61 @@
+package email
+
+import (
+	"context"
+	stderr "errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+// smtpFunc stands in for the SMTP transport, seeing what a session is handed and reporting what it would.
+type smtpFunc func(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error)
+
+func (f smtpFunc) Deliver(ctx context.Context, sub Submission) error {
+	_, err := f.submit(ctx, sub)
+	return err
+}
+
+func (f smtpFunc) submit(ctx context.Context, sub Submission) (deliveryInfo, error) {
+	addr := net.JoinHostPort(strings.TrimSpace(sub.Config.Host), strconv.Itoa(sub.Config.Port))
+	return f(ctx, addr, sub.Auth, sub.From, sub.To, sub.Msg)
+}
+
+func TestTransportReplacesSMTP(t *testing.T) {
+	var got []string
+	var auth smtp.Auth
+	var body string
+	reply := error(nil)
+	s := &Service{
+		Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "op@example.org", Username: "op", Password: "secret"},
+		Transport: TransportFunc(func(_ context.Context, sub Submission) error {
+			got, body, auth = append(got, sub.To...), string(sub.Msg), sub.Auth
+			return reply
+		}),
+	}
+	s.isInitialized.Store(true)
+
+	res, err := s.SendWithResult(t.Context(), MsgDef{To: []string{"dx@example.com"}, Msg: "Subject: qsl\r\n\r\n73\r\n"})
+	if err != nil || res.Status != SendStatusSent || len(got) != 1 || got[0] != "dx@example.com" || !strings.Contains(body, "73") {
+		t.Fatalf("err=%v res=%+v got=%v", err, res, got)
+	}
+	if auth != nil {
+		t.Errorf("SMTP login handed to a non-SMTP transport")
+	}
+	if h := s.History(); len(h) != 1 {
+		t.Fatalf("history %+v", h)
+	}
+
+	// A reply code returned by the transport is classified as an SMTP reply would be
+	got, reply = nil, &textproto.Error{Code: 550, Msg: "5.1.1 recipient address rejected"}
+	err = s.Send(MsgDef{To: []string{"nobody@example.com"}, Msg: "Subject: qsl\r\n\r\n73\r\n"})
+	var se *SendError
+	if !stderr.As(err, &se) || !se.Permanent || se.Reason != ReasonRecipientRejected || len(got) != 1 {
+		t.Fatalf("err=%v tries=%d", err, len(got))
+	}
+}
@@ -0,0 +1,102 @@
+package email
+
+import (
+	stderr "errors"
+	"net"
+	"net/textproto"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultUnconfirmedDelay gives a delivery report for a possibly accepted message time to arrive before it is sent
+// again.
+const defaultUnconfirmedDelay = 15 * time.Minute
+
+// errAcceptanceUnknown marks a connection lost after the whole message was sent but before the server's reply to
+// it: the server may have accepted the message (RFC 1047). A drop while the message was still being sent is an
+// ordinary transient failure, since a server never accepts a message whose end it has not seen.
+var errAcceptanceUnknown = stderr.New("connection lost awaiting the reply to the message; it may have been accepted")
+
+func isAcceptanceUnknown(err error) bool {
+	return stderr.Is(err, errAcceptanceUnknown)
+}
+
+// lostAwaitingReply reports whether err, from closing the DATA writer, which sends the terminating dot and reads
+// the reply, came after the dot was sent: it is neither the server's reply nor a failure to write.
+func lostAwaitingReply(err error) bool {
+	var tpErr *textproto.Error
+	if stderr.As(err, &tpErr) {
+		return false
+	}
+	var opErr *net.OpError
+	return !stderr.As(err, &opErr) || opErr.Op != "write"
+}
+
+func (c QueueConfig) unconfirmedDelay() time.Duration {
+	if c.UnconfirmedDelay > 0 {
+		return c.UnconfirmedDelay
+	}
+	return defaultUnconfirmedDelay
+}
+
+// unconfirmedSet tracks the Message-IDs of queued messages whose earlier attempt may have been accepted, and
+// whether a delivery report has since shown that it was.
+type unconfirmedSet struct {
+	mu       sync.Mutex
+	accepted map[string]bool
+	// to holds the recipients each message's uncertain attempt went to
+	to map[string][]string
+}
+
+// watch tracks msgID, whose uncertain attempt went to the recipients to.
+func (u *unconfirmedSet) watch(msgID string, to []string) {
+	if msgID == "" {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.accepted == nil {
+		u.accepted, u.to = map[string]bool{}, map[string][]string{}
+	}
+	if _, ok := u.accepted[msgID]; !ok {
+		u.accepted[msgID] = false
+		u.to[msgID] = slices.Clone(to)
+	}
+}
+
+// confirm records that a delivery report, of any kind, arrived for msgID about recipient: only a server that
+// accepted the message could have produced one. A report about anyone the uncertain attempt did not go to, forged
+// or unrelated, confirms nothing.
+func (u *unconfirmedSet) confirm(msgID, recipient string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.accepted[msgID]; ok && listsAddress(u.to[msgID], recipient) {
+		u.accepted[msgID] = true
+	}
+}
+
+func (u *unconfirmedSet) confirmed(msgID string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.accepted[msgID]
+}
+
+func (u *unconfirmedSet) forget(msgID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.accepted, msgID)
+	delete(u.to, msgID)
+}
+
+// alreadyAccepted reports whether the queued m should be dropped because a delivery report showed that an attempt
+// the connection dropped on was accepted after all.
+func (s *Service) alreadyAccepted(m *QueuedMessage) bool {
+	if !m.Unconfirmed || !s.unconfirmed.confirmed(m.MessageID) {
+		return false
+	}
+	s.LoggerService.InfoWith().Str("queue_id", m.ID).Str("message_id", m.MessageID).
+		Msg("dropping retry; a delivery report shows the earlier attempt was accepted")
+	s.unconfirmed.forget(m.MessageID)
+	return true
+}
@@ -0,0 +1,96 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestConnectionDropAwaitingDataReply(t *testing.T) {
+	ln := fakeTLSListener(t)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		r := bufio.NewReader(conn)
+		_, _ = conn.Write([]byte("220 gateway SMTP ready\r\n"))
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case inData && line == ".\r\n":
+				// The whole message arrived; hang up before replying to it
+				return
+			case inData:
+			case len(line) >= 4 && line[:4] == "EHLO":
+				_, _ = conn.Write([]byte("250 fake.example.com\r\n"))
+			case len(line) >= 4 && line[:4] == "DATA":
+				inData = true
+				_, _ = conn.Write([]byte("354 go ahead\r\n"))
+			default:
+				_, _ = conn.Write([]byte("250 OK\r\n"))
+			}
+		}
+	}()
+
+	_, err := sendMailWithTLS(withRootCAs(t.Context(), fakeRoots()), ln.Addr().String(), nil, "op@example.com", []string{"dx@example.org"}, []byte("Subject: hi\r\n\r\n73\r\n"))
+	if err == nil || !isAcceptanceUnknown(err) {
+		t.Fatalf("err = %v, want acceptance unknown", err)
+	}
+
+	if lostAwaitingReply(&net.OpError{Op: "write", Err: io.ErrClosedPipe}) {
+		t.Error("a failed write of the message is not ambiguous")
+	}
+	if !lostAwaitingReply(&net.OpError{Op: "read", Err: io.EOF}) || !lostAwaitingReply(io.EOF) {
+		t.Error("a failed read of the reply is ambiguous")
+	}
+}
+
+func TestUnconfirmedRetryDroppedOnDeliveryReport(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.org", Port: 587, From: "op@example.org", SmtpRetryCount: 3}}
+	s.isInitialized.Store(true)
+
+	calls := 0
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		calls++
+		return deliveryInfo{}, fmt.Errorf("%w: %w", errAcceptanceUnknown, io.EOF)
+	})
+
+	const id = "<qsl-1@example.org>"
+	res, err := s.SendWithResult(t.Context(), MsgDef{From: "op@example.org", To: []string{"dx@example.org"}, Msg: "Message-ID: " + id + "\r\nSubject: qsl\r\n\r\n73\r\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != SendStatusQueued || calls != 1 {
+		t.Fatalf("result %+v after %d attempts; want queued after one", res, calls)
+	}
+	q := s.Queued()
+	if len(q) != 1 || !q[0].Unconfirmed || time.Until(q[0].NextAttempt) < 14*time.Minute {
+		t.Fatalf("queued = %+v", q)
+	}
+
+	// A report about someone the message was not sent to confirms nothing
+	s.recordDeliveryStatus([]DeliveryStatus{{MessageID: id, Recipient: "w1aw@example.org", State: DeliveryStateDelivered}})
+	if s.unconfirmed.confirmed(id) {
+		t.Fatalf("a mismatched report confirmed %s", id)
+	}
+
+	// The server had accepted it after all: its delivery report stops the resend
+	s.recordDeliveryStatus([]DeliveryStatus{{MessageID: id, Recipient: "DX@example.org", State: DeliveryStateDelivered}})
+	s.flushQueue(t.Context(), time.Now().Add(time.Hour))
+	if calls != 1 || s.QueueDepth() != 0 {
+		t.Fatalf("%d attempts, depth %d after the delivery report", calls, s.QueueDepth())
+	}
+}
@@ -0,0 +1,197 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/Station-Manager/errors"
+)
+
+// maxLineLength is the RFC 5322 section 2.1.1 hard limit, excluding the CRLF.
+const maxLineLength = 998
+
+// ComplianceMode controls the RFC 5322 validation made before each send.
+type ComplianceMode string
+
+const (
+	// ComplianceOff skips validation; the default.
+	ComplianceOff ComplianceMode = ""
+	// ComplianceReport logs violations and sends anyway.
+	ComplianceReport ComplianceMode = "report"
+	// ComplianceStrict refuses to send a message with violations.
+	ComplianceStrict ComplianceMode = "strict"
+)
+
+// Violation is a single way in which a message departs from RFC 5322 / MIME.
+type Violation struct {
+	Rule   string
+	Detail string
+}
+
+func (v Violation) String() string {
+	return v.Rule + ": " + v.Detail
+}
+
+// headersOnce may appear at most once (RFC 5322 section 3.6).
+var headersOnce = []string{"Date", "From", "Sender", "Reply-To", "To", "Cc", "Bcc", "Message-Id", "In-Reply-To", "References", "Subject"}
+
+// Validate checks the message for required headers, header syntax, line lengths and MIME structure and returns
+// every violation found; nil means the message is compliant.
+func (m MsgDef) Validate() []Violation {
+	var out []Violation
+	add := func(rule, format string, a ...any) {
+		out = append(out, Violation{Rule: rule, Detail: fmt.Sprintf(format, a...)})
+	}
+
+	raw := []byte(m.Msg)
+	for i, line := range bytes.Split(raw, []byte("\r\n")) {
+		if len(line) > maxLineLength {
+			add("line-length", "line %d is %d characters long", i+1, len(line))
+		}
+		if bytes.ContainsAny(line, "\r\n") {
+			add("line-ending", "line %d contains a bare CR or LF", i+1)
+		}
+	}
+
+	head, _, ok := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !ok {
+		add("structure", "no blank line separates the header from the body")
+	}
+	for i, line := range bytes.Split(head, []byte("\r\n")) {
+		if len(line) == 0 || line[0] == ' ' || line[0] == '\t' {
+			if i == 0 {
+				add("header-syntax", "message starts with a continuation line")
+			}
+			continue
+		}
+		name, _, found := bytes.Cut(line, []byte(":"))
+		if !found || len(name) == 0 || !validFieldName(name) {
+			add("header-syntax", "malformed header field %q", truncate(string(line), 40))
+		}
+		for _, c := range line {
+			if c >= 0x80 {
+				add("header-syntax", "header %q contains unencoded 8-bit text", string(name))
+				break
+			}
+		}
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		add("structure", "header cannot be parsed: %v", err)
+		return out
+	}
+	for _, h := range headersOnce {
+		if n := len(msg.Header[textproto.CanonicalMIMEHeaderKey(h)]); n > 1 {
+			add("header-count", "%s appears %d times", h, n)
+		}
+	}
+	if msg.Header.Get("Date") == "" {
+		add("required-header", "Date is missing")
+	} else if _, err = msg.Header.Date(); err != nil {
+		add("header-syntax", "Date is invalid: %v", err)
+	}
+	if msg.Header.Get("From") == "" {
+		add("required-header", "From is missing")
+	} else if _, err = msg.Header.AddressList("From"); err != nil {
+		add("header-syntax", "From is invalid: %v", err)
+	}
+	for _, h := range []string{"To", "Cc", "Reply-To"} {
+		if msg.Header.Get(h) == "" {
+			continue
+		}
+		if _, err = msg.Header.AddressList(h); err != nil {
+			add("header-syntax", "%s is invalid: %v", h, err)
+		}
+	}
+
+	body := raw[len(head)+min(4, len(raw)-len(head)):]
+	validateMIME(textproto.MIMEHeader(msg.Header), body, 0, add)
+	return out
+}
+
+func validateMIME(hdr textproto.MIMEHeader, body []byte, depth int, add func(rule, format string, a ...any)) {
+	if depth > maxPartDepth {
+		add("mime", "parts are nested more than %d deep", maxPartDepth)
+		return
+	}
+	switch cte := strings.ToLower(strings.TrimSpace(hdr.Get("Content-Transfer-Encoding"))); cte {
+	case "", "7bit", "8bit", "binary", "quoted-printable", "base64":
+	default:
+		add("mime", "unknown Content-Transfer-Encoding %q", cte)
+	}
+	ct := hdr.Get("Content-Type")
+	if ct == "" {
+		return
+	}
+	mediaType, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		add("mime", "Content-Type %q is invalid: %v", truncate(ct, 60), err)
+		return
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		add("mime", "%s has no boundary", mediaType)
+		return
+	}
+	if !bytes.Contains(body, []byte("--"+boundary+"--")) {
+		add("mime", "%s is missing its closing boundary", mediaType)
+	}
+	parts := 0
+	err = walkParts(ct, hdr, body, depth, func(h textproto.MIMEHeader, data []byte) bool {
+		// walkParts descends into nested multiparts itself, so these are leaf parts
+		parts++
+		validateMIME(h, data, depth+1, add)
+		return true
+	})
+	if err != nil {
+		add("mime", "%s cannot be parsed: %v", mediaType, err)
+	} else if parts == 0 {
+		add("mime", "%s has no parts", mediaType)
+	}
+}
+
+// validFieldName reports whether name is printable US-ASCII without colons (RFC 5322 section 3.6.8).
+func validFieldName(name []byte) bool {
+	for _, c := range name {
+		if c < 33 || c > 126 || c == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// checkCompliance applies mode to msg before it is sent.
+func (s *Service) checkCompliance(d *delivery) error {
+	const op errors.Op = "email.Service.checkCompliance"
+	if s.Compliance == ComplianceOff {
+		return nil
+	}
+	violations := d.msg.Validate()
+	if len(violations) == 0 {
+		return nil
+	}
+	details := make([]string, len(violations))
+	for i, v := range violations {
+		details[i] = v.String()
+	}
+	d.log.WarnWith().Str("message_id", d.rec.MessageID).Strs("violations", details).Msg("message is not RFC 5322 compliant")
+	if s.Compliance == ComplianceStrict {
+		return errors.New(op).Msgf("message is not RFC 5322 compliant: %s", strings.Join(details, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+func TestBuiltMessagesAreCompliant(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{From: "from@example.com", To: "alice@example.com, bob@example.org", Subject: "Log export"}}
+	def, err := s.BuildEmailWithADIFAttachment("", "", "hello world", nil, []types.Qso{{LogbookID: 1, SessionID: 1}})
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if v := def.Validate(); v != nil {
+		t.Fatalf("built ADIF message has violations: %v", v)
+	}
+	note, err := s.composeNotification(t.Context(), []string{"op@example.com"}, "Rig alert – SWR", "SWR high", nil, nil)
+	if err != nil {
+		t.Fatalf("compose failed: %v", err)
+	}
+	if v := note.Validate(); v != nil {
+		t.Fatalf("notification has violations: %v", v)
+	}
+}
+
+func TestValidateReportsViolations(t *testing.T) {
+	msg := "From: not an address\r\n" +
+		"Subject: one\r\n" +
+		"Subject: two\r\n" +
+		"X-Café: x\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"b\"\r\n" +
+		"\r\n" +
+		"--b\r\nContent-Transfer-Encoding: x-unknown\r\n\r\n" + strings.Repeat("a", 1200) + "\r\n--b--\r\n"
+	rules := map[string]bool{}
+	for _, v := range (MsgDef{Msg: msg}).Validate() {
+		rules[v.Rule+"/"+strings.SplitN(v.Detail, " ", 2)[0]] = true
+	}
+	for _, want := range []string{"required-header/Date", "header-syntax/From", "header-count/Subject", "header-syntax/header", "line-length/line", "mime/unknown"} {
+		if !rules[want] {
+			t.Errorf("expected violation %s, got %v", want, rules)
+		}
+	}
+
+	unterminated := "Date: Mon, 02 Jan 2006 15:04:05 -0700\r\nFrom: a@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"b\"\r\n\r\n--b\r\n\r\ntext\r\n"
+	v := (MsgDef{Msg: unterminated}).Validate()
+	if len(v) == 0 || !strings.Contains(v[0].Detail, "closing boundary") {
+		t.Fatalf("expected a missing closing boundary, got %v", v)
+	}
+}
+
+func TestStrictComplianceRefusesSend(t *testing.T) {
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}}
+	s.isInitialized.Store(true)
+
+	calls := 0
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		calls++
+		return deliveryInfo{}, nil
+	})
+
+	bad := MsgDef{To: []string{"to@example.com"}, Msg: "Subject: hi\r\n\r\nbody"}
+	s.Compliance = ComplianceReport
+	if err := s.Send(bad); err != nil || calls != 1 {
+		t.Fatalf("report mode should send anyway: %v, %d calls", err, calls)
+	}
+	s.Compliance = ComplianceStrict
+	if err := s.Send(bad); err == nil || !strings.Contains(errors.Root(err).Error(), "Date is missing") || calls != 1 {
+		t.Fatalf("strict mode should refuse the message: %v, %d calls", err, calls)
+	}
+}
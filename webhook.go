@@ -0,0 +1,98 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/logging"
+)
+
+const (
+	webhookTimeout = 10 * time.Second
+
+	// WebhookSignatureHeader carries "sha256=<hex>" computed over "<timestamp>.<body>" with the shared secret.
+	WebhookSignatureHeader = "X-StationManager-Signature"
+	// WebhookTimestampHeader carries the Unix time the event was signed, for replay protection.
+	WebhookTimestampHeader = "X-StationManager-Timestamp"
+)
+
+// WebhookSink POSTs delivery events as JSON to a URL, signed with HMAC-SHA256. Events are delivered in order by a
+// single background worker; when the backlog is full new events are dropped rather than delaying sends. Stop, called
+// by Service.Shutdown, cancels the request in flight and discards the backlog.
+type WebhookSink struct {
+	URL    string
+	Secret string
+	Client *http.Client
+	Logger *logging.Service
+
+	worker eventWorker
+}
+
+// Publish queues ev for delivery.
+func (w *WebhookSink) Publish(ev DeliveryEvent) {
+	if !w.worker.enqueue(ev, w.deliver) {
+		w.Logger.WarnWith().Str("url", w.URL).Str("event", string(ev.Type)).Msg("webhook backlog full; dropping event")
+	}
+}
+
+// Stop stops the background worker and waits for it to exit.
+func (w *WebhookSink) Stop() {
+	w.worker.stop()
+}
+
+func (w *WebhookSink) deliver(ctx context.Context, ev DeliveryEvent) {
+	if err := w.post(ctx, ev); err != nil {
+		w.Logger.ErrorWith().Err(err).Str("url", w.URL).Str("event", string(ev.Type)).Msg("webhook delivery failed")
+	}
+}
+
+func (w *WebhookSink) post(ctx context.Context, ev DeliveryEvent) error {
+	const op errors.Op = "email.WebhookSink.post"
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to encode event")
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.New(op).Err(err).Msg("failed to create webhook request")
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, ts)
+	if w.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(w.Secret, ts, body))
+	}
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.New(op).Err(err).Msg("webhook request failed")
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(op).Msgf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>", letting receivers verify a webhook request.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,96 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestWebhookSinkSignsAndPostsEvents(t *testing.T) {
+	type received struct {
+		ev        DeliveryEvent
+		signature string
+		timestamp string
+		body      []byte
+	}
+	got := make(chan received, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var ev DeliveryEvent
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Errorf("invalid JSON: %v", err)
+		}
+		got <- received{ev: ev, signature: r.Header.Get(WebhookSignatureHeader), timestamp: r.Header.Get(WebhookTimestampHeader), body: body}
+	}))
+	defer srv.Close()
+
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}}
+	s.EventSinks = []EventSink{&WebhookSink{URL: srv.URL, Secret: "s3cret"}}
+	s.isInitialized.Store(true)
+
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		return deliveryInfo{}, nil
+	})
+
+	msg := "Message-ID: <m1@example.com>\r\nSubject: Log export\r\n\r\nhi"
+	if err := s.Send(MsgDef{To: []string{"to@example.com"}, Msg: msg}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	select {
+	case r := <-got:
+		if r.ev.Type != EventSent || r.ev.MessageID != "<m1@example.com>" || r.ev.Subject != "Log export" {
+			t.Errorf("unexpected event: %+v", r.ev)
+		}
+		if want := "sha256=" + SignWebhook("s3cret", r.timestamp, r.body); r.signature != want {
+			t.Errorf("signature mismatch: got %q want %q", r.signature, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook was not called")
+	}
+}
+
+func TestShutdownCancelsInFlightWebhook(t *testing.T) {
+	started := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body must be consumed for the server to notice the client hanging up
+		_, _ = io.ReadAll(r.Body)
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	sink := &WebhookSink{URL: srv.URL}
+	s := &Service{Config: &types.EmailConfig{Enabled: true, Host: "smtp.example.com", Port: 587, From: "from@example.com"}}
+	s.EventSinks = []EventSink{sink}
+	s.startQueue()
+	s.isInitialized.Store(true)
+
+	sink.Publish(DeliveryEvent{Type: EventSent, MessageID: "<m1@example.com>"})
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook was not called")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(webhookTimeout / 2):
+		t.Fatalf("Shutdown did not cancel the webhook request in flight")
+	}
+	if sink.worker.events != nil {
+		t.Fatalf("expected the webhook worker to be stopped")
+	}
+}
@@ -0,0 +1,254 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	stderr "errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"syscall"
+
+	"github.com/Station-Manager/errors"
+	"github.com/Station-Manager/types"
+)
+
+// Hint codes returned by the setup wizard steps and with a SendError.
+const (
+	HintAuthFailed       = "auth-failed"
+	HintAppPassword      = "app-password"
+	HintSMTPAuthDisabled = "smtp-auth-disabled"
+	HintRelayDenied      = "relay-denied"
+	HintAuthUnsupported  = "auth-unsupported"
+	HintCertificate      = "certificate"
+	HintTimeout          = "timeout"
+	HintRefused          = "connection-refused"
+	HintHostNotFound     = "host-not-found"
+	HintNoTLS            = "no-tls"
+	HintRejected         = "rejected"
+	HintUnknown          = "unknown"
+)
+
+// Hint is a remediation suggestion a setup wizard or settings screen can show when a step or send fails.
+type Hint struct {
+	Code    string
+	Message string
+}
+
+// ServerCandidate is a suggested SMTP server for an address.
+type ServerCandidate struct {
+	Host string
+	Port int
+	// Source is "provider" for a well-known mail provider, "mx" when inferred from the domain's MX records, and
+	// "guess" for conventional host names that resolve.
+	Source string
+}
+
+// Discovery lists the SMTP servers likely to accept submissions for an address, best first.
+type Discovery struct {
+	Domain     string
+	Candidates []ServerCandidate
+	Hints      []Hint
+}
+
+// StepResult is the outcome of a wizard step. On failure Error describes what went wrong and Hints what to try.
+type StepResult struct {
+	OK     bool
+	Error  string
+	Hints  []Hint
+	Report *ConnectionReport
+	// MessageID is set by TrySendTestMessage.
+	MessageID string
+}
+
+// knownProviders maps consumer mail domains to their submission servers.
+var knownProviders = map[string]ServerCandidate{
+	"gmail.com":      {Host: "smtp.gmail.com", Port: 465},
+	"googlemail.com": {Host: "smtp.gmail.com", Port: 465},
+	"outlook.com":    {Host: "smtp-mail.outlook.com", Port: 587},
+	"hotmail.com":    {Host: "smtp-mail.outlook.com", Port: 587},
+	"live.com":       {Host: "smtp-mail.outlook.com", Port: 587},
+	"yahoo.com":      {Host: "smtp.mail.yahoo.com", Port: 465},
+	"icloud.com":     {Host: "smtp.mail.me.com", Port: 587},
+	"me.com":         {Host: "smtp.mail.me.com", Port: 587},
+	"fastmail.com":   {Host: "smtp.fastmail.com", Port: 465},
+	"zoho.com":       {Host: "smtp.zoho.com", Port: 465},
+}
+
+// mxProviders maps MX host suffixes of hosted mail services to their submission servers.
+var mxProviders = []struct {
+	suffix string
+	server ServerCandidate
+}{
+	{".google.com", ServerCandidate{Host: "smtp.gmail.com", Port: 465}},
+	{".googlemail.com", ServerCandidate{Host: "smtp.gmail.com", Port: 465}},
+	{".outlook.com", ServerCandidate{Host: "smtp.office365.com", Port: 587}},
+	{".messagingengine.com", ServerCandidate{Host: "smtp.fastmail.com", Port: 465}},
+	{".zoho.com", ServerCandidate{Host: "smtp.zoho.com", Port: 465}},
+}
+
+// DiscoverConfig suggests SMTP servers for address from well-known providers, the domain's MX records and
+// conventional host names. It does not connect; ProbeCapabilities checks a candidate.
+func (s *Service) DiscoverConfig(ctx context.Context, address string) (Discovery, error) {
+	const op errors.Op = "email.Service.DiscoverConfig"
+	addr, err := mail.ParseAddress(strings.TrimSpace(address))
+	if err != nil {
+		return Discovery{}, errors.New(op).Err(err).Msgf("invalid email address %q", address)
+	}
+	_, domain, _ := strings.Cut(addr.Address, "@")
+	out := Discovery{Domain: strings.ToLower(domain)}
+	seen := map[ServerCandidate]bool{}
+	add := func(c ServerCandidate, source string) {
+		if !seen[c] {
+			seen[c] = true
+			c.Source = source
+			out.Candidates = append(out.Candidates, c)
+		}
+	}
+
+	if c, ok := knownProviders[out.Domain]; ok {
+		add(c, "provider")
+		return out, nil
+	}
+
+	r := s.resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	if mxs, mxErr := r.LookupMX(ctx, out.Domain); mxErr == nil {
+		for _, mx := range mxs {
+			host := strings.ToLower(strings.TrimSuffix(mx.Host, "."))
+			for _, p := range mxProviders {
+				if strings.HasSuffix(host, p.suffix) {
+					add(p.server, "mx")
+				}
+			}
+		}
+	}
+	for _, prefix := range []string{"smtp.", "mail."} {
+		host := prefix + out.Domain
+		if ips, lerr := r.LookupIPAddr(ctx, host); lerr == nil && len(ips) > 0 {
+			add(ServerCandidate{Host: host, Port: 465}, "guess")
+			add(ServerCandidate{Host: host, Port: 587}, "guess")
+		}
+	}
+	if len(out.Candidates) == 0 {
+		out.Hints = append(out.Hints, Hint{Code: HintHostNotFound, Message: fmt.Sprintf("No mail server could be found for %s. Look up the SMTP server name in your mail provider's help pages.", out.Domain)})
+	}
+	return out, nil
+}
+
+// ProbeCapabilities connects to the server in cfg without authenticating and reports what it supports.
+func (s *Service) ProbeCapabilities(ctx context.Context, cfg types.EmailConfig) StepResult {
+	report, err := s.probe(ctx, &cfg, false)
+	return stepResult(&report, err)
+}
+
+// TestAuth connects to the server in cfg and checks that its credentials are accepted.
+func (s *Service) TestAuth(ctx context.Context, cfg types.EmailConfig) StepResult {
+	if strings.TrimSpace(cfg.Username) == "" {
+		return StepResult{Error: "no username set", Hints: []Hint{{Code: HintAuthFailed, Message: "Enter the username your provider gave you, usually your full email address."}}}
+	}
+	report, err := s.probe(ctx, &cfg, true)
+	res := stepResult(&report, err)
+	if res.OK && !report.Authenticated {
+		res.OK = false
+		res.Error = "the server did not authenticate the session"
+		res.Hints = []Hint{{Code: HintAuthUnsupported, Message: "This server does not offer login. Check with your provider which server to use for sending."}}
+	}
+	return res
+}
+
+// TrySendTestMessage sends the diagnostic test message to to using cfg, bypassing the queue and send history, so
+// the wizard can confirm delivery before the settings are saved.
+func (s *Service) TrySendTestMessage(ctx context.Context, cfg types.EmailConfig, to string) StepResult {
+	const op errors.Op = "email.Service.TrySendTestMessage"
+	if err := validateEmailConfig(op, &cfg); err != nil {
+		return stepResult(nil, err)
+	}
+	def, err := s.testMessage(&cfg, to, nil, nil)
+	if err != nil {
+		return StepResult{Error: err.Error(), Hints: []Hint{{Code: HintRejected, Message: "Enter the address the test message should go to."}}}
+	}
+
+	host := strings.TrimSpace(cfg.Host)
+	auth, err := s.smtpAuth(ctx, strings.TrimSpace(cfg.Username), strings.TrimSpace(cfg.Password), host)
+	if err != nil {
+		return stepResult(nil, err)
+	}
+	if _, err = s.deliver(s.sessionContext(ctx, &cfg), &cfg, auth, def.From, def.To, []byte(def.Msg)); err != nil {
+		return stepResult(nil, err)
+	}
+	return StepResult{OK: true, MessageID: def.Structure.Header.Get("Message-Id")}
+}
+
+func stepResult(report *ConnectionReport, err error) StepResult {
+	if err != nil {
+		return StepResult{Error: err.Error(), Hints: remediate(err)}
+	}
+	return StepResult{OK: true, Report: report}
+}
+
+// providerReplies map fragments of the replies of particular providers, in lower case, to more specific advice than
+// the reply code alone gives.
+var providerReplies = []struct {
+	fragment string
+	hint     Hint
+}{
+	// Gmail: 534-5.7.9 Application-specific password required
+	{"application-specific password required", Hint{Code: HintAppPassword, Message: "Google requires an app password for this account because two-step verification is on. Create one under Google Account > Security > App passwords and use it in place of your password."}},
+	// Microsoft 365: 535 5.7.139 Authentication unsuccessful, SmtpClientAuthentication is disabled for the Tenant
+	{"smtpclientauthentication is disabled", Hint{Code: HintSMTPAuthDisabled, Message: "Microsoft 365 has SMTP sign-in turned off for this mailbox. Ask the administrator to enable Authenticated SMTP for it in the Microsoft 365 admin center, or sign in with OAuth2 instead."}},
+	// 550 5.7.1 Unable to relay, 554 5.7.1 Relay access denied and the like
+	{"relay", Hint{Code: HintRelayDenied, Message: "The server will not pass mail on to other domains for you. Sign in with a username and password, as most servers only relay for their own users, and send from an address that belongs to that account."}},
+}
+
+// remediate turns a connection or SMTP error into suggestions a non-technical user can act on.
+func remediate(err error) []Hint {
+	var tpErr *textproto.Error
+	if stderr.As(err, &tpErr) {
+		msg := strings.ToLower(tpErr.Msg)
+		for _, r := range providerReplies {
+			if strings.Contains(msg, r.fragment) {
+				return []Hint{r.hint}
+			}
+		}
+		switch {
+		case isAuthFailure(err):
+			return []Hint{{Code: HintAuthFailed, Message: "The server rejected the username or password. If your account uses two-step verification (as Gmail, Outlook and iCloud usually do), create an app password and use it here."}}
+		default:
+			return []Hint{{Code: HintRejected, Message: fmt.Sprintf("The server refused the request: %s", tpErr.Msg)}}
+		}
+	}
+	var dnsErr *net.DNSError
+	if stderr.As(err, &dnsErr) {
+		return []Hint{{Code: HintHostNotFound, Message: "The server name could not be found. Check it for typos."}}
+	}
+	var certErr *tls.CertificateVerificationError
+	var hostErr x509.HostnameError
+	var authErr x509.UnknownAuthorityError
+	if stderr.Is(err, errCertRevocation) {
+		return []Hint{{Code: HintCertificate, Message: "The server's certificate has been revoked, or whether it has could not be confirmed. Check with your provider that the server name is current."}}
+	}
+	if stderr.As(err, &certErr) || stderr.As(err, &hostErr) || stderr.As(err, &authErr) {
+		return []Hint{{Code: HintCertificate, Message: "The server's certificate could not be verified. Use the exact server name your provider publishes, not an IP address or alias."}}
+	}
+	if stderr.Is(err, syscall.ECONNREFUSED) {
+		return []Hint{{Code: HintRefused, Message: "Nothing accepted the connection on that port. Most providers use 465 (SSL/TLS) or 587 (STARTTLS)."}}
+	}
+	var netErr net.Error
+	if stderr.As(err, &netErr) && netErr.Timeout() {
+		return []Hint{{Code: HintTimeout, Message: "The connection timed out. Your network or ISP may block this port; try 465 instead of 587, or the other way round."}}
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "STARTTLS"):
+		return []Hint{{Code: HintNoTLS, Message: "The server does not offer encryption on this port. Try port 465, or ask your provider for a secure submission server."}}
+	case strings.Contains(msg, "doesn't support AUTH"), strings.Contains(msg, "unencrypted connection"):
+		return []Hint{{Code: HintAuthUnsupported, Message: "The server does not accept a login on this connection. Check the port and security settings."}}
+	}
+	return []Hint{{Code: HintUnknown, Message: "Check the server name, port and credentials against your provider's instructions."}}
+}
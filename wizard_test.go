@@ -0,0 +1,116 @@
+package email
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"testing"
+
+	"github.com/Station-Manager/types"
+)
+
+func TestDiscoverConfig(t *testing.T) {
+	s := &Service{resolver: fakeResolver{
+		mx:  map[string][]string{"club.example": {"aspmx.l.google.com."}},
+		ips: map[string][]string{"mail.radio.example": {"192.0.2.25"}},
+	}}
+
+	d, err := s.DiscoverConfig(t.Context(), "K1ABC <k1abc@GMail.com>")
+	if err != nil || len(d.Candidates) != 1 || d.Candidates[0] != (ServerCandidate{Host: "smtp.gmail.com", Port: 465, Source: "provider"}) {
+		t.Fatalf("provider lookup = %+v, %v", d, err)
+	}
+	d, _ = s.DiscoverConfig(t.Context(), "op@club.example")
+	if len(d.Candidates) != 1 || d.Candidates[0].Host != "smtp.gmail.com" || d.Candidates[0].Source != "mx" {
+		t.Fatalf("MX lookup = %+v", d)
+	}
+	d, _ = s.DiscoverConfig(t.Context(), "op@radio.example")
+	if len(d.Candidates) != 2 || d.Candidates[0] != (ServerCandidate{Host: "mail.radio.example", Port: 465, Source: "guess"}) {
+		t.Fatalf("guess = %+v", d)
+	}
+	d, _ = s.DiscoverConfig(t.Context(), "op@nowhere.example")
+	if len(d.Candidates) != 0 || len(d.Hints) != 1 || d.Hints[0].Code != HintHostNotFound {
+		t.Fatalf("nothing found = %+v", d)
+	}
+	if _, err = s.DiscoverConfig(t.Context(), "not an address"); err == nil {
+		t.Fatal("expected an error for an invalid address")
+	}
+}
+
+func TestWizardProbeAndAuth(t *testing.T) {
+	ehlo := []string{"fake.example.com", "SIZE 1000", "AUTH PLAIN"}
+	addr := startFakeSMTPS(t, ehlo)
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	cfg := types.EmailConfig{Host: host, Port: p, From: "op@example.com", Username: "op", Password: "secret"}
+	s := &Service{RootCAs: fakeRoots()}
+
+	res := s.ProbeCapabilities(t.Context(), cfg)
+	if !res.OK || res.Report == nil || res.Report.MaxSize != 1000 || res.Report.Authenticated {
+		t.Fatalf("probe = %+v", res)
+	}
+
+	addr = startFakeSMTPS(t, ehlo)
+	_, port, _ = net.SplitHostPort(addr)
+	cfg.Port, _ = strconv.Atoi(port)
+	if res = s.TestAuth(t.Context(), cfg); !res.OK || !res.Report.Authenticated {
+		t.Fatalf("auth = %+v", res)
+	}
+}
+
+func TestTrySendTestMessageReportsHints(t *testing.T) {
+	s := &Service{}
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		return deliveryInfo{}, &textproto.Error{Code: 535, Msg: "5.7.8 Username and Password not accepted"}
+	})
+	cfg := types.EmailConfig{Host: "smtp.gmail.com", Port: 465, From: "op@gmail.com", Username: "op@gmail.com", Password: "wrong"}
+	res := s.TrySendTestMessage(t.Context(), cfg, "op@gmail.com")
+	if res.OK || len(res.Hints) != 1 || res.Hints[0].Code != HintAuthFailed {
+		t.Fatalf("expected an auth hint, got %+v", res)
+	}
+
+	s.Transport = smtpFunc(func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (deliveryInfo, error) {
+		return deliveryInfo{}, nil
+	})
+	if res = s.TrySendTestMessage(t.Context(), cfg, "op@gmail.com"); !res.OK || res.MessageID == "" {
+		t.Fatalf("expected success, got %+v", res)
+	}
+	if len(s.History()) != 0 {
+		t.Fatal("test messages should not be recorded in the send history")
+	}
+}
+
+func TestRemediateConnectionErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	_, err = net.Dial("tcp", addr)
+	if hints := remediate(err); hints[0].Code != HintRefused {
+		t.Fatalf("refused connection gave %+v", hints)
+	}
+	if hints := remediate(&net.DNSError{Err: "no such host", Name: "smtp.typo.example", IsNotFound: true}); hints[0].Code != HintHostNotFound {
+		t.Fatalf("DNS error gave %+v", hints)
+	}
+}
+
+func TestRemediateProviderReplies(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{&textproto.Error{Code: 534, Msg: "5.7.9 Application-specific password required. For more information, go to https://support.google.com/mail/?p=InvalidSecondFactor"}, HintAppPassword},
+		{&textproto.Error{Code: 535, Msg: "5.7.139 Authentication unsuccessful, SmtpClientAuthentication is disabled for the Tenant."}, HintSMTPAuthDisabled},
+		{&textproto.Error{Code: 550, Msg: "5.7.1 Unable to relay"}, HintRelayDenied},
+		{&textproto.Error{Code: 554, Msg: "5.7.1 <dx@example.com>: Relay access denied"}, HintRelayDenied},
+		{&textproto.Error{Code: 535, Msg: "5.7.8 Username and Password not accepted"}, HintAuthFailed},
+	}
+	for _, c := range cases {
+		if hints := remediate(c.err); len(hints) != 1 || hints[0].Code != c.want {
+			t.Errorf("%v gave %+v, want %s", c.err, hints, c.want)
+		}
+	}
+}